@@ -0,0 +1,635 @@
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// Plugin caches. Every cache stores JSON-encoded values behind the Cache interface, so the
+// same size accounting, eviction and metrics apply to all of them, and a cache can be kept in
+// memory or on disk. Expiry is up to the reader: entries carry the time they were stored and
+// each typed Get*/Set* helper applies its own TTL.
+
+// Cache limits. Entry counts bound bookkeeping overhead; byte limits bound memory and disk use.
+// A logs entry holds up to 1000 log lines with their flattened fields, so the logs cache is
+// budgeted mainly by size.
+const (
+	autocompleteCacheMaxEntries     = 1000
+	autocompleteCacheMaxBytes       = 32 << 20
+	logsCacheMaxEntries             = 200
+	logsCacheMaxBytes               = 64 << 20
+	logsAutocompleteCacheMaxEntries = 1000
+	logsAutocompleteCacheMaxBytes   = 8 << 20
+	allTagsCacheMaxEntries          = 100
+	allTagsCacheMaxBytes            = 16 << 20
+	metricMetadataCacheMaxEntries   = 2000
+	metricMetadataCacheMaxBytes     = 4 << 20
+)
+
+// Longest TTL any reader applies to each cache. The janitor removes entries older than that,
+// so results that are never read again do not hold memory until they are evicted.
+const (
+	autocompleteCacheMaxTTL     = 5 * time.Minute
+	logsAutocompleteCacheMaxTTL = 30 * time.Second
+	allTagsCacheMaxTTL          = 10 * time.Minute
+	metricMetadataCacheTTL      = time.Hour
+	cacheJanitorInterval        = 30 * time.Second
+)
+
+// pluginCacheDirName is the directory under the Grafana data path that holds persistent caches.
+const pluginCacheDirName = "wasilak-datadog-datasource"
+
+// Cache stores byte values by key with least-recently-used eviction. Implementations are
+// safe for concurrent use.
+type Cache interface {
+	// Name returns the cache name used as the "cache" metric label.
+	Name() string
+	// RecordLookup counts a lookup with the given result (hit, partial or miss).
+	RecordLookup(result string)
+	// Lookups returns the lookups counted since the cache was created.
+	Lookups() CacheLookups
+	// Get returns the value stored under key and when it was stored.
+	Get(key string) (value []byte, storedAt time.Time, ok bool)
+	// Set stores value under key, evicting the least recently used entries while the cache
+	// is over its limits. Values larger than the byte limit are not stored.
+	Set(key string, value []byte) error
+	// Delete removes the entry stored under key, if any.
+	Delete(key string)
+	// Purge removes all entries stored before cutoff.
+	Purge(cutoff time.Time)
+	// Keys returns the keys of all entries.
+	Keys() []string
+	// Len returns the number of entries.
+	Len() int
+	// Size returns the total size of the stored values in bytes.
+	Size() int64
+	// Close stops accounting for the cache in the metrics. Persistent caches keep their data.
+	Close() error
+}
+
+// cacheLimits bounds a cache. Zero means no limit.
+type cacheLimits struct {
+	maxEntries int
+	maxBytes   int64
+}
+
+func (l cacheLimits) exceeded(entries int, size int64) bool {
+	return (l.maxEntries > 0 && entries > l.maxEntries) || (l.maxBytes > 0 && size > l.maxBytes)
+}
+
+func (l cacheLimits) fits(size int64) bool {
+	return l.maxBytes <= 0 || size <= l.maxBytes
+}
+
+// CacheLookups counts the lookups of one cache by result.
+type CacheLookups struct {
+	Hits    uint64 `json:"hits"`
+	Partial uint64 `json:"partial"`
+	Misses  uint64 `json:"misses"`
+}
+
+// HitRate returns the share of lookups answered at least partly from cache, or 0 without lookups.
+func (l CacheLookups) HitRate() float64 {
+	total := l.Hits + l.Partial + l.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(l.Hits+l.Partial) / float64(total)
+}
+
+// cacheStats counts the lookups of one cache and keeps its metrics up to date. Gauges are
+// adjusted by deltas so that caches of several datasource instances with the same name add up,
+// while the lookup counts are kept per cache for the cache/stats resource.
+type cacheStats struct {
+	name                   string
+	hits, partials, misses atomic.Uint64
+}
+
+// Name implements Cache.
+func (s *cacheStats) Name() string { return s.name }
+
+// RecordLookup implements Cache.
+func (s *cacheStats) RecordLookup(result string) {
+	switch result {
+	case cacheResultHit:
+		s.hits.Add(1)
+	case cacheResultPartial:
+		s.partials.Add(1)
+	default:
+		s.misses.Add(1)
+	}
+	recordCacheLookupResult(s.name, result)
+}
+
+// Lookups implements Cache.
+func (s *cacheStats) Lookups() CacheLookups {
+	return CacheLookups{Hits: s.hits.Load(), Partial: s.partials.Load(), Misses: s.misses.Load()}
+}
+
+func (s *cacheStats) added(size int64) {
+	cacheEntries.WithLabelValues(s.name).Inc()
+	cacheSizeBytes.WithLabelValues(s.name).Add(float64(size))
+}
+
+func (s *cacheStats) removed(size int64, evicted bool) {
+	cacheEntries.WithLabelValues(s.name).Dec()
+	cacheSizeBytes.WithLabelValues(s.name).Sub(float64(size))
+	if evicted {
+		cacheEvictionsTotal.WithLabelValues(s.name).Inc()
+	}
+}
+
+func (s *cacheStats) closed(entries int, size int64) {
+	cacheEntries.WithLabelValues(s.name).Sub(float64(entries))
+	cacheSizeBytes.WithLabelValues(s.name).Sub(float64(size))
+}
+
+// memoryCache is an in-memory Cache.
+type memoryCache struct {
+	*cacheStats
+	mu      sync.Mutex
+	limits  cacheLimits
+	entries map[string]*list.Element
+	lru     *list.List // front is the most recently used entry
+	size    int64
+	now     func() time.Time
+}
+
+type memoryCacheItem struct {
+	key      string
+	value    []byte
+	storedAt time.Time
+}
+
+func newMemoryCache(name string, limits cacheLimits) *memoryCache {
+	return &memoryCache{
+		cacheStats: &cacheStats{name: name},
+		limits:     limits,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+		now:        time.Now,
+	}
+}
+
+// Get implements Cache.
+func (c *memoryCache) Get(key string) ([]byte, time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, time.Time{}, false
+	}
+	c.lru.MoveToFront(el)
+	item := el.Value.(*memoryCacheItem)
+	return item.value, item.storedAt, true
+}
+
+// Set implements Cache.
+func (c *memoryCache) Set(key string, value []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.remove(el, false)
+	}
+	if !c.limits.fits(int64(len(value))) {
+		return nil
+	}
+	item := &memoryCacheItem{key: key, value: value, storedAt: c.now()}
+	c.entries[key] = c.lru.PushFront(item)
+	c.size += int64(len(value))
+	c.added(int64(len(value)))
+	for c.limits.exceeded(len(c.entries), c.size) {
+		c.remove(c.lru.Back(), true)
+	}
+	return nil
+}
+
+// Delete implements Cache.
+func (c *memoryCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.remove(el, false)
+	}
+}
+
+// Purge implements Cache.
+func (c *memoryCache) Purge(cutoff time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, el := range c.entries {
+		if el.Value.(*memoryCacheItem).storedAt.Before(cutoff) {
+			c.remove(el, false)
+		}
+	}
+}
+
+// Keys implements Cache.
+func (c *memoryCache) Keys() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keys := make([]string, 0, len(c.entries))
+	for key := range c.entries {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Len implements Cache.
+func (c *memoryCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// Size implements Cache.
+func (c *memoryCache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// Close implements Cache. The entries are dropped.
+func (c *memoryCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed(len(c.entries), c.size)
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+	c.size = 0
+	return nil
+}
+
+func (c *memoryCache) remove(el *list.Element, evicted bool) {
+	item := c.lru.Remove(el).(*memoryCacheItem)
+	delete(c.entries, item.key)
+	c.size -= int64(len(item.value))
+	c.removed(int64(len(item.value)), evicted)
+}
+
+// diskCache is a Cache that keeps one file per entry in a directory, so its content survives
+// plugin restarts. Files are named after the SHA-256 of the key; they hold the key as a JSON
+// string on the first line followed by the value, and their modification time is the time
+// the entry was stored. The index of files is kept in memory; recency of use is
+// not persisted, so after a restart entries are evicted oldest first.
+type diskCache struct {
+	*cacheStats
+	mu      sync.Mutex
+	limits  cacheLimits
+	dir     string
+	entries map[string]*list.Element // by file name
+	lru     *list.List               // front is the most recently used entry
+	size    int64
+	now     func() time.Time
+}
+
+type diskCacheItem struct {
+	file     string
+	key      string
+	size     int64 // of the value
+	storedAt time.Time
+}
+
+const diskCacheFileExt = ".json"
+
+// newDiskCache opens (creating it if needed) the cache directory dir and indexes the entries
+// already stored there.
+func newDiskCache(name, dir string, limits cacheLimits) (*diskCache, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create cache directory: %w", err)
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read cache directory: %w", err)
+	}
+
+	c := &diskCache{
+		cacheStats: &cacheStats{name: name},
+		limits:     limits,
+		dir:        dir,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+		now:        time.Now,
+	}
+	var items []*diskCacheItem
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		if !strings.HasSuffix(f.Name(), diskCacheFileExt) {
+			// Leftover of an interrupted write
+			_ = os.Remove(filepath.Join(dir, f.Name()))
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		key, headerLen, err := readDiskCacheKey(filepath.Join(dir, f.Name()))
+		if err != nil || diskCacheFileName(key) != f.Name() {
+			// Not written by this cache, or corrupted
+			_ = os.Remove(filepath.Join(dir, f.Name()))
+			continue
+		}
+		items = append(items, &diskCacheItem{file: f.Name(), key: key, size: info.Size() - int64(headerLen), storedAt: info.ModTime()})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].storedAt.Before(items[j].storedAt) })
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, item := range items {
+		c.entries[item.file] = c.lru.PushFront(item)
+		c.size += item.size
+		c.added(item.size)
+	}
+	for c.limits.exceeded(len(c.entries), c.size) {
+		c.remove(c.lru.Back(), true)
+	}
+	return c, nil
+}
+
+func diskCacheFileName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:]) + diskCacheFileExt
+}
+
+// diskCacheHeader returns the first line of the file of key.
+func diskCacheHeader(key string) []byte {
+	header, _ := json.Marshal(key)
+	return append(header, '\n')
+}
+
+// readDiskCacheKey reads the key from the first line of a cache file and returns it with the
+// length of that line.
+func readDiskCacheKey(path string) (key string, headerLen int, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil {
+		return "", 0, err
+	}
+	if err := json.Unmarshal(line, &key); err != nil {
+		return "", 0, err
+	}
+	return key, len(line), nil
+}
+
+// Get implements Cache.
+func (c *diskCache) Get(key string) ([]byte, time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[diskCacheFileName(key)]
+	if !ok {
+		return nil, time.Time{}, false
+	}
+	item := el.Value.(*diskCacheItem)
+	content, err := os.ReadFile(filepath.Join(c.dir, item.file))
+	header := diskCacheHeader(key)
+	if err != nil || !bytes.HasPrefix(content, header) {
+		// Removed, unreadable or overwritten; forget about it
+		c.remove(el, false)
+		return nil, time.Time{}, false
+	}
+	c.lru.MoveToFront(el)
+	return content[len(header):], item.storedAt, true
+}
+
+// Set implements Cache. The file is written next to its final name and renamed into place,
+// so readers (and restarts) never see a partial entry.
+func (c *diskCache) Set(key string, value []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	file := diskCacheFileName(key)
+	if el, ok := c.entries[file]; ok {
+		c.remove(el, false)
+	}
+	if !c.limits.fits(int64(len(value))) {
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(c.dir, "entry-*.tmp")
+	if err != nil {
+		return fmt.Errorf("write cache entry: %w", err)
+	}
+	_, err = tmp.Write(diskCacheHeader(key))
+	if err == nil {
+		_, err = tmp.Write(value)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	storedAt := c.now()
+	if err == nil {
+		err = os.Chtimes(tmp.Name(), storedAt, storedAt)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(c.dir, file))
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("write cache entry: %w", err)
+	}
+
+	item := &diskCacheItem{file: file, key: key, size: int64(len(value)), storedAt: storedAt}
+	c.entries[file] = c.lru.PushFront(item)
+	c.size += item.size
+	c.added(item.size)
+	for c.limits.exceeded(len(c.entries), c.size) {
+		c.remove(c.lru.Back(), true)
+	}
+	return nil
+}
+
+// Delete implements Cache.
+func (c *diskCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[diskCacheFileName(key)]; ok {
+		c.remove(el, false)
+	}
+}
+
+// Purge implements Cache.
+func (c *diskCache) Purge(cutoff time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, el := range c.entries {
+		if el.Value.(*diskCacheItem).storedAt.Before(cutoff) {
+			c.remove(el, false)
+		}
+	}
+}
+
+// Keys implements Cache.
+func (c *diskCache) Keys() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keys := make([]string, 0, len(c.entries))
+	for _, el := range c.entries {
+		keys = append(keys, el.Value.(*diskCacheItem).key)
+	}
+	return keys
+}
+
+// Len implements Cache.
+func (c *diskCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// Size implements Cache.
+func (c *diskCache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// Close implements Cache. The files are kept for the next instance.
+func (c *diskCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed(len(c.entries), c.size)
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+	c.size = 0
+	return nil
+}
+
+func (c *diskCache) remove(el *list.Element, evicted bool) {
+	item := c.lru.Remove(el).(*diskCacheItem)
+	delete(c.entries, item.file)
+	c.size -= item.size
+	c.removed(item.size, evicted)
+	_ = os.Remove(filepath.Join(c.dir, item.file))
+}
+
+// cacheJanitor periodically removes expired entries from the caches of a datasource.
+type cacheJanitor struct {
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// startCacheJanitor starts removing expired entries from the caches every interval until
+// stopCacheJanitor is called.
+func (d *Datasource) startCacheJanitor(interval time.Duration) {
+	j := &cacheJanitor{stop: make(chan struct{}), done: make(chan struct{})}
+	d.janitor = j
+	go func() {
+		defer close(j.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				d.cleanExpiredCaches()
+			case <-j.stop:
+				return
+			}
+		}
+	}()
+}
+
+// stopCacheJanitor stops the janitor and waits for it to exit. It is safe to call more than once.
+func (d *Datasource) stopCacheJanitor() {
+	if d.janitor == nil {
+		return
+	}
+	d.janitor.stopOnce.Do(func() { close(d.janitor.stop) })
+	<-d.janitor.done
+}
+
+// cleanExpiredCaches removes the entries no reader would still accept from every cache.
+func (d *Datasource) cleanExpiredCaches() {
+	if d.cache != nil {
+		d.CleanExpiredCache(autocompleteCacheMaxTTL)
+	}
+	if d.logsCache != nil {
+		d.CleanExpiredLogsCache(logsCacheTTL)
+	}
+	if d.logsAutocompleteCache != nil {
+		d.CleanExpiredLogsAutocompleteCache(logsAutocompleteCacheMaxTTL)
+	}
+	if d.allTagsCache != nil {
+		d.allTagsCache.Purge(time.Now().Add(-allTagsCacheMaxTTL))
+	}
+	if d.metadataCache != nil {
+		d.metadataCache.Purge(time.Now().Add(-metricMetadataCacheTTL))
+	}
+	if d.metricsCache != nil {
+		// Entries older than this were fully fetched even earlier and can no longer be used.
+		d.metricsCache.store.Purge(time.Now().Add(-metricsCacheMaxAge))
+	}
+}
+
+// cacheGet decodes the entry stored under key into dst if it is not older than ttl, and
+// counts the lookup in the cache metrics. Expired and undecodable entries are removed.
+func cacheGet(c Cache, key string, ttl time.Duration, dst interface{}) (storedAt time.Time, ok bool) {
+	value, storedAt, ok := c.Get(key)
+	if ok && time.Since(storedAt) > ttl {
+		c.Delete(key)
+		ok = false
+	}
+	if ok {
+		if err := json.Unmarshal(value, dst); err != nil {
+			c.Delete(key)
+			ok = false
+		}
+	}
+	if ok {
+		c.RecordLookup(cacheResultHit)
+	} else {
+		c.RecordLookup(cacheResultMiss)
+	}
+	return storedAt, ok
+}
+
+// cacheSet encodes v and stores it under key. A value that cannot be stored is only logged:
+// it will be fetched again next time.
+func cacheSet(c Cache, key string, v interface{}) {
+	value, err := json.Marshal(v)
+	if err == nil {
+		err = c.Set(key, value)
+	}
+	if err != nil {
+		log.New().Warn("Failed to store cache entry", "cache", c.Name(), "error", err)
+	}
+}
+
+var reUnsafePathChars = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// persistentCacheDir returns the directory for the persistent caches of the datasource with
+// the given UID: <Grafana data path>/wasilak-datadog-datasource/<uid>. The data path is taken
+// from GF_PATHS_DATA, falling back to the user cache directory when Grafana does not pass it on.
+func persistentCacheDir(uid string) (string, error) {
+	base := os.Getenv("GF_PATHS_DATA")
+	if base == "" {
+		var err error
+		if base, err = os.UserCacheDir(); err != nil {
+			return "", fmt.Errorf("no data directory: GF_PATHS_DATA is not set and %w", err)
+		}
+	}
+	if uid == "" {
+		uid = "default"
+	}
+	return filepath.Join(base, pluginCacheDirName, reUnsafePathChars.ReplaceAllString(uid, "_")), nil
+}
@@ -0,0 +1,240 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// Cache administration resources. They let organization admins see what the caches hold and
+// drop entries (for example after adding a tag in Datadog) without waiting for TTLs or
+// restarting the plugin:
+//
+//	GET  cache/stats                    entries, size and hit rate per cache
+//	GET  cache/keys?cache=NAME&prefix=P keys per cache, optionally filtered
+//	POST cache/purge                    {"cache": "NAME", "prefix": "P"} removes matching entries
+//
+// An empty cache name means every cache; an empty prefix matches every key. With per-user
+// application keys each view has its own caches; they are reported and purged together
+// with the caches of the same name.
+
+// orgAdminRole is the Grafana organization role required for administration resources.
+const orgAdminRole = "Admin"
+
+// orgEditorRole is the Grafana organization role required, with Admin, to import Datadog
+// dashboards, monitors and notebooks, which are read from the whole organization.
+const orgEditorRole = "Editor"
+
+// CacheStats describes one cache in the cache/stats response.
+type CacheStats struct {
+	Name       string       `json:"name"`
+	Entries    int          `json:"entries"`
+	Bytes      int64        `json:"bytes"`
+	Persistent bool         `json:"persistent"`
+	Lookups    CacheLookups `json:"lookups"`
+	HitRate    float64      `json:"hitRate"`
+}
+
+// CachePurgeRequest is the body of cache/purge.
+type CachePurgeRequest struct {
+	Cache  string `json:"cache,omitempty"`
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// CachePurgeResponse reports the number of entries removed per cache.
+type CachePurgeResponse struct {
+	Removed map[string]int `json:"removed"`
+}
+
+// caches returns the caches of the datasource and of its per-user views in a stable order.
+func (d *Datasource) caches() []Cache {
+	var caches []Cache
+	for _, ds := range append([]*Datasource{d}, d.views()...) {
+		for _, c := range []Cache{ds.cache, ds.logsCache, ds.logsAutocompleteCache, ds.allTagsCache, ds.metadataCache} {
+			if c != nil {
+				caches = append(caches, c)
+			}
+		}
+		if ds.metricsCache != nil {
+			caches = append(caches, ds.metricsCache.store)
+		}
+	}
+	return caches
+}
+
+// selectCaches returns the caches called name, or every cache when name is empty.
+func (d *Datasource) selectCaches(name string) ([]Cache, bool) {
+	if name == "" {
+		return d.caches(), true
+	}
+	var selected []Cache
+	for _, c := range d.caches() {
+		if c.Name() == name {
+			selected = append(selected, c)
+		}
+	}
+	return selected, len(selected) > 0
+}
+
+// purgeCache removes the entries of c whose key starts with prefix and returns how many it removed.
+func purgeCache(c Cache, prefix string) int {
+	removed := 0
+	for _, key := range c.Keys() {
+		if strings.HasPrefix(key, prefix) {
+			c.Delete(key)
+			removed++
+		}
+	}
+	return removed
+}
+
+// requireOrgAdmin sends 403 and returns false unless the request comes from an organization admin.
+func requireOrgAdmin(req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) (bool, error) {
+	if user := req.PluginContext.User; user != nil && user.Role == orgAdminRole {
+		return true, nil
+	}
+	return false, sender.Send(&backend.CallResourceResponse{
+		Status: http.StatusForbidden,
+		Body:   []byte(`{"error": "this resource requires the Admin role"}`),
+	})
+}
+
+// requireEditor sends 403 and returns false unless the request comes from an organization
+// editor or admin.
+func requireEditor(req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) (bool, error) {
+	if user := req.PluginContext.User; user != nil && (user.Role == orgEditorRole || user.Role == orgAdminRole) {
+		return true, nil
+	}
+	return false, sender.Send(&backend.CallResourceResponse{
+		Status: http.StatusForbidden,
+		Body:   []byte(`{"error": "this resource requires the Editor or Admin role"}`),
+	})
+}
+
+// sendJSON sends v as a 200 JSON response.
+func sendJSON(sender backend.CallResourceResponseSender, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return sender.Send(&backend.CallResourceResponse{Status: http.StatusOK, Body: body})
+}
+
+func sendUnknownCache(sender backend.CallResourceResponseSender) error {
+	return sender.Send(&backend.CallResourceResponse{
+		Status: http.StatusBadRequest,
+		Body:   []byte(`{"error": "unknown cache"}`),
+	})
+}
+
+// CacheStatsHandler handles GET /cache/stats requests
+func (d *Datasource) CacheStatsHandler(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	if ok, err := requireOrgAdmin(req, sender); !ok {
+		return err
+	}
+
+	var stats []CacheStats
+	index := map[string]int{}
+	for _, c := range d.caches() {
+		i, seen := index[c.Name()]
+		if !seen {
+			i = len(stats)
+			index[c.Name()] = i
+			stats = append(stats, CacheStats{Name: c.Name()})
+		}
+		_, persistent := c.(*diskCache)
+		lookups := c.Lookups()
+		stats[i].Entries += c.Len()
+		stats[i].Bytes += c.Size()
+		stats[i].Persistent = stats[i].Persistent || persistent
+		stats[i].Lookups.Hits += lookups.Hits
+		stats[i].Lookups.Partial += lookups.Partial
+		stats[i].Lookups.Misses += lookups.Misses
+	}
+	for i := range stats {
+		stats[i].HitRate = stats[i].Lookups.HitRate()
+	}
+	return sendJSON(sender, map[string]interface{}{"caches": stats})
+}
+
+// CacheKeysHandler handles GET /cache/keys requests
+func (d *Datasource) CacheKeysHandler(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	if ok, err := requireOrgAdmin(req, sender); !ok {
+		return err
+	}
+
+	params := url.Values{}
+	if u, err := url.Parse(req.URL); err == nil {
+		params = u.Query()
+	}
+	caches, ok := d.selectCaches(params.Get("cache"))
+	if !ok {
+		return sendUnknownCache(sender)
+	}
+
+	prefix := params.Get("prefix")
+	keys := make(map[string][]string, len(caches))
+	for _, c := range caches {
+		matching, ok := keys[c.Name()]
+		if !ok {
+			matching = []string{}
+		}
+		for _, key := range c.Keys() {
+			if strings.HasPrefix(key, prefix) {
+				matching = append(matching, key)
+			}
+		}
+		keys[c.Name()] = matching
+	}
+	for name, matching := range keys {
+		sort.Strings(matching)
+		keys[name] = dedupeSorted(matching)
+	}
+	return sendJSON(sender, map[string]interface{}{"keys": keys})
+}
+
+// CachePurgeHandler handles POST /cache/purge requests
+func (d *Datasource) CachePurgeHandler(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	logger := log.New()
+	if ok, err := requireOrgAdmin(req, sender); !ok {
+		return err
+	}
+
+	var purgeReq CachePurgeRequest
+	if len(req.Body) > 0 {
+		if err := json.Unmarshal(req.Body, &purgeReq); err != nil {
+			return sender.Send(&backend.CallResourceResponse{
+				Status: http.StatusBadRequest,
+				Body:   []byte(`{"error": "Invalid request format"}`),
+			})
+		}
+	}
+	caches, ok := d.selectCaches(purgeReq.Cache)
+	if !ok {
+		return sendUnknownCache(sender)
+	}
+
+	resp := CachePurgeResponse{Removed: make(map[string]int, len(caches))}
+	for _, c := range caches {
+		resp.Removed[c.Name()] += purgeCache(c, purgeReq.Prefix)
+	}
+	logger.Info("Cache purged", "cache", purgeReq.Cache, "prefix", d.logQuery(purgeReq.Prefix), "removed", resp.Removed)
+	return sendJSON(sender, resp)
+}
+
+// dedupeSorted removes adjacent duplicates from a sorted slice.
+func dedupeSorted(values []string) []string {
+	out := values[:0]
+	for i, v := range values {
+		if i == 0 || v != values[i-1] {
+			out = append(out, v)
+		}
+	}
+	return out
+}
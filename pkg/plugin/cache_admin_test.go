@@ -0,0 +1,120 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wasilak/grafana-datadog-datasource/pkg/plugin/fakedatadog"
+)
+
+// callCacheResource invokes a cache administration route as a user with the given org role.
+func callCacheResource(t *testing.T, d *Datasource, role, method, path, rawURL string, body []byte) *backend.CallResourceResponse {
+	t.Helper()
+	var user *backend.User
+	if role != "" {
+		user = &backend.User{Login: "someone", Role: role}
+	}
+	var got *backend.CallResourceResponse
+	err := d.CallResource(context.Background(), &backend.CallResourceRequest{
+		PluginContext: backend.PluginContext{User: user},
+		Method:        method,
+		Path:          path,
+		URL:           rawURL,
+		Body:          body,
+	}, backend.CallResourceResponseSenderFunc(func(resp *backend.CallResourceResponse) error {
+		got = resp
+		return nil
+	}))
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	return got
+}
+
+func TestCacheAdmin_RequiresOrgAdmin(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+	d.SetCachedEntry("tags:system.cpu.user", []string{"host"})
+
+	for _, role := range []string{"", "Viewer", "Editor"} {
+		assert.Equal(t, http.StatusForbidden, callCacheResource(t, d, role, http.MethodGet, "cache/stats", "cache/stats", nil).Status, role)
+		assert.Equal(t, http.StatusForbidden, callCacheResource(t, d, role, http.MethodGet, "cache/keys", "cache/keys", nil).Status, role)
+		assert.Equal(t, http.StatusForbidden, callCacheResource(t, d, role, http.MethodPost, "cache/purge", "cache/purge", nil).Status, role)
+	}
+	assert.NotNil(t, d.GetCachedEntry("tags:system.cpu.user", time.Minute), "nothing is purged without the Admin role")
+	assert.Zero(t, srv.Hits(""))
+}
+
+func TestCacheAdmin_Stats(t *testing.T) {
+	d, _ := newFakeBackedDatasource(t)
+	for i := 0; i < 2; i++ {
+		require.Equal(t, http.StatusOK, callResource(t, d, http.MethodGet, "autocomplete/metrics", nil).Status)
+	}
+
+	resp := callCacheResource(t, d, orgAdminRole, http.MethodGet, "cache/stats", "cache/stats", nil)
+	require.Equal(t, http.StatusOK, resp.Status)
+	var body struct {
+		Caches []CacheStats `json:"caches"`
+	}
+	require.NoError(t, json.Unmarshal(resp.Body, &body))
+
+	byName := map[string]CacheStats{}
+	var names []string
+	for _, c := range body.Caches {
+		byName[c.Name] = c
+		names = append(names, c.Name)
+	}
+	assert.Equal(t, []string{cacheAutocomplete, cacheLogs, cacheLogsAutocomplete, cacheAllTags, cacheMetricMetadata, cacheMetrics}, names)
+	autocomplete := byName[cacheAutocomplete]
+	assert.Equal(t, 1, autocomplete.Entries)
+	assert.Positive(t, autocomplete.Bytes)
+	assert.Equal(t, CacheLookups{Hits: 1, Misses: 1}, autocomplete.Lookups)
+	assert.Equal(t, 0.5, autocomplete.HitRate)
+	assert.False(t, autocomplete.Persistent)
+}
+
+func TestCacheAdmin_KeysAndPurgeByPrefix(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+	d.SetCachedEntry("var-tag-values:system.cpu.user:host:", []string{"web-01"})
+	d.SetCachedEntry("var-tag-values:system.cpu.user:env:", []string{"prod"})
+	d.SetCachedEntry("var-tag-keys:system.cpu.user:", []string{"host", "env"})
+	d.SetCachedLogsAutocompleteEntry("logs_services", []string{"checkout"})
+
+	resp := callCacheResource(t, d, orgAdminRole, http.MethodGet, "cache/keys", "cache/keys?cache=autocomplete&prefix=var-tag-values%3A", nil)
+	require.Equal(t, http.StatusOK, resp.Status)
+	assert.JSONEq(t, `{"keys": {"autocomplete": ["var-tag-values:system.cpu.user:env:", "var-tag-values:system.cpu.user:host:"]}}`, string(resp.Body))
+
+	resp = callCacheResource(t, d, orgAdminRole, http.MethodPost, "cache/purge", "cache/purge", []byte(`{"prefix": "var-tag-values:"}`))
+	require.Equal(t, http.StatusOK, resp.Status)
+	var purged CachePurgeResponse
+	require.NoError(t, json.Unmarshal(resp.Body, &purged))
+	assert.Equal(t, 2, purged.Removed[cacheAutocomplete])
+	assert.Equal(t, 0, purged.Removed[cacheLogsAutocomplete])
+
+	assert.Nil(t, d.GetCachedEntry("var-tag-values:system.cpu.user:host:", time.Minute))
+	assert.NotNil(t, d.GetCachedEntry("var-tag-keys:system.cpu.user:", time.Minute), "other prefixes are kept")
+	assert.NotNil(t, d.GetCachedLogsAutocompleteEntry("logs_services", time.Minute))
+
+	// Purging a whole cache
+	resp = callCacheResource(t, d, orgAdminRole, http.MethodPost, "cache/purge", "cache/purge", []byte(`{"cache": "logs_autocomplete"}`))
+	require.Equal(t, http.StatusOK, resp.Status)
+	assert.Nil(t, d.GetCachedLogsAutocompleteEntry("logs_services", time.Minute))
+	assert.NotNil(t, d.GetCachedEntry("var-tag-keys:system.cpu.user:", time.Minute))
+	assert.Zero(t, srv.Hits(fakedatadog.EndpointTagConfigurations))
+}
+
+func TestCacheAdmin_UnknownCache(t *testing.T) {
+	d, _ := newFakeBackedDatasource(t)
+
+	resp := callCacheResource(t, d, orgAdminRole, http.MethodGet, "cache/keys", "cache/keys?cache=nope", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.Status)
+	resp = callCacheResource(t, d, orgAdminRole, http.MethodPost, "cache/purge", "cache/purge", []byte(`{"cache": "nope"}`))
+	assert.Equal(t, http.StatusBadRequest, resp.Status)
+	resp = callCacheResource(t, d, orgAdminRole, http.MethodPost, "cache/purge", "cache/purge", []byte(`{`))
+	assert.Equal(t, http.StatusBadRequest, resp.Status)
+}
@@ -0,0 +1,252 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wasilak/grafana-datadog-datasource/pkg/plugin/fakedatadog"
+)
+
+// newTestCaches returns one of each Cache implementation with the given limits.
+func newTestCaches(t *testing.T, name string, limits cacheLimits) map[string]Cache {
+	t.Helper()
+	disk, err := newDiskCache(name, t.TempDir(), limits)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = disk.Close() })
+	mem := newMemoryCache(name, limits)
+	t.Cleanup(func() { _ = mem.Close() })
+	return map[string]Cache{"memory": mem, "disk": disk}
+}
+
+func TestCache_Implementations(t *testing.T) {
+	for impl, c := range newTestCaches(t, "test_basic", cacheLimits{}) {
+		t.Run(impl, func(t *testing.T) {
+			require.NoError(t, c.Set("k", []byte("value")))
+			value, storedAt, ok := c.Get("k")
+			require.True(t, ok)
+			assert.Equal(t, "value", string(value))
+			assert.WithinDuration(t, time.Now(), storedAt, time.Second)
+
+			require.NoError(t, c.Set("k", []byte("longer value")))
+			value, _, _ = c.Get("k")
+			assert.Equal(t, "longer value", string(value))
+			assert.Equal(t, 1, c.Len())
+			assert.Equal(t, int64(len("longer value")), c.Size())
+
+			c.Delete("k")
+			_, _, ok = c.Get("k")
+			assert.False(t, ok)
+			assert.Zero(t, c.Size())
+		})
+	}
+}
+
+func TestCache_SizeBasedEviction(t *testing.T) {
+	for impl, c := range newTestCaches(t, "test_evict", cacheLimits{maxBytes: 10}) {
+		t.Run(impl, func(t *testing.T) {
+			require.NoError(t, c.Set("a", []byte("aaaa")))
+			require.NoError(t, c.Set("b", []byte("bbbb")))
+			_, _, _ = c.Get("a") // a is now more recently used than b
+			require.NoError(t, c.Set("c", []byte("cccc")))
+
+			_, _, hasA := c.Get("a")
+			_, _, hasB := c.Get("b")
+			assert.True(t, hasA)
+			assert.False(t, hasB, "the least recently used entry is evicted")
+			assert.Equal(t, int64(8), c.Size())
+
+			require.NoError(t, c.Set("huge", []byte("more than ten bytes")))
+			_, _, ok := c.Get("huge")
+			assert.False(t, ok, "values over the byte limit are not stored")
+			assert.Equal(t, 2, c.Len())
+		})
+	}
+}
+
+func TestCache_Purge(t *testing.T) {
+	for impl, c := range newTestCaches(t, "test_purge", cacheLimits{}) {
+		t.Run(impl, func(t *testing.T) {
+			require.NoError(t, c.Set("old", []byte("1")))
+			cutoff := time.Now().Add(time.Millisecond)
+			time.Sleep(5 * time.Millisecond)
+			require.NoError(t, c.Set("new", []byte("2")))
+
+			c.Purge(cutoff)
+			_, _, hasOld := c.Get("old")
+			_, _, hasNew := c.Get("new")
+			assert.False(t, hasOld)
+			assert.True(t, hasNew)
+		})
+	}
+}
+
+func TestCache_Metrics(t *testing.T) {
+	const name = "test_metrics"
+	c := newMemoryCache(name, cacheLimits{maxEntries: 2})
+	entries := func() float64 { return testutil.ToFloat64(cacheEntries.WithLabelValues(name)) }
+	size := func() float64 { return testutil.ToFloat64(cacheSizeBytes.WithLabelValues(name)) }
+	evictions := func() float64 { return testutil.ToFloat64(cacheEvictionsTotal.WithLabelValues(name)) }
+	evictionsBefore := evictions()
+
+	require.NoError(t, c.Set("a", []byte("12")))
+	require.NoError(t, c.Set("b", []byte("345")))
+	require.NoError(t, c.Set("c", []byte("6789")))
+	assert.Equal(t, float64(2), entries())
+	assert.Equal(t, float64(7), size())
+	assert.Equal(t, evictionsBefore+1, evictions())
+
+	c.Delete("b")
+	assert.Equal(t, float64(1), entries())
+	assert.Equal(t, evictionsBefore+1, evictions(), "deletes are not evictions")
+
+	require.NoError(t, c.Close())
+	assert.Zero(t, entries())
+	assert.Zero(t, size())
+}
+
+func TestDiskCache_SurvivesReopen(t *testing.T) {
+	dir := t.TempDir()
+	c, err := newDiskCache("test_reopen", dir, cacheLimits{})
+	require.NoError(t, err)
+	require.NoError(t, c.Set("k", []byte("persisted")))
+	_, storedAt, _ := c.Get("k")
+	require.NoError(t, c.Close())
+
+	// Leftover of an interrupted write
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "entry-1.tmp"), []byte("partial"), 0o600))
+
+	reopened, err := newDiskCache("test_reopen", dir, cacheLimits{})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = reopened.Close() })
+	value, reopenedAt, ok := reopened.Get("k")
+	require.True(t, ok)
+	assert.Equal(t, "persisted", string(value))
+	assert.WithinDuration(t, storedAt, reopenedAt, time.Second, "the stored time is kept across restarts")
+	assert.Equal(t, []string{"k"}, reopened.Keys(), "keys are read back from the files")
+	assert.NoFileExists(t, filepath.Join(dir, "entry-1.tmp"))
+}
+
+func TestDiskCache_ReopenWithSmallerLimitEvictsOldest(t *testing.T) {
+	dir := t.TempDir()
+	c, err := newDiskCache("test_reopen_limit", dir, cacheLimits{})
+	require.NoError(t, err)
+	base := time.Now().Add(-time.Hour)
+	for i := 0; i < 3; i++ {
+		c.now = func() time.Time { return base.Add(time.Duration(i) * time.Minute) }
+		require.NoError(t, c.Set(fmt.Sprintf("k%d", i), []byte("v")))
+	}
+	require.NoError(t, c.Close())
+
+	reopened, err := newDiskCache("test_reopen_limit", dir, cacheLimits{maxEntries: 2})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = reopened.Close() })
+	_, _, hasOldest := reopened.Get("k0")
+	assert.False(t, hasOldest)
+	assert.Equal(t, 2, reopened.Len())
+	files, _ := os.ReadDir(dir)
+	assert.Len(t, files, 2, "evicted entries are removed from disk")
+}
+
+func TestCacheGet_ExpiredAndUndecodableEntriesAreMisses(t *testing.T) {
+	c := newMemoryCache("test_cache_get", cacheLimits{})
+	t.Cleanup(func() { _ = c.Close() })
+	missesBefore := testutil.ToFloat64(cacheLookupsTotal.WithLabelValues("test_cache_get", cacheResultMiss))
+
+	cacheSet(c, "list", []string{"a"})
+	var data []string
+	_, ok := cacheGet(c, "list", time.Minute, &data)
+	require.True(t, ok)
+	assert.Equal(t, []string{"a"}, data)
+
+	_, ok = cacheGet(c, "list", -time.Second, &data)
+	assert.False(t, ok)
+	assert.Zero(t, c.Len(), "expired entries are removed")
+
+	require.NoError(t, c.Set("broken", []byte("{")))
+	_, ok = cacheGet(c, "broken", time.Minute, &data)
+	assert.False(t, ok)
+	assert.Zero(t, c.Len(), "undecodable entries are removed")
+
+	assert.Equal(t, missesBefore+2, testutil.ToFloat64(cacheLookupsTotal.WithLabelValues("test_cache_get", cacheResultMiss)))
+}
+
+func TestCacheJanitor_RemovesExpiredLogsAndStopsOnDispose(t *testing.T) {
+	d := &Datasource{logsCache: newMemoryCache(cacheLogs, cacheLimits{})}
+	logsCache := d.logsCache.(*memoryCache)
+	logsCache.now = func() time.Time { return time.Now().Add(-2 * logsCacheTTL) }
+	d.SetCachedLogsEntry("expired", []LogEntry{{ID: "old", Body: "never read again"}}, "")
+	logsCache.now = time.Now
+	d.SetCachedLogsEntry("fresh", []LogEntry{{ID: "new"}}, "")
+
+	d.startCacheJanitor(10 * time.Millisecond)
+	require.Eventually(t, func() bool { return d.logsCache.Len() == 1 }, time.Second, 10*time.Millisecond)
+	assert.NotNil(t, d.GetCachedLogsEntry("fresh", logsCacheTTL))
+
+	d.Dispose()
+	select {
+	case <-d.janitor.done:
+	default:
+		t.Fatal("janitor still running after Dispose")
+	}
+	d.Dispose() // idempotent
+}
+
+func TestPersistentCacheDir(t *testing.T) {
+	t.Setenv("GF_PATHS_DATA", "/var/lib/grafana")
+
+	dir, err := persistentCacheDir("abc-123")
+	require.NoError(t, err)
+	assert.Equal(t, "/var/lib/grafana/wasilak-datadog-datasource/abc-123", dir)
+
+	dir, err = persistentCacheDir("../escape")
+	require.NoError(t, err)
+	assert.Equal(t, "/var/lib/grafana/wasilak-datadog-datasource/___escape", dir)
+}
+
+func TestIntegration_AllTagsSurviveRestartWithPersistentCache(t *testing.T) {
+	t.Setenv("GF_PATHS_DATA", t.TempDir())
+	allTags := func() (*backend.CallResourceResponse, *fakedatadog.Server) {
+		srv := fakedatadog.New()
+		t.Cleanup(srv.Close)
+		inst, err := NewDatasource(context.Background(), backend.DataSourceInstanceSettings{
+			UID:      "persistent",
+			JSONData: []byte(fmt.Sprintf(`{"site": "datadoghq.com", "baseUrl": %q, "persistentCache": true}`, srv.URL())),
+			DecryptedSecureJSONData: map[string]string{
+				"apiKey": fakedatadog.TestAPIKey,
+				"appKey": fakedatadog.TestAppKey,
+			},
+		})
+		require.NoError(t, err)
+		d := inst.(*Datasource)
+		defer d.Dispose()
+		_, isDisk := d.allTagsCache.(*diskCache)
+		require.True(t, isDisk)
+		return callResource(t, d, http.MethodPost, "all-tags", []byte(`{"queryType": "tag_keys"}`)), srv
+	}
+
+	first, srv := allTags()
+	require.Equal(t, http.StatusOK, first.Status)
+	assert.Equal(t, 1, srv.Hits(fakedatadog.EndpointTagConfigurations))
+
+	// A new instance (as after a plugin restart) is served from disk.
+	second, srv := allTags()
+	require.Equal(t, http.StatusOK, second.Status)
+	assert.Zero(t, srv.Hits(""))
+
+	var firstValues, secondValues VariableResponse
+	require.NoError(t, json.Unmarshal(first.Body, &firstValues))
+	require.NoError(t, json.Unmarshal(second.Body, &secondValues))
+	assert.NotEmpty(t, firstValues.Values)
+	assert.ElementsMatch(t, firstValues.Values, secondValues.Values)
+}
@@ -0,0 +1,93 @@
+package plugin
+
+import (
+	"context"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Request coalescing. When a dashboard loads, many panels and variables ask for the same
+// metric list, tag values or logs query at the same moment; each of them misses the cache
+// and would call Datadog. Identical concurrent calls are collapsed onto a single in-flight
+// call whose result every caller shares. Coalescing only joins calls that overlap in time;
+// results are kept afterwards by the caches, not here.
+
+// Coalescing kinds, used as key namespace and as the "kind" metric label.
+const (
+	coalesceResource   = "resource"
+	coalesceLogs       = "logs"
+	coalesceTimeseries = "timeseries"
+	coalesceMetadata   = "metric_metadata"
+)
+
+// coalesce runs fn once for all concurrent callers with the same kind and key and hands its
+// result to each of them. coalesced reports whether this caller was served by another
+// caller's call. fn runs detached from the cancellation of the caller that started it, so
+// one client going away does not fail the others, but it keeps that caller's deadline.
+// A caller whose context is done stops waiting and returns ctx.Err().
+func (d *Datasource) coalesce(ctx context.Context, kind, key string, fn func(ctx context.Context) (interface{}, error)) (v interface{}, coalesced bool, err error) {
+	executed := false
+	ch := d.inflight.DoChan(kind+"\x00"+key, func() (interface{}, error) {
+		executed = true
+		callCtx := context.WithoutCancel(ctx)
+		if deadline, ok := ctx.Deadline(); ok {
+			var cancel context.CancelFunc
+			callCtx, cancel = context.WithDeadline(callCtx, deadline)
+			defer cancel()
+		}
+		return fn(callCtx)
+	})
+
+	select {
+	case res := <-ch:
+		// executed is written before the result is delivered on ch, so reading it here is safe.
+		coalesced = !executed
+		if coalesced {
+			coalescedRequestsTotal.WithLabelValues(kind).Inc()
+		}
+		trace.SpanFromContext(ctx).SetAttributes(attrCoalesced.Bool(coalesced))
+		return res.Val, coalesced, res.Err
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	}
+}
+
+// resourceCoalesceKey identifies a resource request. Handlers of coalesced routes only
+// depend on the method, path and body, and credentials are per datasource instance, so
+// identical triples on the same instance always produce the same response. Routes whose
+// response depends on the user's role are not coalesced (see editorRoute).
+func resourceCoalesceKey(req *backend.CallResourceRequest) string {
+	return strings.Join([]string{req.Method, req.Path, string(req.Body)}, "\x00")
+}
+
+// resourceRecorder buffers the responses of a resource handler so they can be replayed to
+// every coalesced caller.
+type resourceRecorder struct {
+	responses []*backend.CallResourceResponse
+}
+
+// Send implements backend.CallResourceResponseSender.
+func (r *resourceRecorder) Send(resp *backend.CallResourceResponse) error {
+	r.responses = append(r.responses, resp)
+	return nil
+}
+
+// callResourceCoalesced runs handler once for identical concurrent resource requests and
+// sends the shared responses to this caller's sender.
+func (d *Datasource) callResourceCoalesced(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender,
+	handler func(context.Context, *backend.CallResourceRequest, backend.CallResourceResponseSender) error) error {
+	v, _, err := d.coalesce(ctx, coalesceResource, resourceCoalesceKey(req), func(ctx context.Context) (interface{}, error) {
+		rec := &resourceRecorder{}
+		err := handler(ctx, req, rec)
+		return rec.responses, err
+	})
+	responses, _ := v.([]*backend.CallResourceResponse)
+	for _, resp := range responses {
+		if sendErr := sender.Send(resp); sendErr != nil {
+			return sendErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,202 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wasilak/grafana-datadog-datasource/pkg/plugin/fakedatadog"
+)
+
+// runConcurrently calls fn from n goroutines at once and waits for all of them.
+func runConcurrently(n int, fn func(i int)) {
+	var start, done sync.WaitGroup
+	start.Add(1)
+	for i := 0; i < n; i++ {
+		done.Add(1)
+		go func(i int) {
+			defer done.Done()
+			start.Wait()
+			fn(i)
+		}(i)
+	}
+	start.Done()
+	done.Wait()
+}
+
+func TestCoalesce_IdenticalCallsShareOneRun(t *testing.T) {
+	d := &Datasource{}
+	release := make(chan struct{})
+	var runs atomic.Int32
+	before := testutil.ToFloat64(coalescedRequestsTotal.WithLabelValues(coalesceResource))
+
+	results := make([]interface{}, 5)
+	coalesced := make([]bool, 5)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(release)
+	}()
+	runConcurrently(5, func(i int) {
+		v, shared, err := d.coalesce(context.Background(), coalesceResource, "same", func(ctx context.Context) (interface{}, error) {
+			runs.Add(1)
+			<-release
+			return "result", nil
+		})
+		assert.NoError(t, err)
+		results[i], coalesced[i] = v, shared
+	})
+
+	assert.Equal(t, int32(1), runs.Load())
+	for _, v := range results {
+		assert.Equal(t, "result", v)
+	}
+	var followers int
+	for _, c := range coalesced {
+		if c {
+			followers++
+		}
+	}
+	assert.Equal(t, 4, followers, "all callers but the one that ran fn are coalesced")
+	assert.Equal(t, before+4, testutil.ToFloat64(coalescedRequestsTotal.WithLabelValues(coalesceResource)))
+}
+
+func TestCoalesce_DistinctKeysAndKindsRunSeparately(t *testing.T) {
+	d := &Datasource{}
+	var runs atomic.Int32
+	fn := func(ctx context.Context) (interface{}, error) {
+		runs.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		return nil, nil
+	}
+
+	runConcurrently(3, func(i int) {
+		kind, key := coalesceResource, "a"
+		switch i {
+		case 1:
+			key = "b"
+		case 2:
+			kind = coalesceLogs
+		}
+		_, _, err := d.coalesce(context.Background(), kind, key, fn)
+		assert.NoError(t, err)
+	})
+
+	assert.Equal(t, int32(3), runs.Load())
+}
+
+func TestCoalesce_CallerCancellationDoesNotFailOthers(t *testing.T) {
+	d := &Datasource{}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (interface{}, error) {
+		close(started)
+		<-release
+		return "result", ctx.Err()
+	}
+
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	leaderErr := make(chan error, 1)
+	go func() {
+		_, _, err := d.coalesce(leaderCtx, coalesceLogs, "key", fn)
+		leaderErr <- err
+	}()
+	<-started
+
+	followerResult := make(chan interface{}, 1)
+	go func() {
+		v, coalesced, err := d.coalesce(context.Background(), coalesceLogs, "key", fn)
+		assert.NoError(t, err)
+		assert.True(t, coalesced)
+		followerResult <- v
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	cancelLeader()
+	assert.ErrorIs(t, <-leaderErr, context.Canceled, "a cancelled caller stops waiting")
+	close(release)
+	assert.Equal(t, "result", <-followerResult, "the shared call is not cancelled with its first caller")
+}
+
+func TestCoalesce_ConcurrentResourceRequestsHitDatadogOnce(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+	srv.SetLatency(fakedatadog.EndpointTagsByMetric, 200*time.Millisecond)
+
+	responses := make([]*backend.CallResourceResponse, 5)
+	runConcurrently(5, func(i int) {
+		responses[i] = callResource(t, d, http.MethodGet, "autocomplete/tags/system.cpu.user", nil)
+	})
+
+	assert.Equal(t, 1, srv.Hits(fakedatadog.EndpointTagsByMetric))
+	for _, resp := range responses {
+		assert.Equal(t, http.StatusOK, resp.Status)
+		assert.JSONEq(t, string(responses[0].Body), string(resp.Body))
+	}
+}
+
+func TestCoalesce_ConcurrentMetricsQueriesHitDatadogOnce(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+	srv.SetLatency(fakedatadog.EndpointTimeseriesQuery, 200*time.Millisecond)
+
+	// One query value shared by all panels, so the time range is identical too.
+	q := dataQuery("A", map[string]interface{}{"queryText": "avg:system.cpu.user{*}"})
+	responses := make([]*backend.QueryDataResponse, 4)
+	runConcurrently(4, func(i int) {
+		resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{Queries: []backend.DataQuery{q}})
+		assert.NoError(t, err)
+		responses[i] = resp
+	})
+
+	assert.Equal(t, 1, srv.Hits(fakedatadog.EndpointTimeseriesQuery))
+	for _, resp := range responses {
+		require.NotNil(t, resp)
+		assert.NoError(t, resp.Responses["A"].Error)
+		assert.NotEmpty(t, resp.Responses["A"].Frames)
+	}
+}
+
+func TestCoalesce_ConcurrentLogsQueriesHitDatadogOnce(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+	srv.SetLatency(fakedatadog.EndpointLogsSearch, 200*time.Millisecond)
+
+	q := dataQuery("L", map[string]interface{}{"queryType": "logs", "logQuery": "service:checkout"})
+	runConcurrently(4, func(i int) {
+		resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{Queries: []backend.DataQuery{q}})
+		assert.NoError(t, err)
+		assert.NoError(t, resp.Responses["L"].Error)
+	})
+
+	assert.Equal(t, 1, srv.Hits(fakedatadog.EndpointLogsSearch))
+}
+
+func TestCoalesce_EditorRoutesAreNotShared(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+	srv.SetLatency(fakedatadog.EndpointNotebooksList, 200*time.Millisecond)
+
+	users := []*backend.User{
+		{Login: "editor1", Role: orgEditorRole},
+		{Login: "viewer", Role: "Viewer"},
+		{Login: "editor2", Role: orgEditorRole},
+		{Login: "viewer2", Role: "Viewer"},
+	}
+	responses := make([]*backend.CallResourceResponse, len(users))
+	runConcurrently(len(users), func(i int) {
+		responses[i] = callResourceAs(t, d, users[i], http.MethodGet, "notebooks")
+	})
+
+	for i, user := range users {
+		if user.Role == orgEditorRole {
+			assert.Equal(t, http.StatusOK, responses[i].Status, user.Login)
+		} else {
+			assert.Equal(t, http.StatusForbidden, responses[i].Status, user.Login)
+		}
+	}
+	assert.Equal(t, 2, srv.Hits(fakedatadog.EndpointNotebooksList), "each editor runs the handler, viewers never call Datadog")
+}
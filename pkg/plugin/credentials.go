@@ -0,0 +1,271 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// A datasource holds up to two Datadog key pairs. Requests use the active pair; when Datadog
+// rejects it with 401 or 403 and the standby pair succeeds, the standby pair becomes active.
+// Datadog answers both invalid keys and keys lacking a permission with 403, so the standby
+// pair is only promoted when the retried request succeeds.
+// Rotating keys without downtime therefore means: put the new pair in the unused slot, let the
+// health check or the credentials/rotate resource validate it, then promote it.
+
+// Names of the key pair slots, used in jsonData.activeKeyPair and in responses.
+const (
+	keyPairPrimary   = "primary"
+	keyPairSecondary = "secondary"
+)
+
+// credentialPair is one Datadog API key and application key.
+type credentialPair struct {
+	name   string
+	apiKey string
+	appKey string
+}
+
+// credentialSet holds the configured key pairs and which one is active.
+type credentialSet struct {
+	mu     sync.RWMutex
+	pairs  []credentialPair
+	active int
+}
+
+// newCredentialSet reads the key pairs from the decrypted secure JSON data. A slot is only
+// configured when both of its keys are set; the primary slot is kept even when empty so that
+// missing credentials are reported as before.
+func newCredentialSet(secure map[string]string, activeKeyPair string) *credentialSet {
+	c := &credentialSet{pairs: []credentialPair{{
+		name:   keyPairPrimary,
+		apiKey: secure["apiKey"],
+		appKey: secure["appKey"],
+	}}}
+	if secure["secondaryApiKey"] != "" && secure["secondaryAppKey"] != "" {
+		c.pairs = append(c.pairs, credentialPair{
+			name:   keyPairSecondary,
+			apiKey: secure["secondaryApiKey"],
+			appKey: secure["secondaryAppKey"],
+		})
+		if activeKeyPair == keyPairSecondary {
+			c.active = 1
+		}
+	}
+	return c
+}
+
+// current returns the active key pair.
+func (c *credentialSet) current() credentialPair {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.pairs[c.active]
+}
+
+// standby returns the configured pair that is not active.
+func (c *credentialSet) standby() (credentialPair, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if len(c.pairs) < 2 {
+		return credentialPair{}, false
+	}
+	return c.pairs[1-c.active], true
+}
+
+// alternative returns the configured pair other than the one carrying apiKey and appKey.
+func (c *credentialSet) alternative(apiKey, appKey string) (credentialPair, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, p := range c.pairs {
+		if p.apiKey != apiKey || p.appKey != appKey {
+			if p.apiKey == "" || p.appKey == "" {
+				return credentialPair{}, false
+			}
+			return p, true
+		}
+	}
+	return credentialPair{}, false
+}
+
+// promote makes the pair called name active. It reports whether the active pair changed.
+func (c *credentialSet) promote(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, p := range c.pairs {
+		if p.name == name {
+			changed := c.active != i
+			c.active = i
+			return changed
+		}
+	}
+	return false
+}
+
+// credentials returns the key pairs of the datasource, reading them on first use.
+func (d *Datasource) credentials() *credentialSet {
+	d.credentialsInit.Do(func() {
+		activeKeyPair := ""
+		if d.JSONData != nil {
+			activeKeyPair = d.JSONData.ActiveKeyPair
+		}
+		d.credentialSet = newCredentialSet(d.SecureJSONData, activeKeyPair)
+	})
+	return d.credentialSet
+}
+
+// activeCredentials returns the API key and application key requests should use.
+func (d *Datasource) activeCredentials() (apiKey, appKey string) {
+	pair := d.credentials().current()
+	return pair.apiKey, pair.appKey
+}
+
+type noCredentialFailoverKey struct{}
+
+// withoutCredentialFailover marks ctx so that requests made with it are not retried with the
+// other key pair. Used when validating one specific pair.
+func withoutCredentialFailover(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noCredentialFailoverKey{}, true)
+}
+
+// credentialFailoverTransport retries requests rejected with 401 or 403 using the other key
+// pair and promotes that pair when the retried request succeeds.
+type credentialFailoverTransport struct {
+	next  http.RoundTripper
+	creds func() *credentialSet
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *credentialFailoverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || !isCredentialRejection(resp.StatusCode) {
+		return resp, err
+	}
+	if disabled, _ := req.Context().Value(noCredentialFailoverKey{}).(bool); disabled {
+		return resp, nil
+	}
+	creds := t.creds()
+	alt, ok := creds.alternative(req.Header.Get("DD-API-KEY"), req.Header.Get("DD-APPLICATION-KEY"))
+	if !ok || (req.Body != nil && req.GetBody == nil) {
+		return resp, nil
+	}
+
+	retry := req.Clone(withRetryAttempt(req.Context()))
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+		retry.Body = body
+	}
+	retry.Header.Set("DD-API-KEY", alt.apiKey)
+	retry.Header.Set("DD-APPLICATION-KEY", alt.appKey)
+
+	altResp, altErr := t.next.RoundTrip(retry)
+	if altErr != nil || altResp.StatusCode < 200 || altResp.StatusCode > 299 {
+		if altErr == nil {
+			drainAndClose(altResp.Body)
+		}
+		return resp, nil
+	}
+	drainAndClose(resp.Body)
+
+	if creds.promote(alt.name) {
+		credentialFailoversTotal.WithLabelValues(alt.name).Inc()
+		log.New().Warn("Datadog rejected the active key pair, switched to the other one",
+			"status", resp.StatusCode, "active", alt.name)
+	}
+	return altResp, nil
+}
+
+// isCredentialRejection reports whether Datadog may have refused the keys of a request.
+func isCredentialRejection(status int) bool {
+	return status == http.StatusUnauthorized || status == http.StatusForbidden
+}
+
+func drainAndClose(body io.ReadCloser) {
+	_, _ = io.Copy(io.Discard, body)
+	body.Close()
+}
+
+// httpClient returns an instrumented HTTP client for Datadog API calls made with the
+// datasource credentials, whose requests fail over to the other key pair and are charged to
+// the request budget.
+func (d *Datasource) httpClient(timeout time.Duration) *http.Client {
+	client := newDatadogHTTPClient(timeout)
+	if d.budget != nil {
+		client.Transport = &budgetTransport{next: client.Transport, budget: d.budget}
+	}
+	client.Transport = &credentialFailoverTransport{next: client.Transport, creds: d.credentials}
+	return client
+}
+
+// CredentialsRotateResponse is the body of credentials/rotate. Capabilities are the results
+// of probing the standby key pair.
+type CredentialsRotateResponse struct {
+	Active       string             `json:"active"`
+	Promoted     bool               `json:"promoted"`
+	Error        string             `json:"error,omitempty"`
+	Capabilities []HealthCapability `json:"capabilities,omitempty"`
+}
+
+// CredentialsRotateHandler handles POST /credentials/rotate requests. It runs the health check
+// probes with the standby key pair and promotes that pair only when all of them pass
+// (409 Conflict otherwise). Organization admins only.
+func (d *Datasource) CredentialsRotateHandler(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	logger := log.New()
+	if ok, err := requireOrgAdmin(req, sender); !ok {
+		return err
+	}
+
+	creds := d.credentials()
+	standby, ok := creds.standby()
+	if !ok {
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusBadRequest,
+			Body:   []byte(`{"error": "no secondary key pair configured"}`),
+		})
+	}
+	_, _, site, err := d.validateCredentials()
+	if err != nil {
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusBadRequest,
+			Body:   []byte(fmt.Sprintf(`{"error": %q}`, err.Error())),
+		})
+	}
+	client, err := d.GetAPIClient()
+	if err != nil {
+		return err
+	}
+
+	probes, capabilities, err := d.probeCapabilities(ctx, client, standby, site)
+	if err != nil {
+		return err
+	}
+	resp := CredentialsRotateResponse{Active: creds.current().name, Capabilities: capabilities}
+	if !allCapabilitiesOK(capabilities) {
+		resp.Error = fmt.Sprintf("the %s key pair failed validation: %s", standby.name,
+			strings.Join(capabilityProblems(probes, capabilities), ", "))
+		logger.Warn("Key pair rotation rejected", "keyPair", standby.name)
+		body, err := json.Marshal(resp)
+		if err != nil {
+			return err
+		}
+		return sender.Send(&backend.CallResourceResponse{Status: http.StatusConflict, Body: body})
+	}
+
+	creds.promote(standby.name)
+	for _, view := range d.views() {
+		view.credentials().promote(standby.name)
+	}
+	resp.Active, resp.Promoted = standby.name, true
+	logger.Info("Key pair rotated", "active", standby.name)
+	return sendJSON(sender, resp)
+}
@@ -0,0 +1,202 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wasilak/grafana-datadog-datasource/pkg/plugin/fakedatadog"
+)
+
+// newFakeBackedDatasourceWithKeys is newFakeBackedDatasource with a primary and a secondary
+// key pair. The fake server only accepts fakedatadog.TestAPIKey/TestAppKey.
+func newFakeBackedDatasourceWithKeys(t *testing.T, primary, secondary [2]string) (*Datasource, *fakedatadog.Server) {
+	t.Helper()
+	srv := fakedatadog.New()
+	t.Cleanup(srv.Close)
+
+	inst, err := NewDatasource(context.Background(), backend.DataSourceInstanceSettings{
+		UID:      "fake-datadog-keys",
+		JSONData: []byte(fmt.Sprintf(`{"site": "datadoghq.com", "baseUrl": %q}`, srv.URL())),
+		DecryptedSecureJSONData: map[string]string{
+			"apiKey":          primary[0],
+			"appKey":          primary[1],
+			"secondaryApiKey": secondary[0],
+			"secondaryAppKey": secondary[1],
+		},
+	})
+	require.NoError(t, err)
+	d := inst.(*Datasource)
+	t.Cleanup(d.Dispose)
+	return d, srv
+}
+
+var (
+	validKeys   = [2]string{fakedatadog.TestAPIKey, fakedatadog.TestAppKey}
+	expiredKeys = [2]string{"expired-api-key", "expired-app-key"}
+)
+
+func TestCredentialSet(t *testing.T) {
+	single := newCredentialSet(map[string]string{"apiKey": "a", "appKey": "b"}, keyPairSecondary)
+	assert.Equal(t, keyPairPrimary, single.current().name, "a secondary pair must be configured to be active")
+	_, ok := single.standby()
+	assert.False(t, ok)
+	_, ok = single.alternative("a", "b")
+	assert.False(t, ok)
+
+	incomplete := newCredentialSet(map[string]string{"apiKey": "a", "appKey": "b", "secondaryApiKey": "c"}, "")
+	_, ok = incomplete.standby()
+	assert.False(t, ok, "a pair needs both keys")
+
+	both := newCredentialSet(map[string]string{"apiKey": "a", "appKey": "b", "secondaryApiKey": "c", "secondaryAppKey": "d"}, keyPairSecondary)
+	assert.Equal(t, keyPairSecondary, both.current().name)
+	standby, ok := both.standby()
+	require.True(t, ok)
+	assert.Equal(t, keyPairPrimary, standby.name)
+	alt, ok := both.alternative("c", "d")
+	require.True(t, ok)
+	assert.Equal(t, keyPairPrimary, alt.name)
+
+	assert.True(t, both.promote(keyPairPrimary))
+	assert.False(t, both.promote(keyPairPrimary), "promoting the active pair is a no-op")
+	assert.Equal(t, "a", both.current().apiKey)
+}
+
+func TestIntegration_CredentialFailover(t *testing.T) {
+	d, srv := newFakeBackedDatasourceWithKeys(t, expiredKeys, validKeys)
+	failoversBefore := testutil.ToFloat64(credentialFailoversTotal.WithLabelValues(keyPairSecondary))
+
+	query := func(q string) {
+		resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+			Queries: []backend.DataQuery{dataQuery("A", map[string]interface{}{"queryText": q})},
+		})
+		require.NoError(t, err)
+		require.NoError(t, resp.Responses["A"].Error)
+		require.Len(t, resp.Responses["A"].Frames, 1)
+	}
+
+	query("avg:system.cpu.user{*}")
+	reqs := srv.Requests(fakedatadog.EndpointTimeseriesQuery)
+	require.Len(t, reqs, 2, "the rejected request is retried once with the other pair")
+	assert.Equal(t, expiredKeys[0], reqs[0].Header.Get("DD-API-KEY"))
+	assert.Equal(t, validKeys[0], reqs[1].Header.Get("DD-API-KEY"))
+	assert.Equal(t, validKeys[1], reqs[1].Header.Get("DD-APPLICATION-KEY"))
+	assert.Equal(t, keyPairSecondary, d.credentials().current().name)
+	assert.Equal(t, failoversBefore+1, testutil.ToFloat64(credentialFailoversTotal.WithLabelValues(keyPairSecondary)))
+
+	// The promoted pair is used from then on, also for raw HTTP calls.
+	srv.Reset()
+	query("avg:system.mem.used{*}")
+	resp := callResource(t, d, http.MethodGet, "autocomplete/logs/services", nil)
+	require.Equal(t, http.StatusOK, resp.Status)
+	for _, r := range srv.Requests("") {
+		assert.Equal(t, validKeys[0], r.Header.Get("DD-API-KEY"), r.Endpoint)
+	}
+}
+
+func TestIntegration_CredentialFailover_BothPairsRejected(t *testing.T) {
+	d, srv := newFakeBackedDatasourceWithKeys(t, expiredKeys, [2]string{"other-api-key", "other-app-key"})
+
+	resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{dataQuery("A", map[string]interface{}{"queryText": "avg:system.cpu.user{*}"})},
+	})
+	require.NoError(t, err)
+	assert.Error(t, resp.Responses["A"].Error)
+	assert.Equal(t, 2, srv.Hits(fakedatadog.EndpointTimeseriesQuery))
+	assert.Equal(t, keyPairPrimary, d.credentials().current().name)
+}
+
+func TestIntegration_CredentialFailover_PromotesOnlyWhenTheOtherPairSucceeds(t *testing.T) {
+	d, srv := newFakeBackedDatasourceWithKeys(t, validKeys, [2]string{"other-api-key", "other-app-key"})
+	// The active pair lacks a permission; the other pair is not accepted either
+	srv.Enqueue(fakedatadog.EndpointTimeseriesQuery, fakedatadog.Error(http.StatusForbidden, "Forbidden"))
+
+	resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{dataQuery("A", map[string]interface{}{"queryText": "avg:system.cpu.user{*}"})},
+	})
+	require.NoError(t, err)
+	assert.Error(t, resp.Responses["A"].Error)
+	assert.Equal(t, 2, srv.Hits(fakedatadog.EndpointTimeseriesQuery), "the request is retried once with the other pair")
+	assert.Equal(t, keyPairPrimary, d.credentials().current().name, "a pair is only promoted when Datadog accepts it")
+}
+
+func TestIntegration_CheckHealth_ValidatesStandbyKeyPair(t *testing.T) {
+	d, _ := newFakeBackedDatasourceWithKeys(t, validKeys, [2]string{"new-api-key", "typo-app-key"})
+
+	res, err := d.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, backend.HealthStatusError, res.Status)
+	assert.Contains(t, res.Message, "Connected to Datadog, but some capabilities are unavailable: secondary key pair")
+
+	var details HealthDetails
+	require.NoError(t, json.Unmarshal(res.JSONDetails, &details))
+	assert.Equal(t, keyPairPrimary, details.KeyPair)
+	assert.True(t, allCapabilitiesOK(details.Capabilities))
+	require.NotNil(t, details.Standby)
+	assert.Equal(t, keyPairSecondary, details.Standby.Name)
+	assert.False(t, details.Standby.OK)
+	assert.Equal(t, keyPairPrimary, d.credentials().current().name, "the health check never promotes a pair")
+}
+
+func TestIntegration_CheckHealth_StandbyKeyPairOK(t *testing.T) {
+	d, _ := newFakeBackedDatasourceWithKeys(t, validKeys, validKeys)
+
+	res, err := d.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, backend.HealthStatusOk, res.Status, res.Message)
+	var details HealthDetails
+	require.NoError(t, json.Unmarshal(res.JSONDetails, &details))
+	require.NotNil(t, details.Standby)
+	assert.True(t, details.Standby.OK)
+}
+
+func TestIntegration_CredentialsRotate(t *testing.T) {
+	d, srv := newFakeBackedDatasourceWithKeys(t, validKeys, [2]string{"new-api-key", "new-app-key"})
+
+	// Only organization admins may rotate.
+	resp := callCacheResource(t, d, "Editor", http.MethodPost, "credentials/rotate", "credentials/rotate", nil)
+	assert.Equal(t, http.StatusForbidden, resp.Status)
+
+	// The new pair is not accepted by Datadog yet: nothing changes.
+	resp = callCacheResource(t, d, orgAdminRole, http.MethodPost, "credentials/rotate", "credentials/rotate", nil)
+	require.Equal(t, http.StatusConflict, resp.Status)
+	var rejected CredentialsRotateResponse
+	require.NoError(t, json.Unmarshal(resp.Body, &rejected))
+	assert.False(t, rejected.Promoted)
+	assert.Equal(t, keyPairPrimary, rejected.Active)
+	assert.Contains(t, rejected.Error, "the secondary key pair failed validation")
+	assert.Equal(t, keyPairPrimary, d.credentials().current().name)
+
+	// Once Datadog accepts the new pair it is promoted.
+	srv.SetCredentials("new-api-key", "new-app-key")
+	resp = callCacheResource(t, d, orgAdminRole, http.MethodPost, "credentials/rotate", "credentials/rotate", nil)
+	require.Equal(t, http.StatusOK, resp.Status, string(resp.Body))
+	var promoted CredentialsRotateResponse
+	require.NoError(t, json.Unmarshal(resp.Body, &promoted))
+	assert.True(t, promoted.Promoted)
+	assert.Equal(t, keyPairSecondary, promoted.Active)
+	assert.Len(t, promoted.Capabilities, 7)
+
+	srv.Reset()
+	res, err := d.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+	require.NoError(t, err)
+	reqs := srv.Requests(fakedatadog.EndpointTimeseriesQuery)
+	require.Len(t, reqs, 2, "active and standby pairs are both probed")
+	assert.Equal(t, "new-api-key", reqs[0].Header.Get("DD-API-KEY"))
+	var details HealthDetails
+	require.NoError(t, json.Unmarshal(res.JSONDetails, &details))
+	assert.Equal(t, keyPairSecondary, details.KeyPair)
+}
+
+func TestIntegration_CredentialsRotate_NoSecondaryPair(t *testing.T) {
+	d, _ := newFakeBackedDatasource(t)
+	resp := callCacheResource(t, d, orgAdminRole, http.MethodPost, "credentials/rotate", "credentials/rotate", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.Status)
+}
@@ -0,0 +1,603 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV1"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// Datadog dashboard import. POST dashboards/import converts a Datadog dashboard, fetched by ID
+// or given as exported JSON, into a Grafana dashboard model whose panels query this
+// datasource. Widgets, queries and layouts without a Grafana counterpart are listed as
+// warnings, so that the result can be checked before it is saved.
+
+// pluginID is the plugin id, the datasource type of the imported panels.
+const pluginID = "wasilak-datadog-datasource"
+
+// Grafana grid units per Datadog grid unit. Datadog's ordered layout is 12 columns wide and
+// Grafana's 24; a Datadog row is about four Grafana rows high.
+const (
+	gridWidthScale  = 2
+	gridHeightScale = 4
+	// gridColumns is the width of the Grafana grid
+	gridColumns = 24
+)
+
+// DashboardImportRequest is the body of POST dashboards/import: the ID of a dashboard to
+// fetch from Datadog, or an exported dashboard.
+type DashboardImportRequest struct {
+	DashboardID string          `json:"dashboardId,omitempty"`
+	Dashboard   json.RawMessage `json:"dashboard,omitempty"`
+}
+
+// DashboardImportResponse is the converted dashboard and what could not be converted.
+type DashboardImportResponse struct {
+	Dashboard *grafanaDashboard `json:"dashboard"`
+	Warnings  []string          `json:"warnings"`
+}
+
+// ddDashboard holds the parts of a Datadog dashboard the import converts.
+type ddDashboard struct {
+	Title             string               `json:"title"`
+	Description       string               `json:"description"`
+	LayoutType        string               `json:"layout_type"`
+	Widgets           []ddWidget           `json:"widgets"`
+	TemplateVariables []ddTemplateVariable `json:"template_variables"`
+}
+
+type ddWidget struct {
+	Definition ddWidgetDefinition `json:"definition"`
+	Layout     *ddWidgetLayout    `json:"layout"`
+}
+
+type ddWidgetLayout struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+type ddWidgetDefinition struct {
+	Type  string `json:"type"`
+	Title string `json:"title"`
+	// Requests is a list for the widgets the import converts, an object for some others
+	Requests json.RawMessage `json:"requests"`
+	// Widgets are the widgets of a group
+	Widgets []ddWidget `json:"widgets"`
+	// Query and Indexes are the search of a log_stream widget
+	Query   string   `json:"query"`
+	Indexes []string `json:"indexes"`
+	// Content is the text of a note widget
+	Content string `json:"content"`
+}
+
+type ddRequest struct {
+	// Q is the query of requests written before formulas and functions
+	Q          string      `json:"q"`
+	Aggregator string      `json:"aggregator"`
+	Queries    []ddQuery   `json:"queries"`
+	Formulas   []ddFormula `json:"formulas"`
+}
+
+type ddQuery struct {
+	DataSource string `json:"data_source"`
+	Name       string `json:"name"`
+	Query      string `json:"query"`
+	Aggregator string `json:"aggregator"`
+}
+
+type ddFormula struct {
+	Formula string `json:"formula"`
+	Alias   string `json:"alias"`
+	Limit   *struct {
+		Count int    `json:"count"`
+		Order string `json:"order"`
+	} `json:"limit"`
+}
+
+type ddTemplateVariable struct {
+	Name            string   `json:"name"`
+	Prefix          string   `json:"prefix"`
+	Default         string   `json:"default"`
+	Defaults        []string `json:"defaults"`
+	AvailableValues []string `json:"available_values"`
+}
+
+// grafanaDashboard is the Grafana dashboard model the import returns.
+type grafanaDashboard struct {
+	Title         string            `json:"title"`
+	Description   string            `json:"description,omitempty"`
+	Tags          []string          `json:"tags"`
+	Editable      bool              `json:"editable"`
+	SchemaVersion int               `json:"schemaVersion"`
+	Time          grafanaTimeRange  `json:"time"`
+	Templating    grafanaTemplating `json:"templating"`
+	Panels        []grafanaPanel    `json:"panels"`
+}
+
+type grafanaTimeRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type grafanaTemplating struct {
+	List []grafanaVariable `json:"list"`
+}
+
+type grafanaVariable struct {
+	Name       string                `json:"name"`
+	Type       string                `json:"type"`
+	Datasource *grafanaDatasourceRef `json:"datasource,omitempty"`
+	Query      interface{}           `json:"query"`
+	Refresh    int                   `json:"refresh,omitempty"`
+	IncludeAll bool                  `json:"includeAll,omitempty"`
+	AllValue   string                `json:"allValue,omitempty"`
+	Multi      bool                  `json:"multi,omitempty"`
+	Current    *grafanaVariableValue `json:"current,omitempty"`
+}
+
+type grafanaVariableValue struct {
+	Text  interface{} `json:"text"`
+	Value interface{} `json:"value"`
+}
+
+type grafanaDatasourceRef struct {
+	Type string `json:"type"`
+	UID  string `json:"uid"`
+}
+
+type grafanaGridPos struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+type grafanaPanel struct {
+	ID         int                    `json:"id"`
+	Type       string                 `json:"type"`
+	Title      string                 `json:"title"`
+	GridPos    grafanaGridPos         `json:"gridPos"`
+	Datasource *grafanaDatasourceRef  `json:"datasource,omitempty"`
+	Targets    []grafanaTarget        `json:"targets,omitempty"`
+	Options    map[string]interface{} `json:"options,omitempty"`
+	Collapsed  bool                   `json:"collapsed,omitempty"`
+}
+
+// grafanaTarget is a panel query: a QueryModel with its refID and datasource.
+type grafanaTarget struct {
+	RefID      string                `json:"refId"`
+	Datasource *grafanaDatasourceRef `json:"datasource"`
+	QueryModel
+}
+
+// ddReducers maps Datadog aggregators onto the Grafana reducers of stat and bar gauge panels.
+var ddReducers = map[string]string{
+	"avg":        "mean",
+	"last":       "lastNotNull",
+	"sum":        "sum",
+	"min":        "min",
+	"max":        "max",
+	"percentile": "p95",
+}
+
+// templateVariablePattern matches template variable references: $name and $name.value.
+var templateVariablePattern = regexp.MustCompile(`\$(\w+)(\.value)?`)
+
+// formulaNamePattern matches the identifiers of a formula: query names and functions.
+var formulaNamePattern = regexp.MustCompile(`\b[A-Za-z_]\w*\b`)
+
+// dashboardConverter converts one Datadog dashboard.
+type dashboardConverter struct {
+	datasource *grafanaDatasourceRef
+	// prefixes holds the tag prefix of each template variable
+	prefixes map[string]string
+	// useLayout is false for free layouts, whose pixel positions are not kept
+	useLayout bool
+	warnings  []string
+	nextID    int
+	// flowX and flowY place widgets without a layout; bottom is the lowest edge so far
+	flowX, flowY, bottom int
+}
+
+// convertDashboard converts a Datadog dashboard for the datasource with the given UID.
+func convertDashboard(dd ddDashboard, datasourceUID string) (*grafanaDashboard, []string) {
+	c := &dashboardConverter{
+		datasource: &grafanaDatasourceRef{Type: pluginID, UID: datasourceUID},
+		prefixes:   map[string]string{},
+		useLayout:  dd.LayoutType != "free",
+	}
+	if !c.useLayout {
+		c.warn("the free layout is not kept; widgets are placed in rows")
+	}
+
+	dashboard := &grafanaDashboard{
+		Title:         dd.Title,
+		Description:   dd.Description,
+		Tags:          []string{"datadog-import"},
+		Editable:      true,
+		SchemaVersion: 39,
+		Time:          grafanaTimeRange{From: "now-1h", To: "now"},
+		Templating:    grafanaTemplating{List: []grafanaVariable{}},
+		Panels:        []grafanaPanel{},
+	}
+	for _, tv := range dd.TemplateVariables {
+		c.prefixes[tv.Name] = tv.Prefix
+		dashboard.Templating.List = append(dashboard.Templating.List, c.variable(tv))
+	}
+	dashboard.Panels = c.widgets(dd.Widgets, 0)
+	return dashboard, c.warnings
+}
+
+func (c *dashboardConverter) warn(format string, args ...interface{}) {
+	c.warnings = append(c.warnings, fmt.Sprintf(format, args...))
+}
+
+// variable converts a template variable. Variables with a tag prefix list the values of the
+// tag; variables with available values list those; others are text boxes.
+func (c *dashboardConverter) variable(tv ddTemplateVariable) grafanaVariable {
+	defaults := tv.Defaults
+	if len(defaults) == 0 && tv.Default != "" {
+		defaults = []string{tv.Default}
+	}
+	v := grafanaVariable{Name: tv.Name, Multi: len(defaults) > 1}
+
+	switch {
+	case len(tv.AvailableValues) > 0:
+		v.Type, v.Query = "custom", strings.Join(tv.AvailableValues, ",")
+		v.IncludeAll, v.AllValue = true, "*"
+	case tv.Prefix != "":
+		v.Type, v.Datasource, v.Refresh = "query", c.datasource, 1
+		v.Query = map[string]string{"queryType": "tag_values", "metricName": "*", "tagKey": tv.Prefix}
+		v.IncludeAll, v.AllValue = true, "*"
+	default:
+		v.Type, v.Query = "textbox", tv.Default
+	}
+
+	switch {
+	case v.IncludeAll && (len(defaults) == 0 || (len(defaults) == 1 && defaults[0] == "*")):
+		v.Current = &grafanaVariableValue{Text: "All", Value: "$__all"}
+	case v.Multi:
+		v.Current = &grafanaVariableValue{Text: defaults, Value: defaults}
+	case len(defaults) == 1:
+		v.Current = &grafanaVariableValue{Text: defaults[0], Value: defaults[0]}
+	}
+	return v
+}
+
+// rewriteVariables rewrites Datadog template variable references for Grafana. In Datadog,
+// $env stands for "<prefix>:<value>" and $env.value for the value alone; in Grafana the
+// variable is always the value.
+func (c *dashboardConverter) rewriteVariables(query string) string {
+	return templateVariablePattern.ReplaceAllStringFunc(query, func(ref string) string {
+		m := templateVariablePattern.FindStringSubmatch(ref)
+		prefix, ok := c.prefixes[m[1]]
+		switch {
+		case !ok:
+			return ref
+		case m[2] != "" || prefix == "":
+			return "$" + m[1]
+		}
+		return prefix + ":$" + m[1]
+	})
+}
+
+// widgets converts the widgets of the dashboard or of a group whose content starts at offsetY.
+func (c *dashboardConverter) widgets(widgets []ddWidget, offsetY int) []grafanaPanel {
+	var panels []grafanaPanel
+	for _, w := range widgets {
+		def := w.Definition
+		if def.Type == "group" {
+			row := c.panel("row", def.Title, c.gridPos(w.Layout, offsetY, gridColumns, 1, true))
+			panels = append(panels, row)
+			c.flowX, c.flowY = 0, row.GridPos.Y+1
+			panels = append(panels, c.widgets(def.Widgets, row.GridPos.Y+1)...)
+			c.flowX, c.flowY = 0, c.bottom
+			continue
+		}
+		panels = append(panels, c.widget(w, offsetY))
+	}
+	return panels
+}
+
+// widget converts one widget into a panel.
+func (c *dashboardConverter) widget(w ddWidget, offsetY int) grafanaPanel {
+	def := w.Definition
+	title := def.Title
+	if title == "" {
+		title = def.Type
+	}
+
+	switch def.Type {
+	case "timeseries":
+		p := c.panel("timeseries", def.Title, c.gridPos(w.Layout, offsetY, 12, 8, false))
+		p.Targets, _ = c.metricsTargets(title, def.Requests)
+		return p
+	case "query_value":
+		p := c.panel("stat", def.Title, c.gridPos(w.Layout, offsetY, 6, 4, false))
+		targets, aggregator := c.metricsTargets(title, def.Requests)
+		p.Targets = targets
+		p.Options = map[string]interface{}{"reduceOptions": reduceOptions(aggregator)}
+		return p
+	case "toplist":
+		p := c.panel("bargauge", def.Title, c.gridPos(w.Layout, offsetY, 12, 8, false))
+		targets, aggregator := c.metricsTargets(title, def.Requests)
+		p.Targets = targets
+		p.Options = map[string]interface{}{
+			"orientation":   "horizontal",
+			"displayMode":   "basic",
+			"reduceOptions": reduceOptions(aggregator),
+		}
+		return p
+	case "log_stream":
+		p := c.panel("logs", def.Title, c.gridPos(w.Layout, offsetY, 24, 10, false))
+		p.Targets = []grafanaTarget{c.target("A", QueryModel{
+			QueryType: "logs",
+			LogQuery:  c.rewriteVariables(def.Query),
+			Indexes:   def.Indexes,
+		})}
+		p.Options = map[string]interface{}{"showTime": true, "sortOrder": "Descending"}
+		return p
+	case "note":
+		p := c.panel("text", "", c.gridPos(w.Layout, offsetY, 6, 4, false))
+		p.Datasource = nil
+		p.Options = map[string]interface{}{"mode": "markdown", "content": def.Content}
+		return p
+	}
+
+	c.warn("widget %q: %s widgets are not supported; a text panel takes its place", title, def.Type)
+	p := c.panel("text", def.Title, c.gridPos(w.Layout, offsetY, 12, 8, false))
+	p.Datasource = nil
+	p.Options = map[string]interface{}{
+		"mode":    "markdown",
+		"content": fmt.Sprintf("Datadog `%s` widget: not imported.", def.Type),
+	}
+	return p
+}
+
+func (c *dashboardConverter) panel(panelType, title string, pos grafanaGridPos) grafanaPanel {
+	c.nextID++
+	p := grafanaPanel{ID: c.nextID, Type: panelType, Title: title, GridPos: pos}
+	if panelType != "row" {
+		p.Datasource = c.datasource
+	}
+	return p
+}
+
+func (c *dashboardConverter) target(refID string, qm QueryModel) grafanaTarget {
+	return grafanaTarget{RefID: refID, Datasource: c.datasource, QueryModel: qm}
+}
+
+// metricsTargets converts the requests of a metrics widget into panel queries. It also returns
+// the aggregator of the first query, for widgets that show one value per series.
+func (c *dashboardConverter) metricsTargets(title string, raw json.RawMessage) ([]grafanaTarget, string) {
+	var requests []ddRequest
+	if err := json.Unmarshal(raw, &requests); err != nil {
+		c.warn("widget %q: requests could not be read; the panel has no queries", title)
+		return nil, ""
+	}
+
+	var targets []grafanaTarget
+	aggregator := ""
+	for _, req := range requests {
+		if aggregator == "" {
+			aggregator = req.Aggregator
+		}
+		if req.Q != "" {
+			targets = append(targets, c.target(refIDFor(len(targets)), QueryModel{QueryText: c.rewriteVariables(req.Q)}))
+			continue
+		}
+
+		// Queries are referenced by name in formulas; queries that are not imported have no refID
+		refIDs := map[string]string{}
+		first := len(targets)
+		for _, q := range req.Queries {
+			if q.DataSource != "metrics" {
+				c.warn("widget %q: query %q uses the %s data source, which is not imported", title, q.Name, q.DataSource)
+				refIDs[q.Name] = ""
+				continue
+			}
+			if aggregator == "" {
+				aggregator = q.Aggregator
+			}
+			refID := refIDFor(len(targets))
+			refIDs[q.Name] = refID
+			targets = append(targets, c.target(refID, QueryModel{QueryText: c.rewriteVariables(q.Query)}))
+		}
+
+		shown := map[string]bool{}
+		for _, f := range req.Formulas {
+			expr := strings.TrimSpace(f.Formula)
+			var qm *QueryModel
+			if refID := refIDs[expr]; refID != "" {
+				// A formula naming one query shows that query
+				for i := first; i < len(targets); i++ {
+					if targets[i].RefID == refID {
+						qm = &targets[i].QueryModel
+					}
+				}
+				shown[refID] = true
+			} else {
+				expression, ok := c.formulaExpression(title, expr, refIDs)
+				if !ok {
+					continue
+				}
+				targets = append(targets, c.target(refIDFor(len(targets)), QueryModel{Type: "math", Expression: expression}))
+				qm = &targets[len(targets)-1].QueryModel
+			}
+			if f.Alias != "" {
+				qm.LegendMode, qm.LegendTemplate = "custom", f.Alias
+			}
+			if f.Limit != nil && f.Limit.Count > 0 {
+				qm.Limit, qm.Order = f.Limit.Count, f.Limit.Order
+			}
+		}
+		if len(req.Formulas) > 0 && len(shown) < len(targets)-first {
+			c.warn("widget %q: queries used only in formulas are shown as series of their own", title)
+		}
+	}
+	return targets, aggregator
+}
+
+// formulaExpression rewrites a Datadog formula over query names into a Grafana expression
+// over refIDs, such as "query1 * 100 / query2" into "$A * 100 / $B".
+func (c *dashboardConverter) formulaExpression(title, formula string, refIDs map[string]string) (string, bool) {
+	ok := true
+	expression := formulaNamePattern.ReplaceAllStringFunc(formula, func(name string) string {
+		refID, found := refIDs[name]
+		switch {
+		case !found:
+			return name
+		case refID == "":
+			ok = false
+		}
+		return "$" + refID
+	})
+	if !ok {
+		c.warn("widget %q: formula %q uses queries that are not imported", title, formula)
+	}
+	return expression, ok
+}
+
+// gridPos places a panel: at its Datadog position when the dashboard layout is kept, in rows
+// of panels of the default size otherwise. A row panel always starts a new line.
+func (c *dashboardConverter) gridPos(layout *ddWidgetLayout, offsetY, w, h int, row bool) grafanaGridPos {
+	var pos grafanaGridPos
+	switch {
+	case layout != nil && c.useLayout:
+		pos = grafanaGridPos{
+			X: layout.X * gridWidthScale,
+			Y: offsetY + layout.Y*gridHeightScale,
+			W: max(layout.Width*gridWidthScale, 1),
+			H: max(layout.Height*gridHeightScale, 1),
+		}
+		if row {
+			pos.X, pos.W, pos.H = 0, gridColumns, 1
+		}
+	default:
+		if row || c.flowX+w > gridColumns {
+			c.flowX, c.flowY = 0, c.bottom
+		}
+		pos = grafanaGridPos{X: c.flowX, Y: c.flowY, W: w, H: h}
+		c.flowX += w
+	}
+	c.bottom = max(c.bottom, pos.Y+pos.H)
+	return pos
+}
+
+// reduceOptions returns the reduce options of a panel showing one value per series.
+func reduceOptions(aggregator string) map[string]interface{} {
+	reducer, ok := ddReducers[aggregator]
+	if !ok {
+		reducer = "mean"
+	}
+	return map[string]interface{}{"calcs": []string{reducer}, "values": false}
+}
+
+// refIDFor returns the refID of the i-th query of a panel: A, B, ..., Z, AA, AB, ...
+func refIDFor(i int) string {
+	if i < 26 {
+		return string(rune('A' + i))
+	}
+	return refIDFor(i/26-1) + string(rune('A'+i%26))
+}
+
+// DashboardImportHandler converts a Datadog dashboard into a Grafana dashboard model.
+func (d *Datasource) DashboardImportHandler(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	logger := log.New()
+	if ok, err := requireEditor(req, sender); !ok {
+		return err
+	}
+	var importReq DashboardImportRequest
+	if err := json.Unmarshal(req.Body, &importReq); err != nil ||
+		(importReq.DashboardID == "") == (len(importReq.Dashboard) == 0) {
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusBadRequest,
+			Body:   []byte(`{"error": "send either dashboardId or an exported dashboard"}`),
+		})
+	}
+	// The key reads every dashboard of the organization, which a policy does not scope
+	policy := d.policy()
+	if importReq.DashboardID != "" && policy.restricts() {
+		return sendPolicyViolation(sender, violationf("fetching Datadog dashboards; import an exported dashboard instead"))
+	}
+
+	raw := []byte(importReq.Dashboard)
+	if importReq.DashboardID != "" {
+		fetched, status, err := d.fetchDashboard(ctx, importReq.DashboardID)
+		if err != nil {
+			logger.Warn("Failed to fetch Datadog dashboard", "dashboardId", importReq.DashboardID, "error", err)
+			return sender.Send(&backend.CallResourceResponse{
+				Status: status,
+				Body:   []byte(fmt.Sprintf(`{"error": %q}`, err.Error())),
+			})
+		}
+		raw = fetched
+	}
+
+	var dd ddDashboard
+	if err := json.Unmarshal(raw, &dd); err != nil {
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusBadRequest,
+			Body:   []byte(fmt.Sprintf(`{"error": %q}`, "invalid Datadog dashboard: "+err.Error())),
+		})
+	}
+	dashboard, warnings := convertDashboard(dd, d.InstanceSettings.UID)
+	for i := range dashboard.Panels {
+		panel := &dashboard.Panels[i]
+		var refused []string
+		panel.Targets, refused = policy.checkTargets(fmt.Sprintf("panel %q", panel.Title), panel.Targets)
+		warnings = append(warnings, refused...)
+	}
+	if warnings == nil {
+		warnings = []string{}
+	}
+	logger.Info("Datadog dashboard imported", "title", dd.Title, "panels", len(dashboard.Panels), "warnings", len(warnings))
+	return sendJSON(sender, DashboardImportResponse{Dashboard: dashboard, Warnings: warnings})
+}
+
+// fetchDashboard fetches a dashboard from Datadog. On failure it returns the status to answer
+// with: 404 for unknown dashboards, 502 for other Datadog errors.
+func (d *Datasource) fetchDashboard(ctx context.Context, id string) ([]byte, int, error) {
+	client, err := d.GetAPIClient()
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	ddCtx, err := d.GetDatadogContext(ctx)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	ddCtx, cancel := context.WithTimeout(ddCtx, 30*time.Second)
+	defer cancel()
+
+	dashboard, r, err := datadogV1.NewDashboardsApi(client).GetDashboard(ddCtx, id)
+	if err != nil {
+		httpStatus, responseBody := 0, ""
+		if r != nil {
+			httpStatus = r.StatusCode
+			if r.Body != nil {
+				bodyBytes, _ := io.ReadAll(r.Body)
+				responseBody = string(bodyBytes)
+			}
+		}
+		if httpStatus == http.StatusNotFound {
+			return nil, http.StatusNotFound, fmt.Errorf("Datadog dashboard %q not found", id)
+		}
+		return nil, http.StatusBadGateway, errors.New(d.parseDatadogError(err, httpStatus, responseBody))
+	}
+	raw, err := json.Marshal(dashboard)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return raw, http.StatusOK, nil
+}
@@ -0,0 +1,214 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wasilak/grafana-datadog-datasource/pkg/plugin/fakedatadog"
+)
+
+// exportedDashboard is a Datadog dashboard export with one widget of each converted type.
+const exportedDashboard = `{
+  "title": "Checkout",
+  "description": "Checkout service overview",
+  "layout_type": "ordered",
+  "template_variables": [
+    {"name": "env", "prefix": "env", "defaults": ["prod"]},
+    {"name": "host", "prefix": "host", "default": "*"},
+    {"name": "tier", "available_values": ["gold", "silver"], "default": "gold"}
+  ],
+  "widgets": [
+    {"definition": {"type": "timeseries", "title": "Memory used", "requests": [{
+      "queries": [
+        {"data_source": "metrics", "name": "query1", "query": "avg:system.mem.used{$env,$host} by {host}"},
+        {"data_source": "metrics", "name": "query2", "query": "avg:system.mem.total{$env} by {host}"}
+      ],
+      "formulas": [{"formula": "query1 * 100 / query2", "alias": "Used %", "limit": {"count": 10, "order": "desc"}}]
+    }]}, "layout": {"x": 0, "y": 0, "width": 6, "height": 2}},
+    {"definition": {"type": "query_value", "title": "Requests", "requests": [{"q": "sum:trace.http.request.hits{env:$env.value}", "aggregator": "sum"}]},
+     "layout": {"x": 6, "y": 0, "width": 3, "height": 2}},
+    {"definition": {"type": "group", "title": "Hosts", "widgets": [
+      {"definition": {"type": "toplist", "title": "Top CPU", "requests": [{
+        "queries": [
+          {"data_source": "metrics", "name": "query1", "query": "avg:system.cpu.user{$env} by {host}", "aggregator": "last"},
+          {"data_source": "logs", "name": "query2", "search": {"query": "status:error"}}
+        ],
+        "formulas": [{"formula": "query1"}]
+      }]}, "layout": {"x": 0, "y": 0, "width": 6, "height": 2}},
+      {"definition": {"type": "hostmap", "title": "Hosts", "requests": {"fill": {"q": "avg:system.cpu.user{*} by {host}"}}},
+       "layout": {"x": 6, "y": 0, "width": 6, "height": 2}}
+    ]}, "layout": {"x": 0, "y": 2, "width": 12, "height": 3}},
+    {"definition": {"type": "log_stream", "title": "Errors", "query": "service:checkout status:error $env", "indexes": ["main"]},
+     "layout": {"x": 0, "y": 5, "width": 12, "height": 4}}
+  ]
+}`
+
+func TestRefIDFor(t *testing.T) {
+	assert.Equal(t, "A", refIDFor(0))
+	assert.Equal(t, "Z", refIDFor(25))
+	assert.Equal(t, "AA", refIDFor(26))
+	assert.Equal(t, "BA", refIDFor(52))
+}
+
+func TestRewriteVariables(t *testing.T) {
+	c := &dashboardConverter{prefixes: map[string]string{"env": "env", "service": ""}}
+	assert.Equal(t, "avg:cpu{env:$env,host:a}", c.rewriteVariables("avg:cpu{$env,host:a}"))
+	assert.Equal(t, "avg:cpu{region:$env}", c.rewriteVariables("avg:cpu{region:$env.value}"))
+	assert.Equal(t, "avg:cpu{$service}", c.rewriteVariables("avg:cpu{$service}"))
+	assert.Equal(t, "avg:cpu{$unknown}", c.rewriteVariables("avg:cpu{$unknown}"), "only template variables are rewritten")
+}
+
+func TestConvertDashboard(t *testing.T) {
+	var dd ddDashboard
+	require.NoError(t, json.Unmarshal([]byte(exportedDashboard), &dd))
+	dashboard, warnings := convertDashboard(dd, "ds-uid")
+
+	assert.Equal(t, "Checkout", dashboard.Title)
+	ds := &grafanaDatasourceRef{Type: pluginID, UID: "ds-uid"}
+
+	vars := dashboard.Templating.List
+	require.Len(t, vars, 3)
+	assert.Equal(t, "query", vars[0].Type)
+	assert.Equal(t, map[string]string{"queryType": "tag_values", "metricName": "*", "tagKey": "env"}, vars[0].Query)
+	assert.Equal(t, &grafanaVariableValue{Text: "prod", Value: "prod"}, vars[0].Current)
+	assert.Equal(t, &grafanaVariableValue{Text: "All", Value: "$__all"}, vars[1].Current)
+	assert.Equal(t, "custom", vars[2].Type)
+	assert.Equal(t, "gold,silver", vars[2].Query)
+
+	panels := dashboard.Panels
+	require.Len(t, panels, 6)
+	types := make([]string, len(panels))
+	for i, p := range panels {
+		types[i] = p.Type
+	}
+	assert.Equal(t, []string{"timeseries", "stat", "row", "bargauge", "text", "logs"}, types)
+
+	// Formulas become expressions over refIDs, with their alias and limit
+	ts := panels[0]
+	assert.Equal(t, grafanaGridPos{X: 0, Y: 0, W: 12, H: 8}, ts.GridPos)
+	require.Len(t, ts.Targets, 3)
+	assert.Equal(t, "avg:system.mem.used{env:$env,host:$host} by {host}", ts.Targets[0].QueryText)
+	assert.Equal(t, ds, ts.Targets[0].Datasource)
+	assert.Equal(t, "C", ts.Targets[2].RefID)
+	assert.Equal(t, QueryModel{Type: "math", Expression: "$A * 100 / $B", LegendMode: "custom", LegendTemplate: "Used %", Limit: 10, Order: "desc"},
+		ts.Targets[2].QueryModel)
+
+	stat := panels[1]
+	assert.Equal(t, "sum:trace.http.request.hits{env:$env}", stat.Targets[0].QueryText)
+	assert.Equal(t, []string{"sum"}, stat.Options["reduceOptions"].(map[string]interface{})["calcs"])
+
+	// Group content is placed below the row
+	assert.Equal(t, grafanaGridPos{X: 0, Y: 8, W: 24, H: 1}, panels[2].GridPos)
+	top := panels[3]
+	assert.Equal(t, grafanaGridPos{X: 0, Y: 9, W: 12, H: 8}, top.GridPos)
+	require.Len(t, top.Targets, 1)
+	assert.Equal(t, []string{"lastNotNull"}, top.Options["reduceOptions"].(map[string]interface{})["calcs"])
+	assert.Nil(t, panels[4].Datasource)
+
+	logs := panels[5]
+	require.Len(t, logs.Targets, 1)
+	assert.Equal(t, QueryModel{QueryType: "logs", LogQuery: "service:checkout status:error env:$env", Indexes: []string{"main"}}, logs.Targets[0].QueryModel)
+
+	assert.Equal(t, []string{
+		`widget "Memory used": queries used only in formulas are shown as series of their own`,
+		`widget "Top CPU": query "query2" uses the logs data source, which is not imported`,
+		`widget "Hosts": hostmap widgets are not supported; a text panel takes its place`,
+	}, warnings)
+}
+
+func TestConvertDashboard_FreeLayout(t *testing.T) {
+	dd := ddDashboard{LayoutType: "free", Widgets: []ddWidget{
+		{Definition: ddWidgetDefinition{Type: "timeseries", Requests: json.RawMessage(`[{"q": "avg:a{*}"}]`)}, Layout: &ddWidgetLayout{X: 40, Y: 10, Width: 50, Height: 20}},
+		{Definition: ddWidgetDefinition{Type: "timeseries", Requests: json.RawMessage(`[{"q": "avg:b{*}"}]`)}, Layout: &ddWidgetLayout{X: 90, Y: 10, Width: 50, Height: 20}},
+		{Definition: ddWidgetDefinition{Type: "timeseries", Requests: json.RawMessage(`[{"q": "avg:c{*}"}]`)}},
+	}}
+	dashboard, warnings := convertDashboard(dd, "ds-uid")
+	require.Len(t, dashboard.Panels, 3)
+	assert.Equal(t, grafanaGridPos{X: 0, Y: 0, W: 12, H: 8}, dashboard.Panels[0].GridPos)
+	assert.Equal(t, grafanaGridPos{X: 12, Y: 0, W: 12, H: 8}, dashboard.Panels[1].GridPos)
+	assert.Equal(t, grafanaGridPos{X: 0, Y: 8, W: 12, H: 8}, dashboard.Panels[2].GridPos)
+	assert.Contains(t, warnings, "the free layout is not kept; widgets are placed in rows")
+}
+
+// callResourceAsEditor invokes a resource route as an organization editor.
+func callResourceAsEditor(t *testing.T, d *Datasource, method, path string, body []byte) *backend.CallResourceResponse {
+	t.Helper()
+	return callCacheResource(t, d, orgEditorRole, method, path, path, body)
+}
+
+func TestIntegration_DashboardImport(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+
+	var exported map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(exportedDashboard), &exported))
+	exported["id"] = "abc-def-ghi"
+	srv.Enqueue(fakedatadog.EndpointDashboard, fakedatadog.JSON(exported))
+
+	resp := callResourceAsEditor(t, d, http.MethodPost, "dashboards/import", []byte(`{"dashboardId": "abc-def-ghi"}`))
+	require.Equal(t, http.StatusOK, resp.Status, string(resp.Body))
+	reqs := srv.Requests(fakedatadog.EndpointDashboard)
+	require.Len(t, reqs, 1)
+	assert.Equal(t, "/api/v1/dashboard/abc-def-ghi", reqs[0].Path)
+
+	var fetched DashboardImportResponse
+	require.NoError(t, json.Unmarshal(resp.Body, &fetched))
+	assert.Equal(t, "Checkout", fetched.Dashboard.Title)
+	assert.Len(t, fetched.Dashboard.Panels, 6)
+	assert.Len(t, fetched.Warnings, 3)
+
+	// An exported dashboard converts the same without calling Datadog
+	resp = callResourceAsEditor(t, d, http.MethodPost, "dashboards/import", []byte(`{"dashboard": `+exportedDashboard+`}`))
+	require.Equal(t, http.StatusOK, resp.Status, string(resp.Body))
+	var exportedResp DashboardImportResponse
+	require.NoError(t, json.Unmarshal(resp.Body, &exportedResp))
+	assert.Equal(t, fetched, exportedResp)
+	assert.Equal(t, 1, srv.Hits(fakedatadog.EndpointDashboard))
+
+	resp = callResourceAsEditor(t, d, http.MethodPost, "dashboards/import", []byte(`{"dashboardId": "missing"}`))
+	assert.Equal(t, http.StatusNotFound, resp.Status)
+	assert.Contains(t, string(resp.Body), `Datadog dashboard \"missing\" not found`)
+
+	resp = callResourceAsEditor(t, d, http.MethodPost, "dashboards/import", []byte(`{}`))
+	assert.Equal(t, http.StatusBadRequest, resp.Status)
+}
+
+func TestIntegration_DashboardImport_Access(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+	for _, role := range []string{"", "Viewer"} {
+		resp := callCacheResource(t, d, role, http.MethodPost, "dashboards/import", "dashboards/import", []byte(`{"dashboardId": "abc-def-ghi"}`))
+		assert.Equal(t, http.StatusForbidden, resp.Status, role)
+	}
+	assert.Zero(t, srv.Hits(fakedatadog.EndpointDashboard))
+
+	// With a policy, dashboards are not fetched and the queries it refuses are left out
+	d, srv = newFakeBackedDatasourceWithPolicy(t)
+	resp := callResourceAsEditor(t, d, http.MethodPost, "dashboards/import", []byte(`{"dashboardId": "abc-def-ghi"}`))
+	assert.Equal(t, http.StatusForbidden, resp.Status)
+	assert.Zero(t, srv.Hits(fakedatadog.EndpointDashboard))
+
+	resp = callResourceAsEditor(t, d, http.MethodPost, "dashboards/import", []byte(`{"dashboard": `+exportedDashboard+`}`))
+	require.Equal(t, http.StatusOK, resp.Status, string(resp.Body))
+	var got DashboardImportResponse
+	require.NoError(t, json.Unmarshal(resp.Body, &got))
+	for _, panel := range got.Dashboard.Panels {
+		for _, target := range panel.Targets {
+			if target.QueryText != "" {
+				_, err := testPolicy.scopeMetricsQuery(target.QueryText)
+				assert.NoError(t, err, "panel %q", panel.Title)
+			}
+		}
+	}
+	found := false
+	for _, w := range got.Warnings {
+		if strings.Contains(w, "left out: not allowed by the datasource policy") {
+			found = true
+		}
+	}
+	assert.True(t, found, "refused queries are reported: %v", got.Warnings)
+}
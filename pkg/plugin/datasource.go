@@ -3,10 +3,13 @@ package plugin
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
 	"regexp"
 	"sort"
 	"strings"
@@ -18,7 +21,10 @@ import (
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/sync/singleflight"
 )
 
 var (
@@ -40,31 +46,60 @@ type Datasource struct {
 	InstanceSettings      *backend.DataSourceInstanceSettings
 	JSONData              *MyDataSourceOptions
 	SecureJSONData        map[string]string
-	cache                 *AutocompleteCache
-	logsCache             map[string]*LogsCacheEntry             // Separate cache for logs data
-	logsCacheMu           sync.Mutex                             // Mutex for logs cache
-	logsAutocompleteCache map[string]*LogsAutocompleteCacheEntry // Cache for logs autocomplete data
-	logsAutocompleteMu    sync.Mutex                             // Mutex for logs autocomplete cache
+	cache                 Cache // Metric names, tags and tag values for autocomplete
+	logsCache             Cache // Separate cache for logs data
+	logsAutocompleteCache Cache // Cache for logs autocomplete data
+	allTagsCache          Cache // Organization-wide tag listings, on disk if PersistentCache is set
+	metadataCache         Cache // Metric units, types and descriptions (see metric_metadata.go)
 	// API client singleton - reused across all queries for connection pooling
 	apiClient     *datadog.APIClient
 	apiClientInit sync.Once
 	apiClientErr  error
 	// cacheDisabled is read once at init from DISABLE_CACHE env var
 	cacheDisabled bool
+	// inflight coalesces identical concurrent Datadog calls (see coalesce.go)
+	inflight singleflight.Group
+	// metricsCache keeps timeseries results for incremental refresh (see metrics_cache.go)
+	metricsCache *metricsResultCache
+	// janitor removes expired cache entries in the background until Dispose
+	janitor *cacheJanitor
+	// credentialSet holds the primary and secondary key pairs (see credentials.go)
+	credentialSet   *credentialSet
+	credentialsInit sync.Once
+	// userViews serve users with their own application keys (see user_keys.go)
+	userViews userViews
+	// budget limits the Datadog requests made per minute, nil when unlimited (see limits.go)
+	budget *requestBudget
 }
 
 // MyDataSourceOptions defines the JSON options for the datasource
 type MyDataSourceOptions struct {
 	Site string `json:"site"`
-}
-
-// AutocompleteCache stores cached metric and tag data with a bounded LRU eviction policy.
-// maxEntries limits memory growth; when the limit is reached the oldest entry is evicted.
-type AutocompleteCache struct {
-	mu         sync.Mutex
-	entries    map[string]*CacheEntry
-	order      []string // insertion-order slice for LRU eviction
-	maxEntries int      // 0 means unlimited (legacy behaviour)
+	// BaseURL optionally overrides the site-derived API endpoint (https://api.<site>).
+	// It may carry a scheme, host, port and path prefix, e.g. https://dd-proxy.internal:8443/datadog,
+	// which allows routing through PrivateLink/proxy gateways or pointing at a local fake server.
+	BaseURL string `json:"baseUrl,omitempty"`
+	// LogQueries allows query text (metric queries, log searches, autocomplete filters) to be
+	// written to the plugin logs verbatim. Off by default: queries are logged as fingerprints.
+	LogQueries bool `json:"logQueries,omitempty"`
+	// PersistentCache keeps expensive organization-wide tag listings on disk under the Grafana
+	// data directory, so they survive plugin restarts. Other caches always stay in memory.
+	PersistentCache bool `json:"persistentCache,omitempty"`
+	// ActiveKeyPair selects the key pair used first when a secondary pair is configured:
+	// "primary" (default) or "secondary". See credentials.go.
+	ActiveKeyPair string `json:"activeKeyPair,omitempty"`
+	// UserAppKeys makes Datadog calls use the application key of the viewing Grafana user
+	// or team instead of the shared one. See user_keys.go.
+	UserAppKeys *UserAppKeysOptions `json:"userAppKeys,omitempty"`
+	// Policy restricts the metrics, tags and log indexes the datasource may query and adds
+	// mandatory filters to every query. See policy.go.
+	Policy *PolicyOptions `json:"policy,omitempty"`
+	// Limits bounds what a query may cost: series, logs time range and pages, and Datadog
+	// requests per minute. See limits.go.
+	Limits *QueryLimits `json:"limits,omitempty"`
+	// SavedLogViews are named logs searches offered by the logs query editor, such as saved
+	// views copied from Datadog. See saved_queries.go.
+	SavedLogViews []SavedLogView `json:"savedLogViews,omitempty"`
 }
 
 // CacheEntry stores cached data with timestamp for TTL validation
@@ -449,9 +484,23 @@ type QueryModel struct {
 	Type       string `json:"type,omitempty"`       // "math" for math expressions
 	Expression string `json:"expression,omitempty"` // Math expression like "$A*100/$B"
 	// Query options
-	Interval *int64 `json:"interval,omitempty"` // Override interval in milliseconds
+	Interval     *int64 `json:"interval,omitempty"`     // Override interval in milliseconds
+	NullHandling string `json:"nullHandling,omitempty"` // "drop" (default), "auto", "null", "zero", "previous" or "gaps"
+	Format       string `json:"format,omitempty"`       // "multi" (default, one frame per series) or "wide"
+	Limit        int    `json:"limit,omitempty"`        // Keep the top N series; 0 keeps all
+	Order        string `json:"order,omitempty"`        // "desc" (default, top series) or "asc" (bottom series)
+	OrderBy      string `json:"orderBy,omitempty"`      // "avg" (default), "max", "min", "last" or "sum"
+	// Service map query fields
+	Env       string `json:"env,omitempty"`       // APM environment of the service map
+	Service   string `json:"service,omitempty"`   // Only this service and the services it calls or is called by
+	Operation string `json:"operation,omitempty"` // Client span operation of the edge statistics (default "http.client.request")
+	// RUM query fields
+	RUMQuery       string   `json:"rumQuery,omitempty"`       // RUM events search query (all events when empty)
+	RUMAggregation string   `json:"rumAggregation,omitempty"` // Empty to list events; "count", "cardinality", "avg", "pc75", ... to aggregate them into time series
+	RUMMetric      string   `json:"rumMetric,omitempty"`      // Measure or facet aggregated, e.g. "@view.loading_time"
+	RUMGroupBy     []string `json:"rumGroupBy,omitempty"`     // Facets the time series are grouped by, e.g. "@view.url_path"
 	// Logs query fields
-	QueryType string   `json:"queryType,omitempty"` // "logs", "logs-volume", or "metrics" (defaults to "metrics")
+	QueryType string   `json:"queryType,omitempty"` // "logs", "logs-volume", "service-map", "rum" or "metrics" (defaults to "metrics")
 	LogQuery  string   `json:"logQuery,omitempty"`  // Logs search query
 	Indexes   []string `json:"indexes,omitempty"`   // Target log indexes
 	// JSON parsing configuration
@@ -465,14 +514,12 @@ func NewDatasource(ctx context.Context, settings backend.DataSourceInstanceSetti
 	logger := log.New()
 
 	ds := &Datasource{
-		InstanceSettings: &settings,
-		cache: &AutocompleteCache{
-			entries:    make(map[string]*CacheEntry),
-			order:      make([]string, 0, 1000),
-			maxEntries: 1000,
-		},
-		logsCache:             make(map[string]*LogsCacheEntry),             // Initialize logs cache
-		logsAutocompleteCache: make(map[string]*LogsAutocompleteCacheEntry), // Initialize logs autocomplete cache
+		InstanceSettings:      &settings,
+		cache:                 newMemoryCache(cacheAutocomplete, cacheLimits{maxEntries: autocompleteCacheMaxEntries, maxBytes: autocompleteCacheMaxBytes}),
+		logsCache:             newMemoryCache(cacheLogs, cacheLimits{maxEntries: logsCacheMaxEntries, maxBytes: logsCacheMaxBytes}),
+		logsAutocompleteCache: newMemoryCache(cacheLogsAutocomplete, cacheLimits{maxEntries: logsAutocompleteCacheMaxEntries, maxBytes: logsAutocompleteCacheMaxBytes}),
+		metadataCache:         newMemoryCache(cacheMetricMetadata, cacheLimits{maxEntries: metricMetadataCacheMaxEntries, maxBytes: metricMetadataCacheMaxBytes}),
+		metricsCache:          newMetricsResultCache(newMemoryCache(cacheMetrics, cacheLimits{maxEntries: metricsCacheMaxEntries, maxBytes: metricsCacheMaxBytes})),
 		cacheDisabled:         os.Getenv("DISABLE_CACHE") == "true",
 	}
 
@@ -483,6 +530,11 @@ func NewDatasource(ctx context.Context, settings backend.DataSourceInstanceSetti
 		return nil, fmt.Errorf("failed to parse JSONData: %w", err)
 	}
 	ds.JSONData = &opts
+	ds.allTagsCache = newAllTagsCache(logger, settings.UID, opts.PersistentCache)
+	if opts.Limits != nil {
+		ds.budget = newRequestBudget(opts.Limits.RequestsPerMinute)
+	}
+	ds.startCacheJanitor(cacheJanitorInterval)
 
 	// Get secure JSON data (API keys)
 	ds.SecureJSONData = settings.DecryptedSecureJSONData
@@ -494,6 +546,15 @@ func NewDatasource(ctx context.Context, settings backend.DataSourceInstanceSetti
 			"site", opts.Site,
 			"expected", "e.g. datadoghq.com, datadoghq.eu, us3.datadoghq.com")
 	}
+	if err := validateBaseURL(opts.BaseURL); err != nil {
+		logger.Warn("Configured base URL is invalid; queries will fail", "baseUrl", opts.BaseURL, "error", err)
+	}
+	if err := opts.Limits.validate(); err != nil {
+		logger.Warn("Configured limits are invalid and will be ignored", "error", err)
+	}
+	if err := opts.Policy.validate(); err != nil {
+		logger.Warn("Configured policy is invalid; restricted queries will be refused", "error", err)
+	}
 
 	logger.Info("Datasource initialized successfully", "site", opts.Site, "uid", settings.UID)
 
@@ -502,7 +563,36 @@ func NewDatasource(ctx context.Context, settings backend.DataSourceInstanceSetti
 
 // Dispose disposes of the datasource instance
 func (d *Datasource) Dispose() {
-	// Cleanup if needed
+	for _, view := range d.views() {
+		view.Dispose()
+	}
+	d.stopCacheJanitor()
+	for _, c := range []Cache{d.cache, d.logsCache, d.logsAutocompleteCache, d.allTagsCache, d.metadataCache} {
+		if c != nil {
+			_ = c.Close()
+		}
+	}
+	if d.metricsCache != nil {
+		_ = d.metricsCache.store.Close()
+	}
+}
+
+// newAllTagsCache returns the cache for organization-wide tag listings: on disk when
+// persistent caching is enabled and the cache directory is usable, in memory otherwise.
+func newAllTagsCache(logger log.Logger, uid string, persistent bool) Cache {
+	limits := cacheLimits{maxEntries: allTagsCacheMaxEntries, maxBytes: allTagsCacheMaxBytes}
+	if persistent {
+		dir, err := persistentCacheDir(uid)
+		if err == nil {
+			var c *diskCache
+			if c, err = newDiskCache(cacheAllTags, filepath.Join(dir, "all-tags"), limits); err == nil {
+				logger.Debug("Using persistent tag cache", "dir", dir, "entries", c.Len())
+				return c
+			}
+		}
+		logger.Warn("Persistent cache unavailable, caching tags in memory", "error", err)
+	}
+	return newMemoryCache(cacheAllTags, limits)
 }
 
 // GetAPIClient returns a singleton Datadog API client
@@ -512,14 +602,12 @@ func (d *Datasource) GetAPIClient() (*datadog.APIClient, error) {
 		logger := log.New()
 
 		// Get API credentials
-		apiKey, ok := d.SecureJSONData["apiKey"]
-		if !ok || apiKey == "" {
+		apiKey, appKey := d.activeCredentials()
+		if apiKey == "" {
 			d.apiClientErr = fmt.Errorf("missing API key")
 			return
 		}
-
-		appKey, ok := d.SecureJSONData["appKey"]
-		if !ok || appKey == "" {
+		if appKey == "" {
 			d.apiClientErr = fmt.Errorf("missing App key")
 			return
 		}
@@ -532,6 +620,14 @@ func (d *Datasource) GetAPIClient() (*datadog.APIClient, error) {
 
 		// Create configuration
 		configuration := datadog.NewConfiguration()
+		configuration.HTTPClient = d.httpClient(0)
+
+		// A custom base URL replaces every server template, including the per-operation
+		// overrides, so all generated API calls go to the same endpoint as raw HTTP calls.
+		if baseURL := d.customBaseURL(); baseURL != "" {
+			configuration.Servers = datadog.ServerConfigurations{{URL: baseURL}}
+			configuration.OperationServers = map[string]datadog.ServerConfigurations{}
+		}
 
 		// Create API client
 		d.apiClient = datadog.NewAPIClient(configuration)
@@ -540,7 +636,7 @@ func (d *Datasource) GetAPIClient() (*datadog.APIClient, error) {
 		// Note: Credentials are stored in context at call time, not in the client
 		d.apiClientErr = nil
 
-		logger.Debug("Datadog API client created", "site", site)
+		logger.Debug("Datadog API client created", "site", site, "baseUrl", d.customBaseURL())
 	})
 
 	return d.apiClient, d.apiClientErr
@@ -549,13 +645,16 @@ func (d *Datasource) GetAPIClient() (*datadog.APIClient, error) {
 // GetDatadogContext returns a context with Datadog credentials and site configured
 // This should be used for all Datadog API calls
 func (d *Datasource) GetDatadogContext(ctx context.Context) (context.Context, error) {
-	apiKey, ok := d.SecureJSONData["apiKey"]
-	if !ok || apiKey == "" {
+	return d.datadogContext(ctx, d.credentials().current())
+}
+
+// datadogContext returns a context carrying the given key pair and the configured site.
+func (d *Datasource) datadogContext(ctx context.Context, pair credentialPair) (context.Context, error) {
+	apiKey, appKey := pair.apiKey, pair.appKey
+	if apiKey == "" {
 		return nil, fmt.Errorf("missing API key")
 	}
-
-	appKey, ok := d.SecureJSONData["appKey"]
-	if !ok || appKey == "" {
+	if appKey == "" {
 		return nil, fmt.Errorf("missing App key")
 	}
 
@@ -585,13 +684,11 @@ func (d *Datasource) GetDatadogContext(ctx context.Context) (context.Context, er
 // configuration. It returns a clear error when credentials are missing or the site format
 // is invalid, avoiding duplication of this boilerplate across every resource handler.
 func (d *Datasource) validateCredentials() (apiKey, appKey, site string, err error) {
-	var ok bool
-	apiKey, ok = d.SecureJSONData["apiKey"]
-	if !ok || apiKey == "" {
+	apiKey, appKey = d.activeCredentials()
+	if apiKey == "" {
 		return "", "", "", fmt.Errorf("missing API key")
 	}
-	appKey, ok = d.SecureJSONData["appKey"]
-	if !ok || appKey == "" {
+	if appKey == "" {
 		return "", "", "", fmt.Errorf("missing App key")
 	}
 	site = "datadoghq.com" // default
@@ -601,9 +698,51 @@ func (d *Datasource) validateCredentials() (apiKey, appKey, site string, err err
 	if !reValidSite.MatchString(site) {
 		return "", "", "", fmt.Errorf("invalid site format %q: must be a valid hostname (e.g. datadoghq.com, datadoghq.eu, us3.datadoghq.com)", site)
 	}
+	if d.JSONData != nil {
+		if err := validateBaseURL(d.JSONData.BaseURL); err != nil {
+			return "", "", "", err
+		}
+	}
 	return apiKey, appKey, site, nil
 }
 
+// validateBaseURL checks that an optional base URL override is an absolute http(s) URL.
+// An empty value is valid and means the site-derived endpoint is used.
+func validateBaseURL(baseURL string) error {
+	if baseURL == "" {
+		return nil
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return fmt.Errorf("invalid base URL %q: %w", baseURL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid base URL %q: must be an absolute http(s) URL (e.g. https://dd-proxy.internal:8443/datadog)", baseURL)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("invalid base URL %q: query strings and fragments are not supported", baseURL)
+	}
+	return nil
+}
+
+// customBaseURL returns the configured base URL override without a trailing slash,
+// or an empty string when the site-derived endpoint should be used.
+func (d *Datasource) customBaseURL() string {
+	if d.JSONData == nil {
+		return ""
+	}
+	return strings.TrimRight(strings.TrimSpace(d.JSONData.BaseURL), "/")
+}
+
+// apiBaseURL returns the root URL for raw HTTP calls to the Datadog API.
+// The configured base URL wins over the https://api.<site> default.
+func (d *Datasource) apiBaseURL(site string) string {
+	if baseURL := d.customBaseURL(); baseURL != "" {
+		return baseURL
+	}
+	return fmt.Sprintf("https://api.%s", site)
+}
+
 // convertGrafanaFormulaToDatadog converts Grafana formula format ($A, $B) to Datadog format (A, B)
 func convertGrafanaFormulaToDatadog(grafanaFormula string) string {
 	// Use reGrafanaFormula (compiled once at package level) to replace $RefID with RefID
@@ -616,23 +755,30 @@ func (d *Datasource) QueryData(ctx context.Context, req *backend.QueryDataReques
 	logger := log.New()
 	response := backend.NewQueryDataResponse()
 
+	// With per-user application keys the query runs in the user's view of the datasource
+	view, _, err := d.queryView(req)
+	if err != nil {
+		logger.Warn("Query refused", "error", err)
+		for _, q := range req.Queries {
+			response.Responses[q.RefID] = backend.ErrDataResponse(backend.StatusForbidden, err.Error())
+		}
+		return response, nil
+	}
+	if view != d {
+		return view.QueryData(ctx, req)
+	}
+
+	ctx, span := startSpan(ctx, "datadog.QueryData", attrQueryCount.Int(len(req.Queries)))
+	defer span.End()
+
 	// Get API credentials from secure JSON data
-	apiKey, appKey, site, credErr := d.validateCredentials()
+	_, _, site, credErr := d.validateCredentials()
 	if credErr != nil {
 		logger.Error("Invalid credentials", "error", credErr)
-		return response, credErr
+		return response, tracing.Error(span, credErr)
 	}
 
-	logger.Info("QueryData called", "site", site)
-	apiKeyPrefix := ""
-	if len(apiKey) > 8 {
-		apiKeyPrefix = apiKey[:8]
-	}
-	appKeyPrefix := ""
-	if len(appKey) > 8 {
-		appKeyPrefix = appKey[:8]
-	}
-	logger.Info("Using API Key and App Key for authentication", "apiKeyPrefix", apiKeyPrefix, "appKeyPrefix", appKeyPrefix)
+	logger.Debug("QueryData called", "site", site, "queryCount", len(req.Queries))
 
 	// Set the site and API keys in context using the shared helper
 	ddCtx, err := d.GetDatadogContext(ctx)
@@ -654,6 +800,8 @@ func (d *Datasource) QueryData(ctx context.Context, req *backend.QueryDataReques
 	handlers := make(map[QueryType]QueryHandler)
 	handlers[MetricsQueryType] = NewMetricsHandler(d, req.Queries, ddCtx, metricsApi)
 	handlers[LogsQueryType] = NewLogsHandler(d, req.Queries, ddCtx)
+	handlers[ServiceMapQueryType] = NewServiceMapHandler(d, req.Queries, ddCtx, metricsApi)
+	handlers[RUMQueryType] = NewRUMHandler(d, req.Queries, ddCtx, datadogV2.NewRUMApi(client))
 
 	// Parse all queries and route to appropriate handlers
 	for _, q := range req.Queries {
@@ -672,7 +820,7 @@ func (d *Datasource) QueryData(ctx context.Context, req *backend.QueryDataReques
 
 		// Detect query type and route to appropriate handler
 		queryType := detectQueryType(&qm)
-		logger.Info("Detected query type", "refID", q.RefID, "queryType", queryType, "logQuery", qm.LogQuery, "explicitQueryType", qm.QueryType)
+		logger.Debug("Detected query type", "refID", q.RefID, "queryType", queryType, "logQuery", d.logQuery(qm.LogQuery), "explicitQueryType", qm.QueryType)
 		handler, exists := handlers[queryType]
 		if !exists {
 			logger.Error("unsupported query type", "queryType", queryType)
@@ -682,6 +830,12 @@ func (d *Datasource) QueryData(ctx context.Context, req *backend.QueryDataReques
 
 		// Process query with the appropriate handler
 		if err := handler.processQuery(&qm); err != nil {
+			var violation *policyViolation
+			if errors.As(err, &violation) {
+				logger.Warn("Query refused by policy", "refID", q.RefID, "error", err)
+				response.Responses[q.RefID] = backend.ErrDataResponse(backend.StatusForbidden, err.Error())
+				continue
+			}
 			logger.Error("failed to process query", "queryType", queryType, "error", err)
 			response.Responses[q.RefID] = backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("failed to process query: %v", err))
 			continue
@@ -894,9 +1048,9 @@ func (d *Datasource) queryDatadog(ctx context.Context, api *datadogV2.MetricsApi
 	if !hasGroupByClause && !hasBooleanOperators {
 		// No "by" clause and no boolean operators present, add "by {*}" to get all series
 		queryText = queryText + " by {*}"
-		logger.Debug("Added 'by {*}' to query", "original", qm.QueryText, "modified", queryText)
+		logger.Debug("Added 'by {*}' to query", "original", d.logQuery(qm.QueryText), "modified", d.logQuery(queryText))
 	} else if hasBooleanOperators {
-		logger.Debug("Skipping 'by {*}' addition due to boolean operators", "original", qm.QueryText)
+		logger.Debug("Skipping 'by {*}' addition due to boolean operators", "original", d.logQuery(qm.QueryText))
 	}
 
 	body := datadogV2.TimeseriesFormulaQueryRequest{
@@ -919,11 +1073,6 @@ func (d *Datasource) queryDatadog(ctx context.Context, api *datadogV2.MetricsApi
 	// Call Datadog Metrics API
 	resp, r, err := api.QueryTimeseriesData(ctx, body)
 	if err != nil {
-		// Log request body for debugging
-		requestBody, _ := json.MarshalIndent(body, "", "  ")
-		logger.Error("QueryTimeseriesData request body",
-			"request", string(requestBody))
-
 		// Log HTTP response details
 		httpStatus := 0
 		var responseBody string
@@ -939,9 +1088,8 @@ func (d *Datasource) queryDatadog(ctx context.Context, api *datadogV2.MetricsApi
 
 		logger.Error("QueryTimeseriesData API call failed",
 			"error", err,
-			"errorString", err.Error(),
 			"httpStatus", httpStatus,
-			"responseBody", responseBody)
+			"responseBody", redactPayload([]byte(responseBody)))
 
 		// Build detailed error message based on HTTP status and response
 		var errorMsg string
@@ -1340,48 +1488,78 @@ func (d *Datasource) processTimeseriesResponse(resp *datadogV2.TimeseriesFormula
 func (d *Datasource) CallResource(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
 	logger := log.New()
 
-	// CRITICAL DEBUG: Log all incoming requests with ERROR level to ensure visibility
-	logger.Error("CRITICAL DEBUG - CallResource received request",
+	logger.Debug("CallResource received request",
 		"method", req.Method,
 		"path", req.Path,
-		"bodyLength", len(req.Body),
-		"body", string(req.Body),
-		"headers", req.Headers)
+		"body", redactPayload(req.Body),
+		"headers", redactHeaders(req.Headers))
 
-	// Route requests to appropriate handlers
+	// With per-user application keys, routes calling Datadog run in the user's view
+	if userViewRequired(req.Path) {
+		view, _, err := d.userView(req.PluginContext.User)
+		if err != nil {
+			logger.Warn("Resource request refused", "path", req.Path, "error", err)
+			return sendNoUserAppKey(sender, err)
+		}
+		if view != d {
+			return view.CallResource(ctx, req, sender)
+		}
+	}
+
+	// Route requests to appropriate handlers. The route is a path template so that
+	// span names stay low-cardinality (metric and tag names are not part of it).
+	var route string
+	var handler func(context.Context, *backend.CallResourceRequest, backend.CallResourceResponseSender) error
 	switch {
 	case req.Method == "GET" && req.Path == "autocomplete/metrics":
-		return d.MetricsHandler(ctx, req, sender)
+		route, handler = "autocomplete/metrics", d.MetricsHandler
 	case req.Method == "GET" && len(req.Path) > len("autocomplete/tags/") && req.Path[:len("autocomplete/tags/")] == "autocomplete/tags/":
-		return d.TagsHandler(ctx, req, sender)
+		route, handler = "autocomplete/tags/{metric}", d.TagsHandler
 	case req.Method == "GET" && len(req.Path) > len("autocomplete/tag-values/") && req.Path[:len("autocomplete/tag-values/")] == "autocomplete/tag-values/":
-		return d.TagValuesHandler(ctx, req, sender)
+		route, handler = "autocomplete/tag-values/{metric}/{tagKey}", d.TagValuesHandler
 	case req.Method == "POST" && req.Path == "autocomplete/complete":
-		return d.CompleteHandler(ctx, req, sender)
+		route, handler = "autocomplete/complete", d.CompleteHandler
 	// Logs autocomplete handlers - reuse existing concurrency limiting and timeout patterns
 	case req.Method == "GET" && req.Path == "autocomplete/logs/services":
-		return d.LogsServicesHandler(ctx, req, sender)
+		route, handler = "autocomplete/logs/services", d.LogsServicesHandler
 	case req.Method == "GET" && req.Path == "autocomplete/logs/sources":
-		return d.LogsSourcesHandler(ctx, req, sender)
+		route, handler = "autocomplete/logs/sources", d.LogsSourcesHandler
 	case req.Method == "GET" && req.Path == "autocomplete/logs/levels":
-		return d.LogsLevelsHandler(ctx, req, sender)
+		route, handler = "autocomplete/logs/levels", d.LogsLevelsHandler
 	case req.Method == "GET" && req.Path == "autocomplete/logs/fields":
-		return d.LogsFieldsHandler(ctx, req, sender)
+		route, handler = "autocomplete/logs/fields", d.LogsFieldsHandler
 	case req.Method == "GET" && strings.HasPrefix(req.Path, "autocomplete/logs/field-values/"):
-		return d.LogsFieldValuesHandler(ctx, req, sender)
+		route, handler = "autocomplete/logs/field-values/{field}", d.LogsFieldValuesHandler
 	case req.Method == "GET" && req.Path == "autocomplete/logs/tags":
-		return d.LogsTagsHandler(ctx, req, sender)
+		route, handler = "autocomplete/logs/tags", d.LogsTagsHandler
 	case req.Method == "GET" && strings.HasPrefix(req.Path, "autocomplete/logs/tag-values/"):
-		return d.LogsTagValuesHandler(ctx, req, sender)
+		route, handler = "autocomplete/logs/tag-values/{tag}", d.LogsTagValuesHandler
 	// Variable resource handlers - Grafana strips "resources/" prefix
 	case req.Method == "POST" && req.Path == "metrics":
-		return d.VariableMetricsHandler(ctx, req, sender)
+		route, handler = "metrics", d.VariableMetricsHandler
 	case req.Method == "POST" && req.Path == "tag-keys":
-		return d.VariableTagKeysHandler(ctx, req, sender)
+		route, handler = "tag-keys", d.VariableTagKeysHandler
 	case req.Method == "POST" && req.Path == "tag-values":
-		return d.VariableTagValuesHandler(ctx, req, sender)
+		route, handler = "tag-values", d.VariableTagValuesHandler
 	case req.Method == "POST" && req.Path == "all-tags":
-		return d.VariableAllTagsHandler(ctx, req, sender)
+		route, handler = "all-tags", d.VariableAllTagsHandler
+	case req.Method == "POST" && req.Path == "dashboards/import":
+		route, handler = "dashboards/import", d.DashboardImportHandler
+	case req.Method == "POST" && req.Path == "monitors/convert":
+		route, handler = "monitors/convert", d.MonitorConvertHandler
+	case req.Method == "GET" && req.Path == "notebooks":
+		route, handler = "notebooks", d.NotebooksHandler
+	case req.Method == "GET" && req.Path == "logs/saved-views":
+		route, handler = "logs/saved-views", d.SavedLogViewsHandler
+	// Cache and key pair administration - organization admins only
+	case req.Method == "GET" && req.Path == "cache/stats":
+		route, handler = "cache/stats", d.CacheStatsHandler
+	case req.Method == "GET" && req.Path == "cache/keys":
+		route, handler = "cache/keys", d.CacheKeysHandler
+	case req.Method == "POST" && req.Path == "cache/purge":
+		route, handler = "cache/purge", d.CachePurgeHandler
+	case req.Method == "POST" && req.Path == "credentials/rotate":
+		route, handler = "credentials/rotate", d.CredentialsRotateHandler
 	default:
 		logger.Warn("Unknown resource path", "path", req.Path, "method", req.Method)
 		return sender.Send(&backend.CallResourceResponse{
@@ -1389,6 +1567,65 @@ func (d *Datasource) CallResource(ctx context.Context, req *backend.CallResource
 			Body:   []byte(`{"error": "endpoint not found"}`),
 		})
 	}
+
+	ctx, span := startSpan(ctx, "datadog.CallResource "+route,
+		attrRoute.String(route),
+		attribute.String("http.request.method", req.Method))
+	defer span.End()
+
+	// Routes reserved to editors are refused before anything else runs, so that no request
+	// of a Viewer reaches Datadog or joins an Editor's in-flight call
+	if editorRoute(route) {
+		if ok, err := requireEditor(req, sender); !ok {
+			return err
+		}
+	}
+
+	// Lookups that call Datadog are refused once the request budget is used up
+	if !localRoute(route) {
+		if err := d.budget.use(0); err != nil {
+			return sender.Send(&backend.CallResourceResponse{
+				Status: http.StatusTooManyRequests,
+				Body:   []byte(fmt.Sprintf(`{"error": %q}`, err.Error())),
+			})
+		}
+	}
+
+	// The governance policy refuses out-of-scope lookups and filters what the others return
+	keep, err := d.resourcePolicy(route, req)
+	if err != nil {
+		logger.Warn("Resource request refused by policy", "route", route, "error", err)
+		return sendPolicyViolation(sender, err)
+	}
+	if keep != nil {
+		sender = policySender{next: sender, keep: keep}
+	}
+
+	// Local routes are handled directly; administration and editor routes depend on the
+	// user's role and must not be shared. Every other route calls Datadog, so identical
+	// concurrent requests share one handler run.
+	if localRoute(route) || editorRoute(route) {
+		err = handler(ctx, req, sender)
+	} else {
+		err = d.callResourceCoalesced(ctx, req, sender, handler)
+	}
+	if err != nil {
+		return tracing.Error(span, err)
+	}
+	return nil
+}
+
+// localRoute reports whether a resource route is served without calling Datadog: completion,
+// saved log views and the administration of caches and credentials.
+func localRoute(route string) bool {
+	return route == "autocomplete/complete" || route == "logs/saved-views" ||
+		strings.HasPrefix(route, "cache/") || strings.HasPrefix(route, "credentials/")
+}
+
+// editorRoute reports whether a resource route requires the Editor or Admin role. Such
+// routes are never coalesced: a shared response would be served to users of other roles.
+func editorRoute(route string) bool {
+	return route == "dashboards/import" || route == "monitors/convert" || route == "notebooks"
 }
 
 // MetricsHandler handles GET /autocomplete/metrics requests
@@ -1398,7 +1635,9 @@ func (d *Datasource) MetricsHandler(ctx context.Context, req *backend.CallResour
 
 	// Check cache first
 	if !d.cacheDisabled {
-		if cached := d.GetCachedEntry("metrics", ttl); cached != nil {
+		cached := d.GetCachedEntry("metrics", ttl)
+		setSpanCacheHit(ctx, cached != nil)
+		if cached != nil {
 			logger.Debug("Returning cached metrics")
 			respData, _ := json.Marshal(cached.Data)
 			return sender.Send(&backend.CallResourceResponse{
@@ -1521,7 +1760,9 @@ func (d *Datasource) TagsHandler(ctx context.Context, req *backend.CallResourceR
 
 	// Check cache first (skip cache if it's empty to force refresh or if cache is disabled)
 	if !d.cacheDisabled {
-		if cached := d.GetCachedEntry(cacheKey, ttl); cached != nil && len(cached.Data) > 0 {
+		cached := d.GetCachedEntry(cacheKey, ttl)
+		setSpanCacheHit(ctx, cached != nil && len(cached.Data) > 0)
+		if cached != nil && len(cached.Data) > 0 {
 			logger.Debug("Returning cached tags", "metric", metric, "tagCount", len(cached.Data))
 			respData, _ := json.Marshal(cached.Data)
 			return sender.Send(&backend.CallResourceResponse{
@@ -1630,7 +1871,7 @@ func (d *Datasource) TagsHandler(ctx context.Context, req *backend.CallResourceR
 		tags = []string{}
 	}
 
-	logger.Debug("Extracted tag keys", "metric", metric, "tagCount", len(tags), "tags", tags)
+	logger.Debug("Extracted tag keys", "metric", metric, "tagCount", len(tags))
 
 	// Cache the result
 	d.SetCachedEntry(cacheKey, tags)
@@ -1672,7 +1913,9 @@ func (d *Datasource) TagValuesHandler(ctx context.Context, req *backend.CallReso
 
 	// Check cache first
 	if !d.cacheDisabled {
-		if cached := d.GetCachedEntry(cacheKey, ttl); cached != nil && len(cached.Data) > 0 {
+		cached := d.GetCachedEntry(cacheKey, ttl)
+		setSpanCacheHit(ctx, cached != nil && len(cached.Data) > 0)
+		if cached != nil && len(cached.Data) > 0 {
 			logger.Debug("Returning cached tag values", "metric", metric, "tagKey", tagKey, "valueCount", len(cached.Data))
 			respData, _ := json.Marshal(cached.Data)
 			return sender.Send(&backend.CallResourceResponse{
@@ -1766,7 +2009,7 @@ func (d *Datasource) TagValuesHandler(ctx context.Context, req *backend.CallReso
 		tagValues = append(tagValues, value)
 	}
 
-	logger.Debug("Extracted tag values", "metric", metric, "tagKey", tagKey, "valueCount", len(tagValues), "values", tagValues)
+	logger.Debug("Extracted tag values", "metric", metric, "tagKey", tagKey, "valueCount", len(tagValues))
 
 	// Cache the result
 	d.SetCachedEntry(cacheKey, tagValues)
@@ -1780,313 +2023,90 @@ func (d *Datasource) TagValuesHandler(ctx context.Context, req *backend.CallReso
 	})
 }
 
-// CheckHealth checks the health of the datasource connection
-func (d *Datasource) CheckHealth(ctx context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
-	logger := log.New()
-
-	// Get API credentials from secure JSON data
-	apiKey, appKey, site, credErr := d.validateCredentials()
-	if credErr != nil {
-		logger.Error("CheckHealth: invalid credentials", "error", credErr)
-		return &backend.CheckHealthResult{
-			Status:  backend.HealthStatusError,
-			Message: credErr.Error(),
-		}, nil
-	}
-
-	// Log credentials info (without exposing full keys)
-	apiKeyPrefix := ""
-	if len(apiKey) >= 8 {
-		apiKeyPrefix = apiKey[:8]
-	} else if len(apiKey) > 0 {
-		apiKeyPrefix = apiKey
-	}
-	appKeyPrefix := ""
-	if len(appKey) >= 8 {
-		appKeyPrefix = appKey[:8]
-	} else if len(appKey) > 0 {
-		appKeyPrefix = appKey
-	}
-
-	logger.Info("CheckHealth: starting health check",
-		"site", site,
-		"apiKeyLen", len(apiKey),
-		"appKeyLen", len(appKey),
-		"apiKeyPrefix", apiKeyPrefix,
-		"appKeyPrefix", appKeyPrefix)
-
-	// Build per-request context (credentials + site) and reuse singleton client
-	ddCtx, err := d.GetDatadogContext(ctx)
-	if err != nil {
-		logger.Error("CheckHealth: failed to build Datadog context", "error", err)
-		return &backend.CheckHealthResult{
-			Status:  backend.HealthStatusError,
-			Message: err.Error(),
-		}, nil
-	}
-
-	// Create timeout context for health check
-	healthCtx, cancel := context.WithTimeout(ddCtx, 5*time.Second)
-	defer cancel()
-
-	client, err := d.GetAPIClient()
-	if err != nil {
-		logger.Error("CheckHealth: failed to get API client", "error", err)
-		return &backend.CheckHealthResult{
-			Status:  backend.HealthStatusError,
-			Message: err.Error(),
-		}, nil
-	}
-	metricsApi := datadogV2.NewMetricsApi(client)
-
-	// Use v2 QueryTimeseriesData endpoint for health check (same as production queries)
-	now := time.Now()
-	fromMs := now.Add(-1 * time.Hour).UnixMilli()
-	toMs := now.UnixMilli()
-
-	interval := int64(5000)
-	formula := "a"
-	queryName := "a"
-
-	body := datadogV2.TimeseriesFormulaQueryRequest{
-		Data: datadogV2.TimeseriesFormulaRequest{
-			Type: datadogV2.TIMESERIESFORMULAREQUESTTYPE_TIMESERIES_REQUEST,
-			Attributes: datadogV2.TimeseriesFormulaRequestAttributes{
-				From:     fromMs,
-				To:       toMs,
-				Interval: &interval,
-				Formulas: []datadogV2.QueryFormula{
-					{
-						Formula: formula,
-					},
-				},
-				Queries: []datadogV2.TimeseriesQuery{
-					{
-						MetricsTimeseriesQuery: &datadogV2.MetricsTimeseriesQuery{
-							DataSource: datadogV2.METRICSDATASOURCE_METRICS,
-							Query:      "avg:datadog.estimated_usage.metrics.custom{*}",
-							Name:       &queryName,
-						}},
-				},
-			},
-		},
-	}
-
-	logger.Info("CheckHealth: calling QueryTimeseriesData (v2 API)")
-
-	// Log the request body
-	requestBody, _ := json.MarshalIndent(body, "", "  ")
-	logger.Debug("CheckHealth: request body", "request", string(requestBody))
-
-	resp, httpResp, err := metricsApi.QueryTimeseriesData(healthCtx, body)
-	if err != nil {
-		httpStatus := 0
-		var responseBody string
-		if httpResp != nil {
-			httpStatus = httpResp.StatusCode
-			if httpResp.Body != nil {
-				bodyBytes, _ := io.ReadAll(httpResp.Body)
-				responseBody = string(bodyBytes)
-				// Restore body for potential future reads
-				httpResp.Body = io.NopCloser(strings.NewReader(responseBody))
-			}
-		}
-
-		logger.Error("CheckHealth: API call failed",
-			"error", err,
-			"errorString", err.Error(),
-			"httpStatus", httpStatus,
-			"responseBody", responseBody)
-
-		if strings.Contains(err.Error(), "401") || strings.Contains(err.Error(), "Unauthorized") {
-			logger.Error("Health check failed - authentication error", "error", err)
-			return &backend.CheckHealthResult{
-				Status:  backend.HealthStatusError,
-				Message: "Invalid Datadog API credentials - check your API key and App key",
-			}, nil
-		}
-		if strings.Contains(err.Error(), "403") || strings.Contains(err.Error(), "Forbidden") {
-			logger.Error("Health check failed - permission error", "error", err)
-			return &backend.CheckHealthResult{
-				Status:  backend.HealthStatusError,
-				Message: "API key missing required permissions - need 'metrics_read' scope",
-			}, nil
-		}
-		if strings.Contains(err.Error(), "timeout") || strings.Contains(err.Error(), "context deadline exceeded") {
-			logger.Error("Health check failed - timeout", "error", err)
-			return &backend.CheckHealthResult{
-				Status:  backend.HealthStatusError,
-				Message: "Connection timeout - Datadog API is not responding",
-			}, nil
-		}
-		logger.Error("Health check failed", "error", err)
-		return &backend.CheckHealthResult{
-			Status:  backend.HealthStatusError,
-			Message: "Failed to connect to Datadog: " + err.Error(),
-		}, nil
-	}
-
-	logger.Info("CheckHealth: API call succeeded", "seriesCount", len(resp.GetData().Attributes.GetSeries()))
-	return &backend.CheckHealthResult{
-		Status:  backend.HealthStatusOk,
-		Message: "Connected to Datadog",
-	}, nil
-}
-
 // GetCachedEntry retrieves a cached entry if valid (not expired)
 func (d *Datasource) GetCachedEntry(key string, ttl time.Duration) *CacheEntry {
-	d.cache.mu.Lock()
-	defer d.cache.mu.Unlock()
-
-	entry, ok := d.cache.entries[key]
-	if !ok {
-		return nil
-	}
-
-	// Check if expired
-	if time.Since(entry.Timestamp) > ttl {
-		delete(d.cache.entries, key)
-		return nil
-	}
-
-	return entry
+	return getCachedStrings(d.cache, key, ttl)
 }
 
 // SetCachedEntry stores data in cache with current timestamp.
-// If the cache has reached maxEntries, the oldest entry is evicted first (LRU eviction).
+// When the cache is over its size limits, the least recently used entries are evicted.
 func (d *Datasource) SetCachedEntry(key string, data []string) {
-	d.cache.mu.Lock()
-	defer d.cache.mu.Unlock()
-
-	// If key already exists, remove it from the order slice so we can re-append it
-	if _, exists := d.cache.entries[key]; exists {
-		for i, k := range d.cache.order {
-			if k == key {
-				d.cache.order = append(d.cache.order[:i], d.cache.order[i+1:]...)
-				break
-			}
-		}
-	} else if d.cache.maxEntries > 0 && len(d.cache.entries) >= d.cache.maxEntries {
-		// Evict the oldest entry
-		oldest := d.cache.order[0]
-		d.cache.order = d.cache.order[1:]
-		delete(d.cache.entries, oldest)
-	}
-
-	d.cache.entries[key] = &CacheEntry{
-		Data:      data,
-		Timestamp: time.Now(),
-	}
-	d.cache.order = append(d.cache.order, key)
+	cacheSet(d.cache, key, data)
 }
 
-// CleanExpiredCache removes expired entries from cache and compacts the order slice.
+// CleanExpiredCache removes expired entries from cache
 func (d *Datasource) CleanExpiredCache(ttl time.Duration) {
-	d.cache.mu.Lock()
-	defer d.cache.mu.Unlock()
+	d.cache.Purge(time.Now().Add(-ttl))
+}
 
-	now := time.Now()
-	for key, entry := range d.cache.entries {
-		if now.Sub(entry.Timestamp) > ttl {
-			delete(d.cache.entries, key)
-		}
-	}
+// GetCachedAllTagsEntry retrieves a cached organization-wide tag listing if valid (not expired)
+func (d *Datasource) GetCachedAllTagsEntry(key string, ttl time.Duration) *CacheEntry {
+	return getCachedStrings(d.allTagsCache, key, ttl)
+}
 
-	// Compact order slice to only contain still-live keys
-	live := d.cache.order[:0]
-	for _, k := range d.cache.order {
-		if _, ok := d.cache.entries[k]; ok {
-			live = append(live, k)
-		}
+// SetCachedAllTagsEntry stores an organization-wide tag listing with current timestamp
+func (d *Datasource) SetCachedAllTagsEntry(key string, data []string) {
+	cacheSet(d.allTagsCache, key, data)
+}
+
+// getCachedStrings reads a string list entry from c if valid (not expired)
+func getCachedStrings(c Cache, key string, ttl time.Duration) *CacheEntry {
+	var data []string
+	storedAt, ok := cacheGet(c, key, ttl, &data)
+	if !ok {
+		return nil
 	}
-	d.cache.order = live
+	return &CacheEntry{Data: data, Timestamp: storedAt}
+}
+
+// logsCacheValue is the stored form of a LogsCacheEntry; the timestamp is kept by the cache.
+type logsCacheValue struct {
+	LogEntries []LogEntry `json:"logEntries"`
+	NextCursor string     `json:"nextCursor,omitempty"`
 }
 
 // GetCachedLogsEntry retrieves a cached logs entry if valid (not expired)
 func (d *Datasource) GetCachedLogsEntry(key string, ttl time.Duration) *LogsCacheEntry {
-	d.logsCacheMu.Lock()
-	defer d.logsCacheMu.Unlock()
-
-	entry, ok := d.logsCache[key]
+	var value logsCacheValue
+	storedAt, ok := cacheGet(d.logsCache, key, ttl, &value)
 	if !ok {
 		return nil
 	}
-
-	// Check if expired
-	if time.Since(entry.Timestamp) > ttl {
-		delete(d.logsCache, key)
-		return nil
+	return &LogsCacheEntry{
+		LogEntries: value.LogEntries,
+		Timestamp:  storedAt,
+		NextCursor: value.NextCursor,
 	}
-
-	return entry
 }
 
 // SetCachedLogsEntry stores logs data in cache with current timestamp
 func (d *Datasource) SetCachedLogsEntry(key string, logEntries []LogEntry, nextCursor string) {
-	d.logsCacheMu.Lock()
-	defer d.logsCacheMu.Unlock()
-
-	d.logsCache[key] = &LogsCacheEntry{
-		LogEntries: logEntries,
-		Timestamp:  time.Now(),
-		NextCursor: nextCursor,
-	}
+	cacheSet(d.logsCache, key, logsCacheValue{LogEntries: logEntries, NextCursor: nextCursor})
 }
 
 // CleanExpiredLogsCache removes expired entries from logs cache
 func (d *Datasource) CleanExpiredLogsCache(ttl time.Duration) {
-	d.logsCacheMu.Lock()
-	defer d.logsCacheMu.Unlock()
-
-	now := time.Now()
-	for key, entry := range d.logsCache {
-		if now.Sub(entry.Timestamp) > ttl {
-			delete(d.logsCache, key)
-		}
-	}
+	d.logsCache.Purge(time.Now().Add(-ttl))
 }
 
 // GetCachedLogsAutocompleteEntry retrieves a cached logs autocomplete entry if valid (not expired)
 func (d *Datasource) GetCachedLogsAutocompleteEntry(key string, ttl time.Duration) *LogsAutocompleteCacheEntry {
-	d.logsAutocompleteMu.Lock()
-	defer d.logsAutocompleteMu.Unlock()
-
-	entry, ok := d.logsAutocompleteCache[key]
+	var data []string
+	storedAt, ok := cacheGet(d.logsAutocompleteCache, key, ttl, &data)
 	if !ok {
 		return nil
 	}
-
-	// Check if expired
-	if time.Since(entry.Timestamp) > ttl {
-		delete(d.logsAutocompleteCache, key)
-		return nil
-	}
-
-	return entry
+	return &LogsAutocompleteCacheEntry{Data: data, Timestamp: storedAt}
 }
 
 // SetCachedLogsAutocompleteEntry stores logs autocomplete data in cache with current timestamp
 func (d *Datasource) SetCachedLogsAutocompleteEntry(key string, data []string) {
-	d.logsAutocompleteMu.Lock()
-	defer d.logsAutocompleteMu.Unlock()
-
-	d.logsAutocompleteCache[key] = &LogsAutocompleteCacheEntry{
-		Data:      data,
-		Timestamp: time.Now(),
-	}
+	cacheSet(d.logsAutocompleteCache, key, data)
 }
 
 // CleanExpiredLogsAutocompleteCache removes expired entries from logs autocomplete cache
 func (d *Datasource) CleanExpiredLogsAutocompleteCache(ttl time.Duration) {
-	d.logsAutocompleteMu.Lock()
-	defer d.logsAutocompleteMu.Unlock()
-
-	now := time.Now()
-	for key, entry := range d.logsAutocompleteCache {
-		if now.Sub(entry.Timestamp) > ttl {
-			delete(d.logsAutocompleteCache, key)
-		}
-	}
+	d.logsAutocompleteCache.Purge(time.Now().Add(-ttl))
 }
 
 // CompleteRequest represents the request body for autocomplete completion
@@ -2132,18 +2152,14 @@ type VariableResponse struct {
 
 // Enhanced logging and error handling utilities for variable operations
 
-// generateTraceID creates a simple trace ID for request tracking
-func generateTraceID() string {
-	return fmt.Sprintf("var-%d", time.Now().UnixNano())
-}
-
-// logVariableRequest logs the start of a variable request with structured context
-func logVariableRequest(logger log.Logger, traceID, endpoint, method string, requestData interface{}) {
-	logger.Info("Variable request started",
+// logVariableRequest logs the start of a variable request with structured context.
+// The request body may carry metric patterns and filters, so only its size is logged.
+func logVariableRequest(logger log.Logger, traceID, endpoint, method string, body []byte) {
+	logger.Debug("Variable request started",
 		"traceID", traceID,
 		"endpoint", endpoint,
 		"method", method,
-		"requestData", requestData,
+		"body", redactPayload(body),
 		"timestamp", time.Now().Format(time.RFC3339))
 }
 
@@ -2205,7 +2221,7 @@ func validateVariableRequest(logger log.Logger, traceID string, body []byte, tar
 	if err := json.Unmarshal(body, target); err != nil {
 		return createUserFriendlyError(logger, traceID, err,
 			"Invalid request format",
-			map[string]interface{}{"bodyLength": len(body), "body": string(body)})
+			map[string]interface{}{"body": redactPayload(body)})
 	}
 
 	return nil
@@ -2374,7 +2390,7 @@ func (d *Datasource) CompleteHandler(ctx context.Context, req *backend.CallResou
 		})
 	}
 
-	logger.Info("Complete request", "query", completeReq.Query, "cursor", completeReq.CursorPosition, "item", completeReq.SelectedItem, "kind", completeReq.ItemKind)
+	logger.Debug("Complete request", "query", d.logQuery(completeReq.Query), "cursor", completeReq.CursorPosition, "kind", completeReq.ItemKind)
 
 	// Determine where to insert based on item kind and cursor position
 	newQuery := completeReq.Query
@@ -2517,14 +2533,13 @@ func (d *Datasource) CompleteHandler(ctx context.Context, req *backend.CallResou
 				tokenEnd++
 			}
 
-			logger.Info("Filter tag key insertion",
-				"filterContent", filterContent,
+			logger.Debug("Filter tag key insertion",
+				"filterContent", d.logQuery(filterContent),
 				"relativePos", relativePos,
 				"cursorPos", completeReq.CursorPosition,
 				"openBracePos", openBracePos,
 				"tokenStart", tokenStart,
-				"tokenEnd", tokenEnd,
-				"selectedItem", selectedItem)
+				"tokenEnd", tokenEnd)
 
 			// Replace the current token with the selected tag key
 			// Always add colon since we're completing a tag key (not value)
@@ -2559,8 +2574,8 @@ func (d *Datasource) CompleteHandler(ctx context.Context, req *backend.CallResou
 				relativePos = len(filterContent)
 			}
 
-			logger.Info("Filter tag value insertion",
-				"filterContent", filterContent,
+			logger.Debug("Filter tag value insertion",
+				"filterContent", d.logQuery(filterContent),
 				"relativePos", relativePos,
 				"cursorPos", completeReq.CursorPosition,
 				"openBracePos", openBracePos)
@@ -2604,7 +2619,7 @@ func (d *Datasource) CompleteHandler(ctx context.Context, req *backend.CallResou
 		newCursorPos = completeReq.CursorPosition + len(selectedItem)
 	}
 
-	logger.Info("Complete response", "newQuery", newQuery, "newCursor", newCursorPos)
+	logger.Debug("Complete response", "newQuery", d.logQuery(newQuery), "newCursor", newCursorPos)
 
 	// Return response
 	response := CompleteResponse{
@@ -2622,12 +2637,12 @@ func (d *Datasource) CompleteHandler(ctx context.Context, req *backend.CallResou
 // VariableMetricsHandler handles POST /resources/metrics requests for variable queries
 func (d *Datasource) VariableMetricsHandler(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
 	logger := log.New()
-	traceID := generateTraceID()
+	traceID := traceIDFromContext(ctx)
 	startTime := time.Now()
 	ttl := 5 * time.Minute // 5-minute cache TTL as specified in requirements
 
 	// Log request start
-	logVariableRequest(logger, traceID, "/resources/metrics", req.Method, string(req.Body))
+	logVariableRequest(logger, traceID, "/resources/metrics", req.Method, req.Body)
 
 	// Parse request body with enhanced validation
 	var metricsReq MetricsRequest
@@ -2644,12 +2659,14 @@ func (d *Datasource) VariableMetricsHandler(ctx context.Context, req *backend.Ca
 	cacheKey := fmt.Sprintf("var-metrics:%s:%s", metricsReq.Namespace, metricsReq.SearchPattern)
 
 	// Check cache first
-	if cached := d.GetCachedEntry(cacheKey, ttl); cached != nil {
+	cached := d.GetCachedEntry(cacheKey, ttl)
+	setSpanCacheHit(ctx, cached != nil)
+	if cached != nil {
 		duration := time.Since(startTime)
 		logger.Debug("Returning cached variable metrics",
 			"traceID", traceID,
 			"namespace", metricsReq.Namespace,
-			"searchPattern", metricsReq.SearchPattern,
+			"searchPattern", d.logQuery(metricsReq.SearchPattern),
 			"cacheHit", true,
 			"resultCount", len(cached.Data))
 		logVariableResponse(logger, traceID, "/resources/metrics", 200, duration, len(cached.Data), nil)
@@ -2693,7 +2710,7 @@ func (d *Datasource) VariableMetricsHandler(ctx context.Context, req *backend.Ca
 	logger.Debug("Fetching metrics from Datadog API",
 		"traceID", traceID,
 		"namespace", metricsReq.Namespace,
-		"searchPattern", metricsReq.SearchPattern,
+		"searchPattern", d.logQuery(metricsReq.SearchPattern),
 		"timeout", "30s")
 
 	resp, _, err := metricsApi.ListTagConfigurations(fetchCtx)
@@ -2744,7 +2761,7 @@ func (d *Datasource) VariableMetricsHandler(ctx context.Context, req *backend.Ca
 	logger.Debug("Successfully fetched variable metrics",
 		"traceID", traceID,
 		"namespace", metricsReq.Namespace,
-		"searchPattern", metricsReq.SearchPattern,
+		"searchPattern", d.logQuery(metricsReq.SearchPattern),
 		"resultCount", len(metrics),
 		"cached", true)
 	logVariableResponse(logger, traceID, "/resources/metrics", 200, duration, len(metrics), nil)
@@ -2762,12 +2779,12 @@ func (d *Datasource) VariableMetricsHandler(ctx context.Context, req *backend.Ca
 // VariableTagKeysHandler handles POST /resources/tag-keys requests for variable queries
 func (d *Datasource) VariableTagKeysHandler(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
 	logger := log.New()
-	traceID := generateTraceID()
+	traceID := traceIDFromContext(ctx)
 	startTime := time.Now()
 	ttl := 5 * time.Minute // 5-minute cache TTL as specified in requirements
 
 	// Log request start
-	logVariableRequest(logger, traceID, "/resources/tag-keys", req.Method, string(req.Body))
+	logVariableRequest(logger, traceID, "/resources/tag-keys", req.Method, req.Body)
 
 	// Parse request body with enhanced validation
 	var tagKeysReq TagKeysRequest
@@ -2784,7 +2801,9 @@ func (d *Datasource) VariableTagKeysHandler(ctx context.Context, req *backend.Ca
 	cacheKey := fmt.Sprintf("var-tag-keys:%s:%s", tagKeysReq.MetricName, tagKeysReq.Filter)
 
 	// Check cache first
-	if cached := d.GetCachedEntry(cacheKey, ttl); cached != nil {
+	cached := d.GetCachedEntry(cacheKey, ttl)
+	setSpanCacheHit(ctx, cached != nil)
+	if cached != nil {
 		duration := time.Since(startTime)
 		logger.Debug("Returning cached variable tag keys",
 			"traceID", traceID,
@@ -2985,7 +3004,7 @@ func (d *Datasource) VariableTagKeysHandler(ctx context.Context, req *backend.Ca
 		tagKeys = filteredTagKeys
 		logger.Debug("Applied filter to tag keys",
 			"traceID", traceID,
-			"filter", tagKeysReq.Filter,
+			"filter", d.logQuery(tagKeysReq.Filter),
 			"originalCount", len(tagKeys),
 			"filteredCount", len(filteredTagKeys))
 	}
@@ -2998,7 +3017,7 @@ func (d *Datasource) VariableTagKeysHandler(ctx context.Context, req *backend.Ca
 	logger.Debug("Successfully fetched variable tag keys",
 		"traceID", traceID,
 		"metricName", tagKeysReq.MetricName,
-		"filter", tagKeysReq.Filter,
+		"filter", d.logQuery(tagKeysReq.Filter),
 		"resultCount", len(tagKeys),
 		"cached", true)
 	logVariableResponse(logger, traceID, "/resources/tag-keys", 200, duration, len(tagKeys), nil)
@@ -3016,12 +3035,12 @@ func (d *Datasource) VariableTagKeysHandler(ctx context.Context, req *backend.Ca
 // VariableTagValuesHandler handles POST /resources/tag-values requests for variable queries
 func (d *Datasource) VariableTagValuesHandler(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
 	logger := log.New()
-	traceID := generateTraceID()
+	traceID := traceIDFromContext(ctx)
 	startTime := time.Now()
 	ttl := 5 * time.Minute // 5-minute cache TTL as specified in requirements
 
 	// Log request start
-	logVariableRequest(logger, traceID, "/resources/tag-values", req.Method, string(req.Body))
+	logVariableRequest(logger, traceID, "/resources/tag-values", req.Method, req.Body)
 
 	// Parse request body with enhanced validation
 	var tagValuesReq TagValuesRequest
@@ -3045,7 +3064,9 @@ func (d *Datasource) VariableTagValuesHandler(ctx context.Context, req *backend.
 			"timeout", "60s")
 
 		// Check cache first for wildcard queries
-		if cached := d.GetCachedEntry(cacheKey, ttl); cached != nil {
+		cached := d.GetCachedEntry(cacheKey, ttl)
+		setSpanCacheHit(ctx, cached != nil)
+		if cached != nil {
 			duration := time.Since(startTime)
 			logger.Debug("Returning cached wildcard tag values",
 				"traceID", traceID,
@@ -3283,7 +3304,9 @@ func (d *Datasource) VariableTagValuesHandler(ctx context.Context, req *backend.
 	}
 
 	// Check cache first
-	if cached := d.GetCachedEntry(cacheKey, ttl); cached != nil {
+	cached := d.GetCachedEntry(cacheKey, ttl)
+	setSpanCacheHit(ctx, cached != nil)
+	if cached != nil {
 		duration := time.Since(startTime)
 		logger.Debug("Returning cached variable tag values",
 			"traceID", traceID,
@@ -3493,7 +3516,7 @@ func (d *Datasource) VariableTagValuesHandler(ctx context.Context, req *backend.
 		tagValues = filteredTagValues
 		logger.Debug("Applied filter to tag values",
 			"traceID", traceID,
-			"filter", tagValuesReq.Filter,
+			"filter", d.logQuery(tagValuesReq.Filter),
 			"originalCount", len(tagValues),
 			"filteredCount", len(filteredTagValues))
 	}
@@ -3507,7 +3530,7 @@ func (d *Datasource) VariableTagValuesHandler(ctx context.Context, req *backend.
 		"traceID", traceID,
 		"metricName", tagValuesReq.MetricName,
 		"tagKey", tagValuesReq.TagKey,
-		"filter", tagValuesReq.Filter,
+		"filter", d.logQuery(tagValuesReq.Filter),
 		"resultCount", len(tagValues),
 		"cached", true)
 	logVariableResponse(logger, traceID, "/resources/tag-values", 200, duration, len(tagValues), nil)
@@ -3532,12 +3555,12 @@ type AllTagsRequest struct {
 // This uses Datadog's v2/metrics API with pagination to get comprehensive tag data
 func (d *Datasource) VariableAllTagsHandler(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
 	logger := log.New()
-	traceID := generateTraceID()
+	traceID := traceIDFromContext(ctx)
 	startTime := time.Now()
 	ttl := 10 * time.Minute // 10-minute cache TTL for organization-wide tags
 
 	// Log request start
-	logVariableRequest(logger, traceID, "/resources/all-tags", req.Method, string(req.Body))
+	logVariableRequest(logger, traceID, "/resources/all-tags", req.Method, req.Body)
 
 	// Parse request body with enhanced validation
 	var allTagsReq AllTagsRequest
@@ -3554,7 +3577,9 @@ func (d *Datasource) VariableAllTagsHandler(ctx context.Context, req *backend.Ca
 	cacheKey := fmt.Sprintf("var-all-tags:%s:%s", allTagsReq.QueryType, allTagsReq.TagKey)
 
 	// Check cache first
-	if cached := d.GetCachedEntry(cacheKey, ttl); cached != nil {
+	cached := d.GetCachedAllTagsEntry(cacheKey, ttl)
+	setSpanCacheHit(ctx, cached != nil)
+	if cached != nil {
 		duration := time.Since(startTime)
 		logger.Debug("Returning cached comprehensive tags",
 			"traceID", traceID,
@@ -3703,7 +3728,7 @@ func (d *Datasource) VariableAllTagsHandler(ctx context.Context, req *backend.Ca
 	}
 
 	// Cache the result
-	d.SetCachedEntry(cacheKey, result)
+	d.SetCachedAllTagsEntry(cacheKey, result)
 
 	// Log successful completion
 	duration := time.Since(startTime)
@@ -3729,7 +3754,7 @@ func (d *Datasource) VariableAllTagsHandler(ctx context.Context, req *backend.Ca
 // Reuses existing concurrency limiting and timeout patterns from metrics implementation
 func (d *Datasource) LogsServicesHandler(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
 	logger := log.New()
-	traceID := generateTraceID()
+	traceID := traceIDFromContext(ctx)
 	startTime := time.Now()
 
 	logger.Info("LogsServicesHandler called", "traceID", traceID, "path", req.Path)
@@ -3744,7 +3769,11 @@ func (d *Datasource) LogsServicesHandler(ctx context.Context, req *backend.CallR
 	cacheKey := "logs_services"
 	cacheTTL := 30 * time.Second
 
-	if cachedEntry := d.GetCachedLogsAutocompleteEntry(cacheKey, cacheTTL); cachedEntry != nil {
+	cachedEntry := d.GetCachedLogsAutocompleteEntry(cacheKey, cacheTTL)
+
+	setSpanCacheHit(ctx, cachedEntry != nil)
+
+	if cachedEntry != nil {
 		logger.Info("LogsServicesHandler cache hit", "traceID", traceID, "serviceCount", len(cachedEntry.Data))
 
 		// Return cached data
@@ -3815,7 +3844,7 @@ func (d *Datasource) LogsServicesHandler(ctx context.Context, req *backend.CallR
 // Reuses existing concurrency limiting and timeout patterns from metrics implementation
 func (d *Datasource) LogsSourcesHandler(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
 	logger := log.New()
-	traceID := generateTraceID()
+	traceID := traceIDFromContext(ctx)
 	startTime := time.Now()
 
 	logger.Info("LogsSourcesHandler called", "traceID", traceID, "path", req.Path)
@@ -3830,7 +3859,11 @@ func (d *Datasource) LogsSourcesHandler(ctx context.Context, req *backend.CallRe
 	cacheKey := "logs_sources"
 	cacheTTL := 30 * time.Second
 
-	if cachedEntry := d.GetCachedLogsAutocompleteEntry(cacheKey, cacheTTL); cachedEntry != nil {
+	cachedEntry := d.GetCachedLogsAutocompleteEntry(cacheKey, cacheTTL)
+
+	setSpanCacheHit(ctx, cachedEntry != nil)
+
+	if cachedEntry != nil {
 		logger.Info("LogsSourcesHandler cache hit", "traceID", traceID, "sourceCount", len(cachedEntry.Data))
 
 		// Return cached data
@@ -3901,7 +3934,7 @@ func (d *Datasource) LogsSourcesHandler(ctx context.Context, req *backend.CallRe
 // Reuses existing concurrency limiting and timeout patterns from metrics implementation
 func (d *Datasource) LogsLevelsHandler(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
 	logger := log.New()
-	traceID := generateTraceID()
+	traceID := traceIDFromContext(ctx)
 	startTime := time.Now()
 
 	logger.Info("LogsLevelsHandler called", "traceID", traceID, "path", req.Path)
@@ -3916,7 +3949,11 @@ func (d *Datasource) LogsLevelsHandler(ctx context.Context, req *backend.CallRes
 	cacheKey := "logs_levels"
 	cacheTTL := 30 * time.Second
 
-	if cachedEntry := d.GetCachedLogsAutocompleteEntry(cacheKey, cacheTTL); cachedEntry != nil {
+	cachedEntry := d.GetCachedLogsAutocompleteEntry(cacheKey, cacheTTL)
+
+	setSpanCacheHit(ctx, cachedEntry != nil)
+
+	if cachedEntry != nil {
 		logger.Info("LogsLevelsHandler cache hit", "traceID", traceID, "levelCount", len(cachedEntry.Data))
 
 		// Return cached data
@@ -3979,7 +4016,7 @@ func (d *Datasource) LogsLevelsHandler(ctx context.Context, req *backend.CallRes
 // Reuses existing concurrency limiting and timeout patterns from metrics implementation
 func (d *Datasource) LogsFieldsHandler(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
 	logger := log.New()
-	traceID := generateTraceID()
+	traceID := traceIDFromContext(ctx)
 	startTime := time.Now()
 
 	logger.Info("LogsFieldsHandler called", "traceID", traceID, "path", req.Path)
@@ -3994,7 +4031,11 @@ func (d *Datasource) LogsFieldsHandler(ctx context.Context, req *backend.CallRes
 	cacheKey := "logs_fields"
 	cacheTTL := 30 * time.Second
 
-	if cachedEntry := d.GetCachedLogsAutocompleteEntry(cacheKey, cacheTTL); cachedEntry != nil {
+	cachedEntry := d.GetCachedLogsAutocompleteEntry(cacheKey, cacheTTL)
+
+	setSpanCacheHit(ctx, cachedEntry != nil)
+
+	if cachedEntry != nil {
 		logger.Info("LogsFieldsHandler cache hit", "traceID", traceID, "fieldCount", len(cachedEntry.Data))
 
 		// Return cached data
@@ -4064,7 +4105,7 @@ func (d *Datasource) LogsFieldsHandler(ctx context.Context, req *backend.CallRes
 // Reuses existing concurrency limiting and timeout patterns from metrics implementation
 func (d *Datasource) LogsFieldValuesHandler(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
 	logger := log.New()
-	traceID := generateTraceID()
+	traceID := traceIDFromContext(ctx)
 	startTime := time.Now()
 
 	// Extract field name from path
@@ -4152,13 +4193,13 @@ func (d *Datasource) fetchLogsFieldValues(ctx context.Context, fieldName, apiKey
 
 	// Use Datadog Logs Search API to get field values from actual log entries
 	// This is more reliable than the aggregation API which may not be available
-	url := fmt.Sprintf("https://api.%s/api/v2/logs/events/search", site)
+	url := d.apiBaseURL(site) + "/api/v2/logs/events/search"
 
 	// Use field name as-is - Datadog logs support dynamic field names
 	// The field extraction logic below will try multiple locations (@field, field, nested attributes)
 	datadogFieldName := fieldName
 
-	logger.Info("Fetching logs field values", "fieldName", fieldName, "datadogFieldName", datadogFieldName)
+	logger.Debug("Fetching logs field values", "fieldName", fieldName, "datadogFieldName", datadogFieldName)
 
 	// Create search request to get recent logs and extract field values
 	// Query recent logs (last 1 hour) to get current field values
@@ -4175,7 +4216,10 @@ func (d *Datasource) fetchLogsFieldValues(ctx context.Context, fieldName, apiKey
 		},
 	}
 
-	logger.Info("Logs search request", "fieldName", fieldName, "requestBody", requestBody)
+	// Only look at logs the governance policy allows
+	if err := d.policy().restrictLogsFilter(requestBody["filter"].(map[string]interface{})); err != nil {
+		return nil, err
+	}
 
 	// Marshal request body
 	jsonBody, err := json.Marshal(requestBody)
@@ -4195,7 +4239,7 @@ func (d *Datasource) fetchLogsFieldValues(ctx context.Context, fieldName, apiKey
 	req.Header.Set("DD-APPLICATION-KEY", appKey)
 
 	// Execute request
-	client := &http.Client{}
+	client := d.httpClient(0)
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute logs search request: %w", err)
@@ -4210,18 +4254,11 @@ func (d *Datasource) fetchLogsFieldValues(ctx context.Context, fieldName, apiKey
 
 	// Check for API errors
 	if resp.StatusCode != 200 {
-		logger.Error("Logs search API error", "fieldName", fieldName, "status", resp.StatusCode, "body", string(body))
-		return nil, fmt.Errorf("logs search API error for field %s: %d - %s", fieldName, resp.StatusCode, string(body))
+		logger.Error("Logs search API error", "fieldName", fieldName, "status", resp.StatusCode, "body", redactPayload(body))
+		return nil, &datadogAPIError{op: "logs search API error for field " + fieldName, status: resp.StatusCode, detail: datadogErrorDetail(body)}
 	}
 
-	logger.Info("Logs search API success", "fieldName", fieldName, "status", resp.StatusCode, "responseSize", len(body))
-
-	// Log first 500 chars of response for debugging
-	responsePreview := string(body)
-	if len(responsePreview) > 500 {
-		responsePreview = responsePreview[:500] + "..."
-	}
-	logger.Info("Logs search API response preview", "fieldName", fieldName, "response", responsePreview)
+	logger.Debug("Logs search API success", "fieldName", fieldName, "status", resp.StatusCode, "responseSize", len(body))
 
 	// Parse response - Logs Search API returns an array of log entries in data field
 	var searchResponse struct {
@@ -4231,7 +4268,7 @@ func (d *Datasource) fetchLogsFieldValues(ctx context.Context, fieldName, apiKey
 	}
 
 	if err := json.Unmarshal(body, &searchResponse); err != nil {
-		logger.Error("Failed to parse logs search response", "fieldName", fieldName, "error", err, "body", string(body))
+		logger.Error("Failed to parse logs search response", "fieldName", fieldName, "error", err, "body", redactPayload(body))
 		return nil, fmt.Errorf("failed to parse logs search response for field %s: %w", fieldName, err)
 	}
 
@@ -4278,24 +4315,10 @@ func (d *Datasource) fetchLogsFieldValues(ctx context.Context, fieldName, apiKey
 		if fieldValue != nil {
 			if strValue, ok := fieldValue.(string); ok && strValue != "" {
 				fieldValuesSet[strValue] = true
-				if i < 3 { // Log first few successful extractions for debugging
-					logger.Info("Found field value", "fieldName", fieldName, "value", strValue, "entryIndex", i)
-				}
 			}
-		} else if i < 3 { // Log first few failed extractions for debugging
+		} else if i < 3 { // Log first few failed extractions for debugging (attribute names only, never values)
 			availableKeys := getMapKeys(logEntry.Attributes)
-			logger.Info("No field value found", "fieldName", fieldName, "entryIndex", i, "availableKeys", availableKeys, "searchPatterns", searchPatterns)
-
-			// Also log some sample attribute values for debugging
-			sampleAttrs := make(map[string]interface{})
-			count := 0
-			for k, v := range logEntry.Attributes {
-				if count < 5 { // Log first 5 attributes
-					sampleAttrs[k] = v
-					count++
-				}
-			}
-			logger.Info("Sample log attributes", "fieldName", fieldName, "entryIndex", i, "sampleAttrs", sampleAttrs)
+			logger.Debug("No field value found", "fieldName", fieldName, "entryIndex", i, "availableKeys", availableKeys, "searchPatterns", searchPatterns)
 		}
 	}
 
@@ -4305,7 +4328,7 @@ func (d *Datasource) fetchLogsFieldValues(ctx context.Context, fieldName, apiKey
 		fieldValues = append(fieldValues, value)
 	}
 
-	logger.Info("Fetched logs field values", "field", fieldName, "count", len(fieldValues), "values", fieldValues)
+	logger.Debug("Fetched logs field values", "field", fieldName, "count", len(fieldValues))
 	return fieldValues, nil
 }
 
@@ -4323,14 +4346,16 @@ func getMapKeys(m map[string]interface{}) []string {
 // Reuses existing concurrency limiting and timeout patterns from metrics implementation
 func (d *Datasource) LogsTagsHandler(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
 	logger := log.New()
-	traceID := generateTraceID()
+	traceID := traceIDFromContext(ctx)
 	startTime := time.Now()
 
 	logger.Info("LogsTagsHandler called", "path", req.Path, "traceID", traceID)
 
 	// Check cache first (reusing existing caching pattern)
 	cacheKey := "logs_tags"
-	if cachedEntry := d.GetCachedLogsAutocompleteEntry(cacheKey, 30*time.Second); cachedEntry != nil {
+	cachedEntry := d.GetCachedLogsAutocompleteEntry(cacheKey, 30*time.Second)
+	setSpanCacheHit(ctx, cachedEntry != nil)
+	if cachedEntry != nil {
 		logger.Info("LogsTagsHandler cache hit", "tagCount", len(cachedEntry.Data), "traceID", traceID)
 
 		duration := time.Since(startTime)
@@ -4366,7 +4391,7 @@ func (d *Datasource) LogsTagsHandler(ctx context.Context, req *backend.CallResou
 		logger.Error("Failed to fetch tags from Datadog Logs API", "error", err, "traceID", traceID)
 		return sender.Send(&backend.CallResourceResponse{
 			Status: 500,
-			Body:   []byte(fmt.Sprintf(`{"error": "failed to fetch tags: %s"}`, err.Error())),
+			Body:   []byte(fmt.Sprintf(`{"error": %q}`, "failed to fetch tags: "+userErrorMessage(err))),
 		})
 	}
 
@@ -4399,7 +4424,7 @@ func (d *Datasource) LogsTagsHandler(ctx context.Context, req *backend.CallResou
 // Reuses existing concurrency limiting and timeout patterns from metrics implementation
 func (d *Datasource) LogsTagValuesHandler(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
 	logger := log.New()
-	traceID := generateTraceID()
+	traceID := traceIDFromContext(ctx)
 	startTime := time.Now()
 
 	// Extract tag name from path
@@ -4417,7 +4442,9 @@ func (d *Datasource) LogsTagValuesHandler(ctx context.Context, req *backend.Call
 
 	// Check cache first (reusing existing caching pattern)
 	cacheKey := fmt.Sprintf("logs_tag_values_%s", tagName)
-	if cachedEntry := d.GetCachedLogsAutocompleteEntry(cacheKey, 30*time.Second); cachedEntry != nil {
+	cachedEntry := d.GetCachedLogsAutocompleteEntry(cacheKey, 30*time.Second)
+	setSpanCacheHit(ctx, cachedEntry != nil)
+	if cachedEntry != nil {
 		logger.Info("LogsTagValuesHandler cache hit", "tagName", tagName, "valueCount", len(cachedEntry.Data), "traceID", traceID)
 
 		duration := time.Since(startTime)
@@ -4453,7 +4480,7 @@ func (d *Datasource) LogsTagValuesHandler(ctx context.Context, req *backend.Call
 		logger.Error("Failed to fetch tag values from Datadog Logs API", "error", err, "tagName", tagName, "traceID", traceID)
 		return sender.Send(&backend.CallResourceResponse{
 			Status: 500,
-			Body:   []byte(fmt.Sprintf(`{"error": "failed to fetch %s values: %s"}`, tagName, err.Error())),
+			Body:   []byte(fmt.Sprintf(`{"error": %q}`, fmt.Sprintf("failed to fetch %s values: %s", tagName, userErrorMessage(err)))),
 		})
 	}
 
@@ -4491,7 +4518,7 @@ func (d *Datasource) fetchLogsTags(ctx context.Context, apiKey, appKey, site str
 	defer cancel()
 
 	// Use Datadog Logs Search API to get tag names from actual log entries
-	url := fmt.Sprintf("https://api.%s/api/v2/logs/events/search", site)
+	url := d.apiBaseURL(site) + "/api/v2/logs/events/search"
 
 	// Create search request to get recent logs and extract tag names
 	// Query recent logs (last 6 hours) to get current tag names
@@ -4507,6 +4534,11 @@ func (d *Datasource) fetchLogsTags(ctx context.Context, apiKey, appKey, site str
 		},
 	}
 
+	// Only look at logs the governance policy allows
+	if err := d.policy().restrictLogsFilter(requestBody["filter"].(map[string]interface{})); err != nil {
+		return nil, err
+	}
+
 	// Marshal request body
 	jsonBody, err := json.Marshal(requestBody)
 	if err != nil {
@@ -4525,7 +4557,7 @@ func (d *Datasource) fetchLogsTags(ctx context.Context, apiKey, appKey, site str
 	req.Header.Set("DD-APPLICATION-KEY", appKey)
 
 	// Execute request
-	client := &http.Client{}
+	client := d.httpClient(0)
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute logs search request: %w", err)
@@ -4540,8 +4572,8 @@ func (d *Datasource) fetchLogsTags(ctx context.Context, apiKey, appKey, site str
 
 	// Check for API errors
 	if resp.StatusCode != 200 {
-		logger.Error("Logs search API error", "status", resp.StatusCode, "body", string(body))
-		return nil, fmt.Errorf("logs search API error: %d - %s", resp.StatusCode, string(body))
+		logger.Error("Logs search API error", "status", resp.StatusCode, "body", redactPayload(body))
+		return nil, &datadogAPIError{op: "logs search API error", status: resp.StatusCode, detail: datadogErrorDetail(body)}
 	}
 
 	// Parse response
@@ -4554,7 +4586,7 @@ func (d *Datasource) fetchLogsTags(ctx context.Context, apiKey, appKey, site str
 	}
 
 	if err := json.Unmarshal(body, &searchResponse); err != nil {
-		logger.Error("Failed to parse logs search response", "error", err, "body", string(body))
+		logger.Error("Failed to parse logs search response", "error", err, "body", redactPayload(body))
 		return nil, fmt.Errorf("failed to parse logs search response: %w", err)
 	}
 
@@ -4593,7 +4625,7 @@ func (d *Datasource) fetchLogsTagValues(ctx context.Context, tagName, apiKey, ap
 	defer cancel()
 
 	// Use Datadog Logs Search API to get tag values from actual log entries
-	url := fmt.Sprintf("https://api.%s/api/v2/logs/events/search", site)
+	url := d.apiBaseURL(site) + "/api/v2/logs/events/search"
 
 	// Create search request to get recent logs with the specific tag
 	// Query recent logs (last 6 hours) to get current tag values
@@ -4609,6 +4641,11 @@ func (d *Datasource) fetchLogsTagValues(ctx context.Context, tagName, apiKey, ap
 		},
 	}
 
+	// Only look at logs the governance policy allows
+	if err := d.policy().restrictLogsFilter(requestBody["filter"].(map[string]interface{})); err != nil {
+		return nil, err
+	}
+
 	// Marshal request body
 	jsonBody, err := json.Marshal(requestBody)
 	if err != nil {
@@ -4627,7 +4664,7 @@ func (d *Datasource) fetchLogsTagValues(ctx context.Context, tagName, apiKey, ap
 	req.Header.Set("DD-APPLICATION-KEY", appKey)
 
 	// Execute request
-	client := &http.Client{}
+	client := d.httpClient(0)
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute logs search request: %w", err)
@@ -4642,8 +4679,8 @@ func (d *Datasource) fetchLogsTagValues(ctx context.Context, tagName, apiKey, ap
 
 	// Check for API errors
 	if resp.StatusCode != 200 {
-		logger.Error("Logs search API error", "status", resp.StatusCode, "body", string(body))
-		return nil, fmt.Errorf("logs search API error: %d - %s", resp.StatusCode, string(body))
+		logger.Error("Logs search API error", "status", resp.StatusCode, "body", redactPayload(body))
+		return nil, &datadogAPIError{op: "logs search API error", status: resp.StatusCode, detail: datadogErrorDetail(body)}
 	}
 
 	// Parse response
@@ -4656,7 +4693,7 @@ func (d *Datasource) fetchLogsTagValues(ctx context.Context, tagName, apiKey, ap
 	}
 
 	if err := json.Unmarshal(body, &searchResponse); err != nil {
-		logger.Error("Failed to parse logs search response", "error", err, "body", string(body))
+		logger.Error("Failed to parse logs search response", "error", err, "body", redactPayload(body))
 		return nil, fmt.Errorf("failed to parse logs search response: %w", err)
 	}
 
@@ -4708,7 +4745,7 @@ func (d *Datasource) makeDatadogAPIRequest(ctx context.Context, method, url stri
 	req.Header.Set("Accept", "application/json")
 
 	// Execute the request with timeout (same as logs implementation)
-	client := &http.Client{Timeout: 30 * time.Second}
+	client := d.httpClient(30 * time.Second)
 	resp, err := client.Do(req)
 	if err != nil {
 		// Use existing error handling patterns for timeout and network errors
@@ -4729,8 +4766,8 @@ func (d *Datasource) makeDatadogAPIRequest(ctx context.Context, method, url stri
 			"method", method,
 			"url", url,
 			"statusCode", resp.StatusCode,
-			"responseBody", string(responseBody),
-			"requestBody", string(requestBody))
+			"responseBody", redactPayload(responseBody),
+			"requestBody", redactPayload(requestBody))
 
 		// Use existing error handling patterns
 		errorMsg := d.parseDatadogError(fmt.Errorf("HTTP %d", resp.StatusCode), resp.StatusCode, string(responseBody))
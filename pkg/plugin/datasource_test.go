@@ -14,14 +14,18 @@ import (
 func newTestDatasource(maxEntries int) *Datasource {
 	return &Datasource{
 		SecureJSONData: map[string]string{},
-		cache: &AutocompleteCache{
-			entries:    make(map[string]*CacheEntry),
-			order:      make([]string, 0, maxEntries),
-			maxEntries: maxEntries,
-		},
+		cache:          newMemoryCache(cacheAutocomplete, cacheLimits{maxEntries: maxEntries}),
 	}
 }
 
+// setCachedEntryAt stores an autocomplete entry as if it had been stored at storedAt.
+func setCachedEntryAt(d *Datasource, key string, data []string, storedAt time.Time) {
+	mc := d.cache.(*memoryCache)
+	mc.now = func() time.Time { return storedAt }
+	defer func() { mc.now = time.Now }()
+	d.SetCachedEntry(key, data)
+}
+
 // -------------------------------------------------------------------------
 // SetCachedEntry / GetCachedEntry — basic get/set
 // -------------------------------------------------------------------------
@@ -73,10 +77,7 @@ func TestCache_EvictsOldestWhenFull(t *testing.T) {
 	require.NotNil(t, d.GetCachedEntry("k4", time.Minute))
 
 	// Internal size must not exceed maxEntries.
-	d.cache.mu.Lock()
-	defer d.cache.mu.Unlock()
-	assert.LessOrEqual(t, len(d.cache.entries), max)
-	assert.LessOrEqual(t, len(d.cache.order), max)
+	assert.LessOrEqual(t, d.cache.Len(), max)
 }
 
 func TestCache_EvictsChainUntilCapacity(t *testing.T) {
@@ -87,10 +88,7 @@ func TestCache_EvictsChainUntilCapacity(t *testing.T) {
 		d.SetCachedEntry(fmt.Sprintf("k%d", i), []string{fmt.Sprintf("v%d", i)})
 	}
 
-	d.cache.mu.Lock()
-	defer d.cache.mu.Unlock()
-	assert.Equal(t, max, len(d.cache.entries), "cache should never exceed maxEntries")
-	assert.Equal(t, max, len(d.cache.order))
+	assert.Equal(t, max, d.cache.Len(), "cache should never exceed maxEntries")
 }
 
 func TestCache_UpdateExistingKeyDoesNotGrow(t *testing.T) {
@@ -104,13 +102,10 @@ func TestCache_UpdateExistingKeyDoesNotGrow(t *testing.T) {
 	// Update an existing key — size must stay at max.
 	d.SetCachedEntry("k1", []string{"updated"})
 
-	d.cache.mu.Lock()
-	defer d.cache.mu.Unlock()
-	assert.Equal(t, max, len(d.cache.entries), "update should not grow the cache")
-	assert.Equal(t, max, len(d.cache.order), "order slice should not grow on update")
+	assert.Equal(t, max, d.cache.Len(), "update should not grow the cache")
 
 	// The value should be updated.
-	entry := d.cache.entries["k1"]
+	entry := d.GetCachedEntry("k1", time.Minute)
 	require.NotNil(t, entry)
 	assert.Equal(t, []string{"updated"}, entry.Data)
 }
@@ -142,44 +137,33 @@ func TestCache_UpdatedKeyMovesToTailOfOrder(t *testing.T) {
 func TestCleanExpiredCache_RemovesExpiredEntries(t *testing.T) {
 	d := newTestDatasource(100)
 	d.SetCachedEntry("live", []string{"live"})
-	d.SetCachedEntry("stale", []string{"stale"})
-
 	// Back-date the "stale" entry so it appears expired.
-	d.cache.mu.Lock()
-	d.cache.entries["stale"].Timestamp = time.Now().Add(-2 * time.Hour)
-	d.cache.mu.Unlock()
+	setCachedEntryAt(d, "stale", []string{"stale"}, time.Now().Add(-2*time.Hour))
 
 	d.CleanExpiredCache(time.Hour)
+	assert.Equal(t, 1, d.cache.Len())
 
 	assert.NotNil(t, d.GetCachedEntry("live", time.Hour))
 	assert.Nil(t, d.GetCachedEntry("stale", time.Hour), "stale entry should be cleaned")
-
-	d.cache.mu.Lock()
-	defer d.cache.mu.Unlock()
-	assert.Equal(t, 1, len(d.cache.entries))
-	assert.Equal(t, 1, len(d.cache.order))
 }
 
-func TestCleanExpiredCache_CompactsOrderSlice(t *testing.T) {
+func TestCleanExpiredCache_ReleasesSpace(t *testing.T) {
 	d := newTestDatasource(100)
 
-	for i := 0; i < 5; i++ {
-		d.SetCachedEntry(fmt.Sprintf("k%d", i), []string{"v"})
-	}
-
 	// Expire all odd-indexed keys.
-	d.cache.mu.Lock()
-	for i := 1; i < 5; i += 2 {
-		d.cache.entries[fmt.Sprintf("k%d", i)].Timestamp = time.Now().Add(-2 * time.Hour)
+	for i := 0; i < 5; i++ {
+		storedAt := time.Now()
+		if i%2 == 1 {
+			storedAt = storedAt.Add(-2 * time.Hour)
+		}
+		setCachedEntryAt(d, fmt.Sprintf("k%d", i), []string{"v"}, storedAt)
 	}
-	d.cache.mu.Unlock()
+	sizeBefore := d.cache.Size()
 
 	d.CleanExpiredCache(time.Hour)
 
-	d.cache.mu.Lock()
-	defer d.cache.mu.Unlock()
-	assert.Equal(t, len(d.cache.entries), len(d.cache.order),
-		"order slice length must match entries map length after cleanup")
+	assert.Equal(t, 3, d.cache.Len())
+	assert.Equal(t, sizeBefore*3/5, d.cache.Size(), "cleanup must release the size of removed entries")
 }
 
 // -------------------------------------------------------------------------
@@ -202,7 +186,58 @@ func TestCache_ConcurrentWritesDoNotRace(t *testing.T) {
 	wg.Wait()
 
 	// Cache size must remain within bounds.
-	d.cache.mu.Lock()
-	defer d.cache.mu.Unlock()
-	assert.LessOrEqual(t, len(d.cache.entries), 50)
+	assert.LessOrEqual(t, d.cache.Len(), 50)
+}
+
+// -------------------------------------------------------------------------
+// Base URL override
+// -------------------------------------------------------------------------
+
+func TestValidateBaseURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		baseURL string
+		wantErr bool
+	}{
+		{"empty is allowed", "", false},
+		{"https host", "https://api.datadoghq.com", false},
+		{"host with port and path prefix", "https://dd-proxy.internal:8443/datadog", false},
+		{"plain http for local fakes", "http://127.0.0.1:9000", false},
+		{"missing scheme", "api.datadoghq.com", true},
+		{"unsupported scheme", "ftp://api.datadoghq.com", true},
+		{"query string", "https://proxy.internal/?token=x", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateBaseURL(tt.baseURL)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestAPIBaseURL(t *testing.T) {
+	d := &Datasource{JSONData: &MyDataSourceOptions{Site: "datadoghq.eu"}}
+	assert.Equal(t, "https://api.datadoghq.eu", d.apiBaseURL("datadoghq.eu"))
+
+	d.JSONData.BaseURL = "https://dd-proxy.internal:8443/datadog/"
+	assert.Equal(t, "https://dd-proxy.internal:8443/datadog", d.apiBaseURL("datadoghq.eu"),
+		"configured base URL should win and drop the trailing slash")
+
+	// A Datasource without JSONData (as used by parser tests) falls back to the site.
+	assert.Equal(t, "https://api.datadoghq.com", (&Datasource{}).apiBaseURL("datadoghq.com"))
+}
+
+func TestValidateCredentials_RejectsInvalidBaseURL(t *testing.T) {
+	d := &Datasource{
+		SecureJSONData: map[string]string{"apiKey": "api", "appKey": "app"},
+		JSONData:       &MyDataSourceOptions{BaseURL: "not a url"},
+	}
+	_, _, _, err := d.validateCredentials()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "base URL")
 }
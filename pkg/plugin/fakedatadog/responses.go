@@ -0,0 +1,348 @@
+package fakedatadog
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultTags are returned by EndpointTagsByMetric unless a test scripts otherwise.
+var defaultTags = []string{"host:web-01", "host:web-02", "env:prod", "service:checkout"}
+
+// JSON returns a 200 response whose body is v encoded as JSON.
+func JSON(v interface{}) Response {
+	return Response{Status: http.StatusOK, Body: v}
+}
+
+// Error returns a Datadog-style error response: {"errors": [...]} with the given status.
+func Error(status int, messages ...string) Response {
+	if len(messages) == 0 {
+		messages = []string{http.StatusText(status)}
+	}
+	return Response{Status: status, Body: map[string]interface{}{"errors": messages}}
+}
+
+// RateLimited returns a 429 response carrying the X-RateLimit-* headers Datadog sends
+// when an organisation exceeds its per-endpoint quota.
+func RateLimited(reset time.Duration) Response {
+	resp := Error(http.StatusTooManyRequests, "Too many requests")
+	resp.Header = map[string]string{
+		"X-RateLimit-Limit":     "300",
+		"X-RateLimit-Period":    "3600",
+		"X-RateLimit-Remaining": "0",
+		"X-RateLimit-Reset":     strconv.Itoa(int(reset.Seconds())),
+	}
+	return resp
+}
+
+// Series describes one timeseries in a TimeseriesResponse.
+type Series struct {
+	QueryIndex int
+	GroupTags  []string
+	Unit       string
+	// Values holds one point per timestamp; nil entries are encoded as JSON null.
+	Values []*float64
+}
+
+// TimeseriesResponse builds a /api/v2/query/timeseries payload.
+func TimeseriesResponse(times []int64, series ...Series) map[string]interface{} {
+	seriesMeta := make([]map[string]interface{}, 0, len(series))
+	values := make([][]*float64, 0, len(series))
+	for _, s := range series {
+		meta := map[string]interface{}{
+			"query_index": s.QueryIndex,
+			"group_tags":  nonNil(s.GroupTags),
+		}
+		if s.Unit != "" {
+			meta["unit"] = []map[string]interface{}{{"family": "general", "name": s.Unit, "short_name": s.Unit}}
+		}
+		seriesMeta = append(seriesMeta, meta)
+		values = append(values, s.Values)
+	}
+	return map[string]interface{}{
+		"data": map[string]interface{}{
+			"type": "timeseries_response",
+			"attributes": map[string]interface{}{
+				"series": seriesMeta,
+				"times":  times,
+				"values": values,
+			},
+		},
+	}
+}
+
+// ScalarRow is one group of a ScalarResponse: a value per group-by tag and a value per query.
+type ScalarRow struct {
+	Group  []string
+	Values []*float64
+}
+
+// ScalarResponse builds a /api/v2/query/scalar payload with a group column per groupBy tag and
+// a number column per query name.
+func ScalarResponse(groupBy, queries []string, rows ...ScalarRow) map[string]interface{} {
+	columns := make([]map[string]interface{}, 0, len(groupBy)+len(queries))
+	for i, tag := range groupBy {
+		values := make([][]string, 0, len(rows))
+		for _, row := range rows {
+			values = append(values, []string{row.Group[i]})
+		}
+		columns = append(columns, map[string]interface{}{"type": "group", "name": tag, "values": values})
+	}
+	for i, name := range queries {
+		values := make([]*float64, 0, len(rows))
+		for _, row := range rows {
+			values = append(values, row.Values[i])
+		}
+		columns = append(columns, map[string]interface{}{"type": "number", "name": name, "values": values})
+	}
+	return map[string]interface{}{
+		"data": map[string]interface{}{
+			"type":       "scalar_response",
+			"attributes": map[string]interface{}{"columns": columns},
+		},
+	}
+}
+
+// Points converts plain values into the pointer slice used by Series.
+func Points(values ...float64) []*float64 {
+	out := make([]*float64, len(values))
+	for i := range values {
+		v := values[i]
+		out[i] = &v
+	}
+	return out
+}
+
+// LogEvent describes one log returned by LogsSearchResponse.
+type LogEvent struct {
+	ID         string
+	Timestamp  time.Time
+	Message    string
+	Status     string
+	Service    string
+	Host       string
+	Tags       []string
+	Attributes map[string]interface{}
+}
+
+// LogsSearchResponse builds a /api/v2/logs/events/search payload. A non-empty
+// after cursor is returned in meta.page.after to signal another page.
+func LogsSearchResponse(after string, events ...LogEvent) map[string]interface{} {
+	data := make([]map[string]interface{}, 0, len(events))
+	for i, e := range events {
+		id := e.ID
+		if id == "" {
+			id = fmt.Sprintf("AQAAAYfake%06d", i)
+		}
+		attrs := e.Attributes
+		if attrs == nil {
+			attrs = map[string]interface{}{}
+		}
+		data = append(data, map[string]interface{}{
+			"id":   id,
+			"type": "log",
+			"attributes": map[string]interface{}{
+				"timestamp":  e.Timestamp.UTC().Format(time.RFC3339Nano),
+				"message":    e.Message,
+				"status":     e.Status,
+				"service":    e.Service,
+				"host":       e.Host,
+				"tags":       nonNil(e.Tags),
+				"attributes": attrs,
+			},
+		})
+	}
+	meta := map[string]interface{}{"status": "done"}
+	if after != "" {
+		meta["page"] = map[string]interface{}{"after": after}
+	}
+	return map[string]interface{}{"data": data, "meta": meta}
+}
+
+// AggregateBucket is one group returned by LogsAggregateResponse.
+type AggregateBucket struct {
+	By    map[string]interface{}
+	Count int
+}
+
+// LogsAggregateResponse builds a /api/v2/logs/analytics/aggregate payload with a count compute.
+func LogsAggregateResponse(buckets ...AggregateBucket) map[string]interface{} {
+	out := make([]map[string]interface{}, 0, len(buckets))
+	for _, b := range buckets {
+		out = append(out, map[string]interface{}{
+			"by":       b.By,
+			"computes": map[string]interface{}{"c0": b.Count},
+		})
+	}
+	return map[string]interface{}{
+		"data": map[string]interface{}{"buckets": out},
+		"meta": map[string]interface{}{"status": "done"},
+	}
+}
+
+// RUMEvent describes one event returned by RUMEventsResponse.
+type RUMEvent struct {
+	ID         string
+	Timestamp  time.Time
+	Service    string
+	Attributes map[string]interface{}
+}
+
+// RUMEventsResponse builds a /api/v2/rum/events/search payload. A non-empty after cursor is
+// returned in meta.page.after to signal another page.
+func RUMEventsResponse(after string, events ...RUMEvent) map[string]interface{} {
+	data := make([]map[string]interface{}, 0, len(events))
+	for i, e := range events {
+		id := e.ID
+		if id == "" {
+			id = fmt.Sprintf("AQAAAYrumfake%06d", i)
+		}
+		attrs := e.Attributes
+		if attrs == nil {
+			attrs = map[string]interface{}{}
+		}
+		data = append(data, map[string]interface{}{
+			"id":   id,
+			"type": "rum",
+			"attributes": map[string]interface{}{
+				"timestamp":  e.Timestamp.UTC().Format(time.RFC3339Nano),
+				"service":    e.Service,
+				"tags":       []string{},
+				"attributes": attrs,
+			},
+		})
+	}
+	meta := map[string]interface{}{"status": "done"}
+	if after != "" {
+		meta["page"] = map[string]interface{}{"after": after}
+	}
+	return map[string]interface{}{"data": data, "meta": meta}
+}
+
+// RUMSeries is one group returned by RUMAggregateResponse, with a value per time.
+type RUMSeries struct {
+	By     map[string]interface{}
+	Values []*float64
+}
+
+// RUMAggregateResponse builds a /api/v2/rum/analytics/aggregate payload with a timeseries
+// compute named c0.
+func RUMAggregateResponse(times []time.Time, series ...RUMSeries) map[string]interface{} {
+	buckets := make([]map[string]interface{}, 0, len(series))
+	for _, s := range series {
+		points := make([]map[string]interface{}, 0, len(s.Values))
+		for i, v := range s.Values {
+			point := map[string]interface{}{"time": times[i].UTC().Format(time.RFC3339)}
+			if v != nil {
+				point["value"] = *v
+			}
+			points = append(points, point)
+		}
+		buckets = append(buckets, map[string]interface{}{
+			"by":       s.By,
+			"computes": map[string]interface{}{"c0": points},
+		})
+	}
+	return map[string]interface{}{
+		"data": map[string]interface{}{"buckets": buckets},
+		"meta": map[string]interface{}{"status": "done"},
+	}
+}
+
+// MetricsListResponse builds a /api/v1/metrics payload.
+func MetricsListResponse(metrics ...string) map[string]interface{} {
+	return map[string]interface{}{
+		"metrics": nonNil(metrics),
+		"from":    strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10),
+	}
+}
+
+// TagConfigurationsResponse builds a /api/v2/metrics payload listing the given metric names.
+func TagConfigurationsResponse(metrics ...string) map[string]interface{} {
+	data := make([]map[string]interface{}, 0, len(metrics))
+	for _, m := range metrics {
+		data = append(data, map[string]interface{}{"id": m, "type": "metrics"})
+	}
+	return map[string]interface{}{"data": data}
+}
+
+// TagsByMetric builds a /api/v2/metrics/{metric_name}/all-tags payload.
+func TagsByMetric(metric string, tags ...string) map[string]interface{} {
+	return map[string]interface{}{
+		"data": map[string]interface{}{
+			"id":   metric,
+			"type": "metrics",
+			"attributes": map[string]interface{}{
+				"tags": nonNil(tags),
+			},
+		},
+	}
+}
+
+// MetricMetadataResponse builds a /api/v1/metrics/{metric_name} payload.
+func MetricMetadataResponse(unit, perUnit, metricType, description string) map[string]interface{} {
+	out := map[string]interface{}{"type": metricType, "description": description}
+	if unit != "" {
+		out["unit"] = unit
+	}
+	if perUnit != "" {
+		out["per_unit"] = perUnit
+	}
+	return out
+}
+
+// defaultMetricMetadata returns the metadata of the default metrics, and 404 for others.
+func defaultMetricMetadata(metric string) Response {
+	switch metric {
+	case "system.cpu.user":
+		return JSON(MetricMetadataResponse("percent", "", "gauge", "Percent of time the CPU spent running user space processes."))
+	case "system.mem.used":
+		return JSON(MetricMetadataResponse("byte", "", "gauge", "Amount of RAM in use."))
+	}
+	return Error(http.StatusNotFound, "Metric not found")
+}
+
+// defaultResponses returns a realistic happy-path response for every endpoint.
+// EndpointTagsByMetric and EndpointMetricMetadata are left empty so the served payload
+// depends on the requested metric.
+func defaultResponses() map[Endpoint]Response {
+	now := time.Now().Truncate(time.Minute)
+	times := []int64{
+		now.Add(-2 * time.Minute).UnixMilli(),
+		now.Add(-1 * time.Minute).UnixMilli(),
+		now.UnixMilli(),
+	}
+	return map[Endpoint]Response{
+		EndpointTimeseriesQuery: JSON(TimeseriesResponse(times,
+			Series{QueryIndex: 0, GroupTags: []string{"host:web-01"}, Values: Points(1, 2, 3)},
+		)),
+		EndpointLogsSearch: JSON(LogsSearchResponse("",
+			LogEvent{Timestamp: now.Add(-30 * time.Second), Message: "GET /checkout 200", Status: "info", Service: "checkout", Host: "web-01", Tags: []string{"env:prod", "service:checkout"}},
+			LogEvent{Timestamp: now.Add(-10 * time.Second), Message: "payment declined", Status: "error", Service: "checkout", Host: "web-02", Tags: []string{"env:prod", "service:checkout"}},
+		)),
+		EndpointLogsAggregate: JSON(LogsAggregateResponse(
+			AggregateBucket{By: map[string]interface{}{"service": "checkout"}, Count: 42},
+		)),
+		EndpointMetricsList:         JSON(MetricsListResponse("system.cpu.user", "system.mem.used")),
+		EndpointTagConfigurations:   JSON(TagConfigurationsResponse("system.cpu.user", "system.mem.used")),
+		EndpointTagsByMetric:        {},
+		EndpointMetricMetadata:      {},
+		EndpointDashboard:           Error(http.StatusNotFound, "Dashboard not found"),
+		EndpointMonitor:             Error(http.StatusNotFound, "Monitor not found"),
+		EndpointMonitorsList:        JSON([]interface{}{}),
+		EndpointNotebooksList:       JSON(map[string]interface{}{"data": []interface{}{}}),
+		EndpointScalarQuery:         JSON(ScalarResponse(nil, nil)),
+		EndpointServiceDependencies: JSON(map[string]interface{}{}),
+		EndpointServiceDefinitions:  JSON(map[string]interface{}{"data": []interface{}{}}),
+		EndpointRUMSearch:           JSON(RUMEventsResponse("")),
+		EndpointRUMAggregate:        JSON(RUMAggregateResponse(nil)),
+	}
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
@@ -0,0 +1,341 @@
+// Package fakedatadog provides an in-process fake of the Datadog HTTP API for integration tests.
+//
+// It serves the subset of endpoints the plugin talks to (timeseries query, logs search and
+// aggregate, metrics list, metric metadata, tag configurations and tags-by-metric) with realistic default
+// payloads. Tests can script per-endpoint responses, add latency, return 429s and error bodies,
+// and inspect the requests that were received. Point the datasource at it through the
+// `baseUrl` JSON option:
+//
+//	srv := fakedatadog.New()
+//	defer srv.Close()
+//	jsonData := fmt.Sprintf(`{"baseUrl": %q}`, srv.URL())
+package fakedatadog
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Credentials accepted by a server created with New.
+const (
+	TestAPIKey = "fake-api-key"
+	TestAppKey = "fake-app-key"
+)
+
+// Endpoint identifies one of the Datadog API operations served by the fake.
+type Endpoint string
+
+const (
+	// EndpointTimeseriesQuery is POST /api/v2/query/timeseries (MetricsApi.QueryTimeseriesData).
+	EndpointTimeseriesQuery Endpoint = "timeseries_query"
+	// EndpointLogsSearch is POST /api/v2/logs/events/search.
+	EndpointLogsSearch Endpoint = "logs_search"
+	// EndpointLogsAggregate is POST /api/v2/logs/analytics/aggregate (also served at /api/v2/logs/aggregate).
+	EndpointLogsAggregate Endpoint = "logs_aggregate"
+	// EndpointMetricsList is GET /api/v1/metrics (active metrics list).
+	EndpointMetricsList Endpoint = "metrics_list"
+	// EndpointTagConfigurations is GET /api/v2/metrics (MetricsApi.ListTagConfigurations).
+	EndpointTagConfigurations Endpoint = "tag_configurations"
+	// EndpointTagsByMetric is GET /api/v2/metrics/{metric_name}/all-tags (MetricsApi.ListTagsByMetricName).
+	EndpointTagsByMetric Endpoint = "tags_by_metric"
+	// EndpointMetricMetadata is GET /api/v1/metrics/{metric_name} (MetricsApi.GetMetricMetadata).
+	EndpointMetricMetadata Endpoint = "metric_metadata"
+	// EndpointDashboard is GET /api/v1/dashboard/{dashboard_id} (DashboardsApi.GetDashboard).
+	EndpointDashboard Endpoint = "dashboard"
+	// EndpointMonitor is GET /api/v1/monitor/{monitor_id} (MonitorsApi.GetMonitor).
+	EndpointMonitor Endpoint = "monitor"
+	// EndpointMonitorsList is GET /api/v1/monitor (MonitorsApi.ListMonitors).
+	EndpointMonitorsList Endpoint = "monitors_list"
+	// EndpointNotebooksList is GET /api/v1/notebooks (NotebooksApi.ListNotebooks).
+	EndpointNotebooksList Endpoint = "notebooks_list"
+	// EndpointScalarQuery is POST /api/v2/query/scalar (MetricsApi.QueryScalarData).
+	EndpointScalarQuery Endpoint = "scalar_query"
+	// EndpointServiceDependencies is GET /api/v1/service_dependencies (APM service dependencies).
+	EndpointServiceDependencies Endpoint = "service_dependencies"
+	// EndpointServiceDefinitions is GET /api/v2/services/definitions (ServiceDefinitionApi.ListServiceDefinitions).
+	EndpointServiceDefinitions Endpoint = "service_definitions"
+	// EndpointRUMSearch is POST /api/v2/rum/events/search (RUMApi.SearchRUMEvents).
+	EndpointRUMSearch Endpoint = "rum_search"
+	// EndpointRUMAggregate is POST /api/v2/rum/analytics/aggregate (RUMApi.AggregateRUMEvents).
+	EndpointRUMAggregate Endpoint = "rum_aggregate"
+)
+
+// Response is a scripted reply for an endpoint.
+type Response struct {
+	// Status is the HTTP status code; zero means 200.
+	Status int
+	// Body is written as-is when it is a string or []byte, otherwise it is JSON encoded.
+	Body interface{}
+	// Header holds extra response headers.
+	Header map[string]string
+	// Delay is waited before the response is written, on top of the endpoint latency.
+	Delay time.Duration
+}
+
+// Request is a recorded inbound request.
+type Request struct {
+	Endpoint Endpoint
+	Method   string
+	Path     string
+	Query    url.Values
+	Header   http.Header
+	Body     []byte
+}
+
+// DecodeBody unmarshals the recorded JSON request body into v.
+func (r Request) DecodeBody(v interface{}) error {
+	return json.Unmarshal(r.Body, v)
+}
+
+// Server is a fake Datadog API backed by httptest.Server.
+// All methods are safe for concurrent use.
+type Server struct {
+	srv *httptest.Server
+
+	mu        sync.Mutex
+	apiKey    string
+	appKey    string
+	queued    map[Endpoint][]Response
+	defaults  map[Endpoint]Response
+	latency   map[Endpoint]time.Duration
+	requests  []Request
+	inFlight  int
+	maxFlight int
+}
+
+// New starts a fake Datadog API with realistic default responses for every endpoint.
+// Requests must carry TestAPIKey and TestAppKey, otherwise the server answers 403.
+func New() *Server {
+	s := &Server{
+		apiKey:   TestAPIKey,
+		appKey:   TestAppKey,
+		queued:   make(map[Endpoint][]Response),
+		defaults: defaultResponses(),
+		latency:  make(map[Endpoint]time.Duration),
+	}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// URL returns the base URL of the fake, suitable for the datasource `baseUrl` option.
+func (s *Server) URL() string {
+	return s.srv.URL
+}
+
+// Close shuts the server down, unblocking any delayed responses.
+func (s *Server) Close() {
+	s.srv.CloseClientConnections()
+	s.srv.Close()
+}
+
+// SetCredentials changes the API and application keys the server accepts.
+// Empty values disable the corresponding check.
+func (s *Server) SetCredentials(apiKey, appKey string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.apiKey = apiKey
+	s.appKey = appKey
+}
+
+// SetDefault replaces the response returned for ep whenever no queued response is pending.
+func (s *Server) SetDefault(ep Endpoint, resp Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.defaults[ep] = resp
+}
+
+// Enqueue schedules one-shot responses for ep. They are served in order before
+// falling back to the endpoint default, which makes sequences such as
+// "429, then 200" easy to express.
+func (s *Server) Enqueue(ep Endpoint, responses ...Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queued[ep] = append(s.queued[ep], responses...)
+}
+
+// SetLatency adds a fixed delay to every response from ep.
+func (s *Server) SetLatency(ep Endpoint, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency[ep] = d
+}
+
+// Requests returns the recorded requests for ep, or all requests when ep is empty.
+func (s *Server) Requests(ep Endpoint) []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Request, 0, len(s.requests))
+	for _, r := range s.requests {
+		if ep == "" || r.Endpoint == ep {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// Hits returns how many requests ep has received.
+func (s *Server) Hits(ep Endpoint) int {
+	return len(s.Requests(ep))
+}
+
+// MaxInFlight returns the highest number of requests that were being served concurrently.
+func (s *Server) MaxInFlight() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.maxFlight
+}
+
+// Reset drops recorded requests and queued responses and restores the default responses.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queued = make(map[Endpoint][]Response)
+	s.defaults = defaultResponses()
+	s.latency = make(map[Endpoint]time.Duration)
+	s.requests = nil
+	s.maxFlight = 0
+}
+
+// route maps a method and path onto an Endpoint.
+func route(method, path string) (Endpoint, bool) {
+	switch {
+	case method == http.MethodPost && path == "/api/v2/query/timeseries":
+		return EndpointTimeseriesQuery, true
+	case method == http.MethodPost && path == "/api/v2/logs/events/search":
+		return EndpointLogsSearch, true
+	case method == http.MethodPost && (path == "/api/v2/logs/analytics/aggregate" || path == "/api/v2/logs/aggregate"):
+		return EndpointLogsAggregate, true
+	case method == http.MethodGet && path == "/api/v1/metrics":
+		return EndpointMetricsList, true
+	case method == http.MethodGet && path == "/api/v2/metrics":
+		return EndpointTagConfigurations, true
+	case method == http.MethodGet && strings.HasPrefix(path, "/api/v2/metrics/") && strings.HasSuffix(path, "/all-tags"):
+		return EndpointTagsByMetric, true
+	case method == http.MethodGet && strings.HasPrefix(path, "/api/v1/metrics/"):
+		return EndpointMetricMetadata, true
+	case method == http.MethodGet && strings.HasPrefix(path, "/api/v1/dashboard/"):
+		return EndpointDashboard, true
+	case method == http.MethodGet && path == "/api/v1/monitor":
+		return EndpointMonitorsList, true
+	case method == http.MethodGet && strings.HasPrefix(path, "/api/v1/monitor/"):
+		return EndpointMonitor, true
+	case method == http.MethodGet && path == "/api/v1/notebooks":
+		return EndpointNotebooksList, true
+	case method == http.MethodPost && path == "/api/v2/query/scalar":
+		return EndpointScalarQuery, true
+	case method == http.MethodGet && path == "/api/v1/service_dependencies":
+		return EndpointServiceDependencies, true
+	case method == http.MethodGet && path == "/api/v2/services/definitions":
+		return EndpointServiceDefinitions, true
+	case method == http.MethodPost && path == "/api/v2/rum/events/search":
+		return EndpointRUMSearch, true
+	case method == http.MethodPost && path == "/api/v2/rum/analytics/aggregate":
+		return EndpointRUMAggregate, true
+	}
+	return "", false
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	ep, ok := route(r.Method, r.URL.Path)
+	if !ok {
+		writeResponse(w, Error(http.StatusNotFound, "Not found"))
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{
+		Endpoint: ep,
+		Method:   r.Method,
+		Path:     r.URL.Path,
+		Query:    r.URL.Query(),
+		Header:   r.Header.Clone(),
+		Body:     body,
+	})
+	s.inFlight++
+	if s.inFlight > s.maxFlight {
+		s.maxFlight = s.inFlight
+	}
+	authorized := (s.apiKey == "" || r.Header.Get("DD-API-KEY") == s.apiKey) &&
+		(s.appKey == "" || r.Header.Get("DD-APPLICATION-KEY") == s.appKey)
+	resp := s.defaults[ep]
+	if queue := s.queued[ep]; len(queue) > 0 {
+		resp = queue[0]
+		s.queued[ep] = queue[1:]
+	}
+	delay := s.latency[ep] + resp.Delay
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		s.inFlight--
+		s.mu.Unlock()
+	}()
+
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		select {
+		case <-r.Context().Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+
+	if !authorized {
+		writeResponse(w, Error(http.StatusForbidden, "Forbidden"))
+		return
+	}
+	if ep == EndpointTagsByMetric && resp.Body == nil && resp.Status == 0 {
+		resp = JSON(TagsByMetric(metricFromPath(r.URL.Path), defaultTags...))
+	}
+	if ep == EndpointMetricMetadata && resp.Body == nil && resp.Status == 0 {
+		resp = defaultMetricMetadata(strings.TrimPrefix(r.URL.Path, "/api/v1/metrics/"))
+	}
+	writeResponse(w, resp)
+}
+
+// metricFromPath extracts {metric_name} from /api/v2/metrics/{metric_name}/all-tags.
+func metricFromPath(path string) string {
+	name := strings.TrimSuffix(strings.TrimPrefix(path, "/api/v2/metrics/"), "/all-tags")
+	if unescaped, err := url.PathUnescape(name); err == nil {
+		return unescaped
+	}
+	return name
+}
+
+func writeResponse(w http.ResponseWriter, resp Response) {
+	var payload []byte
+	switch b := resp.Body.(type) {
+	case nil:
+	case []byte:
+		payload = b
+	case string:
+		payload = []byte(b)
+	default:
+		encoded, err := json.Marshal(b)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		payload = encoded
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	for k, v := range resp.Header {
+		w.Header().Set(k, v)
+	}
+	status := resp.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	_, _ = w.Write(payload)
+}
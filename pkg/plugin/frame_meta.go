@@ -0,0 +1,106 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Frame metadata shown in Query Inspector: the query text sent to Datadog after rewriting,
+// statistics on how it was answered, and notices about data left out of the frames.
+
+// queryStats describes how one query was answered.
+type queryStats struct {
+	// latency is the time spent waiting for Datadog; 0 when the query was answered from cache
+	latency time.Duration
+	pages   int
+	cached  bool
+	// rows counts the series of a metrics query or the lines of a logs query
+	rows      int
+	rowsName  string
+	truncated bool
+}
+
+// frameStats returns the statistics as they are listed in Query Inspector.
+func (s queryStats) frameStats() []data.QueryStat {
+	stats := []data.QueryStat{}
+	if s.latency > 0 {
+		stats = append(stats, queryStat("API latency", "ms", float64(s.latency.Milliseconds())))
+	}
+	return append(stats,
+		queryStat("Pages fetched", "", float64(s.pages)),
+		queryStat("Cache hit", "bool", boolStat(s.cached)),
+		queryStat(s.rowsName, "", float64(s.rows)),
+		queryStat("Truncated", "bool", boolStat(s.truncated)),
+	)
+}
+
+func queryStat(name, unit string, value float64) data.QueryStat {
+	return data.QueryStat{FieldConfig: data.FieldConfig{DisplayName: name, Unit: unit}, Value: value}
+}
+
+func boolStat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// annotateFrames records the executed query on every frame of a query, and its statistics
+// and notices on the first one. A query without frames gets an empty one to carry them.
+func annotateFrames(frames data.Frames, refID, executed string, stats queryStats, notices []data.Notice) data.Frames {
+	if len(frames) == 0 {
+		frame := data.NewFrame("")
+		frame.RefID = refID
+		frames = data.Frames{frame}
+	}
+	for _, frame := range frames {
+		if frame.Meta == nil {
+			frame.Meta = &data.FrameMeta{}
+		}
+		frame.Meta.ExecutedQueryString = executed
+	}
+	first := frames[0].Meta
+	first.Stats = append(first.Stats, stats.frameStats()...)
+	first.Notices = append(first.Notices, notices...)
+	return frames
+}
+
+// droppedNullsNotice tells that null points returned by Datadog are not plotted.
+func droppedNullsNotice(count int) data.Notice {
+	return data.Notice{
+		Severity: data.NoticeSeverityInfo,
+		Text:     fmt.Sprintf("Dropped %d null points returned by Datadog", count),
+	}
+}
+
+// partialLogsNotice tells that the pages of a logs query stopped early because of rate limiting.
+func partialLogsNotice(pages int) data.Notice {
+	return data.Notice{
+		Severity: data.NoticeSeverityWarning,
+		Text: fmt.Sprintf("Showing partial results: requests were rate limited after %d pages of logs; "+
+			"refresh later for the rest", pages),
+	}
+}
+
+// logsExecutedQuery is the executed query string of a logs search.
+func logsExecutedQuery(logsQuery string, indexes []string) string {
+	if len(indexes) == 0 {
+		return logsQuery
+	}
+	return fmt.Sprintf("%s (indexes: %s)", logsQuery, strings.Join(indexes, ", "))
+}
+
+// isRateLimitError reports whether a request was refused for rate, by Datadog or by the
+// request budget.
+func isRateLimitError(err error) bool {
+	var limitErr *limitError
+	if errors.As(err, &limitErr) {
+		return limitErr.status == backend.StatusTooManyRequests
+	}
+	return strings.Contains(err.Error(), "rate limit") || strings.Contains(err.Error(), "429")
+}
@@ -0,0 +1,131 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wasilak/grafana-datadog-datasource/pkg/plugin/fakedatadog"
+)
+
+// frameStats returns the statistics of the first frame of a response by name.
+func frameStats(t *testing.T, res backend.DataResponse) map[string]float64 {
+	t.Helper()
+	require.NotEmpty(t, res.Frames)
+	require.NotNil(t, res.Frames[0].Meta)
+	stats := map[string]float64{}
+	for _, s := range res.Frames[0].Meta.Stats {
+		stats[s.DisplayName] = s.Value
+	}
+	return stats
+}
+
+func TestAnnotateFrames(t *testing.T) {
+	stats := queryStats{latency: 120 * time.Millisecond, pages: 1, rows: 2, rowsName: "Series"}
+	notice := data.Notice{Severity: data.NoticeSeverityInfo, Text: "note"}
+
+	frames := annotateFrames(data.Frames{data.NewFrame("a"), data.NewFrame("b")}, "A", "avg:cpu{*} by {*}", stats, []data.Notice{notice})
+	require.Len(t, frames, 2)
+	for _, f := range frames {
+		assert.Equal(t, "avg:cpu{*} by {*}", f.Meta.ExecutedQueryString)
+	}
+	assert.Equal(t, []data.Notice{notice}, frames[0].Meta.Notices)
+	assert.Empty(t, frames[1].Meta.Stats, "statistics are only on the first frame")
+	assert.Equal(t, []data.QueryStat{
+		queryStat("API latency", "ms", 120),
+		queryStat("Pages fetched", "", 1),
+		queryStat("Cache hit", "bool", 0),
+		queryStat("Series", "", 2),
+		queryStat("Truncated", "bool", 0),
+	}, frames[0].Meta.Stats)
+
+	empty := annotateFrames(nil, "B", "avg:mem{*}", queryStats{cached: true, rowsName: "Series"}, nil)
+	require.Len(t, empty, 1, "queries without data still show what was sent")
+	assert.Equal(t, "B", empty[0].RefID)
+	assert.Equal(t, "avg:mem{*}", empty[0].Meta.ExecutedQueryString)
+	assert.Len(t, empty[0].Meta.Stats, 4, "no latency without a call")
+}
+
+func TestIntegration_FrameMeta_Metrics(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+
+	now := time.Now().Truncate(time.Minute).UnixMilli()
+	v := 1.0
+	srv.Enqueue(fakedatadog.EndpointTimeseriesQuery, fakedatadog.JSON(fakedatadog.TimeseriesResponse(
+		[]int64{now - 120000, now - 60000, now},
+		fakedatadog.Series{GroupTags: []string{"host:web-01"}, Values: []*float64{&v, nil, &v}},
+		fakedatadog.Series{GroupTags: []string{"host:web-02"}, Values: []*float64{nil, &v, &v}},
+	)))
+
+	q := dataQuery("A", map[string]interface{}{"queryText": "avg:system.cpu.user{*}", "nullHandling": "drop"})
+	query := func() backend.DataResponse {
+		resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{Queries: []backend.DataQuery{q}})
+		require.NoError(t, err)
+		require.NoError(t, resp.Responses["A"].Error)
+		return resp.Responses["A"]
+	}
+
+	res := query()
+	require.Len(t, res.Frames, 2)
+	for _, f := range res.Frames {
+		assert.Equal(t, "avg:system.cpu.user{*} by {*}", f.Meta.ExecutedQueryString)
+	}
+	stats := frameStats(t, res)
+	assert.Equal(t, 1.0, stats["Pages fetched"])
+	assert.Equal(t, 0.0, stats["Cache hit"])
+	assert.Equal(t, 2.0, stats["Series"])
+	assert.Equal(t, 0.0, stats["Truncated"])
+	assert.Contains(t, stats, "API latency")
+	require.Len(t, res.Frames[0].Meta.Notices, 1)
+	assert.Contains(t, res.Frames[0].Meta.Notices[0].Text, "Dropped 2 null points")
+
+	stats = frameStats(t, query())
+	assert.Equal(t, 1.0, stats["Cache hit"], "the repeat is answered from cache")
+	assert.Equal(t, 0.0, stats["Pages fetched"])
+	assert.NotContains(t, stats, "API latency")
+}
+
+func TestIntegration_FrameMeta_Logs(t *testing.T) {
+	d, srv := newFakeBackedDatasourceWithPolicy(t)
+
+	resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{dataQuery("A", map[string]interface{}{
+			"queryType": "logs", "logQuery": "status:error",
+		})},
+	})
+	require.NoError(t, err)
+	res := resp.Responses["A"]
+	require.NoError(t, res.Error)
+	assert.Equal(t, "(status:ERROR) AND (team:payments) (indexes: payments, payments-archive)", res.Frames[0].Meta.ExecutedQueryString)
+	stats := frameStats(t, res)
+	assert.Equal(t, 1.0, stats["Pages fetched"])
+	assert.Equal(t, float64(srv.Hits(fakedatadog.EndpointLogsSearch)), stats["Pages fetched"])
+	assert.Contains(t, stats, "Log lines")
+}
+
+func TestIntegration_FrameMeta_LogsPartialAfterRateLimit(t *testing.T) {
+	// The request budget refuses the second page
+	d, srv := newFakeBackedDatasourceWithLimits(t, QueryLimits{MaxLogsPages: 2, RequestsPerMinute: 1})
+
+	events := make([]fakedatadog.LogEvent, 1000)
+	for i := range events {
+		events[i] = fakedatadog.LogEvent{Timestamp: time.Now(), Message: "payment accepted"}
+	}
+	srv.Enqueue(fakedatadog.EndpointLogsSearch, fakedatadog.JSON(fakedatadog.LogsSearchResponse("cursor-2", events...)))
+
+	q := dataQuery("A", map[string]interface{}{"queryType": "logs", "logQuery": "service:checkout"})
+	q.MaxDataPoints = 2000
+	resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{Queries: []backend.DataQuery{q}})
+	require.NoError(t, err)
+	res := resp.Responses["A"]
+	require.NoError(t, res.Error)
+	require.NotEmpty(t, notices(res))
+	assert.Contains(t, notices(res)[0].Text, "Showing partial results: requests were rate limited after 1 pages")
+	assert.Equal(t, 1000.0, frameStats(t, res)["Log lines"])
+	assert.Nil(t, d.GetCachedLogsEntry("logs:service:checkout", logsCacheTTL), "partial results are not cached")
+}
@@ -0,0 +1,565 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadog"
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// healthCheckTimeout bounds each capability probe of the health check. Probes run in parallel.
+const healthCheckTimeout = 5 * time.Second
+
+// Capabilities probed by CheckHealth, one per Datadog API family the plugin calls. Metrics
+// and logs are required; the others back optional features and only warn when they fail.
+const (
+	capabilityMetricsQuery        = "metrics_query"
+	capabilityMetricsList         = "metrics_list"
+	capabilityLogsSearch          = "logs_search"
+	capabilityRUMSearch           = "rum_search"
+	capabilityServiceDependencies = "service_dependencies"
+	capabilityNotebooks           = "notebooks"
+	capabilityMonitors            = "monitors"
+)
+
+// datadogSite is a known Datadog site and the region it serves.
+type datadogSite struct {
+	Site   string
+	Region string
+}
+
+// knownDatadogSites lists the public Datadog sites, see https://docs.datadoghq.com/getting_started/site/
+var knownDatadogSites = []datadogSite{
+	{Site: "datadoghq.com", Region: "US1"},
+	{Site: "us3.datadoghq.com", Region: "US3"},
+	{Site: "us5.datadoghq.com", Region: "US5"},
+	{Site: "datadoghq.eu", Region: "EU1"},
+	{Site: "ap1.datadoghq.com", Region: "AP1"},
+	{Site: "ap2.datadoghq.com", Region: "AP2"},
+	{Site: "ddog-gov.com", Region: "US1-FED"},
+}
+
+// resolveDatadogRegion returns the region of a known Datadog site.
+func resolveDatadogRegion(site string) (string, bool) {
+	for _, known := range knownDatadogSites {
+		if strings.EqualFold(site, known.Site) {
+			return known.Region, true
+		}
+	}
+	return "", false
+}
+
+// HealthSite describes the configured site in the health check details.
+type HealthSite struct {
+	Site   string `json:"site"`
+	Region string `json:"region,omitempty"`
+	Known  bool   `json:"known"`
+	// BaseURL is set when requests go to a base URL override instead of the site.
+	BaseURL string `json:"baseUrl,omitempty"`
+}
+
+// HealthCapability is the outcome of probing one capability.
+type HealthCapability struct {
+	Name          string   `json:"name"`
+	OK            bool     `json:"ok"`
+	LatencyMs     int64    `json:"latencyMs"`
+	HTTPStatus    int      `json:"httpStatus,omitempty"`
+	Error         string   `json:"error,omitempty"`
+	MissingScopes []string `json:"missingScopes,omitempty"`
+	// Optional is set for capabilities of optional features, whose failures are warnings.
+	Optional bool `json:"optional,omitempty"`
+
+	err error
+}
+
+// HealthDetails is sent as the JSONDetails of the health check result.
+// Grafana shows verboseMessage below the message when the details are expanded.
+type HealthDetails struct {
+	Site HealthSite `json:"site"`
+	// KeyPair names the active key pair, which Capabilities were probed with.
+	KeyPair      string             `json:"keyPair"`
+	Capabilities []HealthCapability `json:"capabilities"`
+	// Standby is set when a secondary key pair is configured.
+	Standby        *HealthKeyPair `json:"standby,omitempty"`
+	VerboseMessage string         `json:"verboseMessage,omitempty"`
+}
+
+// HealthKeyPair is the outcome of probing every capability with the standby key pair.
+type HealthKeyPair struct {
+	Name         string             `json:"name"`
+	OK           bool               `json:"ok"`
+	Capabilities []HealthCapability `json:"capabilities"`
+}
+
+// healthProbe is a cheap call exercising one capability.
+type healthProbe struct {
+	name string
+	// label names the capability in messages
+	label string
+	// scope is the Datadog permission the capability needs
+	scope string
+	// optional probes only warn when they fail
+	optional bool
+	run      func(ctx context.Context) (*http.Response, error)
+}
+
+// healthProbes returns the probes for every capability the plugin uses.
+func (d *Datasource) healthProbes(metricsApi *datadogV2.MetricsApi, apiKey, appKey, site string) []healthProbe {
+	return []healthProbe{
+		{
+			name:  capabilityMetricsQuery,
+			label: "metrics query",
+			scope: "timeseries_query",
+			run: func(ctx context.Context) (*http.Response, error) {
+				// Same v2 endpoint as production queries
+				now := time.Now()
+				interval := int64(5000)
+				queryName := "a"
+				body := datadogV2.TimeseriesFormulaQueryRequest{
+					Data: datadogV2.TimeseriesFormulaRequest{
+						Type: datadogV2.TIMESERIESFORMULAREQUESTTYPE_TIMESERIES_REQUEST,
+						Attributes: datadogV2.TimeseriesFormulaRequestAttributes{
+							From:     now.Add(-1 * time.Hour).UnixMilli(),
+							To:       now.UnixMilli(),
+							Interval: &interval,
+							Formulas: []datadogV2.QueryFormula{{Formula: "a"}},
+							Queries: []datadogV2.TimeseriesQuery{{
+								MetricsTimeseriesQuery: &datadogV2.MetricsTimeseriesQuery{
+									DataSource: datadogV2.METRICSDATASOURCE_METRICS,
+									Query:      "avg:datadog.estimated_usage.metrics.custom{*}",
+									Name:       &queryName,
+								},
+							}},
+						},
+					},
+				}
+				_, httpResp, err := metricsApi.QueryTimeseriesData(ctx, body)
+				return httpResp, err
+			},
+		},
+		{
+			name:  capabilityMetricsList,
+			label: "metrics list",
+			scope: "metrics_read",
+			run: func(ctx context.Context) (*http.Response, error) {
+				// Used by metric name and tag autocomplete
+				_, httpResp, err := metricsApi.ListTagConfigurations(ctx,
+					*datadogV2.NewListTagConfigurationsOptionalParameters().WithPageSize(1))
+				return httpResp, err
+			},
+		},
+		{
+			name:  capabilityLogsSearch,
+			label: "logs search",
+			scope: "logs_read_data",
+			run: func(ctx context.Context) (*http.Response, error) {
+				now := time.Now().UTC()
+				body, _ := json.Marshal(map[string]interface{}{
+					"filter": map[string]interface{}{
+						"query": "*",
+						"from":  now.Add(-15 * time.Minute).Format(time.RFC3339),
+						"to":    now.Format(time.RFC3339),
+					},
+					"page": map[string]interface{}{"limit": 1},
+				})
+				return d.healthRawRequest(ctx, http.MethodPost, "/api/v2/logs/events/search", body, apiKey, appKey, site)
+			},
+		},
+		{
+			name:     capabilityRUMSearch,
+			label:    "RUM events",
+			scope:    "rum_apps_read",
+			optional: true,
+			run: func(ctx context.Context) (*http.Response, error) {
+				body, _ := json.Marshal(map[string]interface{}{
+					"filter": map[string]interface{}{"query": "*", "from": "now-15m", "to": "now"},
+					"page":   map[string]interface{}{"limit": 1},
+				})
+				return d.healthRawRequest(ctx, http.MethodPost, "/api/v2/rum/events/search", body, apiKey, appKey, site)
+			},
+		},
+		{
+			name:     capabilityServiceDependencies,
+			label:    "service dependencies",
+			scope:    "apm_read",
+			optional: true,
+			run: func(ctx context.Context) (*http.Response, error) {
+				// Any env will do: an env without traces has no dependencies
+				now := time.Now()
+				path := fmt.Sprintf("/api/v1/service_dependencies?env=none&start=%d&end=%d", now.Add(-15*time.Minute).Unix(), now.Unix())
+				return d.healthRawRequest(ctx, http.MethodGet, path, nil, apiKey, appKey, site)
+			},
+		},
+		{
+			name:     capabilityNotebooks,
+			label:    "notebooks",
+			scope:    "notebooks_read",
+			optional: true,
+			run: func(ctx context.Context) (*http.Response, error) {
+				return d.healthRawRequest(ctx, http.MethodGet, "/api/v1/notebooks?count=1", nil, apiKey, appKey, site)
+			},
+		},
+		{
+			name:     capabilityMonitors,
+			label:    "monitors",
+			scope:    "monitors_read",
+			optional: true,
+			run: func(ctx context.Context) (*http.Response, error) {
+				return d.healthRawRequest(ctx, http.MethodGet, "/api/v1/monitor?page=0&page_size=1", nil, apiKey, appKey, site)
+			},
+		},
+	}
+}
+
+// healthRawRequest calls a raw HTTP endpoint and, like the generated client, returns an
+// error carrying the status for non-2xx responses. The body is drained and closed.
+func (d *Datasource) healthRawRequest(ctx context.Context, method, path string, body []byte, apiKey, appKey, site string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, d.apiBaseURL(site)+path, strings.NewReader(string(body)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("DD-API-KEY", apiKey)
+	req.Header.Set("DD-APPLICATION-KEY", appKey)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := d.httpClient(0).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp, fmt.Errorf("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+	return resp, nil
+}
+
+// runHealthProbe runs a probe with its own timeout and records the outcome.
+func runHealthProbe(ctx context.Context, p healthProbe) HealthCapability {
+	probeCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	httpResp, err := p.run(probeCtx)
+	result := HealthCapability{
+		Name:      p.name,
+		OK:        err == nil,
+		LatencyMs: time.Since(start).Milliseconds(),
+		Optional:  p.optional,
+		err:       err,
+	}
+	if httpResp != nil {
+		result.HTTPStatus = httpResp.StatusCode
+	}
+	if err != nil {
+		result.Error = err.Error()
+		if result.HTTPStatus == http.StatusForbidden {
+			result.MissingScopes = []string{p.scope}
+		}
+	}
+	return result
+}
+
+// probeCapabilities runs every probe in parallel with the given key pair. Failover to the
+// other pair is disabled so that the results describe this pair only.
+func (d *Datasource) probeCapabilities(ctx context.Context, client *datadog.APIClient, pair credentialPair, site string) ([]healthProbe, []HealthCapability, error) {
+	ddCtx, err := d.datadogContext(ctx, pair)
+	if err != nil {
+		return nil, nil, err
+	}
+	ddCtx = withoutCredentialFailover(ddCtx)
+
+	probes := d.healthProbes(datadogV2.NewMetricsApi(client), pair.apiKey, pair.appKey, site)
+	results := make([]HealthCapability, len(probes))
+	var wg sync.WaitGroup
+	for i, p := range probes {
+		wg.Add(1)
+		go func(i int, p healthProbe) {
+			defer wg.Done()
+			results[i] = runHealthProbe(ddCtx, p)
+		}(i, p)
+	}
+	wg.Wait()
+	return probes, results, nil
+}
+
+// allCapabilitiesOK reports whether every required probe passed.
+func allCapabilitiesOK(capabilities []HealthCapability) bool {
+	for _, c := range capabilities {
+		if !c.OK && !c.Optional {
+			return false
+		}
+	}
+	return true
+}
+
+// isHealthTimeout reports whether a probe failed because Datadog did not answer in time.
+func isHealthTimeout(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) ||
+		strings.Contains(err.Error(), "timeout") || strings.Contains(err.Error(), "context deadline exceeded")
+}
+
+// CheckHealth probes every capability the plugin uses (metrics query, metrics list, logs
+// search, and the optional RUM, service dependencies, notebooks and monitors) with each
+// configured key pair and validates the configured site. The status is OK only when the
+// required capabilities pass; optional ones add a warning to the message. Per-capability
+// results, latencies and missing scopes are reported in JSONDetails.
+func (d *Datasource) CheckHealth(ctx context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
+	logger := log.New()
+
+	// With per-user application keys, check the key of the user running the health check
+	view, owner, err := d.userView(req.PluginContext.User)
+	if err != nil {
+		return &backend.CheckHealthResult{
+			Status:  backend.HealthStatusError,
+			Message: err.Error(),
+		}, nil
+	}
+	if view != d {
+		res, err := view.CheckHealth(ctx, &backend.CheckHealthRequest{})
+		if res != nil {
+			res.Message += fmt.Sprintf(" (application key of %s)", owner)
+		}
+		return res, err
+	}
+
+	// Get API credentials from secure JSON data
+	_, _, site, credErr := d.validateCredentials()
+	if credErr != nil {
+		logger.Error("CheckHealth: invalid credentials", "error", credErr)
+		return &backend.CheckHealthResult{
+			Status:  backend.HealthStatusError,
+			Message: credErr.Error(),
+		}, nil
+	}
+
+	logger.Info("CheckHealth: starting health check", "site", site)
+
+	client, err := d.GetAPIClient()
+	if err != nil {
+		logger.Error("CheckHealth: failed to get API client", "error", err)
+		return &backend.CheckHealthResult{
+			Status:  backend.HealthStatusError,
+			Message: err.Error(),
+		}, nil
+	}
+
+	details := HealthDetails{Site: HealthSite{Site: site, BaseURL: d.customBaseURL()}}
+	details.Site.Region, details.Site.Known = resolveDatadogRegion(site)
+
+	// Probe the active key pair, then the standby pair so that a rotation can be checked
+	// before the new pair is promoted.
+	active := d.credentials().current()
+	details.KeyPair = active.name
+	probes, capabilities, err := d.probeCapabilities(ctx, client, active, site)
+	if err != nil {
+		logger.Error("CheckHealth: failed to build Datadog context", "error", err)
+		return &backend.CheckHealthResult{
+			Status:  backend.HealthStatusError,
+			Message: err.Error(),
+		}, nil
+	}
+	details.Capabilities = capabilities
+	if standby, ok := d.credentials().standby(); ok {
+		if _, standbyCapabilities, err := d.probeCapabilities(ctx, client, standby, site); err == nil {
+			details.Standby = &HealthKeyPair{
+				Name:         standby.name,
+				OK:           allCapabilitiesOK(standbyCapabilities),
+				Capabilities: standbyCapabilities,
+			}
+		}
+	}
+
+	for _, c := range details.Capabilities {
+		if c.OK {
+			logger.Debug("CheckHealth: capability available", "capability", c.Name, "latencyMs", c.LatencyMs)
+		} else {
+			logger.Error("CheckHealth: capability failed", "capability", c.Name, "httpStatus", c.HTTPStatus, "error", c.err)
+		}
+	}
+	if details.Standby != nil && !details.Standby.OK {
+		logger.Error("CheckHealth: standby key pair failed", "keyPair", details.Standby.Name)
+	}
+
+	status, message := healthSummary(probes, details)
+	details.VerboseMessage = healthVerboseMessage(probes, details)
+	jsonDetails, err := json.Marshal(details)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("CheckHealth: finished", "ok", status == backend.HealthStatusOk, "region", details.Site.Region)
+	return &backend.CheckHealthResult{
+		Status:      status,
+		Message:     message,
+		JSONDetails: jsonDetails,
+	}, nil
+}
+
+// healthSummary turns the probe results into the health status and headline message.
+// A failing metrics query keeps the historical messages; other failures are listed after
+// "Connected to Datadog" so users can tell which panels will not work.
+func healthSummary(probes []healthProbe, details HealthDetails) (backend.HealthStatus, string) {
+	var missingScopes []string
+	for _, c := range details.Capabilities {
+		missingScopes = appendMissing(missingScopes, c.MissingScopes...)
+	}
+
+	if primary := details.Capabilities[0]; !primary.OK {
+		var message string
+		switch {
+		case primary.HTTPStatus == http.StatusUnauthorized:
+			message = "Invalid Datadog API credentials - check your API key and App key"
+		case primary.HTTPStatus == http.StatusForbidden:
+			message = "API key missing required permissions - need " + quoteScopes(missingScopes)
+		case isHealthTimeout(primary.err):
+			message = "Connection timeout - Datadog API is not responding"
+		default:
+			message = "Failed to connect to Datadog: " + primary.Error
+			if !details.Site.Known && details.Site.BaseURL == "" {
+				message += fmt.Sprintf(" (%q is not a known Datadog site)", details.Site.Site)
+			}
+		}
+		if details.Standby != nil && details.Standby.OK {
+			message += fmt.Sprintf(". The %s key pair passed all checks and is used when the %s pair is rejected", details.Standby.Name, details.KeyPair)
+		}
+		return backend.HealthStatusError, message
+	}
+
+	var problems []string
+	if !details.Site.Known && details.Site.BaseURL == "" {
+		problems = append(problems, fmt.Sprintf("%q is not a known Datadog site", details.Site.Site))
+	}
+	problems = append(problems, capabilityProblems(probes, details.Capabilities)...)
+	if standby := details.Standby; standby != nil && !standby.OK {
+		problems = append(problems, keyPairProblem(probes, standby))
+	}
+	if len(problems) > 0 {
+		return backend.HealthStatusError, "Connected to Datadog, but some capabilities are unavailable: " + strings.Join(problems, "; ")
+	}
+	message := "Connected to Datadog"
+	if warnings := optionalCapabilityProblems(probes, details.Capabilities); len(warnings) > 0 {
+		message += ". Optional features unavailable: " + strings.Join(warnings, "; ")
+	}
+	return backend.HealthStatusOk, message
+}
+
+// capabilityProblems describes each failed required capability, e.g. "logs search (missing
+// 'logs_read_data' scope)".
+func capabilityProblems(probes []healthProbe, capabilities []HealthCapability) []string {
+	var problems []string
+	for i, c := range capabilities {
+		if !c.OK && !c.Optional {
+			problems = append(problems, capabilityProblem(probes[i], c))
+		}
+	}
+	return problems
+}
+
+// optionalCapabilityProblems describes each failed optional capability.
+func optionalCapabilityProblems(probes []healthProbe, capabilities []HealthCapability) []string {
+	var problems []string
+	for i, c := range capabilities {
+		if !c.OK && c.Optional {
+			problems = append(problems, capabilityProblem(probes[i], c))
+		}
+	}
+	return problems
+}
+
+// capabilityProblem describes why a capability failed.
+func capabilityProblem(p healthProbe, c HealthCapability) string {
+	switch {
+	case len(c.MissingScopes) > 0:
+		return fmt.Sprintf("%s (missing %s)", p.label, quoteScopes(c.MissingScopes))
+	case c.HTTPStatus != 0:
+		return fmt.Sprintf("%s (HTTP %d)", p.label, c.HTTPStatus)
+	}
+	return fmt.Sprintf("%s (%s)", p.label, c.Error)
+}
+
+// keyPairProblem describes why a key pair failed its checks.
+func keyPairProblem(probes []healthProbe, kp *HealthKeyPair) string {
+	for _, c := range kp.Capabilities {
+		if c.HTTPStatus == http.StatusUnauthorized {
+			return fmt.Sprintf("%s key pair (invalid credentials)", kp.Name)
+		}
+	}
+	return fmt.Sprintf("%s key pair: %s", kp.Name, strings.Join(capabilityProblems(probes, kp.Capabilities), ", "))
+}
+
+// healthVerboseMessage renders one line per check for the expanded health check details.
+func healthVerboseMessage(probes []healthProbe, details HealthDetails) string {
+	var b strings.Builder
+	switch {
+	case details.Site.Known:
+		fmt.Fprintf(&b, "site %s: OK (region %s)\n", details.Site.Site, details.Site.Region)
+	case details.Site.BaseURL != "":
+		fmt.Fprintf(&b, "site %s: not a known Datadog site, requests go to %s\n", details.Site.Site, details.Site.BaseURL)
+	default:
+		fmt.Fprintf(&b, "site %s: FAILED (not a known Datadog site)\n", details.Site.Site)
+	}
+	for i, c := range details.Capabilities {
+		if c.OK {
+			fmt.Fprintf(&b, "%s: OK (%d ms)\n", probes[i].label, c.LatencyMs)
+			continue
+		}
+		result := "FAILED"
+		if c.Optional {
+			result = "WARNING"
+		}
+		fmt.Fprintf(&b, "%s: %s (%d ms): %s", probes[i].label, result, c.LatencyMs, c.Error)
+		if len(c.MissingScopes) > 0 {
+			fmt.Fprintf(&b, " - missing %s", quoteScopes(c.MissingScopes))
+		}
+		b.WriteString("\n")
+	}
+	if standby := details.Standby; standby != nil {
+		if standby.OK {
+			fmt.Fprintf(&b, "%s key pair (standby): OK\n", standby.Name)
+		} else {
+			fmt.Fprintf(&b, "%s key pair (standby): FAILED: %s\n", standby.Name, strings.Join(capabilityProblems(probes, standby.Capabilities), ", "))
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// appendMissing appends the values not yet in list.
+func appendMissing(list []string, values ...string) []string {
+	for _, v := range values {
+		found := false
+		for _, existing := range list {
+			if existing == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// quoteScopes formats scope names as "'a' scope" or "'a', 'b' scopes".
+func quoteScopes(scopes []string) string {
+	quoted := make([]string, len(scopes))
+	for i, s := range scopes {
+		quoted[i] = "'" + s + "'"
+	}
+	if len(quoted) == 1 {
+		return quoted[0] + " scope"
+	}
+	return strings.Join(quoted, ", ") + " scopes"
+}
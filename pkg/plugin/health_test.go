@@ -0,0 +1,62 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveDatadogRegion(t *testing.T) {
+	tests := []struct {
+		site   string
+		region string
+		known  bool
+	}{
+		{"datadoghq.com", "US1", true},
+		{"us5.datadoghq.com", "US5", true},
+		{"DatadogHQ.eu", "EU1", true},
+		{"ddog-gov.com", "US1-FED", true},
+		{"datadoghq.co", "", false},
+		{"us4.datadoghq.com", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.site, func(t *testing.T) {
+			region, known := resolveDatadogRegion(tt.site)
+			assert.Equal(t, tt.region, region)
+			assert.Equal(t, tt.known, known)
+		})
+	}
+}
+
+func TestHealthSummary_UnknownSite(t *testing.T) {
+	probes := (&Datasource{}).healthProbes(nil, "", "", "")
+	passing := []HealthCapability{
+		{Name: capabilityMetricsQuery, OK: true},
+		{Name: capabilityMetricsList, OK: true},
+		{Name: capabilityLogsSearch, OK: true},
+	}
+
+	status, message := healthSummary(probes, HealthDetails{Site: HealthSite{Site: "datadoghq.co"}, Capabilities: passing})
+	assert.Equal(t, backend.HealthStatusError, status)
+	assert.Equal(t, `Connected to Datadog, but some capabilities are unavailable: "datadoghq.co" is not a known Datadog site`, message)
+
+	// Behind a base URL override the site only labels the datasource.
+	status, message = healthSummary(probes, HealthDetails{Site: HealthSite{Site: "datadoghq.co", BaseURL: "https://dd-proxy.internal"}, Capabilities: passing})
+	assert.Equal(t, backend.HealthStatusOk, status)
+	assert.Equal(t, "Connected to Datadog", message)
+}
+
+func TestHealthSummary_MissingScopesAreMerged(t *testing.T) {
+	probes := (&Datasource{}).healthProbes(nil, "", "", "")
+	status, message := healthSummary(probes, HealthDetails{
+		Site: HealthSite{Site: "datadoghq.eu", Region: "EU1", Known: true},
+		Capabilities: []HealthCapability{
+			{Name: capabilityMetricsQuery, HTTPStatus: 403, MissingScopes: []string{"timeseries_query"}},
+			{Name: capabilityMetricsList, OK: true},
+			{Name: capabilityLogsSearch, HTTPStatus: 403, MissingScopes: []string{"logs_read_data"}},
+		},
+	})
+	assert.Equal(t, backend.HealthStatusError, status)
+	assert.Equal(t, "API key missing required permissions - need 'timeseries_query', 'logs_read_data' scopes", message)
+}
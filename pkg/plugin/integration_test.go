@@ -0,0 +1,316 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wasilak/grafana-datadog-datasource/pkg/plugin/fakedatadog"
+)
+
+// newFakeBackedDatasource starts a fake Datadog API and returns a datasource pointed at it
+// through the baseUrl option, exactly as Grafana would construct it.
+func newFakeBackedDatasource(t *testing.T) (*Datasource, *fakedatadog.Server) {
+	t.Helper()
+	srv := fakedatadog.New()
+	t.Cleanup(srv.Close)
+
+	inst, err := NewDatasource(context.Background(), backend.DataSourceInstanceSettings{
+		UID:      "fake-datadog",
+		JSONData: []byte(fmt.Sprintf(`{"site": "datadoghq.com", "baseUrl": %q}`, srv.URL())),
+		DecryptedSecureJSONData: map[string]string{
+			"apiKey": fakedatadog.TestAPIKey,
+			"appKey": fakedatadog.TestAppKey,
+		},
+	})
+	require.NoError(t, err)
+	d := inst.(*Datasource)
+	t.Cleanup(d.Dispose)
+	return d, srv
+}
+
+// callResource invokes CallResource and returns the single response it sends.
+func callResource(t *testing.T, d *Datasource, method, path string, body []byte) *backend.CallResourceResponse {
+	t.Helper()
+	var got *backend.CallResourceResponse
+	err := d.CallResource(context.Background(), &backend.CallResourceRequest{
+		Method: method,
+		Path:   path,
+		Body:   body,
+	}, backend.CallResourceResponseSenderFunc(func(resp *backend.CallResourceResponse) error {
+		got = resp
+		return nil
+	}))
+	require.NoError(t, err)
+	require.NotNil(t, got, "CallResource did not send a response")
+	return got
+}
+
+func dataQuery(refID string, model map[string]interface{}) backend.DataQuery {
+	raw, _ := json.Marshal(model)
+	now := time.Now()
+	return backend.DataQuery{
+		RefID:     refID,
+		JSON:      raw,
+		TimeRange: backend.TimeRange{From: now.Add(-time.Hour), To: now},
+	}
+}
+
+// -------------------------------------------------------------------------
+// CheckHealth
+// -------------------------------------------------------------------------
+
+func TestIntegration_CheckHealth_OK(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+
+	res, err := d.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, backend.HealthStatusOk, res.Status, res.Message)
+
+	reqs := srv.Requests(fakedatadog.EndpointTimeseriesQuery)
+	require.Len(t, reqs, 1)
+	assert.Equal(t, fakedatadog.TestAPIKey, reqs[0].Header.Get("DD-API-KEY"))
+	assert.Equal(t, fakedatadog.TestAppKey, reqs[0].Header.Get("DD-APPLICATION-KEY"))
+
+	var details HealthDetails
+	require.NoError(t, json.Unmarshal(res.JSONDetails, &details))
+	assert.Equal(t, HealthSite{Site: "datadoghq.com", Region: "US1", Known: true, BaseURL: srv.URL()}, details.Site)
+	var names []string
+	for _, c := range details.Capabilities {
+		names = append(names, c.Name)
+		assert.True(t, c.OK, c.Name)
+		assert.Equal(t, http.StatusOK, c.HTTPStatus, c.Name)
+	}
+	assert.Equal(t, []string{capabilityMetricsQuery, capabilityMetricsList, capabilityLogsSearch,
+		capabilityRUMSearch, capabilityServiceDependencies, capabilityNotebooks, capabilityMonitors}, names)
+	assert.Equal(t, 1, srv.Hits(fakedatadog.EndpointTagConfigurations))
+	assert.Equal(t, 1, srv.Hits(fakedatadog.EndpointLogsSearch))
+	assert.Equal(t, 1, srv.Hits(fakedatadog.EndpointRUMSearch))
+	assert.Equal(t, 1, srv.Hits(fakedatadog.EndpointServiceDependencies))
+	assert.Equal(t, 1, srv.Hits(fakedatadog.EndpointNotebooksList))
+	assert.Equal(t, 1, srv.Hits(fakedatadog.EndpointMonitorsList))
+}
+
+func TestIntegration_CheckHealth_OptionalCapabilitiesWarn(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+	srv.Enqueue(fakedatadog.EndpointRUMSearch, fakedatadog.Error(http.StatusForbidden, "Forbidden"))
+	srv.Enqueue(fakedatadog.EndpointMonitorsList, fakedatadog.Error(http.StatusInternalServerError, "Internal Server Error"))
+
+	res, err := d.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, backend.HealthStatusOk, res.Status, "optional features do not fail the health check")
+	assert.Equal(t, "Connected to Datadog. Optional features unavailable: RUM events (missing 'rum_apps_read' scope); monitors (HTTP 500)", res.Message)
+
+	var details HealthDetails
+	require.NoError(t, json.Unmarshal(res.JSONDetails, &details))
+	rum := details.Capabilities[3]
+	assert.Equal(t, capabilityRUMSearch, rum.Name)
+	assert.False(t, rum.OK)
+	assert.True(t, rum.Optional)
+	assert.Contains(t, details.VerboseMessage, "RUM events: WARNING")
+}
+
+func TestIntegration_CheckHealth_MissingLogsScope(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+	srv.Enqueue(fakedatadog.EndpointLogsSearch, fakedatadog.Error(http.StatusForbidden, "Forbidden"))
+
+	res, err := d.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, backend.HealthStatusError, res.Status)
+	assert.Equal(t, "Connected to Datadog, but some capabilities are unavailable: logs search (missing 'logs_read_data' scope)", res.Message)
+
+	var details HealthDetails
+	require.NoError(t, json.Unmarshal(res.JSONDetails, &details))
+	require.Len(t, details.Capabilities, 7)
+	assert.True(t, details.Capabilities[0].OK)
+	assert.True(t, details.Capabilities[1].OK)
+	logs := details.Capabilities[2]
+	assert.Equal(t, capabilityLogsSearch, logs.Name)
+	assert.False(t, logs.OK)
+	assert.Equal(t, http.StatusForbidden, logs.HTTPStatus)
+	assert.Equal(t, []string{"logs_read_data"}, logs.MissingScopes)
+	assert.Contains(t, details.VerboseMessage, "logs search: FAILED")
+}
+
+func TestIntegration_CheckHealth_ForbiddenKeys(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+	srv.SetCredentials("some-other-key", "some-other-app-key")
+
+	res, err := d.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, backend.HealthStatusError, res.Status)
+	assert.Contains(t, res.Message, "permissions")
+	assert.Contains(t, res.Message, "'logs_read_data'")
+}
+
+func TestIntegration_CheckHealth_RateLimited(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+	srv.Enqueue(fakedatadog.EndpointTimeseriesQuery, fakedatadog.RateLimited(time.Minute))
+
+	res, err := d.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, backend.HealthStatusError, res.Status)
+	assert.Contains(t, res.Message, "429")
+}
+
+// -------------------------------------------------------------------------
+// QueryData
+// -------------------------------------------------------------------------
+
+func TestIntegration_QueryData_Metrics(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+
+	resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{dataQuery("A", map[string]interface{}{"queryText": "avg:system.cpu.user{*}"})},
+	})
+	require.NoError(t, err)
+
+	res := resp.Responses["A"]
+	require.NoError(t, res.Error)
+	require.Len(t, res.Frames, 1)
+	assert.Equal(t, 3, res.Frames[0].Rows())
+
+	reqs := srv.Requests(fakedatadog.EndpointTimeseriesQuery)
+	require.Len(t, reqs, 1)
+	var body struct {
+		Data struct {
+			Attributes struct {
+				Queries []struct {
+					Query string `json:"query"`
+					Name  string `json:"name"`
+				} `json:"queries"`
+			} `json:"attributes"`
+		} `json:"data"`
+	}
+	require.NoError(t, reqs[0].DecodeBody(&body))
+	require.Len(t, body.Data.Attributes.Queries, 1)
+	assert.Equal(t, "avg:system.cpu.user{*} by {*}", body.Data.Attributes.Queries[0].Query)
+	assert.Equal(t, "A", body.Data.Attributes.Queries[0].Name)
+}
+
+func TestIntegration_QueryData_MetricsErrorBody(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+	srv.Enqueue(fakedatadog.EndpointTimeseriesQuery,
+		fakedatadog.Error(http.StatusBadRequest, "Error parsing query: unexpected token"))
+
+	resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{dataQuery("A", map[string]interface{}{"queryText": "avg:system.cpu.user{"})},
+	})
+	require.NoError(t, err)
+	assert.Error(t, resp.Responses["A"].Error)
+}
+
+func TestIntegration_QueryData_MetricsLatencyHonoursDeadline(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+	srv.SetLatency(fakedatadog.EndpointTimeseriesQuery, 5*time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	resp, err := d.QueryData(ctx, &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{dataQuery("A", map[string]interface{}{"queryText": "avg:system.cpu.user{*}"})},
+	})
+	require.NoError(t, err)
+	assert.Error(t, resp.Responses["A"].Error)
+	assert.Less(t, time.Since(start), 5*time.Second, "query should give up at the caller deadline")
+}
+
+func TestIntegration_QueryData_Logs(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+
+	resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{dataQuery("L", map[string]interface{}{
+			"queryType": "logs",
+			"logQuery":  "service:checkout",
+		})},
+	})
+	require.NoError(t, err)
+
+	res := resp.Responses["L"]
+	require.NoError(t, res.Error)
+	require.NotEmpty(t, res.Frames)
+	assert.Equal(t, 2, res.Frames[0].Rows())
+
+	reqs := srv.Requests(fakedatadog.EndpointLogsSearch)
+	require.Len(t, reqs, 1)
+	var body struct {
+		Filter struct {
+			Query string `json:"query"`
+		} `json:"filter"`
+	}
+	require.NoError(t, reqs[0].DecodeBody(&body))
+	assert.Equal(t, "service:checkout", body.Filter.Query)
+}
+
+func TestIntegration_QueryData_LogsRateLimited(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+	srv.Enqueue(fakedatadog.EndpointLogsSearch, fakedatadog.RateLimited(time.Second))
+
+	resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{dataQuery("L", map[string]interface{}{
+			"queryType": "logs",
+			"logQuery":  "status:error",
+		})},
+	})
+	require.NoError(t, err)
+	require.Error(t, resp.Responses["L"].Error)
+	assert.Contains(t, resp.Responses["L"].Error.Error(), "rate limit")
+}
+
+// -------------------------------------------------------------------------
+// CallResource
+// -------------------------------------------------------------------------
+
+func TestIntegration_CallResource_MetricsAutocompleteIsCached(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+
+	for i := 0; i < 2; i++ {
+		resp := callResource(t, d, http.MethodGet, "autocomplete/metrics", nil)
+		require.Equal(t, http.StatusOK, resp.Status)
+
+		var metrics []string
+		require.NoError(t, json.Unmarshal(resp.Body, &metrics))
+		assert.ElementsMatch(t, []string{"system.cpu.user", "system.mem.used"}, metrics)
+	}
+	assert.Equal(t, 1, srv.Hits(fakedatadog.EndpointTagConfigurations), "second call should be served from cache")
+}
+
+func TestIntegration_CallResource_TagKeysForMetric(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+
+	resp := callResource(t, d, http.MethodGet, "autocomplete/tags/system.cpu.user", nil)
+	require.Equal(t, http.StatusOK, resp.Status)
+
+	var tags []string
+	require.NoError(t, json.Unmarshal(resp.Body, &tags))
+	assert.ElementsMatch(t, []string{"host", "env", "service"}, tags)
+
+	reqs := srv.Requests(fakedatadog.EndpointTagsByMetric)
+	require.Len(t, reqs, 1)
+	assert.Equal(t, "/api/v2/metrics/system.cpu.user/all-tags", reqs[0].Path)
+}
+
+func TestIntegration_CallResource_MetricsAutocompleteDegradesOnServerError(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+	srv.Enqueue(fakedatadog.EndpointTagConfigurations, fakedatadog.Error(http.StatusInternalServerError))
+
+	resp := callResource(t, d, http.MethodGet, "autocomplete/metrics", nil)
+	require.Equal(t, http.StatusOK, resp.Status)
+	assert.JSONEq(t, `[]`, string(resp.Body))
+}
+
+func TestIntegration_CallResource_UnknownPath(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+
+	resp := callResource(t, d, http.MethodGet, "does-not-exist", nil)
+	assert.Equal(t, http.StatusNotFound, resp.Status)
+	assert.Zero(t, srv.Hits(""), "unknown routes must not reach Datadog")
+}
@@ -0,0 +1,276 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Query cost guardrails. They bound what one query, and the datasource as a whole, may cost:
+// the series a metrics query returns, the time range and pages of a logs query, and the Datadog
+// requests made per minute. Queries over a limit come back with a frame notice saying which
+// limit applied, so that a panel shows why its data is missing or incomplete.
+
+const (
+	// defaultMaxSeries caps the series of a metrics query when no limit is configured. The
+	// automatic "by {*}" grouping can otherwise return thousands of series that freeze browsers.
+	defaultMaxSeries = 1000
+	// defaultMaxLogsPages keeps logs queries to one page unless configured otherwise.
+	defaultMaxLogsPages = 1
+	// logsMaxPageSize is the largest page the Datadog logs search API returns.
+	logsMaxPageSize = 1000
+)
+
+// Limit names used as the "limit" label value.
+const (
+	limitMaxSeries        = "max_series"
+	limitMaxLogsTimeRange = "max_logs_time_range"
+	limitMaxLogsPages     = "max_logs_pages"
+	limitRequestBudget    = "request_budget"
+)
+
+// QueryLimits configures the query cost guardrails.
+type QueryLimits struct {
+	// MaxSeries caps the series returned for one metrics query; the rest are dropped with a
+	// warning. 0 uses the default of 1000, a negative value disables the limit.
+	MaxSeries int `json:"maxSeries,omitempty"`
+	// MaxLogsTimeRange refuses logs queries over a longer time range, e.g. "7d". Empty: no limit.
+	MaxLogsTimeRange string `json:"maxLogsTimeRange,omitempty"`
+	// MaxLogsPages caps the pages of up to 1000 lines one logs query may fetch. Default 1.
+	MaxLogsPages int `json:"maxLogsPages,omitempty"`
+	// RequestsPerMinute caps the Datadog API requests the datasource makes per minute, shared
+	// by queries, autocomplete and health checks. 0: no limit.
+	RequestsPerMinute int `json:"requestsPerMinute,omitempty"`
+}
+
+// limitError is returned when a query goes over a guardrail.
+type limitError struct {
+	status backend.Status
+	msg    string
+}
+
+func (e *limitError) Error() string {
+	return e.msg
+}
+
+// response returns the response of a query refused by the limit: the error, and an empty
+// frame carrying it as a notice.
+func (e *limitError) response() backend.DataResponse {
+	frame := data.NewFrame("")
+	frame.Meta = &data.FrameMeta{Notices: []data.Notice{{Severity: data.NoticeSeverityError, Text: e.msg}}}
+	return backend.DataResponse{Frames: data.Frames{frame}, Error: e, Status: e.status}
+}
+
+// limitResponse returns the response for err when it is a limitError.
+func limitResponse(err error) (backend.DataResponse, bool) {
+	var limitErr *limitError
+	if !errors.As(err, &limitErr) {
+		return backend.DataResponse{}, false
+	}
+	return limitErr.response(), true
+}
+
+// limits returns the guardrails of the datasource, nil when none are configured.
+func (d *Datasource) limits() *QueryLimits {
+	if d.JSONData == nil {
+		return nil
+	}
+	return d.JSONData.Limits
+}
+
+// maxSeries returns the series cap of a metrics query, 0 when unlimited.
+func (l *QueryLimits) maxSeries() int {
+	switch {
+	case l == nil || l.MaxSeries == 0:
+		return defaultMaxSeries
+	case l.MaxSeries < 0:
+		return 0
+	}
+	return l.MaxSeries
+}
+
+// maxLogsPages returns the pages one logs query may fetch.
+func (l *QueryLimits) maxLogsPages() int {
+	if l == nil || l.MaxLogsPages <= 0 {
+		return defaultMaxLogsPages
+	}
+	return l.MaxLogsPages
+}
+
+// checkLogsTimeRange refuses logs queries over a time range longer than the limit.
+func (l *QueryLimits) checkLogsTimeRange(from, to int64) error {
+	if l == nil || l.MaxLogsTimeRange == "" {
+		return nil
+	}
+	max, err := parseLimitDuration(l.MaxLogsTimeRange)
+	if err != nil {
+		// Invalid limits are reported when the datasource loads
+		return nil
+	}
+	if time.Duration(to-from)*time.Millisecond <= max {
+		return nil
+	}
+	queryLimitHitsTotal.WithLabelValues(limitMaxLogsTimeRange).Inc()
+	return &limitError{
+		status: backend.StatusBadRequest,
+		msg:    fmt.Sprintf("The time range is longer than the %s allowed for logs queries on this datasource; narrow it down", l.MaxLogsTimeRange),
+	}
+}
+
+// validate reports configuration errors.
+func (l *QueryLimits) validate() error {
+	if l == nil || l.MaxLogsTimeRange == "" {
+		return nil
+	}
+	if _, err := parseLimitDuration(l.MaxLogsTimeRange); err != nil {
+		return fmt.Errorf("invalid maxLogsTimeRange: %w", err)
+	}
+	return nil
+}
+
+// parseLimitDuration parses a positive Go duration, or a number of days or weeks ("7d", "2w").
+func parseLimitDuration(s string) (time.Duration, error) {
+	var d time.Duration
+	var err error
+	switch {
+	case strings.HasSuffix(s, "d"), strings.HasSuffix(s, "w"):
+		n, convErr := strconv.Atoi(s[:len(s)-1])
+		err = convErr
+		d = time.Duration(n) * 24 * time.Hour
+		if strings.HasSuffix(s, "w") {
+			d *= 7
+		}
+	default:
+		d, err = time.ParseDuration(s)
+	}
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%q is not a positive duration such as 12h, 7d or 2w", s)
+	}
+	return d, nil
+}
+
+// capSeries drops the series of each query beyond the series cap and adds a warning notice.
+// Metrics responses have one frame per series. It returns the series count of the queries
+// that were cut, by refID.
+func (l *QueryLimits) capSeries(response *backend.QueryDataResponse) map[string]int {
+	truncated := map[string]int{}
+	max := l.maxSeries()
+	if max == 0 {
+		return truncated
+	}
+	for refID, res := range response.Responses {
+		if len(res.Frames) <= max {
+			continue
+		}
+		total := len(res.Frames)
+		res.Frames = res.Frames[:max]
+		first := res.Frames[0]
+		if first.Meta == nil {
+			first.Meta = &data.FrameMeta{}
+		}
+		first.Meta.Notices = append(first.Meta.Notices, data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text: fmt.Sprintf("Showing %d of %d series: this datasource returns at most %d series per query. "+
+				"Group by fewer tags or add a top() function", max, total, max),
+		})
+		response.Responses[refID] = res
+		truncated[refID] = total
+		queryLimitHitsTotal.WithLabelValues(limitMaxSeries).Inc()
+		log.New().Warn("Metrics query returned more series than allowed", "refID", refID, "series", total, "maxSeries", max)
+	}
+	return truncated
+}
+
+// noticeLogsLines adds a warning to frames when a logs query asked for more lines than its
+// pages allow and got as many as they allow. It reports whether it did.
+func (l *QueryLimits) noticeLogsLines(frames data.Frames, requested, got int) bool {
+	maxLines := l.maxLogsPages() * logsMaxPageSize
+	if requested <= maxLines || got < maxLines || len(frames) == 0 {
+		return false
+	}
+	if frames[0].Meta == nil {
+		frames[0].Meta = &data.FrameMeta{}
+	}
+	frames[0].Meta.Notices = append(frames[0].Meta.Notices, data.Notice{
+		Severity: data.NoticeSeverityWarning,
+		Text: fmt.Sprintf("Showing the newest %d log lines: this datasource fetches at most %d pages of %d lines per logs query",
+			maxLines, l.maxLogsPages(), logsMaxPageSize),
+	})
+	queryLimitHitsTotal.WithLabelValues(limitMaxLogsPages).Inc()
+	return true
+}
+
+// requestBudget counts the Datadog requests of a datasource in one-minute windows.
+type requestBudget struct {
+	mu          sync.Mutex
+	perMinute   int
+	windowStart time.Time
+	used        int
+	now         func() time.Time
+}
+
+func newRequestBudget(perMinute int) *requestBudget {
+	if perMinute <= 0 {
+		return nil
+	}
+	return &requestBudget{perMinute: perMinute, now: time.Now}
+}
+
+// roll starts a new window when the current one is over. Callers hold b.mu.
+func (b *requestBudget) roll() {
+	if now := b.now(); now.Sub(b.windowStart) >= time.Minute {
+		b.windowStart = now
+		b.used = 0
+	}
+}
+
+// use charges n requests to the budget; n is 0 to only check that some budget is left. It
+// returns a limitError when the budget of the current window is used up.
+func (b *requestBudget) use(n int) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.roll()
+	if b.used >= b.perMinute {
+		queryLimitHitsTotal.WithLabelValues(limitRequestBudget).Inc()
+		return b.exhaustedError()
+	}
+	b.used += n
+	return nil
+}
+
+func (b *requestBudget) exhaustedError() error {
+	retryIn := time.Minute - b.now().Sub(b.windowStart)
+	return &limitError{
+		status: backend.StatusTooManyRequests,
+		msg: fmt.Sprintf("This datasource has used its budget of %d Datadog requests per minute; try again in %ds",
+			b.perMinute, int(retryIn.Seconds())+1),
+	}
+}
+
+// budgetTransport charges every Datadog request to the request budget.
+type budgetTransport struct {
+	next   http.RoundTripper
+	budget *requestBudget
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *budgetTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.budget.use(1); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,233 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wasilak/grafana-datadog-datasource/pkg/plugin/fakedatadog"
+)
+
+// newFakeBackedDatasourceWithLimits is newFakeBackedDatasource with the given query limits.
+func newFakeBackedDatasourceWithLimits(t *testing.T, limits QueryLimits) (*Datasource, *fakedatadog.Server) {
+	t.Helper()
+	srv := fakedatadog.New()
+	t.Cleanup(srv.Close)
+
+	raw, err := json.Marshal(limits)
+	require.NoError(t, err)
+	inst, err := NewDatasource(context.Background(), backend.DataSourceInstanceSettings{
+		UID:      "fake-datadog-limits",
+		JSONData: []byte(fmt.Sprintf(`{"site": "datadoghq.com", "baseUrl": %q, "limits": %s}`, srv.URL(), raw)),
+		DecryptedSecureJSONData: map[string]string{
+			"apiKey": fakedatadog.TestAPIKey,
+			"appKey": fakedatadog.TestAppKey,
+		},
+	})
+	require.NoError(t, err)
+	d := inst.(*Datasource)
+	t.Cleanup(d.Dispose)
+	return d, srv
+}
+
+// notices returns the notices of the frames of a response.
+func notices(res backend.DataResponse) []data.Notice {
+	var out []data.Notice
+	for _, f := range res.Frames {
+		if f.Meta != nil {
+			out = append(out, f.Meta.Notices...)
+		}
+	}
+	return out
+}
+
+func TestParseLimitDuration(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+		ok   bool
+	}{
+		{"12h", 12 * time.Hour, true},
+		{"90m", 90 * time.Minute, true},
+		{"7d", 7 * 24 * time.Hour, true},
+		{"2w", 14 * 24 * time.Hour, true},
+		{"", 0, false},
+		{"0d", 0, false},
+		{"-1h", 0, false},
+		{"week", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseLimitDuration(tt.in)
+		if !tt.ok {
+			assert.Error(t, err, tt.in)
+			continue
+		}
+		require.NoError(t, err, tt.in)
+		assert.Equal(t, tt.want, got, tt.in)
+	}
+}
+
+func TestRequestBudget(t *testing.T) {
+	assert.Nil(t, newRequestBudget(0), "no budget without a limit")
+	assert.NoError(t, (*requestBudget)(nil).use(1))
+
+	now := time.Unix(1700000000, 0)
+	b := newRequestBudget(2)
+	b.now = func() time.Time { return now }
+
+	require.NoError(t, b.use(1))
+	require.NoError(t, b.use(0), "checking does not charge the budget")
+	require.NoError(t, b.use(1))
+	err := b.use(1)
+	var limitErr *limitError
+	require.ErrorAs(t, err, &limitErr)
+	assert.Equal(t, backend.StatusTooManyRequests, limitErr.status)
+	assert.EqualError(t, err, "This datasource has used its budget of 2 Datadog requests per minute; try again in 61s")
+	assert.Error(t, b.use(0))
+
+	now = now.Add(45 * time.Second)
+	assert.ErrorContains(t, b.use(1), "try again in 16s")
+
+	now = now.Add(15 * time.Second)
+	assert.NoError(t, b.use(1), "the budget is renewed every minute")
+}
+
+func TestCapSeries(t *testing.T) {
+	response := backend.NewQueryDataResponse()
+	response.Responses["A"] = backend.DataResponse{Frames: data.Frames{data.NewFrame("a"), data.NewFrame("b"), data.NewFrame("c")}}
+	response.Responses["B"] = backend.DataResponse{Frames: data.Frames{data.NewFrame("d")}}
+
+	(&QueryLimits{MaxSeries: 2}).capSeries(response)
+	require.Len(t, response.Responses["A"].Frames, 2)
+	require.Len(t, notices(response.Responses["A"]), 1)
+	assert.Equal(t, data.NoticeSeverityWarning, notices(response.Responses["A"])[0].Severity)
+	assert.Contains(t, notices(response.Responses["A"])[0].Text, "Showing 2 of 3 series")
+	assert.Empty(t, notices(response.Responses["B"]))
+
+	(&QueryLimits{MaxSeries: -1}).capSeries(response)
+	assert.Len(t, response.Responses["A"].Frames, 2, "a negative limit disables the cap")
+	assert.Equal(t, defaultMaxSeries, (*QueryLimits)(nil).maxSeries())
+	assert.Equal(t, defaultMaxSeries, (&QueryLimits{MaxLogsPages: 2}).maxSeries())
+	assert.Equal(t, 5000, (&QueryLimits{MaxSeries: 5000}).maxSeries(), "admins may raise the cap")
+	assert.Zero(t, (&QueryLimits{MaxSeries: -1}).maxSeries())
+}
+
+func TestNoticeLogsLines(t *testing.T) {
+	limits := &QueryLimits{MaxLogsPages: 2}
+
+	frames := data.Frames{data.NewFrame("logs")}
+	limits.noticeLogsLines(frames, 2000, 2000)
+	assert.Nil(t, frames[0].Meta, "asked for no more than the pages allow")
+
+	limits.noticeLogsLines(frames, 5000, 1200)
+	assert.Nil(t, frames[0].Meta, "there were fewer lines than the pages allow")
+
+	limits.noticeLogsLines(frames, 5000, 2000)
+	require.NotNil(t, frames[0].Meta)
+	require.Len(t, frames[0].Meta.Notices, 1)
+	assert.Equal(t, "Showing the newest 2000 log lines: this datasource fetches at most 2 pages of 1000 lines per logs query",
+		frames[0].Meta.Notices[0].Text)
+}
+
+func TestIntegration_Limits_MaxSeries(t *testing.T) {
+	d, srv := newFakeBackedDatasourceWithLimits(t, QueryLimits{MaxSeries: 3})
+
+	now := time.Now().UnixMilli()
+	series := make([]fakedatadog.Series, 5)
+	for i := range series {
+		series[i] = fakedatadog.Series{GroupTags: []string{fmt.Sprintf("host:web-%02d", i)}, Values: fakedatadog.Points(float64(i))}
+	}
+	srv.Enqueue(fakedatadog.EndpointTimeseriesQuery, fakedatadog.JSON(fakedatadog.TimeseriesResponse([]int64{now}, series...)))
+
+	resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{dataQuery("A", map[string]interface{}{"queryText": "avg:system.cpu.user{*}"})},
+	})
+	require.NoError(t, err)
+	res := resp.Responses["A"]
+	require.NoError(t, res.Error)
+	assert.Len(t, res.Frames, 3)
+	require.Len(t, notices(res), 1)
+	assert.Contains(t, notices(res)[0].Text, "Showing 3 of 5 series")
+}
+
+func TestIntegration_Limits_LogsTimeRange(t *testing.T) {
+	d, srv := newFakeBackedDatasourceWithLimits(t, QueryLimits{MaxLogsTimeRange: "30m"})
+
+	resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{dataQuery("A", map[string]interface{}{"queryType": "logs", "logQuery": "service:checkout"})},
+	})
+	require.NoError(t, err)
+	res := resp.Responses["A"]
+	assert.Equal(t, backend.StatusBadRequest, res.Status)
+	assert.ErrorContains(t, res.Error, "longer than the 30m allowed")
+	require.Len(t, notices(res), 1)
+	assert.Equal(t, data.NoticeSeverityError, notices(res)[0].Severity)
+	assert.Zero(t, srv.Hits(fakedatadog.EndpointLogsSearch), "the query is not sent")
+}
+
+func TestIntegration_Limits_LogsPages(t *testing.T) {
+	d, srv := newFakeBackedDatasourceWithLimits(t, QueryLimits{MaxLogsPages: 2})
+
+	page := func(after string, n int) fakedatadog.Response {
+		events := make([]fakedatadog.LogEvent, n)
+		for i := range events {
+			events[i] = fakedatadog.LogEvent{Timestamp: time.Now(), Message: "payment accepted", Service: "checkout"}
+		}
+		return fakedatadog.JSON(fakedatadog.LogsSearchResponse(after, events...))
+	}
+	srv.Enqueue(fakedatadog.EndpointLogsSearch, page("cursor-2", 1000), page("cursor-3", 1000))
+
+	q := dataQuery("A", map[string]interface{}{"queryType": "logs", "logQuery": "service:checkout"})
+	q.MaxDataPoints = 5000
+	resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{Queries: []backend.DataQuery{q}})
+	require.NoError(t, err)
+	res := resp.Responses["A"]
+	require.NoError(t, res.Error)
+	require.Len(t, notices(res), 1)
+	assert.Contains(t, notices(res)[0].Text, "Showing the newest 2000 log lines")
+
+	reqs := srv.Requests(fakedatadog.EndpointLogsSearch)
+	require.Len(t, reqs, 2, "no more than the allowed pages are fetched")
+	var body struct {
+		Page struct {
+			Limit  int    `json:"limit"`
+			Cursor string `json:"cursor"`
+		} `json:"page"`
+	}
+	require.NoError(t, reqs[1].DecodeBody(&body))
+	assert.Equal(t, 1000, body.Page.Limit)
+	assert.Equal(t, "cursor-2", body.Page.Cursor)
+}
+
+func TestIntegration_Limits_RequestBudget(t *testing.T) {
+	d, srv := newFakeBackedDatasourceWithLimits(t, QueryLimits{RequestsPerMinute: 1})
+
+	query := func(text string) backend.DataResponse {
+		resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+			Queries: []backend.DataQuery{dataQuery("A", map[string]interface{}{"queryText": text})},
+		})
+		require.NoError(t, err)
+		return resp.Responses["A"]
+	}
+
+	require.NoError(t, query("avg:system.cpu.user{*}").Error)
+
+	res := query("avg:system.mem.used{*}")
+	assert.Equal(t, backend.StatusTooManyRequests, res.Status)
+	assert.ErrorContains(t, res.Error, "budget of 1 Datadog requests per minute")
+	require.Len(t, notices(res), 1)
+
+	resp := callResource(t, d, http.MethodGet, "autocomplete/metrics", nil)
+	assert.Equal(t, http.StatusTooManyRequests, resp.Status)
+	assert.Contains(t, string(resp.Body), "budget of 1 Datadog requests per minute")
+
+	assert.Equal(t, 1, srv.Hits(""), "refused requests do not reach Datadog")
+}
@@ -3,6 +3,7 @@ package plugin
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -13,7 +14,9 @@ import (
 	"github.com/DataDog/datadog-api-client-go/v2/api/datadog"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Compiled once at package init — avoids per-call regexp compilation overhead.
@@ -101,6 +104,11 @@ type LogLabels struct {
 	SpanID  string `json:"span_id,omitempty"`
 }
 
+// logsCacheTTL is how long logs results are served from cache: long enough for better hit
+// rates and fewer API calls (which helps prevent rate limiting), short enough to stay
+// responsive to new logs.
+const logsCacheTTL = 60 * time.Second
+
 // LogsCacheEntry stores cached logs data with timestamp for TTL validation
 type LogsCacheEntry struct {
 	LogEntries []LogEntry
@@ -140,14 +148,13 @@ func (d *Datasource) queryLogs(ctx context.Context, req *backend.QueryDataReques
 	response := backend.NewQueryDataResponse()
 
 	// Get API credentials from secure JSON data (reusing existing pattern)
-	apiKey, ok := d.SecureJSONData["apiKey"]
-	if !ok {
+	apiKey, appKey := d.activeCredentials()
+	if apiKey == "" {
 		logger.Error("missing apiKey in secure data")
 		return response, fmt.Errorf("missing apiKey in secure data")
 	}
 
-	appKey, ok := d.SecureJSONData["appKey"]
-	if !ok {
+	if appKey == "" {
 		logger.Error("missing appKey in secure data")
 		return response, fmt.Errorf("missing appKey in secure data")
 	}
@@ -188,7 +195,7 @@ func (d *Datasource) queryLogs(ctx context.Context, req *backend.QueryDataReques
 			continue
 		}
 
-		logger.Debug("Processing logs query", "refID", q.RefID, "queryType", qm.QueryType, "logQuery", qm.LogQuery)
+		logger.Debug("Processing logs query", "refID", q.RefID, "queryType", qm.QueryType, "logQuery", d.logQuery(qm.LogQuery))
 
 		// Skip hidden queries
 		if qm.Hide {
@@ -198,6 +205,11 @@ func (d *Datasource) queryLogs(ctx context.Context, req *backend.QueryDataReques
 
 		// Execute the logs query
 		frames, err := d.executeSingleLogsQuery(ddCtx, &qm, &q)
+		if res, ok := limitResponse(err); ok {
+			logger.Warn("Logs query refused by a query limit", "refID", q.RefID, "error", err)
+			response.Responses[q.RefID] = res
+			continue
+		}
 		if err != nil {
 			logger.Error("failed to execute logs query", "error", err, "refID", q.RefID)
 			// Use existing error handling patterns for consistent error messages
@@ -229,6 +241,13 @@ func (d *Datasource) executeSingleLogsQuery(ctx context.Context, qm *QueryModel,
 	// Check if this is a logs-volume query (supplementary query for histogram)
 	isVolumeQuery := qm.QueryType == "logs-volume"
 
+	queryType := "logs"
+	if isVolumeQuery {
+		queryType = "logs-volume"
+	}
+	ctx, span := startSpan(ctx, "datadog.logs.query", attrRefID.String(q.RefID), attrQueryType.String(queryType))
+	defer span.End()
+
 	// Create logs response parser (always flattens attributes and tags)
 	parser := NewLogsResponseParser(d)
 
@@ -238,85 +257,123 @@ func (d *Datasource) executeSingleLogsQuery(ctx context.Context, qm *QueryModel,
 		return nil, fmt.Errorf("failed to translate logs query: %w", err)
 	}
 
+	indexes, err := d.policy().logIndexes(qm.Indexes)
+	if err != nil {
+		return nil, err
+	}
+
 	// Convert time range using existing patterns (reusing time conversion logic)
 	from := q.TimeRange.From.UnixMilli()
 	to := q.TimeRange.To.UnixMilli()
 
+	// Refuse time ranges longer than the logs time range limit
+	if err := d.limits().checkLogsTimeRange(from, to); err != nil {
+		return nil, err
+	}
+
 	// Get limit from Grafana's MaxDataPoints (set by logs panel)
-	// Default to 500 if not provided, cap at 1000 for Datadog API limits
+	// Default to 500 if not provided, cap at the lines of the allowed pages
 	limit := 500
+	requested := limit
 	if q.MaxDataPoints > 0 {
 		limit = int(q.MaxDataPoints)
-		if limit > 1000 {
-			limit = 1000 // Cap at 1000 for Datadog API limits
+		requested = limit
+		if maxLines := d.limits().maxLogsPages() * logsMaxPageSize; limit > maxLines {
+			limit = maxLines
 		}
 	}
 
 	// Create cache key for this query (includes query, time range, and limit)
 	cacheKey := fmt.Sprintf("logs:%s:%d:%d:%d", logsQuery, from, to, limit)
+	if len(indexes) > 0 {
+		cacheKey += ":" + strings.Join(indexes, ",")
+	}
 
-	// Check cache first (60-second TTL for better cache hit rates and reduced API calls)
-	// This helps prevent rate limiting while still being responsive to new logs
-	cacheTTL := 60 * time.Second
-
-	logger.Info("Logs cache lookup",
-		"cacheKey", cacheKey,
-		"query", logsQuery,
+	logger.Debug("Logs cache lookup",
+		"query", d.logQuery(logsQuery),
 		"limit", limit,
 		"isVolumeQuery", isVolumeQuery)
 
-	if cachedEntry := d.GetCachedLogsEntry(cacheKey, cacheTTL); cachedEntry != nil {
-		logger.Info("✅ Cache HIT - Returning cached logs result",
-			"query", logsQuery,
+	// Check cache first
+	var logEntries []LogEntry
+	stats := queryStats{rowsName: "Log lines"}
+	var notices []data.Notice
+	cachedEntry := d.GetCachedLogsEntry(cacheKey, logsCacheTTL)
+	setSpanCacheHit(ctx, cachedEntry != nil)
+	if cachedEntry != nil {
+		span.SetAttributes(attrPageCount.Int(0))
+		logger.Debug("✅ Cache HIT - Returning cached logs result",
+			"query", d.logQuery(logsQuery),
 			"entriesCount", len(cachedEntry.LogEntries),
-			"cacheKey", cacheKey,
 			"isVolumeQuery", isVolumeQuery)
+		logEntries = cachedEntry.LogEntries
+		stats.cached = true
+	} else {
+		logger.Debug("❌ Cache MISS - Fetching from Datadog API",
+			"query", d.logQuery(logsQuery),
+			"limit", limit)
+
+		// Fetch up to limit lines, one page per 1000 lines. Panels that miss the cache for
+		// the same key at the same time share the Datadog calls.
+		start := time.Now()
+		v, coalesced, err := d.coalesce(ctx, coalesceLogs, cacheKey, func(ctx context.Context) (interface{}, error) {
+			pages, err := d.fetchLogsPages(ctx, logsQuery, indexes, from, to, limit)
+			if err != nil {
+				return nil, err
+			}
+			if pages.partial {
+				// Not cached, so that the next refresh fetches the rest
+				return pages, nil
+			}
 
-		// For logs-volume queries, return only the volume histogram frame
-		if isVolumeQuery {
-			volumeFrame := parser.createLogsVolumeFrame(cachedEntry.LogEntries, q.RefID, q.TimeRange)
-			return data.Frames{volumeFrame}, nil
+			// Cache the results
+			logger.Debug("💾 Caching logs result",
+				"query", d.logQuery(logsQuery),
+				"entriesCount", len(pages.entries),
+				"limit", limit,
+				"cacheTTL", logsCacheTTL,
+				"isVolumeQuery", isVolumeQuery)
+			d.SetCachedLogsEntry(cacheKey, pages.entries, pages.nextCursor)
+			return pages, nil
+		})
+		if err != nil {
+			return nil, tracing.Errorf(span, "failed to execute logs query: %w", err)
+		}
+		pages := v.(*logsPages)
+		logEntries = pages.entries
+		stats.latency = time.Since(start)
+		if coalesced {
+			span.SetAttributes(attrPageCount.Int(0))
+		} else {
+			span.SetAttributes(attrPageCount.Int(pages.count))
+			stats.pages = pages.count
+		}
+		if pages.partial {
+			notices = append(notices, partialLogsNotice(pages.count))
 		}
-
-		// For regular logs queries, return the logs data frame
-		frames := parser.createLogsDataFrames(cachedEntry.LogEntries, q.RefID, logsQuery, q.TimeRange)
-		return frames, nil
-	}
-
-	logger.Info("❌ Cache MISS - Fetching from Datadog API",
-		"cacheKey", cacheKey,
-		"limit", limit)
-
-	// Execute single query with limit (no cursor-based pagination)
-	logEntries, nextCursor, err := d.executeSingleLogsPageQuery(ctx, logsQuery, from, to, "", limit)
-	if err != nil {
-		return nil, fmt.Errorf("failed to execute logs query: %w", err)
 	}
-
-	// Cache the results
-	logger.Info("💾 Caching logs result",
-		"cacheKey", cacheKey,
-		"entriesCount", len(logEntries),
-		"limit", limit,
-		"cacheTTL", cacheTTL,
-		"isVolumeQuery", isVolumeQuery)
-	d.SetCachedLogsEntry(cacheKey, logEntries, nextCursor)
+	stats.rows = len(logEntries)
+	executed := logsExecutedQuery(logsQuery, indexes)
 
 	// For logs-volume queries, return only the volume histogram frame
 	if isVolumeQuery {
 		volumeFrame := parser.createLogsVolumeFrame(logEntries, q.RefID, q.TimeRange)
 		logger.Info("Created volume histogram frame for logs-volume query",
-			"query", logsQuery,
+			"query", d.logQuery(logsQuery),
 			"entriesCount", len(logEntries),
 			"refID", q.RefID)
-		return data.Frames{volumeFrame}, nil
+		frames := data.Frames{volumeFrame}
+		stats.truncated = d.limits().noticeLogsLines(frames, requested, len(logEntries))
+		return annotateFrames(frames, q.RefID, executed, stats, notices), nil
 	}
 
 	// For regular logs queries, create the logs data frame
 	frames := parser.createLogsDataFrames(logEntries, q.RefID, logsQuery, q.TimeRange)
+	stats.truncated = d.limits().noticeLogsLines(frames, requested, len(logEntries))
+	frames = annotateFrames(frames, q.RefID, executed, stats, notices)
 
 	logger.Info("Successfully executed logs query",
-		"query", logsQuery,
+		"query", d.logQuery(logsQuery),
 		"entriesReturned", len(logEntries),
 		"framesCreated", len(frames),
 		"limit", limit)
@@ -326,7 +383,8 @@ func (d *Datasource) executeSingleLogsQuery(ctx context.Context, qm *QueryModel,
 
 // executeLogsQueryWithPagination executes a logs query with automatic pagination
 // Implements Requirements 10.1, 10.4 for pagination and caching consistency
-func (d *Datasource) executeLogsQueryWithPagination(ctx context.Context, logsQuery string, from, to int64) ([]LogEntry, error) {
+// Pages stopped early by rate limiting are returned with partial set
+func (d *Datasource) executeLogsQueryWithPagination(ctx context.Context, logsQuery string, indexes []string, from, to int64) (*logsPages, error) {
 	logger := log.New()
 
 	// Create context with timeout (reusing existing timeout patterns - 30 seconds)
@@ -334,8 +392,7 @@ func (d *Datasource) executeLogsQueryWithPagination(ctx context.Context, logsQue
 	defer cancel()
 
 	// Get API credentials and site configuration
-	apiKey, _ := d.SecureJSONData["apiKey"]
-	appKey, _ := d.SecureJSONData["appKey"]
+	apiKey, appKey := d.activeCredentials()
 	site := d.JSONData.Site
 	if site == "" {
 		site = "datadoghq.com"
@@ -346,6 +403,8 @@ func (d *Datasource) executeLogsQueryWithPagination(ctx context.Context, logsQue
 
 	var allLogEntries []LogEntry
 	var nextCursor string
+	partial := false
+	fetched := 0
 	pageCount := 0
 	maxPages := 3      // Reduced from 10 to 3 to prevent rate limiting
 	maxEntries := 3000 // Reduced from 10000 to 3000 for better performance
@@ -358,14 +417,15 @@ func (d *Datasource) executeLogsQueryWithPagination(ctx context.Context, logsQue
 		// Adding extra delays here was causing unnecessary blocking without added benefit
 
 		// Execute single page request with retry logic for rate limits
-		logEntries, cursor, err := d.executeSingleLogsPageWithRetry(queryCtx, logsQuery, from, to, nextCursor, apiKey, appKey, site, 500, pageCount+1)
+		logEntries, cursor, err := d.executeSingleLogsPageWithRetry(queryCtx, logsQuery, indexes, from, to, nextCursor, apiKey, appKey, site, 500, pageCount+1)
 
 		if err != nil {
 			// If we get rate limited even with retries, return what we have so far
-			if strings.Contains(err.Error(), "rate limit") || strings.Contains(err.Error(), "429") {
+			if isRateLimitError(err) {
 				logger.Warn("Rate limit exceeded, returning partial results",
 					"totalEntries", len(allLogEntries),
 					"pagesFetched", pageCount)
+				partial = true
 				break
 			}
 			return nil, fmt.Errorf("failed to execute logs page %d: %w", pageCount+1, err)
@@ -373,6 +433,7 @@ func (d *Datasource) executeLogsQueryWithPagination(ctx context.Context, logsQue
 
 		// Add entries to result
 		allLogEntries = append(allLogEntries, logEntries...)
+		fetched++
 
 		logger.Debug("Fetched logs page",
 			"pageNumber", pageCount+1,
@@ -406,13 +467,53 @@ func (d *Datasource) executeLogsQueryWithPagination(ctx context.Context, logsQue
 	logger.Info("Completed paginated logs query",
 		"totalPages", pageCount+1,
 		"totalEntries", len(allLogEntries))
+	trace.SpanFromContext(ctx).SetAttributes(attrPageCount.Int(pageCount + 1))
 
-	return allLogEntries, nil
+	return &logsPages{entries: allLogEntries, nextCursor: nextCursor, count: fetched, partial: partial}, nil
+}
+
+// logsPages is the outcome of fetching the pages of a logs query.
+type logsPages struct {
+	entries    []LogEntry
+	nextCursor string
+	count      int
+	// partial is set when rate limiting stopped the pages early
+	partial bool
+}
+
+// fetchLogsPages fetches up to limit log lines, in pages of at most logsMaxPageSize lines.
+// When a page after the first is rate limited, the lines fetched so far are returned as
+// partial results.
+func (d *Datasource) fetchLogsPages(ctx context.Context, logsQuery string, indexes []string, from, to int64, limit int) (*logsPages, error) {
+	pages := &logsPages{}
+	for len(pages.entries) < limit {
+		pageSize := limit - len(pages.entries)
+		if pageSize > logsMaxPageSize {
+			pageSize = logsMaxPageSize
+		}
+		entries, nextCursor, err := d.executeSingleLogsPageQuery(ctx, logsQuery, indexes, from, to, pages.nextCursor, pageSize)
+		if err != nil {
+			if pages.count > 0 && isRateLimitError(err) {
+				log.New().Warn("Logs pages rate limited, returning partial results",
+					"totalEntries", len(pages.entries), "pagesFetched", pages.count)
+				pages.partial = true
+				break
+			}
+			return nil, err
+		}
+		pages.entries = append(pages.entries, entries...)
+		pages.nextCursor = nextCursor
+		pages.count++
+		if nextCursor == "" || len(entries) < pageSize {
+			break
+		}
+	}
+	return pages, nil
 }
 
 // executeSingleLogsPageQuery executes a single page logs query with user-controlled pagination
 // This replaces the automatic pagination to prevent rate limiting issues
-func (d *Datasource) executeSingleLogsPageQuery(ctx context.Context, logsQuery string, from, to int64, cursor string, pageSize int) ([]LogEntry, string, error) {
+func (d *Datasource) executeSingleLogsPageQuery(ctx context.Context, logsQuery string, indexes []string, from, to int64, cursor string, pageSize int) ([]LogEntry, string, error) {
 	logger := log.New()
 
 	// Create context with timeout (30 seconds)
@@ -420,8 +521,7 @@ func (d *Datasource) executeSingleLogsPageQuery(ctx context.Context, logsQuery s
 	defer cancel()
 
 	// Get API credentials and site configuration
-	apiKey, _ := d.SecureJSONData["apiKey"]
-	appKey, _ := d.SecureJSONData["appKey"]
+	apiKey, appKey := d.activeCredentials()
 	site := d.JSONData.Site
 	if site == "" {
 		site = "datadoghq.com"
@@ -430,13 +530,13 @@ func (d *Datasource) executeSingleLogsPageQuery(ctx context.Context, logsQuery s
 	// Acquire semaphore slot to limit concurrent requests
 
 	// Execute single page request with retry logic
-	logEntries, nextCursor, err := d.executeSingleLogsPageWithRetry(queryCtx, logsQuery, from, to, cursor, apiKey, appKey, site, pageSize, 1)
+	logEntries, nextCursor, err := d.executeSingleLogsPageWithRetry(queryCtx, logsQuery, indexes, from, to, cursor, apiKey, appKey, site, pageSize, 1)
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to execute logs page: %w", err)
 	}
 
-	logger.Info("Executed single logs page query",
-		"query", logsQuery,
+	logger.Debug("Executed single logs page query",
+		"query", d.logQuery(logsQuery),
 		"pageSize", pageSize,
 		"entriesReturned", len(logEntries),
 		"nextCursor", nextCursor != "")
@@ -445,11 +545,11 @@ func (d *Datasource) executeSingleLogsPageQuery(ctx context.Context, logsQuery s
 }
 
 // executeSingleLogsPage executes a single page of logs query
-func (d *Datasource) executeSingleLogsPage(ctx context.Context, logsQuery string, from, to int64, cursor, apiKey, appKey, site string, pageSize int) ([]LogEntry, string, error) {
+func (d *Datasource) executeSingleLogsPage(ctx context.Context, logsQuery string, indexes []string, from, to int64, cursor, apiKey, appKey, site string, pageSize int) ([]LogEntry, string, error) {
 	logger := log.New()
 
 	// Use POST method with JSON body for proper Datadog Logs API v2 integration
-	url := fmt.Sprintf("https://api.%s/api/v2/logs/events/search", site)
+	url := d.apiBaseURL(site) + "/api/v2/logs/events/search"
 
 	// Create request body matching Datadog's actual API format
 	// Based on the API error, it seems Datadog expects a simpler structure
@@ -469,6 +569,11 @@ func (d *Datasource) executeSingleLogsPage(ctx context.Context, logsQuery string
 		},
 	}
 
+	// Search only the given indexes (all indexes otherwise)
+	if len(indexes) > 0 {
+		requestBody["filter"].(map[string]interface{})["indexes"] = indexes
+	}
+
 	// Add pagination cursor if provided
 	if cursor != "" {
 		requestBody["page"].(map[string]interface{})["cursor"] = cursor
@@ -483,8 +588,8 @@ func (d *Datasource) executeSingleLogsPage(ctx context.Context, logsQuery string
 	// Debug logging to help troubleshoot API issues
 	logger.Debug("Sending logs API request",
 		"url", url,
-		"requestBody", string(jsonBody),
-		"query", logsQuery)
+		"requestBody", redactPayload(jsonBody),
+		"query", d.logQuery(logsQuery))
 
 	// Create HTTP request
 	req, err := http.NewRequestWithContext(ctx, "POST", url, strings.NewReader(string(jsonBody)))
@@ -499,9 +604,14 @@ func (d *Datasource) executeSingleLogsPage(ctx context.Context, logsQuery string
 	req.Header.Set("Accept", "application/json")
 
 	// Execute the request
-	client := &http.Client{Timeout: 30 * time.Second}
+	client := d.httpClient(30 * time.Second)
 	resp, err := client.Do(req)
 	if err != nil {
+		// Refusals by the request budget are reported as such
+		var limitErr *limitError
+		if errors.As(err, &limitErr) {
+			return nil, "", limitErr
+		}
 		// Use existing error handling patterns for timeout and network errors
 		errorMsg := d.parseLogsError(err, 0, "")
 		return nil, "", fmt.Errorf("%s", errorMsg)
@@ -513,8 +623,8 @@ func (d *Datasource) executeSingleLogsPage(ctx context.Context, logsQuery string
 		bodyBytes, _ := io.ReadAll(resp.Body)
 		logger.Error("Logs API request failed",
 			"statusCode", resp.StatusCode,
-			"responseBody", string(bodyBytes),
-			"requestBody", string(jsonBody))
+			"responseBody", redactPayload(bodyBytes),
+			"requestBody", redactPayload(jsonBody))
 		errorMsg := d.parseLogsError(fmt.Errorf("HTTP %d", resp.StatusCode), resp.StatusCode, string(bodyBytes))
 		return nil, "", fmt.Errorf("%s", errorMsg)
 	}
@@ -546,14 +656,18 @@ func (d *Datasource) executeSingleLogsPage(ctx context.Context, logsQuery string
 }
 
 // executeSingleLogsPageWithRetry executes a single page with retry logic for rate limits
-func (d *Datasource) executeSingleLogsPageWithRetry(ctx context.Context, logsQuery string, from, to int64, cursor, apiKey, appKey, site string, pageSize, pageNumber int) ([]LogEntry, string, error) {
+func (d *Datasource) executeSingleLogsPageWithRetry(ctx context.Context, logsQuery string, indexes []string, from, to int64, cursor, apiKey, appKey, site string, pageSize, pageNumber int) ([]LogEntry, string, error) {
 	logger := log.New()
 
 	maxRetries := 2              // Reduced from 3 to 2 to avoid excessive retries
 	baseDelay := 3 * time.Second // Increased from 1s to 3s for more conservative approach
 
 	for attempt := 0; attempt <= maxRetries; attempt++ {
-		logEntries, nextCursor, err := d.executeSingleLogsPage(ctx, logsQuery, from, to, cursor, apiKey, appKey, site, pageSize)
+		attemptCtx := ctx
+		if attempt > 0 {
+			attemptCtx = withRetryAttempt(ctx)
+		}
+		logEntries, nextCursor, err := d.executeSingleLogsPage(attemptCtx, logsQuery, indexes, from, to, cursor, apiKey, appKey, site, pageSize)
 
 		if err == nil {
 			return logEntries, nextCursor, nil
@@ -609,7 +723,7 @@ func (d *Datasource) translateLogsQuery(qm *QueryModel, q *backend.DataQuery) (s
 		query = "*" // Default to match all logs
 	}
 
-	logger.Debug("Translating logs query", "originalQuery", query, "refID", q.RefID)
+	logger.Debug("Translating logs query", "originalQuery", d.logQuery(query), "refID", q.RefID)
 
 	// Basic validation - ensure query is not empty after trimming
 	query = strings.TrimSpace(query)
@@ -641,7 +755,13 @@ func (d *Datasource) translateLogsQuery(qm *QueryModel, q *backend.DataQuery) (s
 	// Validate time range integration (Requirements 4.5)
 	query = d.validateTimeRangeIntegration(query)
 
-	logger.Debug("Translated logs query", "translatedQuery", query, "refID", q.RefID)
+	// Restrict the search to what the governance policy allows
+	query, err := d.policy().scopeLogsQuery(query)
+	if err != nil {
+		return "", err
+	}
+
+	logger.Debug("Translated logs query", "translatedQuery", d.logQuery(query), "refID", q.RefID)
 
 	return query, nil
 }
@@ -672,7 +792,7 @@ func (d *Datasource) validateAndNormalizeFacetFilters(query string) string {
 	// Validate other common facet filters
 	query = d.validateCommonFacets(query)
 
-	logger.Debug("Normalized facet filters", "query", query)
+	logger.Debug("Normalized facet filters", "query", d.logQuery(query))
 
 	return query
 }
@@ -917,7 +1037,7 @@ func (d *Datasource) validateWildcardPatterns(query string) string {
 		// Extract the base term and wildcard
 		if strings.HasSuffix(match, "**") {
 			// Multiple asterisks - normalize to single asterisk
-			logger.Debug("Normalizing multiple wildcards", "original", match)
+			logger.Debug("Normalizing multiple wildcards", "original", d.logQuery(match))
 			return strings.TrimSuffix(match, "*") // Remove one asterisk, keep one
 		}
 
@@ -937,7 +1057,7 @@ func (d *Datasource) validateWildcardPatterns(query string) string {
 		return match
 	})
 
-	logger.Debug("Validated wildcard patterns", "query", query)
+	logger.Debug("Validated wildcard patterns", "query", d.logQuery(query))
 
 	return query
 }
@@ -957,7 +1077,7 @@ func (d *Datasource) validateAdvancedBooleanPatterns(query string) string {
 	query = reLogMultiSpace.ReplaceAllString(query, " ")
 	query = strings.TrimSpace(query)
 
-	logger.Debug("Validated advanced boolean patterns", "query", query)
+	logger.Debug("Validated advanced boolean patterns", "query", d.logQuery(query))
 
 	return query
 }
@@ -983,7 +1103,7 @@ func (d *Datasource) validateTimeRangeIntegration(query string) string {
 		if strings.Contains(strings.ToLower(query), pattern) {
 			logger.Warn("Detected inline time filter in logs query",
 				"pattern", pattern,
-				"query", query,
+				"query", d.logQuery(query),
 				"recommendation", "Use Grafana's time range picker instead of inline time filters")
 			// Note: We don't remove these filters as users might have specific use cases
 			// Just log a warning for now
@@ -993,8 +1113,8 @@ func (d *Datasource) validateTimeRangeIntegration(query string) string {
 	// Handle relative time expressions that users might add
 	// Example: @timestamp:>now-1h -> this should be handled by Grafana's time picker
 	if reLogRelativeTimestamp.MatchString(query) {
-		logger.Info("Found relative time filter in query",
-			"query", query,
+		logger.Debug("Found relative time filter in query",
+			"query", d.logQuery(query),
 			"note", "This will be combined with Grafana's time range picker")
 	}
 
@@ -7,6 +7,7 @@ import (
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
 )
 
 // LogsHandler handles Datadog logs queries
@@ -58,6 +59,15 @@ func (h *LogsHandler) processQuery(qm *QueryModel) error {
 		}
 	}
 
+	// Refuse searches the governance policy does not allow
+	policy := h.datasource.policy()
+	if _, err := policy.scopeLogsQuery(qm.LogQuery); err != nil {
+		return err
+	}
+	if _, err := policy.logIndexes(qm.Indexes); err != nil {
+		return err
+	}
+
 	// Find the corresponding backend query for RefID
 	var refID string
 	for _, q := range h.reqQueries {
@@ -78,7 +88,7 @@ func (h *LogsHandler) processQuery(qm *QueryModel) error {
 	h.queryModels[refID] = *qm
 	h.logsQueries = append(h.logsQueries, *qm)
 
-	logger.Debug("Added logs query", "refID", refID, "logQuery", qm.LogQuery)
+	logger.Debug("Added logs query", "refID", refID, "logQuery", h.datasource.logQuery(qm.LogQuery))
 	return nil
 }
 
@@ -95,6 +105,12 @@ func (h *LogsHandler) executeQueries(ctx context.Context) (*backend.QueryDataRes
 
 	logger.Info("Processing logs queries", "logsQueryCount", len(h.logsQueries))
 
+	// h.ddCtx carries the QueryData span, so the handler span nests under it
+	spanCtx, span := startSpan(h.ddCtx, "datadog.logs.executeQueries",
+		attrQueryType.String(string(LogsQueryType)),
+		attrRefIDs.StringSlice(sortedRefIDs(h.queryModels)))
+	defer span.End()
+
 	// Create a new request containing only logs queries
 	logsReq := &backend.QueryDataRequest{
 		Headers: map[string]string{}, // Will be set by the calling context
@@ -119,9 +135,10 @@ func (h *LogsHandler) executeQueries(ctx context.Context) (*backend.QueryDataRes
 
 	// Execute logs queries using the existing logs handler
 	// This reuses the existing queryLogs method which has proper authentication and error handling
-	logsResponse, err := h.datasource.queryLogs(h.ddCtx, logsReq)
+	logsResponse, err := h.datasource.queryLogs(spanCtx, logsReq)
 	if err != nil {
 		logger.Error("Failed to execute logs queries", "error", err)
+		_ = tracing.Error(span, err)
 		// Set error responses for all logs queries
 		for refID := range h.queryModels {
 			response.Responses[refID] = backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("logs query failed: %v", err))
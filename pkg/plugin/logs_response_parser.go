@@ -1051,8 +1051,7 @@ func (p *LogsResponseParser) applyJSONParsing(entry *LogEntry, attributes map[st
 			"logID", entry.ID,
 			"field", config.TargetField,
 			"error", err,
-			"jsonLength", len(targetValue),
-			"jsonPreview", p.truncateForLogging(targetValue, 100))
+			"jsonLength", len(targetValue))
 
 		// Try partial parsing for mixed valid/invalid content
 		partialData := p.attemptPartialParsing(targetValue, config.TargetField, entry.ID)
@@ -1157,14 +1156,6 @@ func (p *LogsResponseParser) convertValueToString(value interface{}) string {
 	}
 }
 
-// truncateForLogging truncates a string for logging purposes to prevent log spam
-func (p *LogsResponseParser) truncateForLogging(s string, maxLen int) string {
-	if len(s) <= maxLen {
-		return s
-	}
-	return s[:maxLen] + "..."
-}
-
 // attemptPartialParsing attempts to parse partial JSON content from mixed valid/invalid content
 // This handles cases where logs contain both JSON and non-JSON content
 // Requirements: 5.3 - Implement partial parsing for mixed valid/invalid content
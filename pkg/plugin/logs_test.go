@@ -401,11 +401,9 @@ func TestLogsAPIIntegrationConsistency(t *testing.T) {
 			
 			// Create datasource
 			datasource := &Datasource{
-				cache: &AutocompleteCache{
-					entries: make(map[string]*CacheEntry),
-				},
-				logsCache:             make(map[string]*LogsCacheEntry),
-				logsAutocompleteCache: make(map[string]*LogsAutocompleteCacheEntry),
+				cache:                 newMemoryCache(cacheAutocomplete, cacheLimits{}),
+				logsCache:             newMemoryCache(cacheLogs, cacheLimits{}),
+				logsAutocompleteCache: newMemoryCache(cacheLogsAutocomplete, cacheLimits{}),
 			}
 			
 			// Test that datasource is properly initialized
@@ -563,7 +561,7 @@ func TestLogsPaginationAndCaching(t *testing.T) {
 			// Requirements: 10.4 - implement 30-second cache TTL
 			
 			datasource := &Datasource{
-				logsCache: make(map[string]*LogsCacheEntry),
+				logsCache: newMemoryCache(cacheLogs, cacheLimits{}),
 			}
 			
 			// Create test log entries
@@ -798,7 +796,7 @@ func TestLogsPaginationAndCaching(t *testing.T) {
 			// Requirements: 10.4 - maintain cache with appropriate TTL
 			
 			datasource := &Datasource{
-				logsCache: make(map[string]*LogsCacheEntry),
+				logsCache: newMemoryCache(cacheLogs, cacheLimits{}),
 			}
 			
 			// Add fresh entry
@@ -807,24 +805,23 @@ func TestLogsPaginationAndCaching(t *testing.T) {
 			
 			// Add old entry by manually setting timestamp
 			oldEntries := []LogEntry{{ID: "old", Body: "Old entry"}}
-			datasource.logsCache["old-key"] = &LogsCacheEntry{
-				LogEntries: oldEntries,
-				Timestamp:  time.Now().Add(-1 * time.Hour), // 1 hour ago
-				NextCursor: "",
-			}
+			logsCache := datasource.logsCache.(*memoryCache)
+			logsCache.now = func() time.Time { return time.Now().Add(-1 * time.Hour) } // 1 hour ago
+			datasource.SetCachedLogsEntry("old-key", oldEntries, "")
+			logsCache.now = time.Now
 			
 			// Property: Both entries should exist before cleanup
-			assert.Len(t, datasource.logsCache, 2, "Should have 2 cache entries before cleanup")
+			assert.Equal(t, 2, datasource.logsCache.Len(), "Should have 2 cache entries before cleanup")
 			
 			// Clean expired entries with 30-second TTL
 			cacheTTL := 30 * time.Second
 			datasource.CleanExpiredLogsCache(cacheTTL)
 			
 			// Property: Only fresh entry should remain after cleanup
-			assert.Len(t, datasource.logsCache, 1, "Should have 1 cache entry after cleanup")
+			assert.Equal(t, 1, datasource.logsCache.Len(), "Should have 1 cache entry after cleanup")
 			
-			_, hasFresh := datasource.logsCache["fresh-key"]
-			_, hasOld := datasource.logsCache["old-key"]
+			_, _, hasFresh := datasource.logsCache.Get("fresh-key")
+			_, _, hasOld := datasource.logsCache.Get("old-key")
 			
 			assert.True(t, hasFresh, "Fresh entry should remain after cleanup")
 			assert.False(t, hasOld, "Old entry should be removed after cleanup")
@@ -836,7 +833,7 @@ func TestLogsPaginationAndCaching(t *testing.T) {
 			// Requirements: 10.4 - cache should handle concurrent access safely
 			
 			datasource := &Datasource{
-				logsCache: make(map[string]*LogsCacheEntry),
+				logsCache: newMemoryCache(cacheLogs, cacheLimits{}),
 			}
 			
 			// Test concurrent cache operations
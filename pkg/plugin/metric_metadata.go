@@ -0,0 +1,297 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV1"
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Metric metadata. Datadog knows the unit, type and description of each metric, so the
+// frames of queries on a single metric get them as field config: panels show bytes, seconds
+// or percentages without the unit being set by hand. Metadata comes from
+// GET /api/v1/metrics/{metric_name} and is cached for metricMetadataCacheTTL. A unit reported
+// with the series of a timeseries response wins over the metadata, since it accounts for
+// functions such as per_second().
+
+// metricMetadata is the metadata of one metric; empty for metrics Datadog has none for.
+type metricMetadata struct {
+	Unit           string `json:"unit,omitempty"`
+	PerUnit        string `json:"perUnit,omitempty"`
+	Type           string `json:"type,omitempty"`
+	Description    string `json:"description,omitempty"`
+	StatsdInterval int64  `json:"statsdInterval,omitempty"`
+}
+
+// grafanaUnits maps Datadog unit names onto Grafana unit ids.
+var grafanaUnits = map[string]string{
+	"bit":               "bits",
+	"byte":              "bytes",
+	"kibibyte":          "kbytes",
+	"mebibyte":          "mbytes",
+	"gibibyte":          "gbytes",
+	"tebibyte":          "tbytes",
+	"pebibyte":          "pbytes",
+	"kilobyte":          "deckbytes",
+	"megabyte":          "decmbytes",
+	"gigabyte":          "decgbytes",
+	"terabyte":          "dectbytes",
+	"petabyte":          "decpbytes",
+	"nanosecond":        "ns",
+	"microsecond":       "µs",
+	"millisecond":       "ms",
+	"second":            "s",
+	"minute":            "m",
+	"hour":              "h",
+	"day":               "d",
+	"week":              "w",
+	"percent":           "percent",
+	"fraction":          "percentunit",
+	"hertz":             "hertz",
+	"degree celsius":    "celsius",
+	"degree fahrenheit": "fahrenheit",
+	"watt":              "watt",
+	"kilowatt":          "kwatt",
+	"volt":              "volt",
+	"ampere":            "amp",
+	"joule":             "joule",
+	"dollar":            "currencyUSD",
+	"euro":              "currencyEUR",
+}
+
+// grafanaRateUnits maps Datadog "unit/per unit" pairs onto Grafana unit ids.
+var grafanaRateUnits = map[string]string{
+	"bit/second":       "bps",
+	"byte/second":      "Bps",
+	"kibibyte/second":  "KiBs",
+	"mebibyte/second":  "MiBs",
+	"gibibyte/second":  "GiBs",
+	"kilobyte/second":  "KBs",
+	"megabyte/second":  "MBs",
+	"gigabyte/second":  "GBs",
+	"request/second":   "reqps",
+	"operation/second": "ops",
+	"read/second":      "rps",
+	"write/second":     "wps",
+	"event/second":     "eps",
+	"message/second":   "mps",
+	"record/second":    "recps",
+	"row/second":       "rowsps",
+	"operation/minute": "opm",
+	"read/minute":      "rpm",
+	"write/minute":     "wpm",
+}
+
+// perUnitSuffixes abbreviates the time per units of rates without a Grafana unit.
+var perUnitSuffixes = map[string]string{
+	"second": "s",
+	"minute": "min",
+	"hour":   "h",
+	"day":    "d",
+}
+
+// grafanaUnit returns the Grafana unit of a Datadog unit and per unit, "" when there is none.
+// Rates without a Grafana unit are shown with a suffix such as "error/s".
+func grafanaUnit(unit, perUnit string) string {
+	switch {
+	case unit == "":
+		return ""
+	case perUnit == "":
+		return grafanaUnits[unit]
+	}
+	if u, ok := grafanaRateUnits[unit+"/"+perUnit]; ok {
+		return u
+	}
+	if suffix, ok := perUnitSuffixes[perUnit]; ok {
+		return "suffix: " + unit + "/" + suffix
+	}
+	return "suffix: " + unit + "/" + perUnit
+}
+
+// seriesUnit returns the unit and per unit Datadog reported with a series, if any.
+func seriesUnit(s *datadogV2.TimeseriesResponseSeries) (unit, perUnit string) {
+	if len(s.Unit) > 0 {
+		unit = s.Unit[0].GetName()
+	}
+	if len(s.Unit) > 1 {
+		perUnit = s.Unit[1].GetName()
+	}
+	return unit, perUnit
+}
+
+// singleMetric returns the metric a metrics query names when it names exactly one.
+func singleMetric(query string) (string, bool) {
+	metric := ""
+	for _, m := range metricScopePattern.FindAllStringSubmatch(query, -1) {
+		name := m[1]
+		if strings.EqualFold(name, "by") {
+			continue
+		}
+		if metric != "" && name != metric {
+			return "", false
+		}
+		metric = name
+	}
+	return metric, metric != ""
+}
+
+// metricMetadataWait bounds how long a query waits for the metadata of its metrics. Fetches
+// still running then go on in the background, so that later queries find them cached.
+// Queries whose null handling is decided from the metric type (nullHandling "auto") wait for
+// their metric's fetch to finish instead, so that the mode does not depend on how fast
+// Datadog answered.
+const metricMetadataWait = time.Second
+
+// metricMetadataByRefID returns the metadata of the metric of each query that names exactly
+// one metric. Metrics are fetched in parallel; queries whose metadata cannot be fetched within
+// metricMetadataWait are left out, unless they are listed in required: metadata only adds to
+// the frames and never fails a query.
+func (d *Datasource) metricMetadataByRefID(ctx context.Context, executed map[string]string, required map[string]bool) map[string]*metricMetadata {
+	refIDsByMetric := map[string][]string{}
+	requiredMetrics := map[string]bool{}
+	for refID, query := range executed {
+		if metric, ok := singleMetric(query); ok {
+			refIDsByMetric[metric] = append(refIDsByMetric[metric], refID)
+			if required[refID] {
+				requiredMetrics[metric] = true
+			}
+		}
+	}
+
+	type fetched struct {
+		metric string
+		md     *metricMetadata
+	}
+	results := make(chan fetched, len(refIDsByMetric))
+	fetchCtx := context.WithoutCancel(ctx)
+	for metric := range refIDsByMetric {
+		go func(metric string) {
+			results <- fetched{metric: metric, md: d.metricMetadata(fetchCtx, metric)}
+		}(metric)
+	}
+
+	timer := time.NewTimer(metricMetadataWait)
+	defer timer.Stop()
+	timeout := timer.C
+	byRefID := map[string]*metricMetadata{}
+	for pending := len(refIDsByMetric); pending > 0; {
+		select {
+		case r := <-results:
+			pending--
+			delete(requiredMetrics, r.metric)
+			if r.md != nil {
+				for _, refID := range refIDsByMetric[r.metric] {
+					byRefID[refID] = r.md
+				}
+			}
+			if timeout == nil && len(requiredMetrics) == 0 {
+				return byRefID
+			}
+		case <-timeout:
+			log.New().Debug("Metric metadata not fetched in time", "pending", pending, "required", len(requiredMetrics))
+			if len(requiredMetrics) == 0 {
+				return byRefID
+			}
+			timeout = nil
+		case <-ctx.Done():
+			return byRefID
+		}
+	}
+	return byRefID
+}
+
+// metricMetadata returns the metadata of metric from cache or Datadog, nil when it could not
+// be fetched. Metrics Datadog does not know are cached with empty metadata. Concurrent
+// fetches of the same metric share one call.
+func (d *Datasource) metricMetadata(ctx context.Context, metric string) *metricMetadata {
+	cacheKey := "metadata:" + metric
+	var md metricMetadata
+	if _, ok := cacheGet(d.metadataCache, cacheKey, metricMetadataCacheTTL, &md); ok {
+		return &md
+	}
+	v, _, _ := d.coalesce(ctx, coalesceMetadata, metric, func(ctx context.Context) (interface{}, error) {
+		return d.fetchMetricMetadata(ctx, metric), nil
+	})
+	fetched, _ := v.(*metricMetadata)
+	return fetched
+}
+
+// fetchMetricMetadata fetches the metadata of metric from Datadog and caches it.
+func (d *Datasource) fetchMetricMetadata(ctx context.Context, metric string) *metricMetadata {
+	logger := log.New()
+	client, err := d.GetAPIClient()
+	if err != nil {
+		return nil
+	}
+	ddCtx, err := d.GetDatadogContext(ctx)
+	if err != nil {
+		return nil
+	}
+	ddCtx, cancel := context.WithTimeout(ddCtx, 10*time.Second)
+	defer cancel()
+
+	var md metricMetadata
+	resp, r, err := datadogV1.NewMetricsApi(client).GetMetricMetadata(ddCtx, metric)
+	if err != nil {
+		if r == nil || r.StatusCode != http.StatusNotFound {
+			logger.Debug("Failed to fetch metric metadata", "metric", metric, "error", err)
+			return nil
+		}
+	} else {
+		md = metricMetadata{
+			Unit:           resp.GetUnit(),
+			PerUnit:        resp.GetPerUnit(),
+			Type:           resp.GetType(),
+			Description:    resp.GetDescription(),
+			StatsdInterval: resp.GetStatsdInterval(),
+		}
+	}
+	cacheSet(d.metadataCache, "metadata:"+metric, md)
+	return &md
+}
+
+// applyFieldConfig sets the unit, description and interval of the fields of a series frame.
+// The unit reported with the series wins over the metadata; the interval is the spacing of
+// the response timestamps, or the statsd interval when there is a single timestamp.
+func applyFieldConfig(frame *data.Frame, s *datadogV2.TimeseriesResponseSeries, md *metricMetadata, times []int64) {
+	timeField, valueField := frame.Fields[0], frame.Fields[1]
+	if valueField.Config == nil {
+		valueField.Config = &data.FieldConfig{}
+	}
+
+	unit, perUnit := seriesUnit(s)
+	if unit == "" && md != nil {
+		unit, perUnit = md.Unit, md.PerUnit
+	}
+	if u := grafanaUnit(unit, perUnit); u != "" {
+		valueField.Config.Unit = u
+	}
+
+	if md != nil {
+		switch {
+		case md.Description != "" && md.Type != "":
+			valueField.Config.Description = fmt.Sprintf("%s (%s)", md.Description, md.Type)
+		case md.Description != "":
+			valueField.Config.Description = md.Description
+		}
+	}
+
+	var interval float64
+	if len(times) > 1 {
+		interval = float64(times[1] - times[0])
+	} else if md != nil && md.StatsdInterval > 0 {
+		interval = float64(md.StatsdInterval * 1000)
+	}
+	if interval > 0 {
+		if timeField.Config == nil {
+			timeField.Config = &data.FieldConfig{}
+		}
+		timeField.Config.Interval = interval
+	}
+}
@@ -0,0 +1,181 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wasilak/grafana-datadog-datasource/pkg/plugin/fakedatadog"
+)
+
+func TestGrafanaUnit(t *testing.T) {
+	tests := []struct {
+		unit, perUnit string
+		want          string
+	}{
+		{"byte", "", "bytes"},
+		{"second", "", "s"},
+		{"percent", "", "percent"},
+		{"fraction", "", "percentunit"},
+		{"byte", "second", "Bps"},
+		{"request", "second", "reqps"},
+		{"error", "second", "suffix: error/s"},
+		{"job", "week", "suffix: job/week"},
+		{"connection", "", ""},
+		{"", "second", ""},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, grafanaUnit(tt.unit, tt.perUnit), tt.unit+"/"+tt.perUnit)
+	}
+}
+
+func TestSingleMetric(t *testing.T) {
+	tests := []struct {
+		query  string
+		metric string
+		ok     bool
+	}{
+		{"avg:system.mem.used{*} by {host}", "system.mem.used", true},
+		{"sum:trace.http.request.duration{env:prod}.rollup(avg, 60)", "trace.http.request.duration", true},
+		{"avg:system.cpu.user{host:a} + avg:system.cpu.user{host:b}", "system.cpu.user", true},
+		{"avg:system.mem.used{*} / avg:system.mem.total{*}", "", false},
+		{"A / B", "", false},
+	}
+	for _, tt := range tests {
+		metric, ok := singleMetric(tt.query)
+		assert.Equal(t, tt.ok, ok, tt.query)
+		assert.Equal(t, tt.metric, metric, tt.query)
+	}
+}
+
+func TestIntegration_MetricMetadata_FieldConfig(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+
+	query := func(text string) backend.DataResponse {
+		resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+			Queries: []backend.DataQuery{dataQuery("A", map[string]interface{}{"queryText": text})},
+		})
+		require.NoError(t, err)
+		require.NoError(t, resp.Responses["A"].Error)
+		require.NotEmpty(t, resp.Responses["A"].Frames)
+		return resp.Responses["A"]
+	}
+
+	res := query("avg:system.mem.used{*}")
+	value := res.Frames[0].Fields[1].Config
+	assert.Equal(t, "bytes", value.Unit)
+	assert.Equal(t, "Amount of RAM in use. (gauge)", value.Description)
+	assert.Equal(t, float64(time.Minute.Milliseconds()), res.Frames[0].Fields[0].Config.Interval)
+
+	query("avg:system.mem.used{host:web-01}")
+	reqs := srv.Requests(fakedatadog.EndpointMetricMetadata)
+	require.Len(t, reqs, 1, "metadata is cached")
+	assert.Equal(t, "/api/v1/metrics/system.mem.used", reqs[0].Path)
+
+	// Metrics without metadata are cached too, and keep the default unit
+	res = query("avg:custom.checkout.jobs{*}")
+	assert.Empty(t, res.Frames[0].Fields[1].Config.Unit)
+	query("avg:custom.checkout.jobs{env:prod}")
+	assert.Equal(t, 2, srv.Hits(fakedatadog.EndpointMetricMetadata))
+
+	// Expressions over several metrics are left alone
+	srv.Reset()
+	res = query("avg:system.mem.used{*} / avg:system.cpu.user{*}")
+	assert.Empty(t, res.Frames[0].Fields[1].Config.Unit)
+	assert.Zero(t, srv.Hits(fakedatadog.EndpointMetricMetadata))
+}
+
+func TestIntegration_MetricMetadata_SeriesUnitWins(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+
+	now := time.Now().Truncate(time.Minute).UnixMilli()
+	srv.Enqueue(fakedatadog.EndpointTimeseriesQuery, fakedatadog.JSON(fakedatadog.TimeseriesResponse(
+		[]int64{now},
+		fakedatadog.Series{GroupTags: []string{"host:web-01"}, Unit: "kibibyte", Values: fakedatadog.Points(512)},
+	)))
+	resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{dataQuery("A", map[string]interface{}{"queryText": "avg:system.mem.used{*}"})},
+	})
+	require.NoError(t, err)
+	res := resp.Responses["A"]
+	require.NoError(t, res.Error)
+	require.Len(t, res.Frames, 1)
+	assert.Equal(t, "kbytes", res.Frames[0].Fields[1].Config.Unit)
+	assert.Equal(t, "Amount of RAM in use. (gauge)", res.Frames[0].Fields[1].Config.Description)
+}
+
+func TestIntegration_MetricMetadata_BoundedWait(t *testing.T) {
+	now := time.Now().Truncate(time.Minute).UnixMilli()
+	query := func(d *Datasource, srv *fakedatadog.Server) (*backend.QueryDataResponse, time.Duration) {
+		srv.Enqueue(fakedatadog.EndpointTimeseriesQuery, fakedatadog.JSON(fakedatadog.TimeseriesResponse(
+			[]int64{now},
+			fakedatadog.Series{QueryIndex: 0, Values: fakedatadog.Points(1)},
+			fakedatadog.Series{QueryIndex: 1, Values: fakedatadog.Points(2)},
+		)))
+		start := time.Now()
+		resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+			Queries: []backend.DataQuery{
+				dataQuery("A", map[string]interface{}{"queryText": "avg:system.mem.used{*}"}),
+				dataQuery("B", map[string]interface{}{"queryText": "avg:system.cpu.user{*}"}),
+			},
+		})
+		require.NoError(t, err)
+		return resp, time.Since(start)
+	}
+
+	// Metrics are fetched in parallel: two fetches of 600ms fit in the wait
+	d, srv := newFakeBackedDatasource(t)
+	srv.SetLatency(fakedatadog.EndpointMetricMetadata, 600*time.Millisecond)
+	resp, _ := query(d, srv)
+	require.Len(t, resp.Responses["B"].Frames, 1)
+	assert.Equal(t, "bytes", resp.Responses["A"].Frames[0].Fields[1].Config.Unit)
+	assert.Equal(t, "percent", resp.Responses["B"].Frames[0].Fields[1].Config.Unit)
+
+	// A slow metadata API does not hold up queries that do not need the metric type
+	d, srv = newFakeBackedDatasource(t)
+	srv.SetLatency(fakedatadog.EndpointMetricMetadata, 5*time.Second)
+	resp, elapsed := query(d, srv)
+	assert.Less(t, elapsed, metricMetadataWait+time.Second)
+	require.NoError(t, resp.Responses["A"].Error)
+	assert.Empty(t, resp.Responses["A"].Frames[0].Fields[1].Config.Unit)
+}
+
+func TestIntegration_MetricMetadata_ConcurrentFetchesShareOneCall(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+	srv.SetLatency(fakedatadog.EndpointMetricMetadata, 200*time.Millisecond)
+
+	results := make([]*metricMetadata, 4)
+	runConcurrently(len(results), func(i int) {
+		results[i] = d.metricMetadata(context.Background(), "system.mem.used")
+	})
+
+	assert.Equal(t, 1, srv.Hits(fakedatadog.EndpointMetricMetadata))
+	for _, md := range results {
+		require.NotNil(t, md)
+		assert.Equal(t, "gauge", md.Type)
+	}
+}
+
+func TestIntegration_MetricMetadata_AutoNullHandlingWaits(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+	srv.SetLatency(fakedatadog.EndpointMetricMetadata, metricMetadataWait+500*time.Millisecond)
+
+	now := time.Now().Truncate(time.Minute).UnixMilli()
+	srv.Enqueue(fakedatadog.EndpointTimeseriesQuery, fakedatadog.JSON(fakedatadog.TimeseriesResponse(
+		[]int64{now - 120000, now - 60000, now},
+		fakedatadog.Series{Values: []*float64{ptr(3), nil, ptr(5)}},
+	)))
+	resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{dataQuery("A", map[string]interface{}{"queryText": "avg:system.cpu.user{*}", "nullHandling": "auto"})},
+	})
+	require.NoError(t, err)
+	res := resp.Responses["A"]
+	require.NoError(t, res.Error)
+	require.Len(t, res.Frames, 1)
+	assert.Equal(t, 3, res.Frames[0].Fields[1].Len(), "the gauge breaks at missing data however slow the metadata API is")
+	assert.Nil(t, res.Frames[0].Fields[1].At(1))
+}
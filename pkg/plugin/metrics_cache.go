@@ -0,0 +1,278 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+// Metrics result cache. Auto-refreshing dashboards re-run the same timeseries queries every
+// few seconds over a window that only slides forward ("last 6h"). The cache keeps the last
+// result per query and range width together with its rollup interval, so a refresh only asks
+// Datadog for the newest buckets and merges them into the cached points.
+//
+// Datadog returns points on interval-aligned bucket starts. The newest buckets are still
+// being filled (and late points may still arrive), so the last metricsCacheRefetchBuckets
+// buckets are always fetched again. Entries are rebuilt from a full fetch after
+// metricsCacheMaxAge to pick up any later corrections to older buckets.
+const (
+	metricsCacheMaxEntries     = 200
+	metricsCacheMaxBytes       = 64 << 20
+	metricsCacheMaxAge         = 10 * time.Minute
+	metricsCacheRefetchBuckets = 2
+)
+
+// metricsCacheEntry is an immutable cached timeseries result. Updates replace the entry.
+type metricsCacheEntry struct {
+	from, to    int64 // requested range the result was built for (ms)
+	interval    int64 // rollup interval of the points (ms)
+	times       []int64
+	series      []datadogV2.TimeseriesResponseSeries
+	values      [][]*float64
+	fullFetchAt time.Time
+}
+
+// metricsCacheValue is the stored form of a metricsCacheEntry.
+type metricsCacheValue struct {
+	From        int64                                `json:"from"`
+	To          int64                                `json:"to"`
+	Interval    int64                                `json:"interval"`
+	Times       []int64                              `json:"times"`
+	Series      []datadogV2.TimeseriesResponseSeries `json:"series"`
+	Values      [][]*float64                         `json:"values"`
+	FullFetchAt time.Time                            `json:"fullFetchAt"`
+}
+
+// metricsResultCache stores timeseries results in a Cache. Entries do not expire by TTL:
+// metricsCacheFetchFrom decides from fullFetchAt whether an entry is still usable.
+type metricsResultCache struct {
+	store Cache
+}
+
+func newMetricsResultCache(store Cache) *metricsResultCache {
+	return &metricsResultCache{store: store}
+}
+
+func (c *metricsResultCache) get(key string) *metricsCacheEntry {
+	raw, _, ok := c.store.Get(key)
+	if !ok {
+		return nil
+	}
+	var v metricsCacheValue
+	if err := json.Unmarshal(raw, &v); err != nil {
+		c.store.Delete(key)
+		return nil
+	}
+	return &metricsCacheEntry{
+		from:        v.From,
+		to:          v.To,
+		interval:    v.Interval,
+		times:       v.Times,
+		series:      v.Series,
+		values:      v.Values,
+		fullFetchAt: v.FullFetchAt,
+	}
+}
+
+func (c *metricsResultCache) set(key string, entry *metricsCacheEntry) {
+	cacheSet(c.store, key, metricsCacheValue{
+		From:        entry.from,
+		To:          entry.to,
+		Interval:    entry.interval,
+		Times:       entry.times,
+		Series:      entry.series,
+		Values:      entry.values,
+		FullFetchAt: entry.fullFetchAt,
+	})
+}
+
+func (c *metricsResultCache) delete(key string) {
+	c.store.Delete(key)
+}
+
+// metricsCacheKey identifies a timeseries request independently of where its window sits:
+// the queries, formulas and interval override, plus the width of the range (panels showing
+// the same query over 1h and 24h are cached separately since they use different rollups).
+func metricsCacheKey(attrs datadogV2.TimeseriesFormulaRequestAttributes) (string, error) {
+	raw, err := json.Marshal(struct {
+		Queries  []datadogV2.TimeseriesQuery `json:"queries"`
+		Formulas []datadogV2.QueryFormula    `json:"formulas,omitempty"`
+		Interval *int64                      `json:"interval,omitempty"`
+	}{attrs.Queries, attrs.Formulas, attrs.Interval})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d:%s", attrs.To-attrs.From, raw), nil
+}
+
+// metricsCacheFetchFrom decides how much of [from, to] must be fetched given a cached entry.
+// It returns the start of the window to fetch, and whether the cached entry can be used:
+//   - ok is false when a full fetch is needed (no usable entry, range moved backwards or
+//     beyond the cached points, entry too old);
+//   - fetchFrom == to means the cached entry already answers the request exactly.
+func metricsCacheFetchFrom(entry *metricsCacheEntry, from, to int64, now time.Time) (fetchFrom int64, ok bool) {
+	if entry == nil || entry.interval <= 0 || now.Sub(entry.fullFetchAt) > metricsCacheMaxAge {
+		return 0, false
+	}
+	if from == entry.from && to == entry.to {
+		return to, true
+	}
+	if from < entry.from || to < entry.to || len(entry.times) < metricsCacheRefetchBuckets {
+		return 0, false
+	}
+	fetchFrom = entry.times[len(entry.times)-metricsCacheRefetchBuckets]
+	if fetchFrom <= from {
+		// Nothing cached would survive the merge
+		return 0, false
+	}
+	return fetchFrom, true
+}
+
+// newMetricsCacheEntry builds a cache entry from a full response. The interval is the
+// explicit override if set, otherwise the spacing of the returned points. It returns nil
+// when the interval cannot be determined (fewer than two points).
+func newMetricsCacheEntry(resp datadogV2.TimeseriesFormulaQueryResponse, interval *int64, from, to int64, now time.Time) *metricsCacheEntry {
+	attrs := resp.GetData().Attributes
+	if attrs == nil || resp.Errors != nil {
+		return nil
+	}
+	step := int64(0)
+	if interval != nil && *interval > 0 {
+		step = *interval
+	} else if len(attrs.Times) >= 2 {
+		step = attrs.Times[1] - attrs.Times[0]
+	}
+	if step <= 0 || len(attrs.Values) != len(attrs.Series) {
+		return nil
+	}
+	return &metricsCacheEntry{
+		from:        from,
+		to:          to,
+		interval:    step,
+		times:       attrs.Times,
+		series:      attrs.Series,
+		values:      attrs.Values,
+		fullFetchAt: now,
+	}
+}
+
+// merge returns a new entry for [from, to] made of the cached buckets before fetchFrom that
+// still overlap the range, followed by the freshly fetched tail. Series are matched by query
+// index and group tags; series that have no points left after the merge are dropped, as a
+// full fetch would not return them either.
+func (e *metricsCacheEntry) merge(tail datadogV2.TimeseriesFormulaQueryResponse, fetchFrom, from, to int64) (*metricsCacheEntry, error) {
+	attrs := tail.GetData().Attributes
+	if attrs == nil {
+		attrs = &datadogV2.TimeseriesResponseAttributes{}
+	}
+	if len(attrs.Values) != len(attrs.Series) {
+		return nil, fmt.Errorf("malformed timeseries response: %d series, %d value rows", len(attrs.Series), len(attrs.Values))
+	}
+	if len(attrs.Times) >= 2 && attrs.Times[1]-attrs.Times[0] != e.interval {
+		return nil, fmt.Errorf("timeseries interval changed from %dms to %dms", e.interval, attrs.Times[1]-attrs.Times[0])
+	}
+
+	// Cached buckets to keep
+	var keep []int
+	for i, t := range e.times {
+		if t < fetchFrom && t+e.interval > from {
+			keep = append(keep, i)
+		}
+	}
+	// Fetched buckets (defensively ignoring anything before fetchFrom)
+	var fresh []int
+	for i, t := range attrs.Times {
+		if t >= fetchFrom {
+			fresh = append(fresh, i)
+		}
+	}
+
+	merged := &metricsCacheEntry{
+		from:        from,
+		to:          to,
+		interval:    e.interval,
+		times:       make([]int64, 0, len(keep)+len(fresh)),
+		fullFetchAt: e.fullFetchAt,
+	}
+	for _, i := range keep {
+		merged.times = append(merged.times, e.times[i])
+	}
+	for _, i := range fresh {
+		merged.times = append(merged.times, attrs.Times[i])
+	}
+
+	tailRows := make(map[string]int, len(attrs.Series))
+	for i := range attrs.Series {
+		tailRows[timeseriesSeriesKey(attrs.Series[i])] = i
+	}
+
+	appendSeries := func(s datadogV2.TimeseriesResponseSeries, cachedRow []*float64, tailRow []*float64) {
+		row := make([]*float64, 0, len(merged.times))
+		for _, i := range keep {
+			row = append(row, valueAt(cachedRow, i))
+		}
+		for _, i := range fresh {
+			row = append(row, valueAt(tailRow, i))
+		}
+		for _, v := range row {
+			if v != nil {
+				merged.series = append(merged.series, s)
+				merged.values = append(merged.values, row)
+				return
+			}
+		}
+	}
+
+	seen := make(map[string]bool, len(e.series))
+	for i, s := range e.series {
+		key := timeseriesSeriesKey(s)
+		seen[key] = true
+		var tailRow []*float64
+		if j, ok := tailRows[key]; ok {
+			tailRow = attrs.Values[j]
+			// Prefer the newest metadata (e.g. unit)
+			s = attrs.Series[j]
+		}
+		appendSeries(s, e.values[i], tailRow)
+	}
+	for j, s := range attrs.Series {
+		if !seen[timeseriesSeriesKey(s)] {
+			appendSeries(s, nil, attrs.Values[j])
+		}
+	}
+
+	return merged, nil
+}
+
+// response rebuilds a Datadog timeseries response from the entry for the response parser.
+func (e *metricsCacheEntry) response() datadogV2.TimeseriesFormulaQueryResponse {
+	responseType := datadogV2.TIMESERIESFORMULARESPONSETYPE_TIMESERIES_RESPONSE
+	return datadogV2.TimeseriesFormulaQueryResponse{
+		Data: &datadogV2.TimeseriesResponse{
+			Type: &responseType,
+			Attributes: &datadogV2.TimeseriesResponseAttributes{
+				Series: e.series,
+				Times:  e.times,
+				Values: e.values,
+			},
+		},
+	}
+}
+
+// timeseriesSeriesKey identifies a series across responses by query index and group tags.
+func timeseriesSeriesKey(s datadogV2.TimeseriesResponseSeries) string {
+	tags := append([]string(nil), s.GroupTags...)
+	sort.Strings(tags)
+	return fmt.Sprintf("%d|%s", s.GetQueryIndex(), strings.Join(tags, ","))
+}
+
+func valueAt(row []*float64, i int) *float64 {
+	if i < len(row) {
+		return row[i]
+	}
+	return nil
+}
@@ -0,0 +1,302 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wasilak/grafana-datadog-datasource/pkg/plugin/fakedatadog"
+)
+
+const testMinute = int64(time.Minute / time.Millisecond)
+
+func ptrFloats(values ...float64) []*float64 {
+	out := make([]*float64, len(values))
+	for i := range values {
+		v := values[i]
+		out[i] = &v
+	}
+	return out
+}
+
+func testSeries(queryIndex int32, tags ...string) datadogV2.TimeseriesResponseSeries {
+	return datadogV2.TimeseriesResponseSeries{QueryIndex: &queryIndex, GroupTags: tags}
+}
+
+func testTimeseriesResponse(times []int64, series []datadogV2.TimeseriesResponseSeries, values ...[]*float64) datadogV2.TimeseriesFormulaQueryResponse {
+	return datadogV2.TimeseriesFormulaQueryResponse{
+		Data: &datadogV2.TimeseriesResponse{
+			Attributes: &datadogV2.TimeseriesResponseAttributes{Series: series, Times: times, Values: values},
+		},
+	}
+}
+
+// minutes returns n timestamps one minute apart starting at start.
+func minutes(start int64, n int) []int64 {
+	out := make([]int64, n)
+	for i := range out {
+		out[i] = start + int64(i)*testMinute
+	}
+	return out
+}
+
+func TestMetricsCacheKey_IgnoresWindowPositionButNotWidth(t *testing.T) {
+	query := datadogV2.TimeseriesQuery{MetricsTimeseriesQuery: datadogV2.NewMetricsTimeseriesQuery(datadogV2.METRICSDATASOURCE_METRICS, "avg:system.cpu.user{*}")}
+	attrs := func(from, to int64) datadogV2.TimeseriesFormulaRequestAttributes {
+		return datadogV2.TimeseriesFormulaRequestAttributes{From: from, To: to, Queries: []datadogV2.TimeseriesQuery{query}}
+	}
+
+	k1, err := metricsCacheKey(attrs(0, 6*60*testMinute))
+	require.NoError(t, err)
+	k2, _ := metricsCacheKey(attrs(testMinute, 6*60*testMinute+testMinute))
+	k3, _ := metricsCacheKey(attrs(0, 24*60*testMinute))
+
+	assert.Equal(t, k1, k2, "a sliding window keeps its key")
+	assert.NotEqual(t, k1, k3, "different range widths are cached separately")
+}
+
+func TestMetricsCacheFetchFrom(t *testing.T) {
+	now := time.Now()
+	entry := &metricsCacheEntry{
+		from:        0,
+		to:          5 * testMinute,
+		interval:    testMinute,
+		times:       minutes(0, 6),
+		fullFetchAt: now,
+	}
+
+	tests := []struct {
+		name      string
+		entry     *metricsCacheEntry
+		from, to  int64
+		now       time.Time
+		fetchFrom int64
+		ok        bool
+	}{
+		{name: "no entry", entry: nil, from: 0, to: 5 * testMinute, now: now},
+		{name: "exact repeat", entry: entry, from: 0, to: 5 * testMinute, now: now, fetchFrom: 5 * testMinute, ok: true},
+		{name: "window slides forward", entry: entry, from: testMinute, to: 6 * testMinute, now: now, fetchFrom: 4 * testMinute, ok: true},
+		{name: "window moves backwards", entry: entry, from: -testMinute, to: 4 * testMinute, now: now},
+		{name: "window moves past cached points", entry: entry, from: 4 * testMinute, to: 9 * testMinute, now: now},
+		{name: "entry too old", entry: entry, from: testMinute, to: 6 * testMinute, now: now.Add(metricsCacheMaxAge + time.Second)},
+		{name: "unknown interval", entry: &metricsCacheEntry{to: 5 * testMinute, fullFetchAt: now}, from: testMinute, to: 6 * testMinute, now: now},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fetchFrom, ok := metricsCacheFetchFrom(tt.entry, tt.from, tt.to, tt.now)
+			assert.Equal(t, tt.ok, ok)
+			if tt.ok {
+				assert.Equal(t, tt.fetchFrom, fetchFrom)
+			}
+		})
+	}
+}
+
+func TestNewMetricsCacheEntry_Interval(t *testing.T) {
+	resp := testTimeseriesResponse(minutes(0, 3), []datadogV2.TimeseriesResponseSeries{testSeries(0)}, ptrFloats(1, 2, 3))
+
+	entry := newMetricsCacheEntry(resp, nil, 0, 3*testMinute, time.Now())
+	require.NotNil(t, entry)
+	assert.Equal(t, testMinute, entry.interval)
+
+	override := int64(5 * testMinute)
+	entry = newMetricsCacheEntry(resp, &override, 0, 3*testMinute, time.Now())
+	require.NotNil(t, entry)
+	assert.Equal(t, override, entry.interval)
+
+	single := testTimeseriesResponse(minutes(0, 1), []datadogV2.TimeseriesResponseSeries{testSeries(0)}, ptrFloats(1))
+	assert.Nil(t, newMetricsCacheEntry(single, nil, 0, testMinute, time.Now()), "interval cannot be inferred from one point")
+
+	errMsg := "partial failure"
+	resp.Errors = &errMsg
+	assert.Nil(t, newMetricsCacheEntry(resp, nil, 0, 3*testMinute, time.Now()), "responses with errors are not cached")
+}
+
+func TestMetricsCacheEntry_Merge(t *testing.T) {
+	cached := newMetricsCacheEntry(testTimeseriesResponse(minutes(0, 6),
+		[]datadogV2.TimeseriesResponseSeries{testSeries(0, "host:a"), testSeries(0, "host:gone")},
+		ptrFloats(1, 2, 3, 4, 5, 6),
+		[]*float64{ptrFloats(9)[0], nil, nil, nil, nil, nil},
+	), nil, 0, 5*testMinute, time.Now())
+	require.NotNil(t, cached)
+
+	// Refresh one minute later: refetch the last two buckets plus the new one.
+	tail := testTimeseriesResponse(minutes(4*testMinute, 3),
+		[]datadogV2.TimeseriesResponseSeries{testSeries(0, "host:new"), testSeries(0, "host:a")},
+		ptrFloats(7, 7, 7),
+		ptrFloats(50, 60, 70),
+	)
+	merged, err := cached.merge(tail, 4*testMinute, testMinute, 6*testMinute)
+	require.NoError(t, err)
+
+	assert.Equal(t, minutes(testMinute, 6), merged.times, "buckets before the new range are dropped")
+	require.Len(t, merged.series, 2, "series without points in the new range are dropped")
+	assert.Equal(t, []string{"host:a"}, merged.series[0].GroupTags)
+	assert.Equal(t, ptrFloats(2, 3, 4, 50, 60, 70), merged.values[0], "refetched buckets replace cached ones")
+	assert.Equal(t, []string{"host:new"}, merged.series[1].GroupTags)
+	assert.Equal(t, []*float64{nil, nil, nil, ptrFloats(7)[0], ptrFloats(7)[0], ptrFloats(7)[0]}, merged.values[1])
+	assert.Equal(t, cached.fullFetchAt, merged.fullFetchAt)
+
+	// The merged entry renders like a Datadog response.
+	rendered := merged.response()
+	attrs := rendered.GetData().Attributes
+	assert.Equal(t, merged.times, attrs.Times)
+	assert.Len(t, attrs.Values, 2)
+}
+
+func TestMetricsCacheEntry_MergeRejectsIntervalChange(t *testing.T) {
+	cached := newMetricsCacheEntry(testTimeseriesResponse(minutes(0, 6),
+		[]datadogV2.TimeseriesResponseSeries{testSeries(0)}, ptrFloats(1, 2, 3, 4, 5, 6)), nil, 0, 5*testMinute, time.Now())
+	require.NotNil(t, cached)
+
+	tail := testTimeseriesResponse([]int64{4 * testMinute, 6 * testMinute},
+		[]datadogV2.TimeseriesResponseSeries{testSeries(0)}, ptrFloats(5, 6))
+	_, err := cached.merge(tail, 4*testMinute, testMinute, 6*testMinute)
+	assert.Error(t, err)
+}
+
+func TestMetricsResultCache_EvictsOldest(t *testing.T) {
+	c := newMetricsResultCache(newMemoryCache(cacheMetrics, cacheLimits{maxEntries: 2}))
+	c.set("a", &metricsCacheEntry{})
+	c.set("b", &metricsCacheEntry{})
+	c.set("a", &metricsCacheEntry{}) // refresh a
+	c.set("c", &metricsCacheEntry{})
+
+	assert.NotNil(t, c.get("a"))
+	assert.Nil(t, c.get("b"))
+	assert.NotNil(t, c.get("c"))
+
+	c.delete("a")
+	assert.Nil(t, c.get("a"))
+	assert.Equal(t, 1, c.store.Len())
+}
+
+func TestMetricsResultCache_RoundTrip(t *testing.T) {
+	c := newMetricsResultCache(newMemoryCache(cacheMetrics, cacheLimits{}))
+	entry := newMetricsCacheEntry(testTimeseriesResponse(minutes(0, 3),
+		[]datadogV2.TimeseriesResponseSeries{testSeries(0, "host:a")},
+		[]*float64{ptrFloats(1)[0], nil, ptrFloats(3)[0]},
+	), nil, 0, 3*testMinute, time.Now().UTC().Truncate(time.Second))
+	require.NotNil(t, entry)
+
+	c.set("k", entry)
+	assert.Equal(t, entry, c.get("k"), "entries come back as stored, including missing points")
+}
+
+func TestIntegration_QueryData_MetricsIncrementalRefresh(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+	base := time.Now().Truncate(time.Minute)
+	baseMs := base.UnixMilli()
+	query := func(from, to time.Time) backend.DataQuery {
+		q := dataQuery("A", map[string]interface{}{"queryText": "avg:system.cpu.user{*}"})
+		q.TimeRange = backend.TimeRange{From: from, To: to}
+		return q
+	}
+	partialBefore := testutil.ToFloat64(cacheLookupsTotal.WithLabelValues(cacheMetrics, cacheResultPartial))
+
+	srv.Enqueue(fakedatadog.EndpointTimeseriesQuery,
+		fakedatadog.JSON(fakedatadog.TimeseriesResponse(minutes(baseMs-5*testMinute, 6),
+			fakedatadog.Series{QueryIndex: 0, GroupTags: []string{"host:web-01"}, Values: fakedatadog.Points(1, 2, 3, 4, 5, 6)})),
+		fakedatadog.JSON(fakedatadog.TimeseriesResponse(minutes(baseMs-testMinute, 3),
+			fakedatadog.Series{QueryIndex: 0, GroupTags: []string{"host:web-01"}, Values: fakedatadog.Points(50, 60, 70)})),
+	)
+
+	// Initial load of a 5 minute window, then an auto-refresh one minute later.
+	_, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{query(base.Add(-5*time.Minute), base)},
+	})
+	require.NoError(t, err)
+	resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{query(base.Add(-4*time.Minute), base.Add(time.Minute))},
+	})
+	require.NoError(t, err)
+
+	reqs := srv.Requests(fakedatadog.EndpointTimeseriesQuery)
+	require.Len(t, reqs, 2)
+	var body struct {
+		Data struct {
+			Attributes struct {
+				From     int64  `json:"from"`
+				To       int64  `json:"to"`
+				Interval *int64 `json:"interval"`
+			} `json:"attributes"`
+		} `json:"data"`
+	}
+	require.NoError(t, reqs[1].DecodeBody(&body))
+	assert.Equal(t, baseMs-testMinute, body.Data.Attributes.From, "only the newest buckets are refetched")
+	assert.Equal(t, baseMs+testMinute, body.Data.Attributes.To)
+	require.NotNil(t, body.Data.Attributes.Interval)
+	assert.Equal(t, testMinute, *body.Data.Attributes.Interval, "the refetch uses the cached rollup interval")
+
+	res := resp.Responses["A"]
+	require.NoError(t, res.Error)
+	require.Len(t, res.Frames, 1)
+	frame := res.Frames[0]
+	require.Equal(t, 6, frame.Rows())
+	first, _ := frame.Fields[1].ConcreteAt(0)
+	last, _ := frame.Fields[1].ConcreteAt(5)
+	assert.Equal(t, float64(2), first)
+	assert.Equal(t, float64(70), last)
+	assert.Equal(t, partialBefore+1, testutil.ToFloat64(cacheLookupsTotal.WithLabelValues(cacheMetrics, cacheResultPartial)))
+
+	// Re-rendering the same window is served from cache.
+	_, err = d.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{query(base.Add(-4*time.Minute), base.Add(time.Minute))},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, srv.Hits(fakedatadog.EndpointTimeseriesQuery))
+}
+
+func TestIntegration_QueryData_MetricsFullRefresh(t *testing.T) {
+	base := time.Now().Truncate(time.Minute)
+	baseMs := base.UnixMilli()
+
+	for name, queries := range map[string][]map[string]interface{}{
+		"series limit": {{"queryText": "avg:system.cpu.user{*} by {host}", "limit": 5}},
+		"top function": {{"queryText": "top(avg:system.cpu.user{*} by {host}, 5, 'mean', 'desc')"}},
+		"formula": {
+			{"queryText": "avg:system.cpu.user{*}"},
+			{"type": "math", "expression": "$A * 2"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			d, srv := newFakeBackedDatasource(t)
+			request := func(from, to time.Time) *backend.QueryDataRequest {
+				req := &backend.QueryDataRequest{}
+				for i, model := range queries {
+					q := dataQuery(string(rune('A'+i)), model)
+					q.TimeRange = backend.TimeRange{From: from, To: to}
+					req.Queries = append(req.Queries, q)
+				}
+				return req
+			}
+			srv.Enqueue(fakedatadog.EndpointTimeseriesQuery,
+				fakedatadog.JSON(fakedatadog.TimeseriesResponse(minutes(baseMs-5*testMinute, 6),
+					fakedatadog.Series{QueryIndex: 0, GroupTags: []string{"host:web-01"}, Values: fakedatadog.Points(1, 2, 3, 4, 5, 6)})),
+			)
+
+			_, err := d.QueryData(context.Background(), request(base.Add(-5*time.Minute), base))
+			require.NoError(t, err)
+			_, err = d.QueryData(context.Background(), request(base.Add(-4*time.Minute), base.Add(time.Minute)))
+			require.NoError(t, err)
+
+			reqs := srv.Requests(fakedatadog.EndpointTimeseriesQuery)
+			require.Len(t, reqs, 2)
+			var body struct {
+				Data struct {
+					Attributes struct {
+						From int64 `json:"from"`
+					} `json:"attributes"`
+				} `json:"data"`
+			}
+			require.NoError(t, reqs[1].DecodeBody(&body))
+			assert.Equal(t, baseMs-4*testMinute, body.Data.Attributes.From, "the whole window is refetched")
+		})
+	}
+}
@@ -11,6 +11,9 @@ import (
 	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // MetricsHandler handles Datadog metrics queries
@@ -22,6 +25,7 @@ type MetricsHandler struct {
 	metricsQueries []datadogV2.TimeseriesQuery
 	formulas       []datadogV2.QueryFormula
 	queryModels    map[string]QueryModel
+	executed       map[string]string // refID -> query text sent to Datadog
 	hasFormulas    bool
 	from           int64
 	to             int64
@@ -43,6 +47,7 @@ func NewMetricsHandler(datasource *Datasource, queries []backend.DataQuery, ddCt
 		metricsQueries: make([]datadogV2.TimeseriesQuery, 0),
 		formulas:       make([]datadogV2.QueryFormula, 0),
 		queryModels:    make(map[string]QueryModel),
+		executed:       make(map[string]string),
 		hasFormulas:    false,
 		from:           from,
 		to:             to,
@@ -60,6 +65,15 @@ func (h *MetricsHandler) processQuery(qm *QueryModel) error {
 	if qm.Hide {
 		return nil
 	}
+	if !validNullHandling(qm.NullHandling) {
+		return fmt.Errorf("unknown nullHandling %q", qm.NullHandling)
+	}
+	if !validFormat(qm.Format) {
+		return fmt.Errorf("unknown format %q", qm.Format)
+	}
+	if err := validateSeriesLimit(qm); err != nil {
+		return err
+	}
 
 	// Find the corresponding backend query for RefID
 	var refID string
@@ -78,15 +92,15 @@ func (h *MetricsHandler) processQuery(qm *QueryModel) error {
 		return fmt.Errorf("could not find RefID for query")
 	}
 
-	h.queryModels[refID] = *qm
-
 	if qm.Type == "math" && qm.Expression != "" {
 		// This is a formula query - convert Grafana format ($A) to Datadog format (A)
 		h.hasFormulas = true
 		datadogFormula := convertGrafanaFormulaToDatadog(qm.Expression)
 		h.formulas = append(h.formulas, datadogV2.QueryFormula{
 			Formula: datadogFormula,
+			Limit:   formulaLimit(*qm),
 		})
+		h.executed[refID] = datadogFormula
 		logger.Debug("Added formula", "refID", refID, "formula", datadogFormula)
 	} else if qm.QueryText != "" {
 		// This is a regular metrics query - add it to the queries list
@@ -99,10 +113,18 @@ func (h *MetricsHandler) processQuery(qm *QueryModel) error {
 			strings.Contains(lowerQuery, " and ") ||
 			strings.Contains(lowerQuery, " not in ")
 
-		if !hasGroupByClause && !hasBooleanOperators {
+		// Policies that limit tag keys get a single series rather than one per tag set
+		if !hasGroupByClause && !hasBooleanOperators && !h.datasource.policy().restrictsTagKeys() {
 			queryText = queryText + " by {*}"
-			logger.Debug("Added 'by {*}' to query", "original", qm.QueryText, "modified", queryText)
+			logger.Debug("Added 'by {*}' to query", "original", h.datasource.logQuery(qm.QueryText), "modified", h.datasource.logQuery(queryText))
+		}
+
+		// Refuse out-of-policy metrics and tags, and add the required tags to every scope
+		scoped, err := h.datasource.policy().scopeMetricsQuery(queryText)
+		if err != nil {
+			return err
 		}
+		queryText = scoped
 
 		// Create query with name set to refID for formula referencing
 		queryName := refID
@@ -113,12 +135,23 @@ func (h *MetricsHandler) processQuery(qm *QueryModel) error {
 				Name:       &queryName,
 			},
 		})
-		logger.Debug("Added metrics query", "refID", refID, "query", queryText)
+		h.executed[refID] = queryText
+		logger.Debug("Added metrics query", "refID", refID, "query", h.datasource.logQuery(queryText))
 	}
 
+	h.queryModels[refID] = *qm
 	return nil
 }
 
+// timeseriesResult is the outcome of a timeseries query call, shared by coalesced callers.
+type timeseriesResult struct {
+	resp         datadogV2.TimeseriesFormulaQueryResponse
+	httpStatus   int
+	responseBody string
+	// cache is cacheResultHit or cacheResultPartial when the metrics cache answered
+	cache string
+}
+
 // executeQueries executes all processed metrics queries and returns the response
 // Requirements: 6.1, 6.2
 func (h *MetricsHandler) executeQueries(ctx context.Context) (*backend.QueryDataResponse, error) {
@@ -142,9 +175,12 @@ func (h *MetricsHandler) executeQueries(ctx context.Context) (*backend.QueryData
 		},
 	}
 
-	// Add formulas if we have any
+	// Add formulas if we have any; otherwise let Datadog truncate the series of queries with
+	// a limit through a formula standing for each query
 	if h.hasFormulas {
 		body.Data.Attributes.Formulas = h.formulas
+	} else {
+		body.Data.Attributes.Formulas = limitFormulas(h.queryModels)
 	}
 
 	// Set interval - use override from any query that has it, otherwise let Datadog auto-calculate
@@ -155,33 +191,36 @@ func (h *MetricsHandler) executeQueries(ctx context.Context) (*backend.QueryData
 		}
 	}
 
-	// Create context with timeout
-	queryCtx, cancel := context.WithTimeout(h.ddCtx, 30*time.Second)
-	defer cancel()
+	// h.ddCtx carries the QueryData span, so the handler span nests under it
+	spanCtx, span := startSpan(h.ddCtx, "datadog.metrics.executeQueries",
+		attrQueryType.String(string(MetricsQueryType)),
+		attrRefIDs.StringSlice(sortedRefIDs(h.queryModels)))
+	defer span.End()
 
-	// Call Datadog API
-	resp, r, err := h.metricsApi.QueryTimeseriesData(queryCtx, body)
+	// Call Datadog API, reusing cached buckets from earlier refreshes where possible
+	start := time.Now()
+	result, err := h.queryTimeseries(spanCtx, body)
+	latency := time.Since(start)
+	resp := result.resp
 	if err != nil {
-		// Log request body for debugging
-		requestBody, _ := json.MarshalIndent(body, "", "  ")
-		logger.Error("QueryTimeseriesData request body", "request", string(requestBody))
+		_ = tracing.Error(span, err)
 
 		// Log HTTP response details
-		httpStatus := 0
-		var responseBody string
-		if r != nil {
-			httpStatus = r.StatusCode
-			if r.Body != nil {
-				bodyBytes, _ := io.ReadAll(r.Body)
-				responseBody = string(bodyBytes)
-				r.Body = io.NopCloser(strings.NewReader(responseBody))
-			}
-		}
+		httpStatus := result.httpStatus
+		responseBody := result.responseBody
 
 		logger.Error("QueryTimeseriesData API call failed",
 			"error", err,
 			"httpStatus", httpStatus,
-			"responseBody", responseBody)
+			"responseBody", redactPayload([]byte(responseBody)))
+
+		// Queries refused by the request budget say so in a frame notice
+		if res, ok := limitResponse(err); ok {
+			for refID := range h.queryModels {
+				response.Responses[refID] = res
+			}
+			return response, nil
+		}
 
 		// Return error for all queries using existing error handling patterns
 		errorMsg := h.datasource.parseDatadogError(err, httpStatus, responseBody)
@@ -193,6 +232,11 @@ func (h *MetricsHandler) executeQueries(ctx context.Context) (*backend.QueryData
 
 	// Process the response and create frames for each query/formula using parser
 	parser := NewMetricsResponseParser(h.datasource)
+	autoNulls := make(map[string]bool)
+	for refID, qm := range h.queryModels {
+		autoNulls[refID] = qm.NullHandling == nullHandlingAuto
+	}
+	parser.metadata = h.datasource.metricMetadataByRefID(spanCtx, h.executed, autoNulls)
 	err = parser.ParseTimeseriesResponse(&resp, h.queryModels, response)
 	if err != nil {
 		logger.Error("Failed to parse metrics response", "error", err)
@@ -204,5 +248,145 @@ func (h *MetricsHandler) executeQueries(ctx context.Context) (*backend.QueryData
 		return response, nil
 	}
 
+	// Drop the series beyond the series cap
+	truncated := h.datasource.limits().capSeries(response)
+
+	// Record what was sent to Datadog and how it was answered, for Query Inspector
+	stats := queryStats{latency: latency, pages: 1, cached: result.cache != "", rowsName: "Series"}
+	if result.cache == cacheResultHit {
+		stats.latency, stats.pages = 0, 0
+	}
+	for refID := range h.queryModels {
+		res := response.Responses[refID]
+		if res.Error != nil {
+			continue
+		}
+		queryStats := stats
+		queryStats.rows = len(res.Frames)
+		if total, ok := truncated[refID]; ok {
+			queryStats.rows, queryStats.truncated = total, true
+		}
+		if h.queryModels[refID].Format == formatWide && len(res.Frames) > 0 {
+			res.Frames = data.Frames{wideFrame(res.Frames)}
+		}
+		res.Frames = annotateFrames(res.Frames, refID, h.executed[refID], queryStats, nil)
+		response.Responses[refID] = res
+	}
+
 	return response, nil
-}
\ No newline at end of file
+}
+
+// queryTimeseries runs a timeseries request through the metrics result cache (see
+// metrics_cache.go): an exact repeat is answered from cache, a refresh of a sliding window
+// only fetches the newest buckets from Datadog, and anything else is fetched in full.
+// Requests whose results depend on the whole window are always fetched in full.
+func (h *MetricsHandler) queryTimeseries(ctx context.Context, body datadogV2.TimeseriesFormulaQueryRequest) (*timeseriesResult, error) {
+	logger := log.New()
+	d := h.datasource
+	if d.cacheDisabled || d.metricsCache == nil {
+		return h.callTimeseries(ctx, body)
+	}
+
+	attrs := body.Data.Attributes
+	key, err := metricsCacheKey(attrs)
+	if err != nil {
+		return h.callTimeseries(ctx, body)
+	}
+
+	now := time.Now()
+	entry := d.metricsCache.get(key)
+	if fetchFrom, ok := metricsCacheFetchFrom(entry, attrs.From, attrs.To, now); ok && (fetchFrom == attrs.To || h.incrementalRefresh(attrs)) {
+		if fetchFrom == attrs.To {
+			logger.Debug("Returning cached metrics result", "refIDs", sortedRefIDs(h.queryModels))
+			d.metricsCache.store.RecordLookup(cacheResultHit)
+			setSpanCacheHit(ctx, true)
+			return &timeseriesResult{resp: entry.response(), cache: cacheResultHit}, nil
+		}
+
+		// Fetch only the newest buckets, at the cached rollup interval
+		tailBody := body
+		tailBody.Data.Attributes.From = fetchFrom
+		interval := entry.interval
+		tailBody.Data.Attributes.Interval = &interval
+		result, err := h.callTimeseries(ctx, tailBody)
+		if err != nil {
+			return result, err
+		}
+
+		merged, mergeErr := entry.merge(result.resp, fetchFrom, attrs.From, attrs.To)
+		if mergeErr == nil {
+			logger.Debug("Merged newest buckets into cached metrics result",
+				"refIDs", sortedRefIDs(h.queryModels),
+				"fetchedMs", attrs.To-fetchFrom,
+				"rangeMs", attrs.To-attrs.From)
+			d.metricsCache.store.RecordLookup(cacheResultPartial)
+			setSpanCacheHit(ctx, true)
+			trace.SpanFromContext(ctx).SetAttributes(attrCachePartial.Bool(true))
+			d.metricsCache.set(key, merged)
+			return &timeseriesResult{resp: merged.response(), cache: cacheResultPartial}, nil
+		}
+		logger.Debug("Discarding cached metrics result", "error", mergeErr)
+		d.metricsCache.delete(key)
+	}
+
+	d.metricsCache.store.RecordLookup(cacheResultMiss)
+	setSpanCacheHit(ctx, false)
+	result, err := h.callTimeseries(ctx, body)
+	if err == nil {
+		if fresh := newMetricsCacheEntry(result.resp, attrs.Interval, attrs.From, attrs.To, now); fresh != nil {
+			d.metricsCache.set(key, fresh)
+		}
+	}
+	return result, err
+}
+
+// incrementalRefresh reports whether newest buckets can be merged into a cached result of
+// the request. Series limits and top-N functions rank series over the whole window, and
+// formulas combine queries, so their buckets change when the window slides.
+func (h *MetricsHandler) incrementalRefresh(attrs datadogV2.TimeseriesFormulaRequestAttributes) bool {
+	if len(attrs.Formulas) > 0 {
+		return false
+	}
+	for _, qm := range h.queryModels {
+		if qm.Limit > 0 {
+			return false
+		}
+	}
+	for _, q := range attrs.Queries {
+		if q.MetricsTimeseriesQuery != nil && topFunctionPattern.MatchString(q.MetricsTimeseriesQuery.Query) {
+			return false
+		}
+	}
+	return true
+}
+
+// callTimeseries sends a timeseries request to Datadog. The serialized request body is the
+// key for coalescing, so panels issuing the same queries over the same range at the same
+// time share one call.
+func (h *MetricsHandler) callTimeseries(ctx context.Context, body datadogV2.TimeseriesFormulaQueryRequest) (*timeseriesResult, error) {
+	coalesceKey, err := json.Marshal(body)
+	if err != nil {
+		return &timeseriesResult{}, fmt.Errorf("failed to marshal timeseries request: %w", err)
+	}
+	v, _, err := h.datasource.coalesce(ctx, coalesceTimeseries, string(coalesceKey), func(ctx context.Context) (interface{}, error) {
+		queryCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+
+		resp, r, err := h.metricsApi.QueryTimeseriesData(queryCtx, body)
+		result := &timeseriesResult{resp: resp}
+		if err != nil && r != nil {
+			// Keep the HTTP details for error reporting; the response body can only be read once.
+			result.httpStatus = r.StatusCode
+			if r.Body != nil {
+				bodyBytes, _ := io.ReadAll(r.Body)
+				result.responseBody = string(bodyBytes)
+			}
+		}
+		return result, err
+	})
+	result, _ := v.(*timeseriesResult)
+	if result == nil {
+		result = &timeseriesResult{}
+	}
+	return result, err
+}
@@ -5,7 +5,6 @@ import (
 	"sort"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
@@ -19,6 +18,8 @@ import (
 // Requirements: 5.1, 5.2
 type MetricsResponseParser struct {
 	datasource *Datasource
+	// metadata holds the metric metadata of single-metric queries by refID (see metric_metadata.go)
+	metadata map[string]*metricMetadata
 }
 
 // NewMetricsResponseParser creates a new MetricsResponseParser instance
@@ -73,6 +74,8 @@ func (p *MetricsResponseParser) ParseTimeseriesResponse(
 
 	// Group frames by query index (which corresponds to refID)
 	framesByQuery := make(map[int]data.Frames)
+	// Null points dropped from the frames are counted by query index for a notice
+	droppedNulls := make(map[int]int)
 
 	for i := range series.Attributes.GetSeries() {
 		s := &series.Attributes.Series[i]
@@ -97,23 +100,20 @@ func (p *MetricsResponseParser) ParseTimeseriesResponse(
 			continue
 		}
 
-		// Extract timestamps and values
-		timeValues := make([]time.Time, 0)
-		numberValues := make([]float64, 0)
-
-		for j, timeVal := range times {
-			if j >= len(pointlist) {
-				break
-			}
-			point := pointlist[j]
-			if point != nil {
-				timestamp := time.UnixMilli(timeVal)
-				timeValues = append(timeValues, timestamp)
-				numberValues = append(numberValues, *point)
-			}
+		// Get the query model for this query to determine legend formatting and null handling
+		refID := queryList[queryIndex]
+		qm, exists := queryModels[refID]
+		if !exists {
+			logger.Warn("Query model not found for refID", "refID", refID)
+			continue
 		}
 
-		if len(timeValues) == 0 {
+		// Extract timestamps and values
+		mode := resolveNullHandling(qm.NullHandling, p.metadata[refID])
+		timeValues, numberValues, valid, dropped := seriesValues(times, pointlist, mode)
+		droppedNulls[queryIndex] += dropped
+
+		if valid == 0 {
 			logger.Debug("No valid time values for series", "seriesIndex", i)
 			continue
 		}
@@ -131,12 +131,9 @@ func (p *MetricsResponseParser) ParseTimeseriesResponse(
 			}
 		}
 
-		// Get the query model for this query to determine legend formatting
-		refID := queryList[queryIndex]
-		qm, exists := queryModels[refID]
-		if !exists {
-			logger.Warn("Query model not found for refID", "refID", refID)
-			continue
+		// Tags the policy does not allow are not shown
+		if p.datasource != nil {
+			labels = p.datasource.policy().allowedLabels(labels)
 		}
 
 		// Build series name using legend configuration
@@ -153,6 +150,8 @@ func (p *MetricsResponseParser) ParseTimeseriesResponse(
 		frame.Fields[1].Config = &data.FieldConfig{
 			DisplayName: seriesName, // Explicitly set display name to our formatted series name
 		}
+		// Unit, description and interval from the series and the metric metadata
+		applyFieldConfig(frame, s, p.metadata[refID], times)
 
 		// Set metadata
 		frame.Meta = &data.FrameMeta{
@@ -167,6 +166,11 @@ func (p *MetricsResponseParser) ParseTimeseriesResponse(
 	for queryIndex, frames := range framesByQuery {
 		if queryIndex < len(queryList) {
 			refID := queryList[queryIndex]
+			// Keep the top series of queries with a limit
+			frames = topSeries(frames, queryModels[refID])
+			if dropped := droppedNulls[queryIndex]; dropped > 0 {
+				frames[0].Meta.Notices = append(frames[0].Meta.Notices, droppedNullsNotice(dropped))
+			}
 			response.Responses[refID] = backend.DataResponse{
 				Frames: frames,
 			}
@@ -0,0 +1,506 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV1"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// Datadog monitor conversion. POST monitors/convert reads metric and log monitors, by ID, by
+// tag or as exported JSON, and converts each into Grafana alert rules that query this
+// datasource: the monitor query, a reduce expression over its evaluation window and a
+// threshold expression. Monitors with a warning threshold get a second rule. Monitor tags
+// become rule labels so that notification policies can route on them.
+
+// defaultRuleGroup is the rule group of converted rules when the request names none.
+const defaultRuleGroup = "datadog"
+
+// MonitorConvertRequest is the body of POST monitors/convert. Exactly one of MonitorIDs,
+// MonitorTags and Monitors says which monitors to convert.
+type MonitorConvertRequest struct {
+	MonitorIDs []int64 `json:"monitorIds,omitempty"`
+	// MonitorTags selects monitors by tag, e.g. "team:payments,env:prod"
+	MonitorTags string `json:"monitorTags,omitempty"`
+	// Monitors are monitors exported from Datadog
+	Monitors  []json.RawMessage `json:"monitors,omitempty"`
+	FolderUID string            `json:"folderUid,omitempty"`
+	RuleGroup string            `json:"ruleGroup,omitempty"`
+}
+
+// MonitorConvertResponse is the converted rules and the monitors that could not be converted.
+type MonitorConvertResponse struct {
+	Rules    []grafanaAlertRule `json:"rules"`
+	Skipped  []SkippedMonitor   `json:"skipped"`
+	Warnings []string           `json:"warnings"`
+}
+
+// SkippedMonitor is a monitor that was not converted, and why.
+type SkippedMonitor struct {
+	ID     int64  `json:"id"`
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// ddMonitor holds the parts of a Datadog monitor the conversion uses.
+type ddMonitor struct {
+	ID       int64     `json:"id"`
+	Name     string    `json:"name"`
+	Type     string    `json:"type"`
+	Query    string    `json:"query"`
+	Message  string    `json:"message"`
+	Tags     []string  `json:"tags"`
+	Priority *int64    `json:"priority"`
+	Options  ddOptions `json:"options"`
+}
+
+type ddOptions struct {
+	Thresholds      ddThresholds `json:"thresholds"`
+	NotifyNoData    bool         `json:"notify_no_data"`
+	EvaluationDelay int64        `json:"evaluation_delay"`
+}
+
+type ddThresholds struct {
+	Critical         *float64 `json:"critical"`
+	CriticalRecovery *float64 `json:"critical_recovery"`
+	Warning          *float64 `json:"warning"`
+	WarningRecovery  *float64 `json:"warning_recovery"`
+}
+
+// grafanaAlertRule is an alert rule in the format of Grafana's alert rule provisioning API.
+type grafanaAlertRule struct {
+	Title        string              `json:"title"`
+	RuleGroup    string              `json:"ruleGroup"`
+	FolderUID    string              `json:"folderUID,omitempty"`
+	Condition    string              `json:"condition"`
+	Data         []grafanaAlertQuery `json:"data"`
+	NoDataState  string              `json:"noDataState"`
+	ExecErrState string              `json:"execErrState"`
+	For          string              `json:"for"`
+	Annotations  map[string]string   `json:"annotations"`
+	Labels       map[string]string   `json:"labels"`
+	IsPaused     bool                `json:"isPaused"`
+}
+
+type grafanaAlertQuery struct {
+	RefID             string                    `json:"refId"`
+	RelativeTimeRange *grafanaRelativeTimeRange `json:"relativeTimeRange,omitempty"`
+	DatasourceUID     string                    `json:"datasourceUid"`
+	Model             interface{}               `json:"model"`
+}
+
+// grafanaRelativeTimeRange is a query time range in seconds before the evaluation.
+type grafanaRelativeTimeRange struct {
+	From int64 `json:"from"`
+	To   int64 `json:"to"`
+}
+
+// expressionDatasourceUID is the datasource of Grafana server-side expressions.
+const expressionDatasourceUID = "__expr__"
+
+// monitorQuery is a parsed monitor query.
+type monitorQuery struct {
+	model      QueryModel
+	reducer    string
+	window     time.Duration
+	comparator string
+	threshold  float64
+}
+
+// metricMonitorPattern matches metric monitor queries such as
+// "avg(last_5m):avg:system.cpu.user{env:prod} by {host} > 90".
+var metricMonitorPattern = regexp.MustCompile(`^\s*(avg|sum|min|max|last)\(last_(\d+[mhdw])\):(.+?)\s*(>=|<=|>|<|==|!=)\s*(-?[0-9.]+(?:[eE][-+]?[0-9]+)?)\s*$`)
+
+// logMonitorPattern matches log monitor queries such as
+// `logs("service:checkout status:error").index("main").rollup("count").last("5m") > 100`.
+var logMonitorPattern = regexp.MustCompile(`^\s*logs\(("(?:[^"\\]|\\.)*")\)(.*)\.last\("(\d+[mhdw])"\)\s*(>=|<=|>|<|==|!=)\s*(-?[0-9.]+(?:[eE][-+]?[0-9]+)?)\s*$`)
+
+// logMonitorMethodPattern matches the methods between logs() and last() of a log monitor.
+var logMonitorMethodPattern = regexp.MustCompile(`\.(\w+)\(([^)]*)\)`)
+
+// quotedPattern matches the quoted arguments of a log monitor method.
+var quotedPattern = regexp.MustCompile(`"(?:[^"\\]|\\.)*"`)
+
+// monitorReducers maps monitor time aggregations onto Grafana reduce expressions.
+var monitorReducers = map[string]string{
+	"avg":  "mean",
+	"sum":  "sum",
+	"min":  "min",
+	"max":  "max",
+	"last": "last",
+}
+
+// thresholdEvaluators maps monitor comparators onto Grafana threshold evaluators, and
+// recoveryEvaluators onto the evaluators that resolve an alert at the recovery threshold.
+var (
+	thresholdEvaluators = map[string]string{">": "gt", "<": "lt", ">=": "gte", "<=": "lte", "==": "eq", "!=": "ne"}
+	recoveryEvaluators  = map[string]string{">": "lte", "<": "gte", ">=": "lt", "<=": "gt"}
+)
+
+// notificationHandlePattern matches the @-handles of a monitor message, such as @slack-ops or
+// @oncall@example.com.
+var notificationHandlePattern = regexp.MustCompile(`(^|\s)@([\w.+-]+(?:@[\w.-]+)?)`)
+
+// Message template patterns: recovery sections are left out, other conditional markers are
+// dropped, {{value}} and {{tag.name}} become Grafana template data.
+var (
+	recoverySectionPattern = regexp.MustCompile(`(?s)\{\{#is_(\w*recovery)\}\}.*?\{\{/is_(\w*recovery)\}\}`)
+	conditionalPattern     = regexp.MustCompile(`\{\{[#/^]\w+(?:\s+[^}]*)?\}\}`)
+	valuePattern           = regexp.MustCompile(`\{\{\s*value\s*\}\}`)
+	tagValuePattern        = regexp.MustCompile(`\{\{\s*([\w.-]+)\.name\s*\}\}`)
+	labelNameInvalid       = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+)
+
+// parseMonitorQuery parses the query of a metric or log monitor.
+func parseMonitorQuery(m ddMonitor) (*monitorQuery, []string, error) {
+	switch m.Type {
+	case "metric alert", "query alert":
+		return parseMetricMonitorQuery(m.Query)
+	case "log alert":
+		return parseLogMonitorQuery(m.Query)
+	}
+	return nil, nil, fmt.Errorf("%s monitors are not supported", m.Type)
+}
+
+func parseMetricMonitorQuery(query string) (*monitorQuery, []string, error) {
+	match := metricMonitorPattern.FindStringSubmatch(query)
+	if match == nil {
+		return nil, nil, fmt.Errorf("query %q is not a metric threshold query", query)
+	}
+	window, err := parseLimitDuration(match[2])
+	if err != nil {
+		return nil, nil, err
+	}
+	threshold, err := strconv.ParseFloat(match[5], 64)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &monitorQuery{
+		model:      QueryModel{QueryText: strings.TrimSpace(match[3])},
+		reducer:    monitorReducers[match[1]],
+		window:     window,
+		comparator: match[4],
+		threshold:  threshold,
+	}, nil, nil
+}
+
+// parseLogMonitorQuery parses a log count monitor. The count comes from a logs-volume query,
+// summed over the evaluation window.
+func parseLogMonitorQuery(query string) (*monitorQuery, []string, error) {
+	match := logMonitorPattern.FindStringSubmatch(query)
+	if match == nil {
+		return nil, nil, fmt.Errorf("query %q is not a log count query", query)
+	}
+	search, err := strconv.Unquote(match[1])
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid logs search %s", match[1])
+	}
+	window, err := parseLimitDuration(match[3])
+	if err != nil {
+		return nil, nil, err
+	}
+	threshold, err := strconv.ParseFloat(match[5], 64)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	model := QueryModel{QueryType: "logs-volume", LogQuery: search}
+	var warnings []string
+	for _, method := range logMonitorMethodPattern.FindAllStringSubmatch(match[2], -1) {
+		args := quotedPattern.FindAllString(method[2], -1)
+		values := make([]string, 0, len(args))
+		for _, arg := range args {
+			if v, err := strconv.Unquote(arg); err == nil {
+				values = append(values, v)
+			}
+		}
+		switch method[1] {
+		case "index":
+			if len(values) != 1 || values[0] != "*" {
+				model.Indexes = values
+			}
+		case "rollup":
+			if len(values) > 0 && values[0] != "count" {
+				return nil, nil, fmt.Errorf("log monitors with a %s rollup are not supported", values[0])
+			}
+		case "by":
+			warnings = append(warnings, fmt.Sprintf("the count is not grouped by %s", strings.Join(values, ", ")))
+		default:
+			warnings = append(warnings, fmt.Sprintf("the %s() method is not converted", method[1]))
+		}
+	}
+	return &monitorQuery{
+		model:      model,
+		reducer:    "sum",
+		window:     window,
+		comparator: match[4],
+		threshold:  threshold,
+	}, warnings, nil
+}
+
+// convertMonitor converts a monitor into alert rules: one for the critical threshold and one
+// for the warning threshold when there is one. maxLogLines is the number of log lines a logs
+// query of the datasource fetches, which bounds the counts of log monitors. It returns
+// warnings about what was left out.
+func convertMonitor(m ddMonitor, datasourceUID, folderUID, ruleGroup string, maxLogLines int) ([]grafanaAlertRule, []string, error) {
+	q, warnings, err := parseMonitorQuery(m)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, ok := thresholdEvaluators[q.comparator]; !ok {
+		return nil, nil, fmt.Errorf("comparator %q is not supported", q.comparator)
+	}
+	if q.model.QueryType == "logs-volume" && q.threshold >= float64(maxLogLines) {
+		warnings = append(warnings, fmt.Sprintf("counts stop at the %d log lines one logs query fetches; raise the logs pages limit of the datasource", maxLogLines))
+	}
+
+	description, handles := convertMonitorMessage(m.Message)
+	annotations := map[string]string{
+		"summary":            m.Name,
+		"description":        description,
+		"datadog_monitor_id": strconv.FormatInt(m.ID, 10),
+	}
+	if len(handles) > 0 {
+		annotations["datadog_notify"] = strings.Join(handles, ",")
+	}
+	labels := monitorLabels(m)
+
+	noDataState := "OK"
+	if m.Options.NotifyNoData {
+		noDataState = "Alerting"
+	}
+	delay := m.Options.EvaluationDelay
+	timeRange := &grafanaRelativeTimeRange{From: int64(q.window.Seconds()) + delay, To: delay}
+
+	rule := func(title, severity string, threshold float64, recovery *float64) grafanaAlertRule {
+		condition := map[string]interface{}{
+			"evaluator": map[string]interface{}{"type": thresholdEvaluators[q.comparator], "params": []float64{threshold}},
+		}
+		if unload, ok := recoveryEvaluators[q.comparator]; ok && recovery != nil {
+			condition["unloadEvaluator"] = map[string]interface{}{"type": unload, "params": []float64{*recovery}}
+		}
+		ruleLabels := map[string]string{"severity": severity}
+		for k, v := range labels {
+			ruleLabels[k] = v
+		}
+		return grafanaAlertRule{
+			Title:     title,
+			RuleGroup: ruleGroup,
+			FolderUID: folderUID,
+			Condition: "C",
+			Data: []grafanaAlertQuery{
+				{
+					RefID:             "A",
+					RelativeTimeRange: timeRange,
+					DatasourceUID:     datasourceUID,
+					Model:             grafanaTarget{RefID: "A", Datasource: &grafanaDatasourceRef{Type: pluginID, UID: datasourceUID}, QueryModel: q.model},
+				},
+				{
+					RefID:         "B",
+					DatasourceUID: expressionDatasourceUID,
+					Model:         map[string]interface{}{"refId": "B", "type": "reduce", "expression": "A", "reducer": q.reducer},
+				},
+				{
+					RefID:         "C",
+					DatasourceUID: expressionDatasourceUID,
+					Model:         map[string]interface{}{"refId": "C", "type": "threshold", "expression": "B", "conditions": []interface{}{condition}},
+				},
+			},
+			NoDataState:  noDataState,
+			ExecErrState: "Error",
+			For:          "0s",
+			Annotations:  annotations,
+			Labels:       ruleLabels,
+		}
+	}
+
+	critical := q.threshold
+	if m.Options.Thresholds.Critical != nil {
+		critical = *m.Options.Thresholds.Critical
+	}
+	rules := []grafanaAlertRule{rule(m.Name, "critical", critical, m.Options.Thresholds.CriticalRecovery)}
+	if w := m.Options.Thresholds.Warning; w != nil {
+		rules = append(rules, rule(m.Name+" (warning)", "warning", *w, m.Options.Thresholds.WarningRecovery))
+	}
+	return rules, warnings, nil
+}
+
+// monitorLabels turns the tags and priority of a monitor into rule labels: "team:payments"
+// becomes team=payments, a tag without a value becomes <tag>=true.
+func monitorLabels(m ddMonitor) map[string]string {
+	labels := map[string]string{}
+	for _, tag := range m.Tags {
+		key, value, ok := strings.Cut(tag, ":")
+		if !ok {
+			value = "true"
+		}
+		key = labelNameInvalid.ReplaceAllString(key, "_")
+		if key == "" || key == "severity" {
+			continue
+		}
+		labels[key] = value
+	}
+	if m.Priority != nil {
+		labels["priority"] = fmt.Sprintf("P%d", *m.Priority)
+	}
+	return labels
+}
+
+// convertMonitorMessage converts a monitor message into a rule description and returns its
+// notification handles, which Grafana replaces with notification policies.
+func convertMonitorMessage(message string) (string, []string) {
+	var handles []string
+	for _, m := range notificationHandlePattern.FindAllStringSubmatch(message, -1) {
+		handles = append(handles, m[2])
+	}
+	sort.Strings(handles)
+	handles = dedupeSorted(handles)
+
+	description := notificationHandlePattern.ReplaceAllString(message, "$1")
+	description = recoverySectionPattern.ReplaceAllString(description, "")
+	description = conditionalPattern.ReplaceAllString(description, "")
+	description = valuePattern.ReplaceAllString(description, "{{ $$values.B.Value }}")
+	description = tagValuePattern.ReplaceAllString(description, "{{ $$labels.$1 }}")
+	return strings.TrimSpace(description), handles
+}
+
+// MonitorConvertHandler converts Datadog monitors into Grafana alert rules.
+func (d *Datasource) MonitorConvertHandler(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	logger := log.New()
+	if ok, err := requireEditor(req, sender); !ok {
+		return err
+	}
+	var convertReq MonitorConvertRequest
+	err := json.Unmarshal(req.Body, &convertReq)
+	sources := 0
+	for _, set := range []bool{len(convertReq.MonitorIDs) > 0, convertReq.MonitorTags != "", len(convertReq.Monitors) > 0} {
+		if set {
+			sources++
+		}
+	}
+	if err != nil || sources != 1 {
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusBadRequest,
+			Body:   []byte(`{"error": "send one of monitorIds, monitorTags or monitors"}`),
+		})
+	}
+	ruleGroup := convertReq.RuleGroup
+	if ruleGroup == "" {
+		ruleGroup = defaultRuleGroup
+	}
+
+	// The key reads every monitor of the organization, which a policy does not scope
+	raws := convertReq.Monitors
+	if len(raws) == 0 && d.policy().restricts() {
+		return sendPolicyViolation(sender, violationf("fetching Datadog monitors; convert exported monitors instead"))
+	}
+	if len(raws) == 0 {
+		fetched, status, err := d.fetchMonitors(ctx, convertReq.MonitorIDs, convertReq.MonitorTags)
+		if err != nil {
+			logger.Warn("Failed to fetch Datadog monitors", "error", err)
+			return sender.Send(&backend.CallResourceResponse{
+				Status: status,
+				Body:   []byte(fmt.Sprintf(`{"error": %q}`, err.Error())),
+			})
+		}
+		raws = fetched
+	}
+
+	resp := MonitorConvertResponse{Rules: []grafanaAlertRule{}, Skipped: []SkippedMonitor{}, Warnings: []string{}}
+	for _, raw := range raws {
+		var m ddMonitor
+		if err := json.Unmarshal(raw, &m); err != nil {
+			resp.Skipped = append(resp.Skipped, SkippedMonitor{Reason: "invalid monitor: " + err.Error()})
+			continue
+		}
+		rules, warnings, err := convertMonitor(m, d.InstanceSettings.UID, convertReq.FolderUID, ruleGroup,
+			d.limits().maxLogsPages()*logsMaxPageSize)
+		if err != nil {
+			resp.Skipped = append(resp.Skipped, SkippedMonitor{ID: m.ID, Name: m.Name, Reason: err.Error()})
+			continue
+		}
+		resp.Rules = append(resp.Rules, rules...)
+		for _, w := range warnings {
+			resp.Warnings = append(resp.Warnings, fmt.Sprintf("monitor %d (%s): %s", m.ID, m.Name, w))
+		}
+	}
+	logger.Info("Datadog monitors converted", "monitors", len(raws), "rules", len(resp.Rules), "skipped", len(resp.Skipped))
+	return sendJSON(sender, resp)
+}
+
+// fetchMonitors fetches monitors from Datadog by ID, or all monitors with the given tags. On
+// failure it returns the status to answer with: 404 for unknown monitors, 502 for other
+// Datadog errors.
+func (d *Datasource) fetchMonitors(ctx context.Context, ids []int64, tags string) ([]json.RawMessage, int, error) {
+	client, err := d.GetAPIClient()
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	ddCtx, err := d.GetDatadogContext(ctx)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	ddCtx, cancel := context.WithTimeout(ddCtx, 60*time.Second)
+	defer cancel()
+	api := datadogV1.NewMonitorsApi(client)
+
+	var monitors []datadogV1.Monitor
+	if len(ids) == 0 {
+		listed, r, err := api.ListMonitors(ddCtx, *datadogV1.NewListMonitorsOptionalParameters().WithMonitorTags(tags))
+		if err != nil {
+			return nil, monitorErrorStatus(r), errors.New(d.monitorError(err, r))
+		}
+		monitors = listed
+	}
+	for _, id := range ids {
+		monitor, r, err := api.GetMonitor(ddCtx, id)
+		if err != nil {
+			if r != nil && r.StatusCode == http.StatusNotFound {
+				return nil, http.StatusNotFound, fmt.Errorf("Datadog monitor %d not found", id)
+			}
+			return nil, monitorErrorStatus(r), errors.New(d.monitorError(err, r))
+		}
+		monitors = append(monitors, monitor)
+	}
+
+	raws := make([]json.RawMessage, 0, len(monitors))
+	for _, monitor := range monitors {
+		raw, err := json.Marshal(monitor)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		raws = append(raws, raw)
+	}
+	return raws, http.StatusOK, nil
+}
+
+func monitorErrorStatus(r *http.Response) int {
+	if r != nil && r.StatusCode == http.StatusNotFound {
+		return http.StatusNotFound
+	}
+	return http.StatusBadGateway
+}
+
+// monitorError returns the message of a failed monitors API call.
+func (d *Datasource) monitorError(err error, r *http.Response) string {
+	httpStatus, responseBody := 0, ""
+	if r != nil {
+		httpStatus = r.StatusCode
+		if r.Body != nil {
+			bodyBytes, _ := io.ReadAll(r.Body)
+			responseBody = string(bodyBytes)
+		}
+	}
+	return d.parseDatadogError(err, httpStatus, responseBody)
+}
@@ -0,0 +1,186 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wasilak/grafana-datadog-datasource/pkg/plugin/fakedatadog"
+)
+
+// cpuMonitor is a metric monitor with warning and recovery thresholds.
+const cpuMonitor = `{
+  "id": 101,
+  "name": "High CPU on {{host.name}}",
+  "type": "metric alert",
+  "query": "avg(last_5m):avg:system.cpu.user{env:prod} by {host} > 90",
+  "message": "CPU is at {{value}} on {{host.name}}.\n{{#is_alert}}Page the on-call.{{/is_alert}}\n{{#is_recovery}}Recovered.{{/is_recovery}}\n@slack-ops @pagerduty-Checkout",
+  "tags": ["team:payments", "env:prod", "critical-path"],
+  "priority": 2,
+  "options": {
+    "thresholds": {"critical": 90, "critical_recovery": 80, "warning": 75},
+    "notify_no_data": true,
+    "evaluation_delay": 60
+  }
+}`
+
+// errorLogsMonitor is a log count monitor.
+const errorLogsMonitor = `{
+  "id": 202,
+  "name": "Checkout errors",
+  "type": "log alert",
+  "query": "logs(\"service:checkout status:error\").index(\"main\").rollup(\"count\").by(\"host\").last(\"15m\") > 100",
+  "message": "Too many errors @oncall@example.com",
+  "tags": [],
+  "options": {"thresholds": {"critical": 100}}
+}`
+
+func TestParseMonitorQuery(t *testing.T) {
+	q, warnings, err := parseMonitorQuery(ddMonitor{Type: "query alert", Query: "max(last_1h):sum:trace.http.request.errors{service:web} / sum:trace.http.request.hits{service:web} >= 0.05"})
+	require.NoError(t, err)
+	assert.Empty(t, warnings)
+	assert.Equal(t, "sum:trace.http.request.errors{service:web} / sum:trace.http.request.hits{service:web}", q.model.QueryText)
+	assert.Equal(t, "max", q.reducer)
+	assert.Equal(t, time.Hour, q.window)
+	assert.Equal(t, ">=", q.comparator)
+	assert.Equal(t, 0.05, q.threshold)
+
+	q, warnings, err = parseMonitorQuery(ddMonitor{Type: "log alert", Query: `logs("service:checkout \"payment failed\"").index("*").rollup("count").last("1d") < 1`})
+	require.NoError(t, err)
+	assert.Empty(t, warnings)
+	assert.Equal(t, QueryModel{QueryType: "logs-volume", LogQuery: `service:checkout "payment failed"`}, q.model)
+	assert.Equal(t, "sum", q.reducer)
+	assert.Equal(t, 24*time.Hour, q.window)
+
+	_, _, err = parseMonitorQuery(ddMonitor{Type: "log alert", Query: `logs("*").rollup("avg", "@duration").last("5m") > 1`})
+	assert.EqualError(t, err, "log monitors with a avg rollup are not supported")
+	_, _, err = parseMonitorQuery(ddMonitor{Type: "query alert", Query: "change(avg(last_5m),last_5m):avg:system.load.1{*} > 2"})
+	assert.Error(t, err)
+	_, _, err = parseMonitorQuery(ddMonitor{Type: "composite", Query: "101 && 202"})
+	assert.EqualError(t, err, "composite monitors are not supported")
+}
+
+func TestConvertMonitorMessage(t *testing.T) {
+	var m ddMonitor
+	require.NoError(t, json.Unmarshal([]byte(cpuMonitor), &m))
+	description, handles := convertMonitorMessage(m.Message)
+	assert.Equal(t, "CPU is at {{ $values.B.Value }} on {{ $labels.host }}.\nPage the on-call.", description)
+	assert.Equal(t, []string{"pagerduty-Checkout", "slack-ops"}, handles)
+}
+
+func TestConvertMonitor(t *testing.T) {
+	var m ddMonitor
+	require.NoError(t, json.Unmarshal([]byte(cpuMonitor), &m))
+	rules, warnings, err := convertMonitor(m, "ds-uid", "folder", "payments", 1000)
+	require.NoError(t, err)
+	assert.Empty(t, warnings)
+	require.Len(t, rules, 2)
+
+	critical := rules[0]
+	assert.Equal(t, "High CPU on {{host.name}}", critical.Title)
+	assert.Equal(t, "payments", critical.RuleGroup)
+	assert.Equal(t, "folder", critical.FolderUID)
+	assert.Equal(t, "C", critical.Condition)
+	assert.Equal(t, "Alerting", critical.NoDataState)
+	assert.Equal(t, map[string]string{
+		"severity": "critical", "team": "payments", "env": "prod", "critical_path": "true", "priority": "P2",
+	}, critical.Labels)
+	assert.Equal(t, "101", critical.Annotations["datadog_monitor_id"])
+	assert.Equal(t, "pagerduty-Checkout,slack-ops", critical.Annotations["datadog_notify"])
+
+	require.Len(t, critical.Data, 3)
+	query := critical.Data[0]
+	assert.Equal(t, &grafanaRelativeTimeRange{From: 360, To: 60}, query.RelativeTimeRange, "the evaluation delay shifts the window")
+	assert.Equal(t, "ds-uid", query.DatasourceUID)
+	assert.Equal(t, "avg:system.cpu.user{env:prod} by {host}", query.Model.(grafanaTarget).QueryText)
+	assert.Equal(t, map[string]interface{}{"refId": "B", "type": "reduce", "expression": "A", "reducer": "mean"}, critical.Data[1].Model)
+	assert.Equal(t, expressionDatasourceUID, critical.Data[2].DatasourceUID)
+	assert.Equal(t, []interface{}{map[string]interface{}{
+		"evaluator":       map[string]interface{}{"type": "gt", "params": []float64{90}},
+		"unloadEvaluator": map[string]interface{}{"type": "lte", "params": []float64{80}},
+	}}, critical.Data[2].Model.(map[string]interface{})["conditions"])
+
+	warning := rules[1]
+	assert.Equal(t, "High CPU on {{host.name}} (warning)", warning.Title)
+	assert.Equal(t, "warning", warning.Labels["severity"])
+	assert.Equal(t, []interface{}{map[string]interface{}{
+		"evaluator": map[string]interface{}{"type": "gt", "params": []float64{75}},
+	}}, warning.Data[2].Model.(map[string]interface{})["conditions"])
+
+	var logsMonitor ddMonitor
+	require.NoError(t, json.Unmarshal([]byte(errorLogsMonitor), &logsMonitor))
+	rules, warnings, err = convertMonitor(logsMonitor, "ds-uid", "", defaultRuleGroup, 100)
+	require.NoError(t, err)
+	require.Len(t, rules, 1)
+	assert.Equal(t, "OK", rules[0].NoDataState)
+	assert.Equal(t, QueryModel{QueryType: "logs-volume", LogQuery: "service:checkout status:error", Indexes: []string{"main"}},
+		rules[0].Data[0].Model.(grafanaTarget).QueryModel)
+	assert.Equal(t, []string{
+		"the count is not grouped by host",
+		"counts stop at the 100 log lines one logs query fetches; raise the logs pages limit of the datasource",
+	}, warnings)
+}
+
+func TestIntegration_MonitorConvert(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+
+	srv.Enqueue(fakedatadog.EndpointMonitor, fakedatadog.JSON(json.RawMessage(cpuMonitor)))
+	srv.Enqueue(fakedatadog.EndpointMonitor, fakedatadog.JSON(json.RawMessage(errorLogsMonitor)))
+	resp := callResourceAsEditor(t, d, http.MethodPost, "monitors/convert", []byte(`{"monitorIds": [101, 202], "folderUid": "datadog"}`))
+	require.Equal(t, http.StatusOK, resp.Status, string(resp.Body))
+	reqs := srv.Requests(fakedatadog.EndpointMonitor)
+	require.Len(t, reqs, 2)
+	assert.Equal(t, "/api/v1/monitor/101", reqs[0].Path)
+
+	var converted MonitorConvertResponse
+	require.NoError(t, json.Unmarshal(resp.Body, &converted))
+	require.Len(t, converted.Rules, 3)
+	assert.Equal(t, "datadog", converted.Rules[2].FolderUID)
+	assert.Equal(t, "Checkout errors", converted.Rules[2].Title)
+	assert.Equal(t, []string{"monitor 202 (Checkout errors): the count is not grouped by host"}, converted.Warnings)
+	assert.Empty(t, converted.Skipped)
+
+	srv.Enqueue(fakedatadog.EndpointMonitorsList, fakedatadog.JSON([]json.RawMessage{
+		json.RawMessage(cpuMonitor),
+		json.RawMessage(`{"id": 303, "name": "Checkout composite", "type": "composite", "query": "101 && 202"}`),
+	}))
+	resp = callResourceAsEditor(t, d, http.MethodPost, "monitors/convert", []byte(`{"monitorTags": "team:payments"}`))
+	require.Equal(t, http.StatusOK, resp.Status, string(resp.Body))
+	assert.Equal(t, "team:payments", srv.Requests(fakedatadog.EndpointMonitorsList)[0].Query.Get("monitor_tags"))
+	require.NoError(t, json.Unmarshal(resp.Body, &converted))
+	assert.Len(t, converted.Rules, 2)
+	assert.Equal(t, []SkippedMonitor{{ID: 303, Name: "Checkout composite", Reason: "composite monitors are not supported"}}, converted.Skipped)
+
+	resp = callResourceAsEditor(t, d, http.MethodPost, "monitors/convert", []byte(`{"monitors": [`+cpuMonitor+`]}`))
+	require.Equal(t, http.StatusOK, resp.Status, string(resp.Body))
+	require.NoError(t, json.Unmarshal(resp.Body, &converted))
+	assert.Equal(t, defaultRuleGroup, converted.Rules[0].RuleGroup)
+
+	resp = callResourceAsEditor(t, d, http.MethodPost, "monitors/convert", []byte(`{"monitorIds": [404]}`))
+	assert.Equal(t, http.StatusNotFound, resp.Status)
+	resp = callResourceAsEditor(t, d, http.MethodPost, "monitors/convert", []byte(`{"monitorIds": [1], "monitorTags": "team:payments"}`))
+	assert.Equal(t, http.StatusBadRequest, resp.Status)
+}
+
+func TestIntegration_MonitorConvert_Access(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+	for _, role := range []string{"", "Viewer"} {
+		resp := callCacheResource(t, d, role, http.MethodPost, "monitors/convert", "monitors/convert", []byte(`{"monitorTags": ""}`))
+		assert.Equal(t, http.StatusForbidden, resp.Status, role)
+	}
+	assert.Zero(t, srv.Hits(fakedatadog.EndpointMonitorsList))
+
+	// With a policy, monitors are not fetched
+	d, srv = newFakeBackedDatasourceWithPolicy(t)
+	for _, body := range []string{`{"monitorIds": [101]}`, `{"monitorTags": "team:payments"}`} {
+		resp := callResourceAsEditor(t, d, http.MethodPost, "monitors/convert", []byte(body))
+		assert.Equal(t, http.StatusForbidden, resp.Status, body)
+	}
+	assert.Zero(t, srv.Hits(fakedatadog.EndpointMonitor)+srv.Hits(fakedatadog.EndpointMonitorsList))
+	resp := callResourceAsEditor(t, d, http.MethodPost, "monitors/convert", []byte(`{"monitors": [`+cpuMonitor+`]}`))
+	assert.Equal(t, http.StatusOK, resp.Status, string(resp.Body))
+}
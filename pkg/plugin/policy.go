@@ -0,0 +1,496 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// Governance policy. A datasource can be restricted to a subset of what its keys may read, so
+// that sharing it with a team does not share the whole Datadog organization:
+//
+//   - metric queries may only name metrics under the allowed prefixes and filter or group by
+//     the allowed tag keys; the required tags are added to every metric scope;
+//   - logs queries may only search the allowed indexes; the required logs query is ANDed to
+//     every search, including the ones made for autocompletion;
+//   - autocomplete and variable results are filtered with the same rules.
+//
+// The policy is enforced here, in the backend, whatever the query editor sends.
+
+// PolicyOptions restricts what the datasource may be used for. Empty fields do not restrict.
+type PolicyOptions struct {
+	// AllowedMetricPrefixes lists the metric name prefixes queries may use, e.g. "payments.".
+	AllowedMetricPrefixes []string `json:"allowedMetricPrefixes,omitempty"`
+	// AllowedTagKeys lists the tag keys metric queries may filter and group by. The keys of
+	// RequiredMetricTags are always allowed.
+	AllowedTagKeys []string `json:"allowedTagKeys,omitempty"`
+	// RequiredMetricTags are key:value tags added to the scope of every metric, e.g. "env:prod".
+	RequiredMetricTags []string `json:"requiredMetricTags,omitempty"`
+	// AllowedLogIndexes lists the log indexes searches may use. Searches that do not name
+	// indexes are limited to these.
+	AllowedLogIndexes []string `json:"allowedLogIndexes,omitempty"`
+	// RequiredLogQuery is a logs search ANDed to every logs query, e.g. "team:payments".
+	RequiredLogQuery string `json:"requiredLogQuery,omitempty"`
+}
+
+var (
+	// metricScopePattern matches a metric and its scope, "system.cpu.user{env:prod}", and
+	// groupings, "by {host}".
+	metricScopePattern = regexp.MustCompile(`([A-Za-z][\w.]*)\s*\{([^{}]*)\}`)
+	// scopeInListPattern matches the value list of "key IN (...)" filters.
+	scopeInListPattern = regexp.MustCompile(`(?i)\bIN\s*\([^()]*\)`)
+	// scopeTermSeparators splits a metric scope into its filters.
+	scopeTermSeparators = regexp.MustCompile(`[\s,()]+`)
+	// scopeBooleanPattern matches the boolean operators of a metric scope.
+	scopeBooleanPattern = regexp.MustCompile(`(?i)\b(AND|OR|NOT|IN)\b`)
+	// unscopedMetricPattern matches a dotted metric name left once scoped metrics are removed.
+	unscopedMetricPattern = regexp.MustCompile(`[A-Za-z_]\w*(?:\.\w+)+`)
+	// requiredTagPattern is the format of a required metric tag.
+	requiredTagPattern = regexp.MustCompile(`^[A-Za-z][\w.\-/]*:[^\s,{}()]+$`)
+)
+
+// policyViolation is returned for queries and resource requests the policy does not allow.
+type policyViolation struct {
+	reason string
+}
+
+func (e *policyViolation) Error() string {
+	return "not allowed by the datasource policy: " + e.reason
+}
+
+func violationf(format string, args ...interface{}) error {
+	return &policyViolation{reason: fmt.Sprintf(format, args...)}
+}
+
+// policy returns the governance policy of the datasource, nil when none is configured.
+func (d *Datasource) policy() *PolicyOptions {
+	if d.JSONData == nil {
+		return nil
+	}
+	return d.JSONData.Policy
+}
+
+// validate reports configuration errors. An invalid policy refuses every query it applies to.
+func (p *PolicyOptions) validate() error {
+	if p == nil {
+		return nil
+	}
+	for _, tag := range p.RequiredMetricTags {
+		if !requiredTagPattern.MatchString(tag) {
+			return fmt.Errorf("required metric tag %q is not of the form key:value", tag)
+		}
+	}
+	if !balancedQuery(p.RequiredLogQuery) {
+		return fmt.Errorf("required logs query has unbalanced parentheses or quotes")
+	}
+	return nil
+}
+
+// restrictsMetrics reports whether metric queries and lookups are restricted.
+func (p *PolicyOptions) restrictsMetrics() bool {
+	return p != nil && (len(p.AllowedMetricPrefixes) > 0 || len(p.AllowedTagKeys) > 0 || len(p.RequiredMetricTags) > 0)
+}
+
+// restrictsTagKeys reports whether metric queries may only filter and group by some tag keys.
+func (p *PolicyOptions) restrictsTagKeys() bool {
+	return p != nil && len(p.AllowedTagKeys) > 0
+}
+
+// restricts reports whether the policy restricts anything at all.
+func (p *PolicyOptions) restricts() bool {
+	return p.restrictsMetrics() || (p != nil && (len(p.AllowedLogIndexes) > 0 || p.RequiredLogQuery != ""))
+}
+
+// metricAllowed reports whether name is under one of the allowed prefixes.
+func (p *PolicyOptions) metricAllowed(name string) bool {
+	if p == nil || len(p.AllowedMetricPrefixes) == 0 {
+		return true
+	}
+	for _, prefix := range p.AllowedMetricPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// tagKeyAllowed reports whether metric queries may filter and group by key.
+func (p *PolicyOptions) tagKeyAllowed(key string) bool {
+	if p == nil || len(p.AllowedTagKeys) == 0 {
+		return true
+	}
+	for _, allowed := range p.AllowedTagKeys {
+		if strings.EqualFold(allowed, key) {
+			return true
+		}
+	}
+	_, required := p.requiredValues(key)
+	return required
+}
+
+// requiredValues returns the values required for tag key, and whether key is required at all.
+func (p *PolicyOptions) requiredValues(key string) ([]string, bool) {
+	if p == nil {
+		return nil, false
+	}
+	var values []string
+	for _, tag := range p.RequiredMetricTags {
+		k, v, _ := strings.Cut(tag, ":")
+		if strings.EqualFold(k, key) {
+			values = append(values, v)
+		}
+	}
+	return values, len(values) > 0
+}
+
+// tagValueAllowed reports whether value of tag key may be offered: when the key is required,
+// only its required values are.
+func (p *PolicyOptions) tagValueAllowed(key, value string) bool {
+	values, required := p.requiredValues(key)
+	if !required {
+		return true
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// scopeMetricsQuery checks the metrics and tag keys of a metric query and adds the required
+// tags to the scope of each metric.
+func (p *PolicyOptions) scopeMetricsQuery(query string) (string, error) {
+	if !p.restrictsMetrics() {
+		return query, nil
+	}
+	if err := p.validate(); err != nil {
+		return "", violationf("%v", err)
+	}
+
+	var b strings.Builder
+	last, metrics := 0, 0
+	for _, m := range metricScopePattern.FindAllStringSubmatchIndex(query, -1) {
+		name, scope := query[m[2]:m[3]], query[m[4]:m[5]]
+		b.WriteString(query[last:m[4]])
+		last = m[5]
+
+		if strings.EqualFold(name, "by") {
+			for _, key := range strings.Split(scope, ",") {
+				key = strings.TrimSpace(key)
+				// Grouping by every tag would label series with the tags that are not allowed
+				if key == "*" && p.restrictsTagKeys() {
+					return "", violationf("grouping by all tags; group by the allowed tag keys instead")
+				}
+				if key != "*" && key != "" && !p.tagKeyAllowed(key) {
+					return "", violationf("grouping by tag %q", key)
+				}
+			}
+			b.WriteString(scope)
+			continue
+		}
+
+		metrics++
+		if !p.metricAllowed(name) {
+			return "", violationf("metric %q", name)
+		}
+		if !balancedQuery(scope) {
+			return "", violationf("scope of metric %q has unbalanced parentheses", name)
+		}
+		for _, key := range scopeTagKeys(scope) {
+			if !p.tagKeyAllowed(key) {
+				return "", violationf("filtering by tag %q", key)
+			}
+		}
+		b.WriteString(p.scopeWithRequiredTags(scope))
+	}
+	b.WriteString(query[last:])
+
+	if metrics == 0 {
+		return "", violationf("the query must name a metric with a {scope}")
+	}
+	if name := unscopedMetricPattern.FindString(metricScopePattern.ReplaceAllString(query, "")); name != "" {
+		return "", violationf("metric %q has no {scope}", name)
+	}
+	return b.String(), nil
+}
+
+// scopeTagKeys returns the tag keys a metric scope filters by: the key of "key:value",
+// "!key:value" and "key IN (...)" filters, and bare tags such as "production", which are a
+// key of their own.
+func scopeTagKeys(scope string) []string {
+	var keys []string
+	for _, term := range scopeTermSeparators.Split(scopeInListPattern.ReplaceAllString(scope, " "), -1) {
+		term = strings.TrimLeft(term, "!-")
+		switch strings.ToUpper(term) {
+		case "", "*", "AND", "OR", "NOT", "IN":
+			continue
+		}
+		key, _, _ := strings.Cut(term, ":")
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// allowedLabels drops the labels of a series whose tag keys metric queries may not use.
+func (p *PolicyOptions) allowedLabels(labels map[string]string) map[string]string {
+	if !p.restrictsTagKeys() {
+		return labels
+	}
+	for key := range labels {
+		if !p.tagKeyAllowed(key) {
+			delete(labels, key)
+		}
+	}
+	return labels
+}
+
+// scopeWithRequiredTags returns scope restricted to the required metric tags.
+func (p *PolicyOptions) scopeWithRequiredTags(scope string) string {
+	if len(p.RequiredMetricTags) == 0 {
+		return scope
+	}
+	trimmed := strings.TrimSpace(scope)
+	switch {
+	case trimmed == "" || trimmed == "*":
+		return strings.Join(p.RequiredMetricTags, ",")
+	case scopeBooleanPattern.MatchString(trimmed):
+		return "(" + trimmed + ") AND " + strings.Join(p.RequiredMetricTags, " AND ")
+	default:
+		return trimmed + "," + strings.Join(p.RequiredMetricTags, ",")
+	}
+}
+
+// scopeLogsQuery ANDs the required logs query to query.
+func (p *PolicyOptions) scopeLogsQuery(query string) (string, error) {
+	if p == nil || strings.TrimSpace(p.RequiredLogQuery) == "" {
+		return query, nil
+	}
+	if err := p.validate(); err != nil {
+		return "", violationf("%v", err)
+	}
+	if !balancedQuery(query) {
+		return "", violationf("the logs query has unbalanced parentheses or quotes")
+	}
+	required := strings.TrimSpace(p.RequiredLogQuery)
+	trimmed := strings.TrimSpace(query)
+	if trimmed == "" || trimmed == "*" {
+		return required, nil
+	}
+	return "(" + trimmed + ") AND (" + required + ")", nil
+}
+
+// logIndexes returns the log indexes a search naming requested may use, nil when searches
+// are not restricted.
+func (p *PolicyOptions) logIndexes(requested []string) ([]string, error) {
+	if p == nil || len(p.AllowedLogIndexes) == 0 {
+		return nil, nil
+	}
+	if len(requested) == 0 {
+		return append([]string(nil), p.AllowedLogIndexes...), nil
+	}
+	for _, index := range requested {
+		allowed := false
+		for _, a := range p.AllowedLogIndexes {
+			if a == index {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return nil, violationf("log index %q", index)
+		}
+	}
+	return requested, nil
+}
+
+// restrictLogsFilter applies the policy to the "filter" object of a logs search made by the
+// datasource itself.
+func (p *PolicyOptions) restrictLogsFilter(filter map[string]interface{}) error {
+	query, _ := filter["query"].(string)
+	scoped, err := p.scopeLogsQuery(query)
+	if err != nil {
+		return err
+	}
+	filter["query"] = scoped
+	indexes, err := p.logIndexes(nil)
+	if err != nil {
+		return err
+	}
+	if len(indexes) > 0 {
+		filter["indexes"] = indexes
+	}
+	return nil
+}
+
+// balancedQuery reports whether the parentheses and double quotes of a query are balanced,
+// so that wrapping it in parentheses cannot change what the rest of a scope applies to.
+func balancedQuery(query string) bool {
+	depth := 0
+	inQuote := false
+	for i := 0; i < len(query); i++ {
+		switch c := query[i]; {
+		case c == '\\':
+			if i == len(query)-1 {
+				return false
+			}
+			i++
+		case c == '"':
+			inQuote = !inQuote
+		case inQuote:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth < 0 {
+				return false
+			}
+		}
+	}
+	return depth == 0 && !inQuote
+}
+
+// resourcePolicy checks a resource request against the policy and returns the filter to
+// apply to the values it returns, nil for routes that are not filtered.
+func (d *Datasource) resourcePolicy(route string, req *backend.CallResourceRequest) (keep func(string) bool, err error) {
+	p := d.policy()
+	if !p.restrictsMetrics() {
+		return nil, nil
+	}
+	switch route {
+	case "autocomplete/metrics", "metrics":
+		return p.metricAllowed, nil
+	case "autocomplete/tags/{metric}":
+		return p.tagKeyAllowed, p.checkLookup(strings.TrimPrefix(req.Path, "autocomplete/tags/"), "")
+	case "autocomplete/tag-values/{metric}/{tagKey}":
+		parts := strings.Split(strings.TrimPrefix(req.Path, "autocomplete/tag-values/"), "/")
+		if len(parts) < 2 {
+			return nil, nil
+		}
+		return p.tagValueFilter(parts[1]), p.checkLookup(parts[0], parts[1])
+	case "tag-keys":
+		var body TagKeysRequest
+		_ = json.Unmarshal(req.Body, &body)
+		return p.tagKeyAllowed, p.checkLookup(body.MetricName, "")
+	case "tag-values":
+		var body TagValuesRequest
+		_ = json.Unmarshal(req.Body, &body)
+		return p.tagValueFilter(body.TagKey), p.checkLookup(body.MetricName, body.TagKey)
+	case "all-tags":
+		var body AllTagsRequest
+		_ = json.Unmarshal(req.Body, &body)
+		if body.QueryType == "tag_values" {
+			return p.tagValueFilter(body.TagKey), p.checkLookup("", body.TagKey)
+		}
+		return p.tagKeyAllowed, nil
+	}
+	return nil, nil
+}
+
+// checkTargets drops the imported queries the policy does not allow, with a warning for each.
+// Queries are checked again when they run; this tells early what will not work.
+func (p *PolicyOptions) checkTargets(where string, targets []grafanaTarget) ([]grafanaTarget, []string) {
+	if !p.restricts() {
+		return targets, nil
+	}
+	var warnings []string
+	kept := targets[:0]
+	for _, t := range targets {
+		var err error
+		switch {
+		case t.QueryType == "logs":
+			if _, err = p.scopeLogsQuery(t.LogQuery); err == nil {
+				_, err = p.logIndexes(t.Indexes)
+			}
+		case t.QueryType == "service-map" || t.QueryType == "rum":
+			err = violationf("%s queries", t.QueryType)
+		case t.QueryText != "":
+			_, err = p.scopeMetricsQuery(t.QueryText)
+		}
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: query %s left out: %v", where, t.RefID, err))
+			continue
+		}
+		kept = append(kept, t)
+	}
+	return kept, warnings
+}
+
+// checkLookup refuses lookups of a metric or tag key the policy does not allow. Patterns and
+// wildcards are accepted; their results are filtered instead.
+func (p *PolicyOptions) checkLookup(metric, tagKey string) error {
+	if metric != "" && !strings.ContainsAny(metric, "*/") && !p.metricAllowed(metric) {
+		return violationf("metric %q", metric)
+	}
+	if tagKey != "" && tagKey != "*" && !p.tagKeyAllowed(tagKey) {
+		return violationf("tag %q", tagKey)
+	}
+	return nil
+}
+
+func (p *PolicyOptions) tagValueFilter(key string) func(string) bool {
+	return func(value string) bool {
+		return p.tagValueAllowed(key, value)
+	}
+}
+
+// policySender filters the values of successful resource responses. Responses are copied:
+// coalesced requests share them.
+type policySender struct {
+	next backend.CallResourceResponseSender
+	keep func(string) bool
+}
+
+// Send implements backend.CallResourceResponseSender.
+func (s policySender) Send(resp *backend.CallResourceResponse) error {
+	if resp.Status != http.StatusOK {
+		return s.next.Send(resp)
+	}
+	filtered := *resp
+	filtered.Body = filterResourceValues(resp.Body, s.keep)
+	return s.next.Send(&filtered)
+}
+
+// filterResourceValues filters a list of strings or a VariableResponse.
+func filterResourceValues(body []byte, keep func(string) bool) []byte {
+	filter := func(values []string) []string {
+		if values == nil {
+			return nil
+		}
+		kept := make([]string, 0, len(values))
+		for _, v := range values {
+			if keep(v) {
+				kept = append(kept, v)
+			}
+		}
+		return kept
+	}
+
+	var values []string
+	if err := json.Unmarshal(body, &values); err == nil {
+		if out, err := json.Marshal(filter(values)); err == nil {
+			return out
+		}
+		return body
+	}
+	var variable VariableResponse
+	if err := json.Unmarshal(body, &variable); err == nil {
+		variable.Values = filter(variable.Values)
+		if out, err := json.Marshal(variable); err == nil {
+			return out
+		}
+	}
+	return body
+}
+
+// sendPolicyViolation refuses a resource request the policy does not allow.
+func sendPolicyViolation(sender backend.CallResourceResponseSender, err error) error {
+	return sender.Send(&backend.CallResourceResponse{
+		Status: http.StatusForbidden,
+		Body:   []byte(fmt.Sprintf(`{"error": %q}`, err.Error())),
+	})
+}
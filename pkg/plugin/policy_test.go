@@ -0,0 +1,268 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wasilak/grafana-datadog-datasource/pkg/plugin/fakedatadog"
+)
+
+var testPolicy = &PolicyOptions{
+	AllowedMetricPrefixes: []string{"system.cpu."},
+	AllowedTagKeys:        []string{"host"},
+	RequiredMetricTags:    []string{"env:prod"},
+	AllowedLogIndexes:     []string{"payments", "payments-archive"},
+	RequiredLogQuery:      "team:payments",
+}
+
+// newFakeBackedDatasourceWithPolicy is newFakeBackedDatasource restricted by testPolicy.
+func newFakeBackedDatasourceWithPolicy(t *testing.T) (*Datasource, *fakedatadog.Server) {
+	t.Helper()
+	return newFakeBackedDatasourceWithPolicyOptions(t, testPolicy)
+}
+
+// newFakeBackedDatasourceWithPolicyOptions is newFakeBackedDatasourceWithPolicy with the
+// given policy.
+func newFakeBackedDatasourceWithPolicyOptions(t *testing.T, options *PolicyOptions) (*Datasource, *fakedatadog.Server) {
+	t.Helper()
+	srv := fakedatadog.New()
+	t.Cleanup(srv.Close)
+
+	policy, err := json.Marshal(options)
+	require.NoError(t, err)
+	inst, err := NewDatasource(context.Background(), backend.DataSourceInstanceSettings{
+		UID:      "fake-datadog-policy",
+		JSONData: []byte(fmt.Sprintf(`{"site": "datadoghq.com", "baseUrl": %q, "policy": %s}`, srv.URL(), policy)),
+		DecryptedSecureJSONData: map[string]string{
+			"apiKey": fakedatadog.TestAPIKey,
+			"appKey": fakedatadog.TestAppKey,
+		},
+	})
+	require.NoError(t, err)
+	d := inst.(*Datasource)
+	t.Cleanup(d.Dispose)
+	return d, srv
+}
+
+func TestPolicyScopeMetricsQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+		err   string
+	}{
+		{"wildcard scope", "avg:system.cpu.user{*}", "avg:system.cpu.user{env:prod}", ""},
+		{"group by all tags", "avg:system.cpu.user{*} by {*}", "", "grouping by all tags"},
+		{"scope is extended", "avg:system.cpu.user{host:web-01} by {host}", "avg:system.cpu.user{host:web-01,env:prod} by {host}", ""},
+		{"boolean scope is grouped", "avg:system.cpu.user{host:a OR host:b}", "avg:system.cpu.user{(host:a OR host:b) AND env:prod}", ""},
+		{"every metric is scoped", "sum:system.cpu.user{*}.rollup(sum, 60) / sum:system.cpu.idle{host:a}",
+			"sum:system.cpu.user{env:prod}.rollup(sum, 60) / sum:system.cpu.idle{host:a,env:prod}", ""},
+		{"required key may be used", "avg:system.cpu.user{env:prod} by {env}", "avg:system.cpu.user{env:prod,env:prod} by {env}", ""},
+		{"metric outside prefixes", "avg:system.mem.used{*}", "", `metric "system.mem.used"`},
+		{"one metric outside prefixes", "avg:system.cpu.user{*} - avg:aws.billing{*}", "", `metric "aws.billing"`},
+		{"filter on other tag", "avg:system.cpu.user{!service:checkout}", "", `filtering by tag "service"`},
+		{"IN filter on other tag", "avg:system.cpu.user{service IN (a,b)}", "", `filtering by tag "service"`},
+		{"bare tag", "avg:system.cpu.user{production}", "", `filtering by tag "production"`},
+		{"negated bare tag", "avg:system.cpu.user{host:a AND NOT canary}", "", `filtering by tag "canary"`},
+		{"allowed bare tag", "avg:system.cpu.user{host AND NOT host:b}", "avg:system.cpu.user{(host AND NOT host:b) AND env:prod}", ""},
+		{"IN filter on allowed tag", "avg:system.cpu.user{host IN (a,b)}", "avg:system.cpu.user{(host IN (a,b)) AND env:prod}", ""},
+		{"group by other tag", "avg:system.cpu.user{*} by {service}", "", `grouping by tag "service"`},
+		{"scope escape", "avg:system.cpu.user{host:a) OR (host:b}", "", "unbalanced parentheses"},
+		{"metric without scope", "avg:system.cpu.user{*} + avg:system.mem.used", "", `metric "system.mem.used" has no {scope}`},
+		{"no metric", "avg:system.cpu.user", "", "must name a metric"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := testPolicy.scopeMetricsQuery(tt.query)
+			if tt.err != "" {
+				var violation *policyViolation
+				require.ErrorAs(t, err, &violation)
+				assert.Contains(t, err.Error(), tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	var none *PolicyOptions
+	got, err := none.scopeMetricsQuery("avg:anything{*}")
+	require.NoError(t, err)
+	assert.Equal(t, "avg:anything{*}", got)
+
+	invalid := &PolicyOptions{RequiredMetricTags: []string{"env:prod}"}}
+	_, err = invalid.scopeMetricsQuery("avg:system.cpu.user{*}")
+	assert.ErrorContains(t, err, "not of the form key:value", "an invalid policy refuses queries")
+}
+
+func TestPolicyScopeLogsQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+		ok    bool
+	}{
+		{"", "team:payments", true},
+		{"*", "team:payments", true},
+		{"service:checkout OR status:error", "(service:checkout OR status:error) AND (team:payments)", true},
+		{`"payment (declined"`, `("payment (declined") AND (team:payments)`, true},
+		{"service:checkout) OR (*", "", false},
+		{`"unterminated`, "", false},
+		{`trailing\`, "", false},
+	}
+	for _, tt := range tests {
+		got, err := testPolicy.scopeLogsQuery(tt.query)
+		if !tt.ok {
+			assert.Error(t, err, tt.query)
+			continue
+		}
+		require.NoError(t, err, tt.query)
+		assert.Equal(t, tt.want, got)
+	}
+}
+
+func TestPolicyLogIndexes(t *testing.T) {
+	indexes, err := testPolicy.logIndexes(nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"payments", "payments-archive"}, indexes, "searches are limited to the allowed indexes")
+
+	indexes, err = testPolicy.logIndexes([]string{"payments-archive"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"payments-archive"}, indexes)
+
+	_, err = testPolicy.logIndexes([]string{"payments", "main"})
+	assert.ErrorContains(t, err, `log index "main"`)
+
+	indexes, err = (&PolicyOptions{RequiredLogQuery: "env:prod"}).logIndexes([]string{"main"})
+	require.NoError(t, err)
+	assert.Nil(t, indexes, "indexes are not restricted")
+}
+
+func TestIntegration_Policy_MetricsQueries(t *testing.T) {
+	d, srv := newFakeBackedDatasourceWithPolicy(t)
+
+	resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{
+			dataQuery("A", map[string]interface{}{"queryText": "avg:system.cpu.user{host:web-01}"}),
+			dataQuery("B", map[string]interface{}{"queryText": "avg:system.mem.used{*}"}),
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, resp.Responses["A"].Error)
+	assert.Equal(t, backend.StatusForbidden, resp.Responses["B"].Status)
+	assert.ErrorContains(t, resp.Responses["B"].Error, `not allowed by the datasource policy: metric "system.mem.used"`)
+
+	reqs := srv.Requests(fakedatadog.EndpointTimeseriesQuery)
+	require.Len(t, reqs, 1)
+	var body struct {
+		Data struct {
+			Attributes struct {
+				Queries []struct {
+					Query string `json:"query"`
+				} `json:"queries"`
+			} `json:"attributes"`
+		} `json:"data"`
+	}
+	require.NoError(t, reqs[0].DecodeBody(&body))
+	require.Len(t, body.Data.Attributes.Queries, 1, "the refused query is not sent")
+	assert.Equal(t, "avg:system.cpu.user{host:web-01,env:prod}", body.Data.Attributes.Queries[0].Query, "no by {*} with allowed tag keys")
+}
+
+func TestIntegration_Policy_SeriesLabels(t *testing.T) {
+	d, srv := newFakeBackedDatasourceWithPolicy(t)
+	now := time.Now().Truncate(time.Minute)
+	srv.Enqueue(fakedatadog.EndpointTimeseriesQuery, fakedatadog.JSON(fakedatadog.TimeseriesResponse(
+		[]int64{now.UnixMilli()},
+		fakedatadog.Series{QueryIndex: 0, GroupTags: []string{"host:web-01", "env:prod", "service:checkout"}, Values: fakedatadog.Points(1)},
+	)))
+
+	resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{dataQuery("A", map[string]interface{}{"queryText": "avg:system.cpu.user{*} by {host}"})},
+	})
+	require.NoError(t, err)
+	res := resp.Responses["A"]
+	require.NoError(t, res.Error)
+	require.Len(t, res.Frames, 1)
+	labels := res.Frames[0].Fields[1].Labels
+	assert.Equal(t, data.Labels{"host": "web-01", "env": "prod"}, labels, "tag keys the policy does not allow are dropped")
+	assert.NotContains(t, res.Frames[0].Name, "checkout")
+
+	resp, err = d.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{dataQuery("A", map[string]interface{}{"queryText": "avg:system.cpu.user{*} by {*}"})},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, backend.StatusForbidden, resp.Responses["A"].Status)
+}
+
+func TestIntegration_Policy_LogsQueries(t *testing.T) {
+	d, srv := newFakeBackedDatasourceWithPolicy(t)
+
+	resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{
+			dataQuery("A", map[string]interface{}{"queryType": "logs", "logQuery": "service:checkout"}),
+			dataQuery("B", map[string]interface{}{"queryType": "logs", "logQuery": "status:error", "indexes": []string{"main"}}),
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, resp.Responses["A"].Error)
+	assert.Equal(t, backend.StatusForbidden, resp.Responses["B"].Status)
+
+	reqs := srv.Requests(fakedatadog.EndpointLogsSearch)
+	require.Len(t, reqs, 1)
+	var body struct {
+		Filter struct {
+			Query   string   `json:"query"`
+			Indexes []string `json:"indexes"`
+		} `json:"filter"`
+	}
+	require.NoError(t, reqs[0].DecodeBody(&body))
+	assert.Equal(t, "(service:checkout) AND (team:payments)", body.Filter.Query)
+	assert.Equal(t, []string{"payments", "payments-archive"}, body.Filter.Indexes)
+
+	// Autocomplete lookups only see the same logs
+	srv.Reset()
+	require.Equal(t, http.StatusOK, callResource(t, d, http.MethodGet, "autocomplete/logs/services", nil).Status)
+	reqs = srv.Requests(fakedatadog.EndpointLogsSearch)
+	require.Len(t, reqs, 1)
+	require.NoError(t, reqs[0].DecodeBody(&body))
+	assert.Equal(t, "team:payments", body.Filter.Query)
+	assert.Equal(t, []string{"payments", "payments-archive"}, body.Filter.Indexes)
+}
+
+func TestIntegration_Policy_AutocompleteAndVariables(t *testing.T) {
+	d, _ := newFakeBackedDatasourceWithPolicy(t)
+
+	listed := func(resp *backend.CallResourceResponse) []string {
+		t.Helper()
+		require.Equal(t, http.StatusOK, resp.Status, string(resp.Body))
+		var values []string
+		if err := json.Unmarshal(resp.Body, &values); err != nil {
+			var variable VariableResponse
+			require.NoError(t, json.Unmarshal(resp.Body, &variable))
+			values = variable.Values
+		}
+		sort.Strings(values)
+		return values
+	}
+
+	assert.Equal(t, []string{"system.cpu.user"}, listed(callResource(t, d, http.MethodGet, "autocomplete/metrics", nil)))
+	assert.Equal(t, []string{"env", "host"}, listed(callResource(t, d, http.MethodGet, "autocomplete/tags/system.cpu.user", nil)))
+	assert.Equal(t, []string{"prod"}, listed(callResource(t, d, http.MethodGet, "autocomplete/tag-values/system.cpu.user/env", nil)))
+	assert.Equal(t, []string{"web-01", "web-02"}, listed(callResource(t, d, http.MethodGet, "autocomplete/tag-values/system.cpu.user/host", nil)))
+
+	assert.Equal(t, http.StatusForbidden, callResource(t, d, http.MethodGet, "autocomplete/tags/system.mem.used", nil).Status)
+	assert.Equal(t, http.StatusForbidden, callResource(t, d, http.MethodGet, "autocomplete/tag-values/system.cpu.user/service", nil).Status)
+
+	assert.Equal(t, []string{"system.cpu.user"}, listed(callResource(t, d, http.MethodPost, "metrics", []byte(`{}`))))
+	assert.Equal(t, []string{"prod"}, listed(callResource(t, d, http.MethodPost, "tag-values", []byte(`{"metricName": "system.cpu.user", "tagKey": "env"}`))))
+	assert.Equal(t, http.StatusForbidden, callResource(t, d, http.MethodPost, "tag-keys", []byte(`{"metricName": "system.mem.used"}`)).Status)
+}
@@ -27,6 +27,12 @@ const (
 	// Note: logs-volume queries are also handled by LogsHandler since volume
 	// is calculated from log entries (no separate API call needed)
 	LogsQueryType QueryType = "logs"
+
+	// ServiceMapQueryType represents APM service map queries, returned as node graph frames
+	ServiceMapQueryType QueryType = "service-map"
+
+	// RUMQueryType represents RUM events searches and aggregations
+	RUMQueryType QueryType = "rum"
 )
 
 // detectQueryType determines the query type based on the QueryModel
@@ -41,6 +47,10 @@ func detectQueryType(qm *QueryModel) QueryType {
 			return LogsQueryType
 		case "metrics":
 			return MetricsQueryType
+		case "service-map":
+			return ServiceMapQueryType
+		case "rum":
+			return RUMQueryType
 		}
 	}
 	
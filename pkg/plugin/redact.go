@@ -0,0 +1,132 @@
+package plugin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+)
+
+// Log redaction. Grafana server logs are commonly shipped to shared log pipelines, so
+// nothing that identifies credentials or customer data is written verbatim:
+//   - API/App keys and credential-bearing headers are never logged, not even as prefixes.
+//   - Request and response payloads (log events, Datadog error bodies, resource request
+//     bodies) are reduced to their size. The reason Datadog gives for an error is still
+//     returned to the requesting user (see datadogAPIError).
+//   - Query text (metric queries, log searches, autocomplete filters) is replaced by a short
+//     fingerprint unless the datasource opts in with the `logQueries` option.
+
+// redactedValue is logged in place of a secret value.
+const redactedValue = "[REDACTED]"
+
+// sensitiveHeaders lists request headers whose values must never be logged (canonical form).
+var sensitiveHeaders = map[string]bool{
+	"Authorization":      true,
+	"Cookie":             true,
+	"Set-Cookie":         true,
+	"Dd-Api-Key":         true,
+	"Dd-Application-Key": true,
+	"X-Grafana-Id":       true,
+	"X-Id-Token":         true,
+	"X-Access-Token":     true,
+}
+
+// logQuery returns the query text as it may appear in logs: verbatim when the
+// datasource has `logQueries` enabled, otherwise a fingerprint that still allows
+// correlating log lines about the same query.
+func (d *Datasource) logQuery(query string) string {
+	if d.JSONData != nil && d.JSONData.LogQueries {
+		return query
+	}
+	return redactQuery(query)
+}
+
+// redactQuery replaces query text with its length and a short SHA-256 fingerprint.
+func redactQuery(query string) string {
+	if query == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(query))
+	return fmt.Sprintf("[REDACTED len=%d sha256=%s]", len(query), hex.EncodeToString(sum[:4]))
+}
+
+// redactPayload describes a request or response body without revealing its contents.
+func redactPayload(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("[REDACTED %d bytes]", len(body))
+}
+
+// maxErrorDetail bounds the Datadog error detail returned to users.
+const maxErrorDetail = 200
+
+// datadogAPIError is an error response of the Datadog API. Its Error text is safe to log: the
+// detail Datadog gave, which may echo query text, is only returned to the requesting user.
+type datadogAPIError struct {
+	op     string
+	status int
+	detail string
+}
+
+func (e *datadogAPIError) Error() string {
+	return fmt.Sprintf("%s: %d", e.op, e.status)
+}
+
+// userErrorMessage returns the message of err for the user who made the request: the error
+// and, for Datadog API errors, the reason Datadog gave.
+func userErrorMessage(err error) string {
+	var apiErr *datadogAPIError
+	if errors.As(err, &apiErr) && apiErr.detail != "" {
+		return err.Error() + " - " + apiErr.detail
+	}
+	return err.Error()
+}
+
+// datadogErrorDetail returns the messages of the "errors" field of a Datadog error body,
+// without control characters and cut to maxErrorDetail characters. Bodies that are not
+// Datadog errors are left out.
+func datadogErrorDetail(body []byte) string {
+	var resp struct {
+		Errors []string `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return ""
+	}
+	detail := strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, strings.Join(resp.Errors, "; "))
+	if runes := []rune(detail); len(runes) > maxErrorDetail {
+		detail = string(runes[:maxErrorDetail]) + "…"
+	}
+	return detail
+}
+
+// redactHeaders returns a copy of headers with credential-bearing values replaced.
+func redactHeaders(headers map[string][]string) map[string][]string {
+	out := make(map[string][]string, len(headers))
+	for k, v := range headers {
+		if isSensitiveHeader(k) {
+			v = []string{redactedValue}
+		}
+		out[k] = v
+	}
+	return out
+}
+
+func isSensitiveHeader(name string) bool {
+	canonical := http.CanonicalHeaderKey(name)
+	if sensitiveHeaders[canonical] {
+		return true
+	}
+	lower := strings.ToLower(name)
+	return strings.Contains(lower, "api-key") || strings.Contains(lower, "apikey") ||
+		strings.Contains(lower, "secret") || strings.Contains(lower, "token")
+}
@@ -0,0 +1,105 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wasilak/grafana-datadog-datasource/pkg/plugin/fakedatadog"
+)
+
+func TestRedactQuery(t *testing.T) {
+	redacted := redactQuery("service:checkout @user.email:jane@example.com")
+
+	assert.NotContains(t, redacted, "checkout")
+	assert.NotContains(t, redacted, "jane@example.com")
+	assert.Regexp(t, `^\[REDACTED len=45 sha256=[0-9a-f]{8}\]$`, redacted)
+	// The fingerprint is stable so log lines about the same query can be correlated.
+	assert.Equal(t, redacted, redactQuery("service:checkout @user.email:jane@example.com"))
+	assert.NotEqual(t, redacted, redactQuery("service:payments"))
+	assert.Equal(t, "", redactQuery(""))
+}
+
+func TestLogQuery_OptIn(t *testing.T) {
+	query := "avg:system.cpu.user{env:prod}"
+
+	d := &Datasource{JSONData: &MyDataSourceOptions{}}
+	assert.Equal(t, redactQuery(query), d.logQuery(query))
+
+	d = &Datasource{}
+	assert.Equal(t, redactQuery(query), d.logQuery(query))
+
+	d = &Datasource{JSONData: &MyDataSourceOptions{LogQueries: true}}
+	assert.Equal(t, query, d.logQuery(query))
+}
+
+func TestRedactPayload(t *testing.T) {
+	assert.Equal(t, "[REDACTED 28 bytes]", redactPayload([]byte(`{"message":"secret payload"}`)))
+	assert.Equal(t, "", redactPayload(nil))
+}
+
+func TestDatadogErrorDetail(t *testing.T) {
+	assert.Equal(t, "Invalid query; Unknown facet", datadogErrorDetail([]byte(`{"errors": ["Invalid query", "Unknown facet"]}`)))
+	assert.Equal(t, "line one line two", datadogErrorDetail([]byte(`{"errors": ["line one\nline two"]}`)))
+	assert.Equal(t, strings.Repeat("x", maxErrorDetail)+"…", datadogErrorDetail([]byte(`{"errors": ["`+strings.Repeat("x", 500)+`"]}`)))
+	assert.Empty(t, datadogErrorDetail([]byte("<html>Bad Gateway</html>")))
+
+	err := &datadogAPIError{op: "logs search API error", status: 400, detail: "Invalid query"}
+	assert.Equal(t, "logs search API error: 400", err.Error())
+	assert.Equal(t, "wrapped: logs search API error: 400 - Invalid query", userErrorMessage(fmt.Errorf("wrapped: %w", err)))
+	assert.Equal(t, "plain", userErrorMessage(errors.New("plain")))
+}
+
+func TestIntegration_LogsAutocompleteErrors(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+	apiKey, appKey, site, err := d.validateCredentials()
+	require.NoError(t, err)
+	srv.SetDefault(fakedatadog.EndpointLogsSearch, fakedatadog.Error(http.StatusBadRequest, "Invalid query: @user.email:jane@example.com"))
+
+	for name, fetch := range map[string]func() ([]string, error){
+		"field values": func() ([]string, error) {
+			return d.fetchLogsFieldValues(context.Background(), "service", apiKey, appKey, site)
+		},
+		"tags": func() ([]string, error) { return d.fetchLogsTags(context.Background(), apiKey, appKey, site) },
+		"tag values": func() ([]string, error) {
+			return d.fetchLogsTagValues(context.Background(), "env", apiKey, appKey, site)
+		},
+	} {
+		_, err := fetch()
+		require.Error(t, err, name)
+		assert.NotContains(t, err.Error(), "jane@example.com", "%s: the logged error leaves out the response body", name)
+		assert.Contains(t, userErrorMessage(err), "400 - Invalid query: @user.email:jane@example.com", "%s: the user sees why", name)
+	}
+
+	resp := callResource(t, d, http.MethodGet, "autocomplete/logs/tags", nil)
+	assert.Equal(t, http.StatusInternalServerError, resp.Status)
+	assert.JSONEq(t, `{"error": "failed to fetch tags: logs search API error: 400 - Invalid query: @user.email:jane@example.com"}`, string(resp.Body))
+}
+
+func TestRedactHeaders(t *testing.T) {
+	headers := map[string][]string{
+		"Authorization":      {"Bearer abc"},
+		"Cookie":             {"grafana_session=abc"},
+		"dd-api-key":         {"0123456789abcdef"},
+		"DD-APPLICATION-KEY": {"fedcba9876543210"},
+		"X-Custom-Token":     {"abc"},
+		"Content-Type":       {"application/json"},
+		"X-Grafana-Org-Id":   {"1"},
+	}
+
+	redacted := redactHeaders(headers)
+
+	for _, name := range []string{"Authorization", "Cookie", "dd-api-key", "DD-APPLICATION-KEY", "X-Custom-Token"} {
+		assert.Equal(t, []string{redactedValue}, redacted[name], name)
+	}
+	assert.Equal(t, []string{"application/json"}, redacted["Content-Type"])
+	assert.Equal(t, []string{"1"}, redacted["X-Grafana-Org-Id"])
+	// The input is not modified.
+	assert.Equal(t, []string{"Bearer abc"}, headers["Authorization"])
+}
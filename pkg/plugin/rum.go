@@ -0,0 +1,488 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadog"
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// RUM queries. A rum query searches the Real User Monitoring events of the time range. Without
+// an aggregation it lists the events as a table; with one it aggregates them with Datadog's
+// RUM analytics into a time series per group, such as the p75 of @view.loading_time by
+// @view.url_path.
+
+const (
+	// defaultRUMEventsLimit and maxRUMEventsLimit bound the events listed by a query
+	defaultRUMEventsLimit = 100
+	maxRUMEventsLimit     = 1000
+	// defaultRUMGroupLimit is the number of groups kept for each facet of an aggregation
+	defaultRUMGroupLimit = 10
+	// rumComputeName is the name of the single compute of an aggregation
+	rumComputeName = "c0"
+)
+
+// rumAggregations are the aggregations of RUM analytics.
+var rumAggregations = map[string]datadogV2.RUMAggregationFunction{
+	"count":       datadogV2.RUMAGGREGATIONFUNCTION_COUNT,
+	"cardinality": datadogV2.RUMAGGREGATIONFUNCTION_CARDINALITY,
+	"sum":         datadogV2.RUMAGGREGATIONFUNCTION_SUM,
+	"min":         datadogV2.RUMAGGREGATIONFUNCTION_MIN,
+	"max":         datadogV2.RUMAGGREGATIONFUNCTION_MAX,
+	"avg":         datadogV2.RUMAGGREGATIONFUNCTION_AVG,
+	"median":      datadogV2.RUMAGGREGATIONFUNCTION_MEDIAN,
+	"pc75":        datadogV2.RUMAGGREGATIONFUNCTION_PERCENTILE_75,
+	"pc90":        datadogV2.RUMAGGREGATIONFUNCTION_PERCENTILE_90,
+	"pc95":        datadogV2.RUMAGGREGATIONFUNCTION_PERCENTILE_95,
+	"pc98":        datadogV2.RUMAGGREGATIONFUNCTION_PERCENTILE_98,
+	"pc99":        datadogV2.RUMAGGREGATIONFUNCTION_PERCENTILE_99,
+}
+
+// RUMHandler handles rum queries.
+type RUMHandler struct {
+	datasource  *Datasource
+	reqQueries  []backend.DataQuery
+	queryModels map[string]QueryModel
+	timeRanges  map[string]backend.TimeRange
+	ddCtx       context.Context
+	rumApi      *datadogV2.RUMApi
+}
+
+// NewRUMHandler creates a new RUMHandler instance
+func NewRUMHandler(datasource *Datasource, queries []backend.DataQuery, ddCtx context.Context, rumApi *datadogV2.RUMApi) *RUMHandler {
+	return &RUMHandler{
+		datasource:  datasource,
+		reqQueries:  queries,
+		queryModels: make(map[string]QueryModel),
+		timeRanges:  make(map[string]backend.TimeRange),
+		ddCtx:       ddCtx,
+		rumApi:      rumApi,
+	}
+}
+
+// ddRUMEvents holds the parts of a RUM events search the table reads.
+type ddRUMEvents struct {
+	Data []struct {
+		ID         string `json:"id"`
+		Attributes struct {
+			Timestamp  time.Time              `json:"timestamp"`
+			Service    string                 `json:"service"`
+			Attributes map[string]interface{} `json:"attributes"`
+		} `json:"attributes"`
+	} `json:"data"`
+	Meta struct {
+		Page struct {
+			After string `json:"after"`
+		} `json:"page"`
+	} `json:"meta"`
+}
+
+// ddRUMBuckets holds the groups of a RUM aggregation. Group values are strings or numbers,
+// computes are the points of each time series.
+type ddRUMBuckets struct {
+	Data struct {
+		Buckets []struct {
+			By       map[string]interface{}     `json:"by"`
+			Computes map[string]json.RawMessage `json:"computes"`
+		} `json:"buckets"`
+	} `json:"data"`
+}
+
+// processQuery validates a rum query and prepares it for execution
+func (h *RUMHandler) processQuery(qm *QueryModel) error {
+	if qm.Hide {
+		return nil
+	}
+	if qm.RUMAggregation != "" {
+		if _, ok := rumAggregations[qm.RUMAggregation]; !ok {
+			return fmt.Errorf("unknown RUM aggregation %q", qm.RUMAggregation)
+		}
+		if qm.RUMAggregation != "count" && qm.RUMMetric == "" {
+			return fmt.Errorf("the %s aggregation needs a measure or facet", qm.RUMAggregation)
+		}
+	} else if qm.Limit > maxRUMEventsLimit {
+		return fmt.Errorf("at most %d RUM events can be listed", maxRUMEventsLimit)
+	}
+	if qm.Limit < 0 {
+		return fmt.Errorf("limit cannot be negative")
+	}
+	// RUM events are not covered by the policy and cannot be scoped by it
+	if h.datasource.policy().restricts() {
+		return violationf("RUM queries are not available with a policy")
+	}
+
+	// Find the corresponding backend query for RefID; identical queries take the next one
+	var refID string
+	for _, q := range h.reqQueries {
+		var tempQM QueryModel
+		if err := json.Unmarshal(q.JSON, &tempQM); err != nil {
+			continue
+		}
+		if _, taken := h.queryModels[q.RefID]; taken {
+			continue
+		}
+		if tempQM.QueryType == qm.QueryType && tempQM.RUMQuery == qm.RUMQuery &&
+			tempQM.RUMAggregation == qm.RUMAggregation && tempQM.RUMMetric == qm.RUMMetric {
+			refID = q.RefID
+			h.timeRanges[refID] = q.TimeRange
+			break
+		}
+	}
+	if refID == "" {
+		return fmt.Errorf("could not find RefID for RUM query")
+	}
+	h.queryModels[refID] = *qm
+	return nil
+}
+
+// executeQueries runs each rum query
+func (h *RUMHandler) executeQueries(ctx context.Context) (*backend.QueryDataResponse, error) {
+	logger := log.New()
+	response := backend.NewQueryDataResponse()
+	if len(h.queryModels) == 0 {
+		return response, nil
+	}
+
+	// h.ddCtx carries the QueryData span, so the handler span nests under it
+	spanCtx, span := startSpan(h.ddCtx, "datadog.rum.executeQueries",
+		attrQueryType.String(string(RUMQueryType)),
+		attrRefIDs.StringSlice(sortedRefIDs(h.queryModels)))
+	defer span.End()
+
+	for refID, qm := range h.queryModels {
+		var (
+			frames data.Frames
+			stats  queryStats
+			err    error
+		)
+		start := time.Now()
+		if qm.RUMAggregation == "" {
+			frames, stats, err = h.searchEvents(spanCtx, qm, h.timeRanges[refID])
+		} else {
+			frames, stats, err = h.aggregateEvents(spanCtx, qm, h.timeRanges[refID])
+		}
+		if err != nil {
+			logger.Error("RUM query failed", "refID", refID, "error", err)
+			_ = tracing.Error(span, err)
+			if res, ok := limitResponse(err); ok {
+				response.Responses[refID] = res
+				continue
+			}
+			response.Responses[refID] = backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+			continue
+		}
+		stats.latency, stats.pages = time.Since(start), 1
+		for _, frame := range frames {
+			frame.RefID = refID
+		}
+		response.Responses[refID] = backend.DataResponse{
+			Frames: annotateFrames(frames, refID, rumExecutedQuery(qm), stats, nil),
+		}
+	}
+	return response, nil
+}
+
+// rumSearchQuery returns the search of a query, all events when it has none.
+func rumSearchQuery(qm QueryModel) string {
+	if strings.TrimSpace(qm.RUMQuery) == "" {
+		return "*"
+	}
+	return qm.RUMQuery
+}
+
+// rumExecutedQuery describes a query as it is sent to Datadog, for Query Inspector.
+func rumExecutedQuery(qm QueryModel) string {
+	executed := rumSearchQuery(qm)
+	if qm.RUMAggregation != "" {
+		executed = rumSeriesName(qm) + " | " + executed
+		if len(qm.RUMGroupBy) > 0 {
+			executed += " by " + strings.Join(qm.RUMGroupBy, ", ")
+		}
+	}
+	return executed
+}
+
+// rumSeriesName is the name of the aggregation of a query, e.g. "pc75(@view.loading_time)".
+func rumSeriesName(qm QueryModel) string {
+	if qm.RUMMetric == "" || qm.RUMAggregation == "count" {
+		return qm.RUMAggregation
+	}
+	return fmt.Sprintf("%s(%s)", qm.RUMAggregation, qm.RUMMetric)
+}
+
+// rumFilter is the search and time range of a query.
+func rumFilter(qm QueryModel, tr backend.TimeRange) *datadogV2.RUMQueryFilter {
+	return &datadogV2.RUMQueryFilter{
+		Query: datadog.PtrString(rumSearchQuery(qm)),
+		From:  datadog.PtrString(tr.From.UTC().Format(time.RFC3339)),
+		To:    datadog.PtrString(tr.To.UTC().Format(time.RFC3339)),
+	}
+}
+
+// rumInterval formats an interval in milliseconds as a RUM analytics bucket size.
+func rumInterval(ms int64) string {
+	switch {
+	case ms >= 3600000 && ms%3600000 == 0:
+		return fmt.Sprintf("%dh", ms/3600000)
+	case ms >= 60000 && ms%60000 == 0:
+		return fmt.Sprintf("%dm", ms/60000)
+	case ms < 1000:
+		return "1s"
+	}
+	return fmt.Sprintf("%ds", ms/1000)
+}
+
+// rumAggregateRequest builds the RUM analytics request of an aggregation. Groups are ranked
+// by the aggregated value, largest first.
+func rumAggregateRequest(qm QueryModel, tr backend.TimeRange) datadogV2.RUMAggregateRequest {
+	aggregation := rumAggregations[qm.RUMAggregation]
+	compute := datadogV2.RUMCompute{
+		Aggregation: aggregation,
+		Type:        datadogV2.RUMCOMPUTETYPE_TIMESERIES.Ptr(),
+	}
+	if qm.RUMMetric != "" && qm.RUMAggregation != "count" {
+		compute.Metric = datadog.PtrString(qm.RUMMetric)
+	}
+	// Datadog picks about 150 buckets for the time range unless the interval is overridden
+	if qm.Interval != nil && *qm.Interval > 0 {
+		compute.Interval = datadog.PtrString(rumInterval(*qm.Interval))
+	}
+
+	limit := int64(defaultRUMGroupLimit)
+	if qm.Limit > 0 {
+		limit = int64(qm.Limit)
+	}
+	groupBy := make([]datadogV2.RUMGroupBy, 0, len(qm.RUMGroupBy))
+	for _, facet := range qm.RUMGroupBy {
+		order := datadogV2.RUMAggregateSort{
+			Type:        datadogV2.RUMAGGREGATESORTTYPE_MEASURE.Ptr(),
+			Aggregation: aggregation.Ptr(),
+			Order:       datadogV2.RUMSORTORDER_DESCENDING.Ptr(),
+			Metric:      compute.Metric,
+		}
+		groupBy = append(groupBy, datadogV2.RUMGroupBy{Facet: facet, Limit: datadog.PtrInt64(limit), Sort: &order})
+	}
+
+	return datadogV2.RUMAggregateRequest{
+		Compute: []datadogV2.RUMCompute{compute},
+		Filter:  rumFilter(qm, tr),
+		GroupBy: groupBy,
+	}
+}
+
+// rumError turns a failed RUM API call into the error reported for the query.
+func (d *Datasource) rumError(err error, r *http.Response) error {
+	if _, ok := limitResponse(err); ok {
+		return err
+	}
+	httpStatus, responseBody := 0, ""
+	if r != nil {
+		httpStatus = r.StatusCode
+		if r.Body != nil {
+			bodyBytes, _ := io.ReadAll(r.Body)
+			responseBody = string(bodyBytes)
+		}
+	}
+	if httpStatus == http.StatusForbidden {
+		return fmt.Errorf("API key missing required permissions - need 'rum_apps_read' scope for RUM events")
+	}
+	return fmt.Errorf("%s", d.parseDatadogError(err, httpStatus, responseBody))
+}
+
+// searchEvents lists the latest RUM events of a query as a table.
+func (h *RUMHandler) searchEvents(ctx context.Context, qm QueryModel, tr backend.TimeRange) (data.Frames, queryStats, error) {
+	stats := queryStats{rowsName: "Events"}
+	limit := defaultRUMEventsLimit
+	if qm.Limit > 0 {
+		limit = qm.Limit
+	}
+	body := datadogV2.RUMSearchEventsRequest{
+		Filter: rumFilter(qm, tr),
+		Sort:   datadogV2.RUMSORT_TIMESTAMP_DESCENDING.Ptr(),
+		Page:   &datadogV2.RUMQueryPageOptions{Limit: datadog.PtrInt32(int32(limit))},
+	}
+
+	queryCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	resp, r, err := h.rumApi.SearchRUMEvents(queryCtx, body)
+	if err != nil {
+		return nil, stats, h.datasource.rumError(err, r)
+	}
+	raw, err := json.Marshal(resp)
+	if err != nil {
+		return nil, stats, err
+	}
+	var events ddRUMEvents
+	if err := json.Unmarshal(raw, &events); err != nil {
+		return nil, stats, fmt.Errorf("invalid RUM events response: %w", err)
+	}
+
+	frame := rumEventsFrame(events)
+	stats.rows, stats.truncated = frame.Rows(), events.Meta.Page.After != ""
+	return data.Frames{frame}, stats, nil
+}
+
+// rumEventsFrame builds the table of RUM events: when and where each happened, a summary
+// of what happened and all of its attributes as JSON.
+func rumEventsFrame(events ddRUMEvents) *data.Frame {
+	n := len(events.Data)
+	var (
+		timestamps = make([]time.Time, n)
+		types      = make([]string, n)
+		services   = make([]string, n)
+		apps       = make([]string, n)
+		views      = make([]string, n)
+		sessions   = make([]string, n)
+		summaries  = make([]string, n)
+		attributes = make([]json.RawMessage, n)
+	)
+	for i, e := range events.Data {
+		attrs := e.Attributes.Attributes
+		timestamps[i] = e.Attributes.Timestamp
+		types[i] = rumAttribute(attrs, "type")
+		services[i] = e.Attributes.Service
+		apps[i] = rumAttribute(attrs, "application.name")
+		views[i] = rumAttribute(attrs, "view.url_path")
+		sessions[i] = rumAttribute(attrs, "session.id")
+		summaries[i] = rumEventSummary(types[i], attrs)
+		attributes[i], _ = json.Marshal(attrs)
+	}
+
+	frame := data.NewFrame("rum",
+		data.NewField("timestamp", nil, timestamps),
+		data.NewField("type", nil, types),
+		data.NewField("service", nil, services),
+		data.NewField("application", nil, apps),
+		data.NewField("view", nil, views),
+		data.NewField("session", nil, sessions),
+		data.NewField("summary", nil, summaries),
+		data.NewField("attributes", nil, attributes),
+	)
+	frame.Meta = &data.FrameMeta{PreferredVisualization: data.VisTypeTable}
+	return frame
+}
+
+// rumEventSummary is the attribute that best says what an event of the given type was.
+func rumEventSummary(eventType string, attrs map[string]interface{}) string {
+	switch eventType {
+	case "error":
+		return rumAttribute(attrs, "error.message")
+	case "action":
+		return rumAttribute(attrs, "action.target.name")
+	case "resource":
+		return rumAttribute(attrs, "resource.url")
+	case "long_task":
+		return rumAttribute(attrs, "long_task.duration")
+	}
+	return rumAttribute(attrs, "view.name")
+}
+
+// rumAttribute returns the value at a dotted path of the attributes of an event as text,
+// "" when there is none.
+func rumAttribute(attrs map[string]interface{}, path string) string {
+	var value interface{} = attrs
+	for _, key := range strings.Split(path, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		if value, ok = m[key]; !ok {
+			return ""
+		}
+	}
+	switch v := value.(type) {
+	case string:
+		return v
+	case nil, map[string]interface{}, []interface{}:
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+// aggregateEvents aggregates the RUM events of a query into a time series per group.
+func (h *RUMHandler) aggregateEvents(ctx context.Context, qm QueryModel, tr backend.TimeRange) (data.Frames, queryStats, error) {
+	stats := queryStats{rowsName: "Series"}
+	queryCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	resp, r, err := h.rumApi.AggregateRUMEvents(queryCtx, rumAggregateRequest(qm, tr))
+	if err != nil {
+		return nil, stats, h.datasource.rumError(err, r)
+	}
+	raw, err := json.Marshal(resp)
+	if err != nil {
+		return nil, stats, err
+	}
+	frames, err := rumSeriesFrames(raw, qm)
+	if err != nil {
+		return nil, stats, err
+	}
+	stats.rows = len(frames)
+	return frames, stats, nil
+}
+
+// rumSeriesFrames reads the buckets of a RUM aggregation into one frame per group, labelled
+// with the facet values of the group and named like metrics series.
+func rumSeriesFrames(raw []byte, qm QueryModel) (data.Frames, error) {
+	var buckets ddRUMBuckets
+	if err := json.Unmarshal(raw, &buckets); err != nil {
+		return nil, fmt.Errorf("invalid RUM aggregation response: %w", err)
+	}
+
+	parser := NewMetricsResponseParser(nil)
+	name := rumSeriesName(qm)
+	frames := data.Frames{}
+	for _, b := range buckets.Data.Buckets {
+		var points []struct {
+			Time  time.Time `json:"time"`
+			Value *float64  `json:"value"`
+		}
+		// Buckets without a series, such as totals, have a single number
+		if err := json.Unmarshal(b.Computes[rumComputeName], &points); err != nil || len(points) == 0 {
+			continue
+		}
+		times := make([]time.Time, len(points))
+		values := make([]*float64, len(points))
+		for i, p := range points {
+			times[i], values[i] = p.Time, p.Value
+		}
+
+		labels := data.Labels{}
+		keys := make([]string, 0, len(b.By))
+		for facet, value := range b.By {
+			labels[facet] = fmt.Sprint(value)
+			keys = append(keys, facet)
+		}
+		sort.Strings(keys)
+
+		seriesName := name
+		if qm.LegendMode == "custom" && qm.LegendTemplate != "" {
+			seriesName = parser.replaceTemplateVariables(qm.LegendTemplate, labels)
+		} else if len(keys) > 0 {
+			pairs := make([]string, len(keys))
+			for i, k := range keys {
+				pairs[i] = k + ":" + labels[k]
+			}
+			seriesName = name + " {" + strings.Join(pairs, ", ") + "}"
+		}
+
+		frame := data.NewFrame(seriesName,
+			data.NewField("Time", nil, times),
+			data.NewField("Value", labels, values),
+		)
+		frame.Fields[1].Config = &data.FieldConfig{DisplayName: seriesName}
+		frame.Meta = &data.FrameMeta{Type: data.FrameTypeTimeSeriesMulti}
+		frames = append(frames, frame)
+	}
+	return frames, nil
+}
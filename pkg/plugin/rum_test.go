@@ -0,0 +1,205 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wasilak/grafana-datadog-datasource/pkg/plugin/fakedatadog"
+)
+
+func TestRUMInterval(t *testing.T) {
+	assert.Equal(t, "2h", rumInterval(7200000))
+	assert.Equal(t, "5m", rumInterval(300000))
+	assert.Equal(t, "90s", rumInterval(90000))
+	assert.Equal(t, "1s", rumInterval(200))
+}
+
+func TestRUMAggregateRequest(t *testing.T) {
+	interval := int64(60000)
+	req := rumAggregateRequest(QueryModel{
+		RUMQuery:       "@type:view",
+		RUMAggregation: "pc75",
+		RUMMetric:      "@view.loading_time",
+		RUMGroupBy:     []string{"@view.url_path"},
+		Limit:          5,
+		Interval:       &interval,
+	}, backend.TimeRange{From: time.Unix(0, 0), To: time.Unix(3600, 0)})
+
+	require.Len(t, req.Compute, 1)
+	assert.Equal(t, "pc75", string(req.Compute[0].Aggregation))
+	assert.Equal(t, "@view.loading_time", *req.Compute[0].Metric)
+	assert.Equal(t, "1m", *req.Compute[0].Interval)
+	assert.Equal(t, "@type:view", *req.Filter.Query)
+	require.Len(t, req.GroupBy, 1)
+	assert.Equal(t, int64(5), *req.GroupBy[0].Limit)
+	assert.Equal(t, "@view.loading_time", *req.GroupBy[0].Sort.Metric)
+
+	req = rumAggregateRequest(QueryModel{RUMAggregation: "count", RUMMetric: "@view.loading_time"}, backend.TimeRange{})
+	assert.Nil(t, req.Compute[0].Metric, "counts do not take a measure")
+	assert.Nil(t, req.Compute[0].Interval)
+	assert.Equal(t, "*", *req.Filter.Query)
+}
+
+func TestIntegration_RUMEvents(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+	now := time.Now().Truncate(time.Second)
+	srv.Enqueue(fakedatadog.EndpointRUMSearch, fakedatadog.JSON(fakedatadog.RUMEventsResponse("next-page",
+		fakedatadog.RUMEvent{Timestamp: now, Service: "shop-web", Attributes: map[string]interface{}{
+			"type":        "error",
+			"application": map[string]interface{}{"name": "Shop"},
+			"view":        map[string]interface{}{"url_path": "/cart"},
+			"session":     map[string]interface{}{"id": "s-1"},
+			"error":       map[string]interface{}{"message": "TypeError: cart is undefined"},
+		}},
+		fakedatadog.RUMEvent{Timestamp: now.Add(-time.Minute), Service: "shop-web", Attributes: map[string]interface{}{
+			"type": "view",
+			"view": map[string]interface{}{"url_path": "/", "name": "Home", "loading_time": 1.2e9},
+		}},
+	)))
+
+	resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{dataQuery("A", map[string]interface{}{"queryType": "rum", "rumQuery": "@application.name:Shop", "limit": 2})},
+	})
+	require.NoError(t, err)
+	res := resp.Responses["A"]
+	require.NoError(t, res.Error)
+	require.Len(t, res.Frames, 1)
+	frame := res.Frames[0]
+	require.Equal(t, 2, frame.Rows())
+	assert.Equal(t, data.VisType(data.VisTypeTable), frame.Meta.PreferredVisualization)
+	assert.Equal(t, []string{"error", "view"}, stringValues(fieldByName(t, frame, "type")))
+	assert.Equal(t, []string{"Shop", ""}, stringValues(fieldByName(t, frame, "application")))
+	assert.Equal(t, []string{"/cart", "/"}, stringValues(fieldByName(t, frame, "view")))
+	assert.Equal(t, []string{"TypeError: cart is undefined", "Home"}, stringValues(fieldByName(t, frame, "summary")))
+	assert.Equal(t, now.UTC(), fieldByName(t, frame, "timestamp").At(0).(time.Time).UTC())
+	assert.Contains(t, string(fieldByName(t, frame, "attributes").At(1).(json.RawMessage)), `"loading_time":1200000000`)
+	assert.Equal(t, "@application.name:Shop", frame.Meta.ExecutedQueryString)
+
+	reqs := srv.Requests(fakedatadog.EndpointRUMSearch)
+	require.Len(t, reqs, 1)
+	var body struct {
+		Filter struct {
+			Query string `json:"query"`
+		} `json:"filter"`
+		Page struct {
+			Limit int `json:"limit"`
+		} `json:"page"`
+		Sort string `json:"sort"`
+	}
+	require.NoError(t, reqs[0].DecodeBody(&body))
+	assert.Equal(t, "@application.name:Shop", body.Filter.Query)
+	assert.Equal(t, 2, body.Page.Limit)
+	assert.Equal(t, "-timestamp", body.Sort)
+}
+
+func TestIntegration_RUMAggregate(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+	now := time.Now().Truncate(time.Minute)
+	times := []time.Time{now.Add(-time.Minute), now}
+	srv.Enqueue(fakedatadog.EndpointRUMAggregate, fakedatadog.JSON(fakedatadog.RUMAggregateResponse(times,
+		fakedatadog.RUMSeries{By: map[string]interface{}{"@view.url_path": "/cart"}, Values: []*float64{fakedatadog.Points(1.5e9)[0], nil}},
+		fakedatadog.RUMSeries{By: map[string]interface{}{"@view.url_path": "/"}, Values: fakedatadog.Points(0.8e9, 0.9e9)},
+	)))
+
+	resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{dataQuery("A", map[string]interface{}{
+			"queryType":      "rum",
+			"rumQuery":       "@type:view",
+			"rumAggregation": "pc75",
+			"rumMetric":      "@view.loading_time",
+			"rumGroupBy":     []string{"@view.url_path"},
+		})},
+	})
+	require.NoError(t, err)
+	res := resp.Responses["A"]
+	require.NoError(t, res.Error)
+	require.Len(t, res.Frames, 2)
+	cart := res.Frames[0]
+	assert.Equal(t, "pc75(@view.loading_time) {@view.url_path:/cart}", cart.Name)
+	assert.Equal(t, data.Labels{"@view.url_path": "/cart"}, cart.Fields[1].Labels)
+	require.Equal(t, 2, cart.Rows())
+	assert.InDelta(t, 1.5e9, *cart.Fields[1].At(0).(*float64), 1)
+	assert.Nil(t, cart.Fields[1].At(1))
+	assert.Equal(t, "pc75(@view.loading_time) | @type:view by @view.url_path", cart.Meta.ExecutedQueryString)
+
+	var body struct {
+		Compute []struct {
+			Aggregation string `json:"aggregation"`
+			Metric      string `json:"metric"`
+			Type        string `json:"type"`
+		} `json:"compute"`
+		GroupBy []struct {
+			Facet string `json:"facet"`
+			Limit int    `json:"limit"`
+		} `json:"group_by"`
+	}
+	reqs := srv.Requests(fakedatadog.EndpointRUMAggregate)
+	require.Len(t, reqs, 1)
+	require.NoError(t, reqs[0].DecodeBody(&body))
+	require.Len(t, body.Compute, 1)
+	assert.Equal(t, "timeseries", body.Compute[0].Type)
+	assert.Equal(t, "@view.loading_time", body.Compute[0].Metric)
+	require.Len(t, body.GroupBy, 1)
+	assert.Equal(t, defaultRUMGroupLimit, body.GroupBy[0].Limit)
+
+	// Custom legends name the groups
+	srv.Enqueue(fakedatadog.EndpointRUMAggregate, fakedatadog.JSON(fakedatadog.RUMAggregateResponse(times,
+		fakedatadog.RUMSeries{By: map[string]interface{}{"@view.url_path": "/cart"}, Values: fakedatadog.Points(1, 2)},
+	)))
+	resp, err = d.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{dataQuery("A", map[string]interface{}{
+			"queryType":      "rum",
+			"rumAggregation": "count",
+			"rumGroupBy":     []string{"@view.url_path"},
+			"legendMode":     "custom",
+			"legendTemplate": "views of {{@view.url_path}}",
+		})},
+	})
+	require.NoError(t, err)
+	require.NoError(t, resp.Responses["A"].Error)
+	assert.Equal(t, "views of /cart", resp.Responses["A"].Frames[0].Name)
+}
+
+func TestIntegration_RUM_Errors(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+
+	for name, model := range map[string]map[string]interface{}{
+		"unknown aggregation": {"queryType": "rum", "rumAggregation": "p42", "rumMetric": "@view.loading_time"},
+		"missing measure":     {"queryType": "rum", "rumAggregation": "pc75"},
+		"too many events":     {"queryType": "rum", "limit": 5000},
+	} {
+		resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+			Queries: []backend.DataQuery{dataQuery("A", model)},
+		})
+		require.NoError(t, err)
+		assert.Error(t, resp.Responses["A"].Error, name)
+	}
+	assert.Zero(t, srv.Hits(fakedatadog.EndpointRUMSearch)+srv.Hits(fakedatadog.EndpointRUMAggregate))
+
+	srv.Enqueue(fakedatadog.EndpointRUMSearch, fakedatadog.Error(http.StatusForbidden, "Forbidden"))
+	resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{dataQuery("A", map[string]interface{}{"queryType": "rum"})},
+	})
+	require.NoError(t, err)
+	require.Error(t, resp.Responses["A"].Error)
+	assert.Contains(t, resp.Responses["A"].Error.Error(), "rum_apps_read")
+}
+
+func TestIntegration_RUM_Policy(t *testing.T) {
+	d, srv := newFakeBackedDatasourceWithPolicy(t)
+
+	resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{dataQuery("A", map[string]interface{}{"queryType": "rum"})},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, backend.StatusForbidden, resp.Responses["A"].Status)
+	assert.Zero(t, srv.Hits(fakedatadog.EndpointRUMSearch))
+}
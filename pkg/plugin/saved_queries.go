@@ -0,0 +1,279 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV1"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// Saved queries. GET logs/saved-views lists the saved log views of the datasource and
+// GET notebooks the Datadog notebooks, each with its searches and metric queries as query
+// models the query editors can apply as they are. Datadog's public API has no endpoint for
+// the saved views of the Log Explorer, so saved views are kept in the datasource settings.
+
+// notebooksDefaultCount and notebooksMaxCount bound the notebooks listed by one request.
+const (
+	notebooksDefaultCount = 50
+	notebooksMaxCount     = 100
+)
+
+// SavedLogView is a named logs search kept in the datasource settings, such as a saved view
+// copied from Datadog's Log Explorer.
+type SavedLogView struct {
+	Name        string             `json:"name"`
+	LogQuery    string             `json:"logQuery"`
+	Indexes     []string           `json:"indexes,omitempty"`
+	JSONParsing *JSONParsingConfig `json:"jsonParsing,omitempty"`
+}
+
+// SavedLogViewsResponse is the body of GET logs/saved-views.
+type SavedLogViewsResponse struct {
+	Views []SavedQuery `json:"views"`
+}
+
+// SavedQuery is a saved view as the query it stands for.
+type SavedQuery struct {
+	Name  string     `json:"name"`
+	Query QueryModel `json:"query"`
+}
+
+// NotebooksResponse is the body of GET notebooks: the notebooks found and what of them could
+// not be converted.
+type NotebooksResponse struct {
+	Notebooks []SavedNotebook `json:"notebooks"`
+	Warnings  []string        `json:"warnings"`
+}
+
+// SavedNotebook is a Datadog notebook with the cells that query metrics or logs.
+type SavedNotebook struct {
+	ID    int64               `json:"id"`
+	Name  string              `json:"name"`
+	Cells []SavedNotebookCell `json:"cells"`
+}
+
+// SavedNotebookCell is a notebook cell and its queries, with refIDs as in a panel.
+type SavedNotebookCell struct {
+	ID      string          `json:"id"`
+	Title   string          `json:"title"`
+	Type    string          `json:"type"`
+	Queries []grafanaTarget `json:"queries"`
+}
+
+// ddNotebooks holds the parts of a Datadog notebooks listing the conversion reads.
+type ddNotebooks struct {
+	Data []struct {
+		ID         int64 `json:"id"`
+		Attributes struct {
+			Name              string               `json:"name"`
+			Cells             []ddNotebookCell     `json:"cells"`
+			TemplateVariables []ddTemplateVariable `json:"template_variables"`
+		} `json:"attributes"`
+	} `json:"data"`
+}
+
+type ddNotebookCell struct {
+	ID         string `json:"id"`
+	Attributes struct {
+		Definition ddWidgetDefinition `json:"definition"`
+	} `json:"attributes"`
+}
+
+// savedLogViews returns the saved log views of the datasource as logs queries.
+func (d *Datasource) savedLogViews() []SavedQuery {
+	views := []SavedQuery{}
+	if d.JSONData == nil {
+		return views
+	}
+	for _, v := range d.JSONData.SavedLogViews {
+		views = append(views, SavedQuery{
+			Name: v.Name,
+			Query: QueryModel{
+				QueryType:   "logs",
+				LogQuery:    v.LogQuery,
+				Indexes:     v.Indexes,
+				JSONParsing: v.JSONParsing,
+			},
+		})
+	}
+	return views
+}
+
+// convertNotebooks converts the cells of Datadog notebooks into queries for the datasource
+// with the given UID. Markdown cells are left out; cells of other kinds are warned about.
+func convertNotebooks(dd ddNotebooks, datasourceUID string) ([]SavedNotebook, []string) {
+	var warnings []string
+	notebooks := []SavedNotebook{}
+	for _, n := range dd.Data {
+		c := &dashboardConverter{
+			datasource: &grafanaDatasourceRef{Type: pluginID, UID: datasourceUID},
+			prefixes:   map[string]string{},
+		}
+		for _, tv := range n.Attributes.TemplateVariables {
+			c.prefixes[tv.Name] = tv.Prefix
+		}
+
+		notebook := SavedNotebook{ID: n.ID, Name: n.Attributes.Name, Cells: []SavedNotebookCell{}}
+		for _, cell := range n.Attributes.Cells {
+			def := cell.Attributes.Definition
+			title := def.Title
+			if title == "" {
+				title = def.Type
+			}
+
+			var targets []grafanaTarget
+			switch def.Type {
+			case "timeseries", "toplist", "heatmap", "distribution":
+				targets, _ = c.metricsTargets(n.Attributes.Name+" / "+title, def.Requests)
+			case "log_stream":
+				targets = []grafanaTarget{c.target("A", QueryModel{
+					QueryType: "logs",
+					LogQuery:  c.rewriteVariables(def.Query),
+					Indexes:   def.Indexes,
+				})}
+			case "markdown", "":
+				continue
+			default:
+				c.warn("notebook %q: %s cells are not supported", n.Attributes.Name, def.Type)
+				continue
+			}
+			if len(targets) == 0 {
+				continue
+			}
+			notebook.Cells = append(notebook.Cells, SavedNotebookCell{ID: cell.ID, Title: def.Title, Type: def.Type, Queries: targets})
+		}
+		notebooks = append(notebooks, notebook)
+		warnings = append(warnings, c.warnings...)
+	}
+	return notebooks, warnings
+}
+
+// SavedLogViewsHandler handles GET /logs/saved-views requests
+func (d *Datasource) SavedLogViewsHandler(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	return sendJSON(sender, SavedLogViewsResponse{Views: d.savedLogViews()})
+}
+
+// NotebooksHandler handles GET /notebooks requests. The query parameter searches notebooks
+// by name and count bounds how many are listed. Under a policy, queries it refuses are left
+// out, and so are the cells and notebooks left without queries.
+func (d *Datasource) NotebooksHandler(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	logger := log.New()
+	if ok, err := requireEditor(req, sender); !ok {
+		return err
+	}
+	params := url.Values{}
+	if u, err := url.Parse(req.URL); err == nil {
+		params = u.Query()
+	}
+	count := int64(notebooksDefaultCount)
+	if raw := params.Get("count"); raw != "" {
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || n <= 0 || n > notebooksMaxCount {
+			return sender.Send(&backend.CallResourceResponse{
+				Status: http.StatusBadRequest,
+				Body:   []byte(fmt.Sprintf(`{"error": "count must be between 1 and %d"}`, notebooksMaxCount)),
+			})
+		}
+		count = n
+	}
+
+	raw, err := d.fetchNotebooks(ctx, params.Get("query"), count)
+	if err != nil {
+		logger.Warn("Failed to list Datadog notebooks", "error", err)
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusBadGateway,
+			Body:   []byte(fmt.Sprintf(`{"error": %q}`, err.Error())),
+		})
+	}
+	var dd ddNotebooks
+	if err := json.Unmarshal(raw, &dd); err != nil {
+		return err
+	}
+	notebooks, warnings := convertNotebooks(dd, d.InstanceSettings.UID)
+	if policy := d.policy(); policy.restricts() {
+		var refused []string
+		notebooks, refused = checkNotebooks(policy, notebooks)
+		warnings = append(warnings, refused...)
+	}
+	if warnings == nil {
+		warnings = []string{}
+	}
+	return sendJSON(sender, NotebooksResponse{Notebooks: notebooks, Warnings: warnings})
+}
+
+// checkNotebooks drops the notebook queries policy refuses, then the cells and notebooks left
+// without queries.
+func checkNotebooks(policy *PolicyOptions, notebooks []SavedNotebook) ([]SavedNotebook, []string) {
+	var warnings []string
+	kept := notebooks[:0]
+	for _, nb := range notebooks {
+		cells := nb.Cells[:0]
+		for _, cell := range nb.Cells {
+			where := fmt.Sprintf("notebook %q, cell %s", nb.Name, cell.ID)
+			queries, refused := policy.checkTargets(where, cell.Queries)
+			warnings = append(warnings, refused...)
+			if !hasQuery(queries) {
+				continue
+			}
+			cell.Queries = queries
+			cells = append(cells, cell)
+		}
+		if len(cells) == 0 {
+			continue
+		}
+		nb.Cells = cells
+		kept = append(kept, nb)
+	}
+	return kept, warnings
+}
+
+// hasQuery reports whether targets query Datadog, not only combine other queries.
+func hasQuery(targets []grafanaTarget) bool {
+	for _, t := range targets {
+		if t.Type != "math" {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchNotebooks lists Datadog notebooks with their cells.
+func (d *Datasource) fetchNotebooks(ctx context.Context, query string, count int64) ([]byte, error) {
+	client, err := d.GetAPIClient()
+	if err != nil {
+		return nil, err
+	}
+	ddCtx, err := d.GetDatadogContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ddCtx, cancel := context.WithTimeout(ddCtx, 30*time.Second)
+	defer cancel()
+
+	opts := datadogV1.NewListNotebooksOptionalParameters().WithIncludeCells(true).WithCount(count)
+	if query != "" {
+		opts = opts.WithQuery(query)
+	}
+	notebooks, r, err := datadogV1.NewNotebooksApi(client).ListNotebooks(ddCtx, *opts)
+	if err != nil {
+		httpStatus, responseBody := 0, ""
+		if r != nil {
+			httpStatus = r.StatusCode
+			if r.Body != nil {
+				bodyBytes, _ := io.ReadAll(r.Body)
+				responseBody = string(bodyBytes)
+			}
+		}
+		return nil, errors.New(d.parseDatadogError(err, httpStatus, responseBody))
+	}
+	return json.Marshal(notebooks)
+}
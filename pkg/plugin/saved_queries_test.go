@@ -0,0 +1,170 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wasilak/grafana-datadog-datasource/pkg/plugin/fakedatadog"
+)
+
+// notebooksListing is a Datadog notebooks listing with cells of each kind.
+const notebooksListing = `{"data": [{
+  "id": 1234,
+  "type": "notebooks",
+  "attributes": {
+    "name": "Checkout incident",
+    "template_variables": [{"name": "env", "prefix": "env", "default": "prod"}],
+    "cells": [
+      {"id": "a1", "type": "notebook_cells", "attributes": {"definition": {"type": "markdown", "text": "# Timeline"}}},
+      {"id": "b2", "type": "notebook_cells", "attributes": {"definition": {"type": "timeseries", "title": "Error rate", "requests": [{
+        "queries": [
+          {"data_source": "metrics", "name": "query1", "query": "sum:trace.http.request.errors{$env}.as_count()"},
+          {"data_source": "metrics", "name": "query2", "query": "sum:trace.http.request.hits{$env}.as_count()"}
+        ],
+        "formulas": [{"formula": "query1 / query2", "alias": "Error rate"}]
+      }]}}},
+      {"id": "c3", "type": "notebook_cells", "attributes": {"definition": {"type": "toplist", "requests": [{"q": "top(avg:system.cpu.user{*} by {host}, 10, 'mean', 'desc')"}]}}},
+      {"id": "d4", "type": "notebook_cells", "attributes": {"definition": {"type": "log_stream", "title": "Errors", "query": "service:checkout status:error", "indexes": ["main"]}}},
+      {"id": "e5", "type": "notebook_cells", "attributes": {"definition": {"type": "slo", "title": "Checkout SLO"}}}
+    ]
+  }
+}]}`
+
+func TestConvertNotebooks(t *testing.T) {
+	var dd ddNotebooks
+	require.NoError(t, json.Unmarshal([]byte(notebooksListing), &dd))
+	notebooks, warnings := convertNotebooks(dd, "ds-uid")
+
+	require.Len(t, notebooks, 1)
+	nb := notebooks[0]
+	assert.Equal(t, int64(1234), nb.ID)
+	assert.Equal(t, "Checkout incident", nb.Name)
+	require.Len(t, nb.Cells, 3, "markdown and unsupported cells are left out")
+
+	series := nb.Cells[0]
+	assert.Equal(t, "b2", series.ID)
+	assert.Equal(t, "timeseries", series.Type)
+	require.Len(t, series.Queries, 3)
+	assert.Equal(t, "sum:trace.http.request.errors{env:$env}.as_count()", series.Queries[0].QueryText)
+	assert.Equal(t, "math", series.Queries[2].Type)
+	assert.Equal(t, "$A / $B", series.Queries[2].Expression)
+	assert.Equal(t, "Error rate", series.Queries[2].LegendTemplate)
+	assert.Equal(t, "ds-uid", series.Queries[0].Datasource.UID)
+
+	assert.Equal(t, "top(avg:system.cpu.user{*} by {host}, 10, 'mean', 'desc')", nb.Cells[1].Queries[0].QueryText)
+
+	logs := nb.Cells[2].Queries[0]
+	assert.Equal(t, "logs", logs.QueryType)
+	assert.Equal(t, "service:checkout status:error", logs.LogQuery)
+	assert.Equal(t, []string{"main"}, logs.Indexes)
+
+	assert.Len(t, warnings, 2)
+	assert.Contains(t, warnings, `notebook "Checkout incident": slo cells are not supported`)
+}
+
+func TestIntegration_Notebooks(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+	var listing interface{}
+	require.NoError(t, json.Unmarshal([]byte(notebooksListing), &listing))
+	srv.Enqueue(fakedatadog.EndpointNotebooksList, fakedatadog.JSON(listing))
+
+	resp := callResourceAsEditor(t, d, "GET", "notebooks", nil)
+	require.Equal(t, http.StatusOK, resp.Status, string(resp.Body))
+	var got NotebooksResponse
+	require.NoError(t, json.Unmarshal(resp.Body, &got))
+	require.Len(t, got.Notebooks, 1)
+	assert.Len(t, got.Notebooks[0].Cells, 3)
+
+	reqs := srv.Requests(fakedatadog.EndpointNotebooksList)
+	require.Len(t, reqs, 1)
+	assert.Equal(t, "true", reqs[0].Query.Get("include_cells"))
+	assert.Equal(t, "50", reqs[0].Query.Get("count"))
+}
+
+func TestIntegration_Notebooks_Errors(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+
+	resp := callResourceAsEditor(t, d, "GET", "notebooks", nil)
+	require.Equal(t, http.StatusOK, resp.Status)
+	assert.JSONEq(t, `{"notebooks": [], "warnings": []}`, string(resp.Body))
+
+	srv.Enqueue(fakedatadog.EndpointNotebooksList, fakedatadog.Error(http.StatusForbidden, "Forbidden"))
+	resp = callResourceAsEditor(t, d, "GET", "notebooks", nil)
+	assert.Equal(t, http.StatusBadGateway, resp.Status)
+
+	resp = callCacheResource(t, d, orgEditorRole, "GET", "notebooks", "notebooks?count=500", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.Status)
+}
+
+func TestIntegration_Notebooks_Access(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+	for _, role := range []string{"", "Viewer"} {
+		assert.Equal(t, http.StatusForbidden, callCacheResource(t, d, role, "GET", "notebooks", "notebooks", nil).Status, role)
+	}
+	assert.Zero(t, srv.Hits(fakedatadog.EndpointNotebooksList))
+
+	// Under a policy, refused queries are left out with the cells they leave empty
+	d, srv = newFakeBackedDatasourceWithPolicy(t)
+	var listing interface{}
+	require.NoError(t, json.Unmarshal([]byte(notebooksListing), &listing))
+	srv.Enqueue(fakedatadog.EndpointNotebooksList, fakedatadog.JSON(listing))
+
+	resp := callResourceAsEditor(t, d, "GET", "notebooks", nil)
+	require.Equal(t, http.StatusOK, resp.Status, string(resp.Body))
+	var got NotebooksResponse
+	require.NoError(t, json.Unmarshal(resp.Body, &got))
+	require.Len(t, got.Notebooks, 1)
+	cells := got.Notebooks[0].Cells
+	require.Len(t, cells, 1, "only the system.cpu cell has queries the policy allows")
+	assert.Equal(t, "c3", cells[0].ID)
+	_, err := testPolicy.scopeMetricsQuery(cells[0].Queries[0].QueryText)
+	assert.NoError(t, err)
+	assert.Contains(t, got.Warnings, `notebook "Checkout incident", cell d4: query A left out: not allowed by the datasource policy: log index "main"`)
+
+	// Notebooks left without cells are not listed
+	srv.Enqueue(fakedatadog.EndpointNotebooksList, fakedatadog.JSON(map[string]interface{}{"data": []interface{}{
+		map[string]interface{}{"id": 1, "type": "notebooks", "attributes": map[string]interface{}{
+			"name": "Payments", "cells": []interface{}{map[string]interface{}{"id": "a1", "type": "notebook_cells",
+				"attributes": map[string]interface{}{"definition": map[string]interface{}{"type": "timeseries", "requests": []interface{}{
+					map[string]interface{}{"q": "avg:system.mem.used{*}"},
+				}}}}},
+		}},
+	}}))
+	resp = callResourceAsEditor(t, d, "GET", "notebooks", nil)
+	require.Equal(t, http.StatusOK, resp.Status, string(resp.Body))
+	require.NoError(t, json.Unmarshal(resp.Body, &got))
+	assert.Empty(t, got.Notebooks)
+	assert.Len(t, got.Warnings, 1)
+}
+
+func TestSavedLogViews(t *testing.T) {
+	inst, err := NewDatasource(context.Background(), backend.DataSourceInstanceSettings{
+		UID: "saved-views",
+		JSONData: []byte(`{"site": "datadoghq.com", "savedLogViews": [
+			{"name": "Checkout errors", "logQuery": "service:checkout status:error", "indexes": ["main"],
+			 "jsonParsing": {"enabled": true, "targetField": "message"}}
+		]}`),
+	})
+	require.NoError(t, err)
+	d := inst.(*Datasource)
+	t.Cleanup(d.Dispose)
+
+	resp := callResource(t, d, "GET", "logs/saved-views", nil)
+	require.Equal(t, http.StatusOK, resp.Status)
+	var got SavedLogViewsResponse
+	require.NoError(t, json.Unmarshal(resp.Body, &got))
+	require.Len(t, got.Views, 1)
+	view := got.Views[0]
+	assert.Equal(t, "Checkout errors", view.Name)
+	assert.Equal(t, "logs", view.Query.QueryType)
+	assert.Equal(t, "service:checkout status:error", view.Query.LogQuery)
+	assert.Equal(t, []string{"main"}, view.Query.Indexes)
+	require.NotNil(t, view.Query.JSONParsing)
+	assert.Equal(t, "message", view.Query.JSONParsing.TargetField)
+}
@@ -0,0 +1,265 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Plugin self-metrics. They are registered on the default Prometheus registry, which the SDK
+// serves through the backend CollectMetrics call, so they show up at Grafana's
+// /api/plugins/wasilak-datadog-datasource/metrics endpoint next to the SDK's own metrics.
+const (
+	metricsNamespace = "grafana_plugin"
+	metricsSubsystem = "datadog"
+)
+
+// Cache names used as the "cache" label value.
+const (
+	cacheAutocomplete     = "autocomplete"
+	cacheLogs             = "logs"
+	cacheLogsAutocomplete = "logs_autocomplete"
+	cacheMetrics          = "metrics"
+	cacheAllTags          = "all_tags"
+	cacheMetricMetadata   = "metric_metadata"
+)
+
+// Cache lookup results used as the "result" label value. A partial result means part of
+// the answer came from cache and the rest was fetched from Datadog.
+const (
+	cacheResultHit     = "hit"
+	cacheResultPartial = "partial"
+	cacheResultMiss    = "miss"
+)
+
+var (
+	datadogRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "api_requests_total",
+		Help:      "Total number of HTTP requests sent to the Datadog API, by endpoint and response status.",
+	}, []string{"endpoint", "status"})
+
+	datadogRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "api_request_duration_seconds",
+		Help:      "Latency of HTTP requests to the Datadog API, by endpoint and response status.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	}, []string{"endpoint", "status"})
+
+	datadogRateLimitedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "api_rate_limited_total",
+		Help:      "Total number of Datadog API responses with HTTP 429 (Too Many Requests), by endpoint.",
+	}, []string{"endpoint"})
+
+	datadogRetriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "api_retries_total",
+		Help:      "Total number of retried Datadog API requests, by endpoint.",
+	}, []string{"endpoint"})
+
+	datadogRequestsInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "api_requests_in_flight",
+		Help:      "Number of Datadog API requests currently in flight.",
+	})
+
+	cacheLookupsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "cache_lookups_total",
+		Help:      "Total number of plugin cache lookups, by cache and result (hit, partial or miss).",
+	}, []string{"cache", "result"})
+
+	cacheEntries = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "cache_entries",
+		Help:      "Number of entries in the plugin caches, by cache.",
+	}, []string{"cache"})
+
+	cacheSizeBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "cache_size_bytes",
+		Help:      "Total size of the values stored in the plugin caches, by cache.",
+	}, []string{"cache"})
+
+	cacheEvictionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "cache_evictions_total",
+		Help:      "Total number of entries evicted from the plugin caches to stay within their size limits, by cache.",
+	}, []string{"cache"})
+
+	coalescedRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "coalesced_requests_total",
+		Help:      "Total number of calls served by an identical in-flight call instead of a new Datadog request, by kind.",
+	}, []string{"kind"})
+
+	credentialFailoversTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "credential_failovers_total",
+		Help:      "Total number of times Datadog rejected the active key pair and the other pair was promoted, by promoted key pair.",
+	}, []string{"key_pair"})
+
+	queryLimitHitsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "query_limit_hits_total",
+		Help:      "Total number of queries and requests refused or truncated by a query cost guardrail, by limit.",
+	}, []string{"limit"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		datadogRequestsTotal,
+		datadogRequestDuration,
+		datadogRateLimitedTotal,
+		datadogRetriesTotal,
+		datadogRequestsInFlight,
+		cacheLookupsTotal,
+		cacheEntries,
+		cacheSizeBytes,
+		cacheEvictionsTotal,
+		coalescedRequestsTotal,
+		credentialFailoversTotal,
+		queryLimitHitsTotal,
+	)
+}
+
+// recordCacheLookupResult counts a cache lookup with the given result for the named cache.
+func recordCacheLookupResult(cache, result string) {
+	cacheLookupsTotal.WithLabelValues(cache, result).Inc()
+}
+
+// datadogEndpointLabel maps a Datadog API path onto a bounded endpoint label.
+// Metric names and IDs embedded in paths are dropped so label cardinality stays fixed,
+// and matching by suffix keeps working behind a base URL path prefix.
+func datadogEndpointLabel(path string) string {
+	switch {
+	case strings.HasSuffix(path, "/api/v2/query/timeseries"):
+		return "timeseries_query"
+	case strings.HasSuffix(path, "/api/v2/query/scalar"):
+		return "scalar_query"
+	case strings.HasSuffix(path, "/api/v2/logs/events/search"):
+		return "logs_search"
+	case strings.HasSuffix(path, "/api/v2/logs/analytics/aggregate"), strings.HasSuffix(path, "/api/v2/logs/aggregate"):
+		return "logs_aggregate"
+	case strings.HasSuffix(path, "/api/v1/metrics"):
+		return "metrics_list"
+	case strings.HasSuffix(path, "/api/v2/metrics"):
+		return "tag_configurations"
+	case strings.Contains(path, "/api/v2/metrics/") && strings.HasSuffix(path, "/all-tags"):
+		return "tags_by_metric"
+	case strings.Contains(path, "/api/v1/metrics/"):
+		return "metric_metadata"
+	case strings.Contains(path, "/api/v1/dashboard/"):
+		return "dashboard"
+	case strings.HasSuffix(path, "/api/v1/monitor"):
+		return "monitors_list"
+	case strings.Contains(path, "/api/v1/monitor/"):
+		return "monitor"
+	case strings.HasSuffix(path, "/api/v1/notebooks"):
+		return "notebooks_list"
+	case strings.HasSuffix(path, "/api/v2/rum/events/search"):
+		return "rum_search"
+	case strings.HasSuffix(path, "/api/v2/rum/analytics/aggregate"):
+		return "rum_aggregate"
+	case strings.HasSuffix(path, "/api/v1/service_dependencies"):
+		return "service_dependencies"
+	case strings.HasSuffix(path, "/api/v2/services/definitions"):
+		return "service_definitions"
+	default:
+		return "other"
+	}
+}
+
+type retryAttemptKey struct{}
+
+// withRetryAttempt marks ctx so that a request made with it is counted as a retry of an
+// earlier request in the api_retries_total metric.
+func withRetryAttempt(ctx context.Context) context.Context {
+	return context.WithValue(ctx, retryAttemptKey{}, true)
+}
+
+// instrumentedTransport is an http.RoundTripper that records request counts, latency,
+// 429s and in-flight requests for every call made to the Datadog API. Each call also
+// gets a client span, and the trace context is propagated in the outgoing headers.
+type instrumentedTransport struct {
+	next http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	endpoint := datadogEndpointLabel(req.URL.Path)
+
+	ctx, span := tracing.DefaultTracer().Start(req.Context(), "datadog.api "+endpoint,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attrEndpoint.String(endpoint),
+			attribute.String("http.request.method", req.Method),
+			attribute.String("server.address", req.URL.Hostname()),
+			attribute.String("url.path", req.URL.Path),
+		))
+	defer span.End()
+
+	// RoundTrippers must not modify the caller's request, so inject into a clone.
+	req = req.Clone(ctx)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	if retry, _ := ctx.Value(retryAttemptKey{}).(bool); retry {
+		datadogRetriesTotal.WithLabelValues(endpoint).Inc()
+	}
+	datadogRequestsInFlight.Inc()
+	defer datadogRequestsInFlight.Dec()
+
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	elapsed := time.Since(start).Seconds()
+
+	status := "error"
+	if err != nil {
+		_ = tracing.Error(span, err)
+	} else {
+		status = strconv.Itoa(resp.StatusCode)
+		span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+		if resp.StatusCode >= 400 {
+			span.SetStatus(codes.Error, resp.Status)
+		}
+		if resp.StatusCode == http.StatusTooManyRequests {
+			datadogRateLimitedTotal.WithLabelValues(endpoint).Inc()
+		}
+	}
+	datadogRequestsTotal.WithLabelValues(endpoint, status).Inc()
+	datadogRequestDuration.WithLabelValues(endpoint, status).Observe(elapsed)
+
+	return resp, err
+}
+
+// newDatadogHTTPClient returns an HTTP client for Datadog API calls whose requests are
+// traced and reflected in the plugin self-metrics. A zero timeout leaves deadlines to the request context.
+func newDatadogHTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &instrumentedTransport{next: http.DefaultTransport},
+	}
+}
@@ -0,0 +1,93 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wasilak/grafana-datadog-datasource/pkg/plugin/fakedatadog"
+)
+
+func TestDatadogEndpointLabel(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/api/v2/query/timeseries", "timeseries_query"},
+		{"/api/v2/logs/events/search", "logs_search"},
+		{"/api/v2/logs/analytics/aggregate", "logs_aggregate"},
+		{"/api/v1/metrics", "metrics_list"},
+		{"/api/v2/metrics", "tag_configurations"},
+		{"/api/v2/metrics/system.cpu.user/all-tags", "tags_by_metric"},
+		{"/api/v2/query/scalar", "scalar_query"},
+		{"/api/v1/metrics/system.cpu.user", "metric_metadata"},
+		{"/api/v1/dashboard/abc-def-ghi", "dashboard"},
+		{"/api/v1/monitor", "monitors_list"},
+		{"/api/v1/monitor/101", "monitor"},
+		{"/api/v1/notebooks", "notebooks_list"},
+		{"/api/v2/rum/events/search", "rum_search"},
+		{"/api/v2/rum/analytics/aggregate", "rum_aggregate"},
+		{"/api/v1/service_dependencies", "service_dependencies"},
+		{"/api/v2/services/definitions", "service_definitions"},
+		{"/datadog/api/v2/query/timeseries", "timeseries_query"},
+		{"/api/v1/validate", "other"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			assert.Equal(t, tt.want, datadogEndpointLabel(tt.path))
+		})
+	}
+}
+
+func TestSelfMetrics_RequestsAndRateLimits(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+	srv.Enqueue(fakedatadog.EndpointTimeseriesQuery, fakedatadog.RateLimited(time.Minute))
+
+	okBefore := testutil.ToFloat64(datadogRequestsTotal.WithLabelValues("timeseries_query", "200"))
+	throttledBefore := testutil.ToFloat64(datadogRequestsTotal.WithLabelValues("timeseries_query", "429"))
+	rateLimitedBefore := testutil.ToFloat64(datadogRateLimitedTotal.WithLabelValues("timeseries_query"))
+
+	for i := 0; i < 2; i++ {
+		_, err := d.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, okBefore+1, testutil.ToFloat64(datadogRequestsTotal.WithLabelValues("timeseries_query", "200")))
+	assert.Equal(t, throttledBefore+1, testutil.ToFloat64(datadogRequestsTotal.WithLabelValues("timeseries_query", "429")))
+	assert.Equal(t, rateLimitedBefore+1, testutil.ToFloat64(datadogRateLimitedTotal.WithLabelValues("timeseries_query")))
+	assert.Zero(t, testutil.ToFloat64(datadogRequestsInFlight))
+}
+
+func TestSelfMetrics_Retries(t *testing.T) {
+	d, _ := newFakeBackedDatasourceWithKeys(t, expiredKeys, validKeys)
+	retriesBefore := testutil.ToFloat64(datadogRetriesTotal.WithLabelValues("timeseries_query"))
+
+	resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{dataQuery("A", map[string]interface{}{"queryText": "avg:system.cpu.user{*}"})},
+	})
+	require.NoError(t, err)
+	require.NoError(t, resp.Responses["A"].Error)
+
+	assert.Equal(t, retriesBefore+1, testutil.ToFloat64(datadogRetriesTotal.WithLabelValues("timeseries_query")),
+		"the request retried with the other key pair is counted")
+}
+
+func TestSelfMetrics_CacheLookups(t *testing.T) {
+	d, _ := newFakeBackedDatasource(t)
+
+	hitsBefore := testutil.ToFloat64(cacheLookupsTotal.WithLabelValues(cacheAutocomplete, "hit"))
+	missesBefore := testutil.ToFloat64(cacheLookupsTotal.WithLabelValues(cacheAutocomplete, "miss"))
+
+	callResource(t, d, http.MethodGet, "autocomplete/metrics", nil)
+	callResource(t, d, http.MethodGet, "autocomplete/metrics", nil)
+
+	assert.Equal(t, missesBefore+1, testutil.ToFloat64(cacheLookupsTotal.WithLabelValues(cacheAutocomplete, "miss")))
+	assert.Equal(t, hitsBefore+1, testutil.ToFloat64(cacheLookupsTotal.WithLabelValues(cacheAutocomplete, "hit")))
+}
@@ -0,0 +1,149 @@
+package plugin
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Top-N series of metrics queries. The limit and order of a query are sent to Datadog as a
+// formula limit, so Datadog truncates the series before returning them. The query itself is
+// left as written: wrapping it in top() would change the series its name stands for in
+// formulas. Datadog ranks formula series by their average; other order-by values, and any
+// series Datadog returns beyond the limit, are ranked and truncated by the plugin.
+
+// Orders of the series of a query with a limit.
+const (
+	orderDesc = "desc"
+	orderAsc  = "asc"
+)
+
+// seriesReducers are the order-by options: the values series are ranked by.
+var seriesReducers = map[string]bool{
+	"avg":  true,
+	"max":  true,
+	"min":  true,
+	"last": true,
+	"sum":  true,
+}
+
+// topFunctionPattern matches queries that already call a top-N function.
+var topFunctionPattern = regexp.MustCompile(`(?i)\b(top|bottom)\w*\s*\(`)
+
+// validateSeriesLimit reports invalid limit, order and order-by options of a query.
+func validateSeriesLimit(qm *QueryModel) error {
+	if qm.Limit < 0 {
+		return fmt.Errorf("limit must not be negative, got %d", qm.Limit)
+	}
+	if qm.Order != "" && qm.Order != orderDesc && qm.Order != orderAsc {
+		return fmt.Errorf("unknown order %q", qm.Order)
+	}
+	if qm.OrderBy != "" && !seriesReducers[qm.OrderBy] {
+		return fmt.Errorf("unknown orderBy %q", qm.OrderBy)
+	}
+	return nil
+}
+
+// seriesOrder returns the order and order-by options of a query with their defaults.
+func seriesOrder(qm QueryModel) (order, by string) {
+	order, by = qm.Order, qm.OrderBy
+	if order == "" {
+		order = orderDesc
+	}
+	if by == "" {
+		by = "avg"
+	}
+	return order, by
+}
+
+// formulaLimit returns the limit of a query as Datadog applies it to a formula, or nil when
+// the query has no limit or ranks its series by something other than their average.
+func formulaLimit(qm QueryModel) *datadogV2.FormulaLimit {
+	order, by := seriesOrder(qm)
+	if qm.Limit <= 0 || by != "avg" {
+		return nil
+	}
+	count := int32(qm.Limit)
+	sortOrder := datadogV2.QUERYSORTORDER_DESC
+	if order == orderAsc {
+		sortOrder = datadogV2.QUERYSORTORDER_ASC
+	}
+	return &datadogV2.FormulaLimit{Count: &count, Order: &sortOrder}
+}
+
+// limitFormulas returns one formula per query, in refID order so that series keep their query
+// index, when any of the queries has a limit Datadog can apply. It returns nil otherwise, and
+// the request is sent without formulas.
+func limitFormulas(queryModels map[string]QueryModel) []datadogV2.QueryFormula {
+	var formulas []datadogV2.QueryFormula
+	limited := false
+	for _, refID := range sortedRefIDs(queryModels) {
+		limit := formulaLimit(queryModels[refID])
+		limited = limited || limit != nil
+		formulas = append(formulas, datadogV2.QueryFormula{Formula: refID, Limit: limit})
+	}
+	if !limited {
+		return nil
+	}
+	return formulas
+}
+
+// topSeries sorts the series frames of a query with a limit by their order-by value and keeps
+// the first limit of them. Frames of queries without a limit are returned as they are.
+func topSeries(frames data.Frames, qm QueryModel) data.Frames {
+	if qm.Limit <= 0 {
+		return frames
+	}
+	order, by := seriesOrder(qm)
+	values := make(map[*data.Frame]float64, len(frames))
+	for _, frame := range frames {
+		values[frame] = reduceSeries(frame.Fields[1], by)
+	}
+	sort.SliceStable(frames, func(i, j int) bool {
+		a, b := values[frames[i]], values[frames[j]]
+		// Series without values sort last either way
+		switch {
+		case math.IsNaN(a):
+			return false
+		case math.IsNaN(b):
+			return true
+		case order == orderAsc:
+			return a < b
+		}
+		return a > b
+	})
+	if len(frames) > qm.Limit {
+		frames = frames[:qm.Limit]
+	}
+	return frames
+}
+
+// reduceSeries reduces the non-null values of a field to one value, NaN when there are none.
+func reduceSeries(field *data.Field, by string) float64 {
+	result, count := math.NaN(), 0
+	for i := 0; i < field.Len(); i++ {
+		v, err := field.NullableFloatAt(i)
+		if err != nil || v == nil {
+			continue
+		}
+		switch {
+		case count == 0, by == "last":
+			result = *v
+		case by == "max":
+			result = math.Max(result, *v)
+		case by == "min":
+			result = math.Min(result, *v)
+		default:
+			result += *v
+		}
+		count++
+	}
+	if by == "avg" && count > 0 {
+		result /= float64(count)
+	}
+	return result
+}
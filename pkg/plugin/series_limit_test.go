@@ -0,0 +1,130 @@
+package plugin
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wasilak/grafana-datadog-datasource/pkg/plugin/fakedatadog"
+)
+
+func TestValidateSeriesLimit(t *testing.T) {
+	assert.NoError(t, validateSeriesLimit(&QueryModel{Limit: 5, Order: "asc", OrderBy: "last"}))
+	assert.Error(t, validateSeriesLimit(&QueryModel{Limit: -1}))
+	assert.Error(t, validateSeriesLimit(&QueryModel{Order: "up"}))
+	assert.Error(t, validateSeriesLimit(&QueryModel{OrderBy: "median"}))
+}
+
+func TestTopSeries(t *testing.T) {
+	series := func(name string, values ...*float64) *data.Frame {
+		times := make([]time.Time, len(values))
+		return data.NewFrame(name, data.NewField("Time", nil, times), data.NewField("Value", nil, values))
+	}
+	names := func(frames data.Frames) []string {
+		var out []string
+		for _, f := range frames {
+			out = append(out, f.Name)
+		}
+		return out
+	}
+	frames := func() data.Frames {
+		return data.Frames{
+			series("a", ptr(1), ptr(9)),
+			series("b", ptr(4), ptr(4)),
+			series("c", nil, nil),
+			series("d", ptr(6), ptr(2)),
+		}
+	}
+
+	assert.Equal(t, []string{"a", "b", "c", "d"}, names(topSeries(frames(), QueryModel{})))
+	assert.Equal(t, []string{"a", "b"}, names(topSeries(frames(), QueryModel{Limit: 2})))
+	assert.Equal(t, []string{"a", "d"}, names(topSeries(frames(), QueryModel{Limit: 2, OrderBy: "max"})))
+	assert.Equal(t, []string{"d", "b", "a"}, names(topSeries(frames(), QueryModel{Limit: 3, Order: "asc", OrderBy: "last"})))
+	assert.Equal(t, []string{"a", "b", "d", "c"}, names(topSeries(frames(), QueryModel{Limit: 10, OrderBy: "sum"})), "ties keep their order")
+
+	assert.True(t, math.IsNaN(reduceSeries(frames()[2].Fields[1], "avg")))
+	assert.Equal(t, 8.0, reduceSeries(frames()[3].Fields[1], "sum"))
+}
+
+func TestIntegration_SeriesLimit(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+
+	now := time.Now().Truncate(time.Minute).UnixMilli()
+	srv.Enqueue(fakedatadog.EndpointTimeseriesQuery, fakedatadog.JSON(fakedatadog.TimeseriesResponse(
+		[]int64{now - 60000, now},
+		fakedatadog.Series{GroupTags: []string{"host:web-01"}, Values: fakedatadog.Points(1, 2)},
+		fakedatadog.Series{GroupTags: []string{"host:web-02"}, Values: fakedatadog.Points(8, 9)},
+		fakedatadog.Series{GroupTags: []string{"host:web-03"}, Values: fakedatadog.Points(5, 5)},
+	)))
+	resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{dataQuery("A", map[string]interface{}{
+			"queryText": "avg:system.cpu.user{*} by {host}", "limit": 2,
+		})},
+	})
+	require.NoError(t, err)
+	res := resp.Responses["A"]
+	require.NoError(t, res.Error)
+	require.Len(t, res.Frames, 2)
+	assert.Equal(t, "web-02", res.Frames[0].Fields[1].Labels["host"])
+	assert.Equal(t, "web-03", res.Frames[1].Fields[1].Labels["host"])
+
+	reqs := srv.Requests(fakedatadog.EndpointTimeseriesQuery)
+	require.Len(t, reqs, 1)
+	var body datadogV2.TimeseriesFormulaQueryRequest
+	require.NoError(t, reqs[0].DecodeBody(&body))
+	assert.Equal(t, "avg:system.cpu.user{*} by {host}", body.Data.Attributes.Queries[0].MetricsTimeseriesQuery.Query,
+		"the limit is sent as a formula limit, not by rewriting the query")
+	require.Len(t, body.Data.Attributes.Formulas, 1)
+	assert.Equal(t, "A", body.Data.Attributes.Formulas[0].Formula)
+	require.NotNil(t, body.Data.Attributes.Formulas[0].Limit)
+	assert.Equal(t, int32(2), body.Data.Attributes.Formulas[0].Limit.GetCount())
+	assert.Equal(t, datadogV2.QUERYSORTORDER_DESC, body.Data.Attributes.Formulas[0].Limit.GetOrder())
+	assert.Equal(t, "avg:system.cpu.user{*} by {host}", res.Frames[0].Meta.ExecutedQueryString)
+}
+
+func TestLimitFormulas(t *testing.T) {
+	assert.Nil(t, limitFormulas(map[string]QueryModel{"A": {}, "B": {}}), "no limit, no formulas")
+	assert.Nil(t, limitFormulas(map[string]QueryModel{"A": {Limit: 5, OrderBy: "max"}}),
+		"Datadog ranks formula series by their average; other reducers are left to the plugin")
+
+	formulas := limitFormulas(map[string]QueryModel{"B": {Limit: 3, Order: "asc"}, "A": {}})
+	require.Len(t, formulas, 2)
+	assert.Equal(t, "A", formulas[0].Formula)
+	assert.Nil(t, formulas[0].Limit)
+	assert.Equal(t, "B", formulas[1].Formula)
+	assert.Equal(t, int32(3), formulas[1].Limit.GetCount())
+	assert.Equal(t, datadogV2.QUERYSORTORDER_ASC, formulas[1].Limit.GetOrder())
+}
+
+func TestIntegration_SeriesLimit_Formulas(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+
+	resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{
+			dataQuery("A", map[string]interface{}{"queryText": "sum:trace.http.request.errors{*} by {service}", "limit": 3}),
+			dataQuery("B", map[string]interface{}{"queryText": "sum:trace.http.request.hits{*} by {service}"}),
+			dataQuery("C", map[string]interface{}{"type": "math", "expression": "$A / $B", "limit": 5}),
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, resp.Responses["A"].Error)
+
+	reqs := srv.Requests(fakedatadog.EndpointTimeseriesQuery)
+	require.Len(t, reqs, 1)
+	var body datadogV2.TimeseriesFormulaQueryRequest
+	require.NoError(t, reqs[0].DecodeBody(&body))
+	require.Len(t, body.Data.Attributes.Queries, 2)
+	assert.Equal(t, "A", body.Data.Attributes.Queries[0].MetricsTimeseriesQuery.GetName())
+	assert.Equal(t, "sum:trace.http.request.errors{*} by {service}", body.Data.Attributes.Queries[0].MetricsTimeseriesQuery.Query,
+		"A stands for all its series in the formula")
+	require.Len(t, body.Data.Attributes.Formulas, 1)
+	assert.Equal(t, "A / B", body.Data.Attributes.Formulas[0].Formula)
+	assert.Equal(t, int32(5), body.Data.Attributes.Formulas[0].Limit.GetCount(), "the formula's own limit is sent to Datadog")
+}
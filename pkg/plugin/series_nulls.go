@@ -0,0 +1,114 @@
+package plugin
+
+import (
+	"time"
+)
+
+// Null handling of metrics series. Datadog returns a point per rollup interval for every
+// series, null where the interval has no data. Dropping those points makes Grafana draw a
+// straight line across an outage, so queries choose what a null becomes. What it means
+// depends on the metric: a count without points counted nothing, a gauge without points
+// is missing data.
+
+const (
+	// nullHandlingAuto picks zero for count and rate metrics, gaps for other metrics with
+	// metadata, and drop when the metric type is not known
+	nullHandlingAuto = "auto"
+	// nullHandlingDrop leaves null points out of the frame
+	nullHandlingDrop = "drop"
+	// nullHandlingNull keeps null points as nulls of a nullable field
+	nullHandlingNull = "null"
+	// nullHandlingZero replaces null points with 0
+	nullHandlingZero = "zero"
+	// nullHandlingPrevious replaces null points with the last value before them
+	nullHandlingPrevious = "previous"
+	// nullHandlingGaps keeps one null per run of null points, so the graph breaks there
+	nullHandlingGaps = "gaps"
+)
+
+// validNullHandling reports whether mode is a null handling a query may ask for.
+func validNullHandling(mode string) bool {
+	switch mode {
+	case "", nullHandlingAuto, nullHandlingDrop, nullHandlingNull, nullHandlingZero, nullHandlingPrevious, nullHandlingGaps:
+		return true
+	}
+	return false
+}
+
+// resolveNullHandling returns the null handling of a query, deciding "auto" from the
+// type of its metric. Queries that do not choose one drop nulls, as before null handling
+// existed.
+func resolveNullHandling(mode string, md *metricMetadata) string {
+	switch {
+	case mode == "":
+		return nullHandlingDrop
+	case mode != nullHandlingAuto:
+		return mode
+	case md == nil:
+		return nullHandlingDrop
+	}
+	switch md.Type {
+	case "count", "rate":
+		return nullHandlingZero
+	case "gauge", "distribution":
+		return nullHandlingGaps
+	}
+	return nullHandlingDrop
+}
+
+// seriesValues builds the time and value columns of a series under a null handling. Values
+// are []float64 for drop and zero, which leave no nulls, and []*float64 otherwise. It
+// returns the number of non-null points and the number of null points dropped.
+func seriesValues(times []int64, points []*float64, mode string) (timeValues []time.Time, values interface{}, valid, dropped int) {
+	n := len(times)
+	if len(points) < n {
+		n = len(points)
+	}
+
+	switch mode {
+	case nullHandlingZero, nullHandlingDrop:
+		numbers := make([]float64, 0, n)
+		for j := 0; j < n; j++ {
+			switch {
+			case points[j] != nil:
+				numbers = append(numbers, *points[j])
+				valid++
+			case mode == nullHandlingZero:
+				numbers = append(numbers, 0)
+			default:
+				dropped++
+				continue
+			}
+			timeValues = append(timeValues, time.UnixMilli(times[j]))
+		}
+		return timeValues, numbers, valid, dropped
+	}
+
+	nullable := make([]*float64, 0, n)
+	var previous *float64
+	inGap := false
+	for j := 0; j < n; j++ {
+		point := points[j]
+		if point != nil {
+			v := *point
+			point = &v
+			previous = point
+			inGap = false
+			valid++
+		} else {
+			switch mode {
+			case nullHandlingPrevious:
+				point = previous
+			case nullHandlingGaps:
+				// One null per run marks the gap; leading nulls mark nothing
+				if inGap || previous == nil {
+					continue
+				}
+				inGap = true
+			}
+		}
+		timeValues = append(timeValues, time.UnixMilli(times[j]))
+		nullable = append(nullable, point)
+	}
+	return timeValues, nullable, valid, dropped
+}
@@ -0,0 +1,110 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wasilak/grafana-datadog-datasource/pkg/plugin/fakedatadog"
+)
+
+func ptr(v float64) *float64 {
+	return &v
+}
+
+func TestResolveNullHandling(t *testing.T) {
+	assert.Equal(t, nullHandlingDrop, resolveNullHandling("", &metricMetadata{Type: "count"}), "auto is only used when chosen")
+	assert.Equal(t, nullHandlingDrop, resolveNullHandling("", &metricMetadata{Type: "gauge"}))
+	assert.Equal(t, nullHandlingZero, resolveNullHandling("auto", &metricMetadata{Type: "count"}))
+	assert.Equal(t, nullHandlingZero, resolveNullHandling("auto", &metricMetadata{Type: "rate"}))
+	assert.Equal(t, nullHandlingGaps, resolveNullHandling("auto", &metricMetadata{Type: "gauge"}))
+	assert.Equal(t, nullHandlingDrop, resolveNullHandling("auto", &metricMetadata{}))
+	assert.Equal(t, nullHandlingDrop, resolveNullHandling("auto", nil))
+	assert.Equal(t, nullHandlingPrevious, resolveNullHandling("previous", &metricMetadata{Type: "count"}))
+
+	assert.True(t, validNullHandling(""))
+	assert.True(t, validNullHandling("gaps"))
+	assert.False(t, validNullHandling("linear"))
+}
+
+func TestSeriesValues(t *testing.T) {
+	times := []int64{1000, 2000, 3000, 4000, 5000, 6000}
+	points := []*float64{nil, ptr(1), nil, nil, ptr(4), nil}
+
+	millis := func(ts []time.Time) []int64 {
+		out := make([]int64, len(ts))
+		for i, t := range ts {
+			out[i] = t.UnixMilli()
+		}
+		return out
+	}
+
+	tests := []struct {
+		mode    string
+		times   []int64
+		values  interface{}
+		dropped int
+	}{
+		{nullHandlingDrop, []int64{2000, 5000}, []float64{1, 4}, 4},
+		{nullHandlingZero, times, []float64{0, 1, 0, 0, 4, 0}, 0},
+		{nullHandlingNull, times, []*float64{nil, ptr(1), nil, nil, ptr(4), nil}, 0},
+		{nullHandlingPrevious, times, []*float64{nil, ptr(1), ptr(1), ptr(1), ptr(4), ptr(4)}, 0},
+		{nullHandlingGaps, []int64{2000, 3000, 5000, 6000}, []*float64{ptr(1), nil, ptr(4), nil}, 0},
+	}
+	for _, tt := range tests {
+		timeValues, values, valid, dropped := seriesValues(times, points, tt.mode)
+		assert.Equal(t, tt.times, millis(timeValues), tt.mode)
+		assert.Equal(t, tt.values, values, tt.mode)
+		assert.Equal(t, 2, valid, tt.mode)
+		assert.Equal(t, tt.dropped, dropped, tt.mode)
+	}
+}
+
+func TestIntegration_NullHandling(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+
+	now := time.Now().Truncate(time.Minute).UnixMilli()
+	query := func(model map[string]interface{}) backend.DataResponse {
+		srv.Enqueue(fakedatadog.EndpointTimeseriesQuery, fakedatadog.JSON(fakedatadog.TimeseriesResponse(
+			[]int64{now - 120000, now - 60000, now},
+			fakedatadog.Series{GroupTags: []string{"host:web-01"}, Values: []*float64{ptr(3), nil, ptr(5)}},
+		)))
+		resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+			Queries: []backend.DataQuery{dataQuery("A", model)},
+		})
+		require.NoError(t, err)
+		res := resp.Responses["A"]
+		require.NoError(t, res.Error)
+		require.Len(t, res.Frames, 1)
+		return res
+	}
+
+	// Nulls are dropped unless the query chooses otherwise
+	res := query(map[string]interface{}{"queryText": "avg:system.cpu.user{*}"})
+	assert.Equal(t, 2, res.Frames[0].Fields[1].Len())
+
+	// With auto, gauges break at missing data
+	res = query(map[string]interface{}{"queryText": "avg:system.cpu.user{env:prod}", "nullHandling": "auto"})
+	assert.Equal(t, 3, res.Frames[0].Fields[1].Len())
+	assert.Nil(t, res.Frames[0].Fields[1].At(1))
+	assert.Empty(t, res.Frames[0].Meta.Notices)
+
+	res = query(map[string]interface{}{"queryText": "avg:system.mem.used{*}", "nullHandling": "zero"})
+	assert.Equal(t, 0.0, res.Frames[0].Fields[1].At(1))
+
+	res = query(map[string]interface{}{"queryText": "avg:system.mem.used{host:web-01}", "nullHandling": "drop"})
+	assert.Equal(t, 2, res.Frames[0].Fields[1].Len())
+	require.Len(t, res.Frames[0].Meta.Notices, 1)
+	assert.Contains(t, res.Frames[0].Meta.Notices[0].Text, "Dropped 1 null points")
+
+	resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{dataQuery("A", map[string]interface{}{"queryText": "avg:system.cpu.user{*}", "nullHandling": "linear"})},
+	})
+	require.NoError(t, err)
+	assert.Error(t, resp.Responses["A"].Error)
+	assert.Equal(t, backend.StatusBadRequest, resp.Responses["A"].Status)
+}
@@ -0,0 +1,642 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Service maps. A service-map query reads the APM service dependencies of an environment and
+// returns them as the nodes and edges frames of Grafana's node graph. Edge statistics come
+// from the trace metrics of the calling service's client spans, which are tagged with the
+// called service as peer.service; node details come from the service catalog.
+
+const (
+	// defaultServiceMapOperation is the span operation whose trace metrics give edge statistics
+	defaultServiceMapOperation = "http.client.request"
+	// serviceCatalogTTL is how long the service catalog is cached
+	serviceCatalogTTL = 5 * time.Minute
+	// serviceCatalogPageSize and serviceCatalogMaxPages bound the catalog read for a map
+	serviceCatalogPageSize = 100
+	serviceCatalogMaxPages = 10
+)
+
+// ServiceMapHandler handles service-map queries.
+type ServiceMapHandler struct {
+	datasource  *Datasource
+	reqQueries  []backend.DataQuery
+	queryModels map[string]QueryModel
+	timeRanges  map[string]backend.TimeRange
+	ddCtx       context.Context
+	metricsApi  *datadogV2.MetricsApi
+}
+
+// NewServiceMapHandler creates a new ServiceMapHandler instance
+func NewServiceMapHandler(datasource *Datasource, queries []backend.DataQuery, ddCtx context.Context, metricsApi *datadogV2.MetricsApi) *ServiceMapHandler {
+	return &ServiceMapHandler{
+		datasource:  datasource,
+		reqQueries:  queries,
+		queryModels: make(map[string]QueryModel),
+		timeRanges:  make(map[string]backend.TimeRange),
+		ddCtx:       ddCtx,
+		metricsApi:  metricsApi,
+	}
+}
+
+// serviceEdge is a call from one service to another and its statistics over the time range.
+type serviceEdge struct {
+	source, target string
+	// hits, errors and duration are totals over the time range; duration is in seconds
+	hits, errors, duration float64
+	hasStats               bool
+}
+
+// serviceDefinition holds the parts of a service catalog entry shown on a node.
+type serviceDefinition struct {
+	Service     string   `json:"dd-service"`
+	Team        string   `json:"team"`
+	Description string   `json:"description"`
+	Tier        string   `json:"tier"`
+	Lifecycle   string   `json:"lifecycle"`
+	Application string   `json:"application"`
+	Contacts    []string `json:"contacts"`
+	// Links maps each link type (runbook, doc, repo, dashboard, other) to the first link of it
+	Links map[string]string `json:"links"`
+}
+
+// ddServiceDefinitions holds the parts of a service definitions page the map reads.
+type ddServiceDefinitions struct {
+	Data []struct {
+		Attributes struct {
+			Schema struct {
+				DdService   string `json:"dd-service"`
+				Team        string `json:"team"`
+				Description string `json:"description"`
+				Tier        string `json:"tier"`
+				Lifecycle   string `json:"lifecycle"`
+				Application string `json:"application"`
+				Contacts    []struct {
+					Name    string `json:"name"`
+					Type    string `json:"type"`
+					Contact string `json:"contact"`
+				} `json:"contacts"`
+				Links []struct {
+					Name string `json:"name"`
+					Type string `json:"type"`
+					URL  string `json:"url"`
+				} `json:"links"`
+			} `json:"schema"`
+		} `json:"attributes"`
+	} `json:"data"`
+}
+
+// processQuery validates a service-map query and prepares it for execution
+func (h *ServiceMapHandler) processQuery(qm *QueryModel) error {
+	if qm.Hide {
+		return nil
+	}
+	if qm.Env == "" {
+		return fmt.Errorf("service maps need an env")
+	}
+	// Dependencies are listed for the whole organization and cannot be scoped
+	if h.datasource.policy().restricts() {
+		return violationf("service maps are not available with a policy")
+	}
+
+	// Find the corresponding backend query for RefID; identical queries take the next one
+	var refID string
+	for _, q := range h.reqQueries {
+		var tempQM QueryModel
+		if err := json.Unmarshal(q.JSON, &tempQM); err != nil {
+			continue
+		}
+		if _, taken := h.queryModels[q.RefID]; taken {
+			continue
+		}
+		if tempQM.QueryType == qm.QueryType && tempQM.Env == qm.Env && tempQM.Service == qm.Service && tempQM.Operation == qm.Operation {
+			refID = q.RefID
+			h.timeRanges[refID] = q.TimeRange
+			break
+		}
+	}
+	if refID == "" {
+		return fmt.Errorf("could not find RefID for service map query")
+	}
+	h.queryModels[refID] = *qm
+	return nil
+}
+
+// executeQueries builds the service map of each query
+func (h *ServiceMapHandler) executeQueries(ctx context.Context) (*backend.QueryDataResponse, error) {
+	response := backend.NewQueryDataResponse()
+	if len(h.queryModels) == 0 {
+		return response, nil
+	}
+
+	// h.ddCtx carries the QueryData span, so the handler span nests under it
+	spanCtx, span := startSpan(h.ddCtx, "datadog.servicemap.executeQueries",
+		attrQueryType.String(string(ServiceMapQueryType)),
+		attrRefIDs.StringSlice(sortedRefIDs(h.queryModels)))
+	defer span.End()
+
+	for refID, qm := range h.queryModels {
+		res, err := h.serviceMap(spanCtx, qm, h.timeRanges[refID])
+		if err != nil {
+			_ = tracing.Error(span, err)
+		}
+		response.Responses[refID] = res
+	}
+	return response, nil
+}
+
+// serviceMap builds the nodes and edges frames of one query. Without edge statistics or
+// catalog details the map is still returned, with a warning saying what is missing.
+func (h *ServiceMapHandler) serviceMap(ctx context.Context, qm QueryModel, tr backend.TimeRange) (backend.DataResponse, error) {
+	logger := log.New()
+	d := h.datasource
+
+	dependencies, err := d.fetchServiceDependencies(ctx, qm.Env, tr)
+	if err != nil {
+		logger.Error("Failed to fetch service dependencies", "env", qm.Env, "error", err)
+		if res, ok := limitResponse(err); ok {
+			return res, err
+		}
+		return backend.ErrDataResponse(backend.StatusBadRequest, err.Error()), err
+	}
+	edges := serviceEdges(dependencies, qm.Service)
+
+	var notices []data.Notice
+	if len(edges) > 0 {
+		if err := h.fetchEdgeStats(ctx, qm, tr, edges); err != nil {
+			logger.Warn("Failed to fetch service map edge statistics", "env", qm.Env, "error", err)
+			notices = append(notices, data.Notice{
+				Severity: data.NoticeSeverityWarning,
+				Text:     "Edge statistics are not available: " + err.Error(),
+			})
+		}
+	}
+	catalog, err := d.serviceCatalog(ctx)
+	if err != nil {
+		logger.Warn("Failed to fetch service catalog", "error", err)
+		notices = append(notices, data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     "Service catalog details are not available: " + err.Error(),
+		})
+	}
+
+	seconds := tr.To.Sub(tr.From).Seconds()
+	nodes := serviceNodesFrame(edges, qm.Service, catalog, seconds, d.serviceURL(qm.Env))
+	nodes.Meta.Notices = notices
+	return backend.DataResponse{Frames: data.Frames{nodes, serviceEdgesFrame(edges, seconds)}}, nil
+}
+
+// serviceEdges returns the calls of a dependency listing, sorted, keeping only the calls from
+// and to service when one is given.
+func serviceEdges(dependencies map[string][]string, service string) []*serviceEdge {
+	var edges []*serviceEdge
+	for source, targets := range dependencies {
+		for _, target := range targets {
+			if service != "" && source != service && target != service {
+				continue
+			}
+			edges = append(edges, &serviceEdge{source: source, target: target})
+		}
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].source != edges[j].source {
+			return edges[i].source < edges[j].source
+		}
+		return edges[i].target < edges[j].target
+	})
+	return edges
+}
+
+// edgeStatsQueries returns the trace metric queries of the edge statistics of a query, by
+// the name of the number column each one fills.
+func edgeStatsQueries(qm QueryModel) map[string]string {
+	operation := qm.Operation
+	if operation == "" {
+		operation = defaultServiceMapOperation
+	}
+	scope := fmt.Sprintf("{env:%s}", qm.Env)
+	if qm.Service != "" {
+		// Calls to the service are made by others: they are found by peer.service
+		scope = fmt.Sprintf("{env:%s AND (service:%s OR peer.service:%s)}", qm.Env, qm.Service, qm.Service)
+	}
+	queries := map[string]string{}
+	for _, stat := range []string{"hits", "errors", "duration"} {
+		queries[stat] = fmt.Sprintf("sum:trace.%s.%s%s by {service,peer.service}.rollup(sum)", operation, stat, scope)
+	}
+	return queries
+}
+
+// fetchEdgeStats fills in the statistics of the edges from the trace metrics of the calling
+// services, totalled over the time range.
+func (h *ServiceMapHandler) fetchEdgeStats(ctx context.Context, qm QueryModel, tr backend.TimeRange, edges []*serviceEdge) error {
+	statsQueries := edgeStatsQueries(qm)
+	names := make([]string, 0, len(statsQueries))
+	for name := range statsQueries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	queries := make([]datadogV2.ScalarQuery, 0, len(names))
+	for _, name := range names {
+		name := name
+		queries = append(queries, datadogV2.MetricsScalarQueryAsScalarQuery(&datadogV2.MetricsScalarQuery{
+			Aggregator: datadogV2.METRICSAGGREGATOR_SUM,
+			DataSource: datadogV2.METRICSDATASOURCE_METRICS,
+			Name:       &name,
+			Query:      statsQueries[name],
+		}))
+	}
+	body := datadogV2.ScalarFormulaQueryRequest{
+		Data: datadogV2.ScalarFormulaRequest{
+			Type: datadogV2.SCALARFORMULAREQUESTTYPE_SCALAR_REQUEST,
+			Attributes: datadogV2.ScalarFormulaRequestAttributes{
+				From:    tr.From.UnixMilli(),
+				To:      tr.To.UnixMilli(),
+				Queries: queries,
+			},
+		},
+	}
+
+	queryCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	resp, r, err := h.metricsApi.QueryScalarData(queryCtx, body)
+	if err != nil {
+		httpStatus, responseBody := 0, ""
+		if r != nil {
+			httpStatus = r.StatusCode
+			if r.Body != nil {
+				bodyBytes, _ := io.ReadAll(r.Body)
+				responseBody = string(bodyBytes)
+			}
+		}
+		return fmt.Errorf("%s", h.datasource.parseDatadogError(err, httpStatus, responseBody))
+	}
+
+	raw, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	stats, err := parseEdgeStats(raw)
+	if err != nil {
+		return err
+	}
+	for _, e := range edges {
+		if s, ok := stats[[2]string{e.source, e.target}]; ok {
+			e.hits, e.errors, e.duration, e.hasStats = s["hits"], s["errors"], s["duration"], true
+		}
+	}
+	return nil
+}
+
+// parseEdgeStats reads a scalar response grouped by service and peer.service into the values
+// of its number columns by calling and called service.
+func parseEdgeStats(raw []byte) (map[[2]string]map[string]float64, error) {
+	var resp struct {
+		Data struct {
+			Attributes struct {
+				Columns []struct {
+					Name   string          `json:"name"`
+					Type   string          `json:"type"`
+					Values json.RawMessage `json:"values"`
+				} `json:"columns"`
+			} `json:"attributes"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(raw, &resp); err != nil {
+		return nil, fmt.Errorf("invalid scalar response: %w", err)
+	}
+
+	var sources, targets [][]string
+	numbers := map[string][]*float64{}
+	for _, col := range resp.Data.Attributes.Columns {
+		switch {
+		case col.Type == "group" && col.Name == "service":
+			_ = json.Unmarshal(col.Values, &sources)
+		case col.Type == "group" && col.Name == "peer.service":
+			_ = json.Unmarshal(col.Values, &targets)
+		case col.Type == "number":
+			var values []*float64
+			_ = json.Unmarshal(col.Values, &values)
+			numbers[col.Name] = values
+		}
+	}
+
+	stats := map[[2]string]map[string]float64{}
+	for i := 0; i < len(sources) && i < len(targets); i++ {
+		if len(sources[i]) == 0 || len(targets[i]) == 0 {
+			continue
+		}
+		row := map[string]float64{}
+		for name, values := range numbers {
+			if i < len(values) && values[i] != nil {
+				row[name] = *values[i]
+			}
+		}
+		stats[[2]string{sources[i][0], targets[i][0]}] = row
+	}
+	return stats, nil
+}
+
+// serviceNodesFrame builds the nodes frame: a node per service with the requests it received,
+// its error rate and its catalog details. serviceURL gives the Datadog page of a service.
+func serviceNodesFrame(edges []*serviceEdge, focus string, catalog map[string]serviceDefinition, seconds float64, serviceURL func(string) string) *data.Frame {
+	seen := map[string]bool{}
+	var services []string
+	hits, errs, known := map[string]float64{}, map[string]float64{}, map[string]bool{}
+	if focus != "" {
+		seen[focus] = true
+		services = append(services, focus)
+	}
+	for _, e := range edges {
+		for _, s := range []string{e.source, e.target} {
+			if !seen[s] {
+				seen[s] = true
+				services = append(services, s)
+			}
+		}
+		if e.hasStats {
+			hits[e.target] += e.hits
+			errs[e.target] += e.errors
+			known[e.target] = true
+		}
+	}
+	sort.Strings(services)
+
+	// Link types present in the catalog of the services, each a field with a data link
+	linkTypes := map[string]bool{}
+	for _, s := range services {
+		for linkType := range catalog[s].Links {
+			linkTypes[linkType] = true
+		}
+	}
+	sortedLinkTypes := make([]string, 0, len(linkTypes))
+	for linkType := range linkTypes {
+		sortedLinkTypes = append(sortedLinkTypes, linkType)
+	}
+	sort.Strings(sortedLinkTypes)
+
+	n := len(services)
+	ids, subtitles := make([]string, n), make([]string, n)
+	mainstat, secondarystat := make([]*float64, n), make([]*float64, n)
+	success, failed := make([]float64, n), make([]float64, n)
+	teams, tiers, lifecycles, descriptions, contacts, pages := make([]string, n), make([]string, n), make([]string, n), make([]string, n), make([]string, n), make([]string, n)
+	links := make(map[string][]string, len(sortedLinkTypes))
+	for _, linkType := range sortedLinkTypes {
+		links[linkType] = make([]string, n)
+	}
+
+	for i, s := range services {
+		ids[i] = s
+		def := catalog[s]
+		subtitles[i] = def.Team
+		teams[i], tiers[i], lifecycles[i], descriptions[i] = def.Team, def.Tier, def.Lifecycle, def.Description
+		contacts[i] = strings.Join(def.Contacts, ", ")
+		pages[i] = serviceURL(s)
+		for linkType, url := range def.Links {
+			links[linkType][i] = url
+		}
+		if known[s] && seconds > 0 {
+			rate := hits[s] / seconds
+			mainstat[i] = &rate
+			if hits[s] > 0 {
+				errorRate := errs[s] / hits[s]
+				secondarystat[i] = &errorRate
+				success[i], failed[i] = 1-errorRate, errorRate
+			}
+		}
+	}
+
+	frame := data.NewFrame("nodes",
+		data.NewField("id", nil, ids),
+		data.NewField("title", nil, append([]string(nil), ids...)),
+		data.NewField("subtitle", nil, subtitles),
+		data.NewField("mainstat", nil, mainstat).SetConfig(&data.FieldConfig{DisplayName: "Requests", Unit: "reqps"}),
+		data.NewField("secondarystat", nil, secondarystat).SetConfig(&data.FieldConfig{DisplayName: "Error rate", Unit: "percentunit"}),
+		data.NewField("arc__success", nil, success).SetConfig(&data.FieldConfig{DisplayName: "Success", Color: map[string]interface{}{"mode": "fixed", "fixedColor": "green"}}),
+		data.NewField("arc__errors", nil, failed).SetConfig(&data.FieldConfig{DisplayName: "Errors", Color: map[string]interface{}{"mode": "fixed", "fixedColor": "red"}}),
+		data.NewField("detail__team", nil, teams).SetConfig(&data.FieldConfig{DisplayName: "Team"}),
+		data.NewField("detail__tier", nil, tiers).SetConfig(&data.FieldConfig{DisplayName: "Tier"}),
+		data.NewField("detail__lifecycle", nil, lifecycles).SetConfig(&data.FieldConfig{DisplayName: "Lifecycle"}),
+		data.NewField("detail__description", nil, descriptions).SetConfig(&data.FieldConfig{DisplayName: "Description"}),
+		data.NewField("detail__contacts", nil, contacts).SetConfig(&data.FieldConfig{DisplayName: "Contacts"}),
+		data.NewField("detail__datadog", nil, pages).SetConfig(&data.FieldConfig{
+			DisplayName: "Datadog",
+			Links:       []data.DataLink{{Title: "Open in Datadog APM", URL: "${__value.raw}", TargetBlank: true}},
+		}),
+	)
+	for _, linkType := range sortedLinkTypes {
+		frame.Fields = append(frame.Fields, data.NewField("detail__"+linkType, nil, links[linkType]).SetConfig(&data.FieldConfig{
+			DisplayName: linkType,
+			Links:       []data.DataLink{{Title: "Open " + linkType, URL: "${__value.raw}", TargetBlank: true}},
+		}))
+	}
+	frame.Meta = &data.FrameMeta{PreferredVisualization: data.VisTypeNodeGraph}
+	return frame
+}
+
+// serviceEdgesFrame builds the edges frame: an edge per call with its request rate, error
+// rate and mean latency.
+func serviceEdgesFrame(edges []*serviceEdge, seconds float64) *data.Frame {
+	n := len(edges)
+	ids, sources, targets := make([]string, n), make([]string, n), make([]string, n)
+	rates, errorRates, latencies := make([]*float64, n), make([]*float64, n), make([]*float64, n)
+	for i, e := range edges {
+		ids[i], sources[i], targets[i] = e.source+"->"+e.target, e.source, e.target
+		if !e.hasStats {
+			continue
+		}
+		if seconds > 0 {
+			rate := e.hits / seconds
+			rates[i] = &rate
+		}
+		if e.hits > 0 {
+			errorRate := e.errors / e.hits
+			latency := e.duration / e.hits * 1000
+			errorRates[i], latencies[i] = &errorRate, &latency
+		}
+	}
+
+	frame := data.NewFrame("edges",
+		data.NewField("id", nil, ids),
+		data.NewField("source", nil, sources),
+		data.NewField("target", nil, targets),
+		data.NewField("mainstat", nil, rates).SetConfig(&data.FieldConfig{DisplayName: "Requests", Unit: "reqps"}),
+		data.NewField("secondarystat", nil, latencies).SetConfig(&data.FieldConfig{DisplayName: "Latency", Unit: "ms"}),
+		data.NewField("detail__error_rate", nil, errorRates).SetConfig(&data.FieldConfig{DisplayName: "Error rate", Unit: "percentunit"}),
+	)
+	frame.Meta = &data.FrameMeta{PreferredVisualization: data.VisTypeNodeGraph}
+	return frame
+}
+
+// serviceURL returns a function giving the Datadog APM page of a service in env.
+func (d *Datasource) serviceURL(env string) func(string) string {
+	site := "datadoghq.com"
+	if d.JSONData != nil && d.JSONData.Site != "" {
+		site = d.JSONData.Site
+	}
+	return func(service string) string {
+		return fmt.Sprintf("https://app.%s/apm/services/%s?env=%s", site, url.PathEscape(service), url.QueryEscape(env))
+	}
+}
+
+// fetchServiceDependencies lists the services each service of env called in the time range.
+func (d *Datasource) fetchServiceDependencies(ctx context.Context, env string, tr backend.TimeRange) (map[string][]string, error) {
+	apiKey, appKey, site, err := d.validateCredentials()
+	if err != nil {
+		return nil, err
+	}
+	params := url.Values{}
+	params.Set("env", env)
+	params.Set("start", fmt.Sprint(tr.From.Unix()))
+	params.Set("end", fmt.Sprint(tr.To.Unix()))
+	endpoint := d.apiBaseURL(site) + "/api/v1/service_dependencies?" + params.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create service dependencies request: %w", err)
+	}
+	req.Header.Set("DD-API-KEY", apiKey)
+	req.Header.Set("DD-APPLICATION-KEY", appKey)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := d.httpClient(30 * time.Second).Do(req)
+	if err != nil {
+		if _, ok := limitResponse(err); ok {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%s", d.parseDatadogError(err, 0, ""))
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service dependencies: %w", err)
+	}
+	switch {
+	case resp.StatusCode == http.StatusForbidden:
+		return nil, fmt.Errorf("API key missing required permissions - need 'apm_read' scope for service dependencies")
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("%s", d.parseDatadogError(fmt.Errorf("HTTP %d", resp.StatusCode), resp.StatusCode, string(body)))
+	}
+
+	var listing map[string]struct {
+		Calls []string `json:"calls"`
+	}
+	if err := json.Unmarshal(body, &listing); err != nil {
+		return nil, fmt.Errorf("invalid service dependencies response: %w", err)
+	}
+	dependencies := make(map[string][]string, len(listing))
+	for service, deps := range listing {
+		dependencies[service] = deps.Calls
+	}
+	return dependencies, nil
+}
+
+// serviceCatalog returns the service catalog by service name, cached for serviceCatalogTTL.
+func (d *Datasource) serviceCatalog(ctx context.Context) (map[string]serviceDefinition, error) {
+	const cacheKey = "service-catalog"
+	var catalog map[string]serviceDefinition
+	if !d.cacheDisabled {
+		if _, ok := cacheGet(d.cache, cacheKey, serviceCatalogTTL, &catalog); ok {
+			return catalog, nil
+		}
+	}
+
+	client, err := d.GetAPIClient()
+	if err != nil {
+		return nil, err
+	}
+	ddCtx, err := d.GetDatadogContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ddCtx, cancel := context.WithTimeout(ddCtx, 30*time.Second)
+	defer cancel()
+	api := datadogV2.NewServiceDefinitionApi(client)
+
+	catalog = map[string]serviceDefinition{}
+	for page := int64(0); page < serviceCatalogMaxPages; page++ {
+		opts := datadogV2.NewListServiceDefinitionsOptionalParameters().
+			WithPageSize(serviceCatalogPageSize).
+			WithPageNumber(page).
+			WithSchemaVersion(datadogV2.SERVICEDEFINITIONSCHEMAVERSIONS_V2_2)
+		resp, r, err := api.ListServiceDefinitions(ddCtx, *opts)
+		if err != nil {
+			httpStatus, responseBody := 0, ""
+			if r != nil {
+				httpStatus = r.StatusCode
+				if r.Body != nil {
+					bodyBytes, _ := io.ReadAll(r.Body)
+					responseBody = string(bodyBytes)
+				}
+			}
+			if httpStatus == http.StatusForbidden {
+				return nil, fmt.Errorf("API key missing required permissions - need 'apm_service_catalog_read' scope for the service catalog")
+			}
+			return nil, fmt.Errorf("%s", d.parseDatadogError(err, httpStatus, responseBody))
+		}
+		raw, err := json.Marshal(resp)
+		if err != nil {
+			return nil, err
+		}
+		var definitions ddServiceDefinitions
+		if err := json.Unmarshal(raw, &definitions); err != nil {
+			return nil, fmt.Errorf("invalid service definitions response: %w", err)
+		}
+		for _, entry := range definitions.Data {
+			schema := entry.Attributes.Schema
+			if schema.DdService == "" {
+				continue
+			}
+			def := serviceDefinition{
+				Service:     schema.DdService,
+				Team:        schema.Team,
+				Description: schema.Description,
+				Tier:        schema.Tier,
+				Lifecycle:   schema.Lifecycle,
+				Application: schema.Application,
+				Links:       map[string]string{},
+			}
+			for _, c := range schema.Contacts {
+				contact := c.Contact
+				if c.Name != "" {
+					contact = c.Name + " (" + c.Contact + ")"
+				}
+				def.Contacts = append(def.Contacts, contact)
+			}
+			for _, l := range schema.Links {
+				linkType := l.Type
+				if linkType == "" {
+					linkType = "other"
+				}
+				if _, ok := def.Links[linkType]; !ok {
+					def.Links[linkType] = l.URL
+				}
+			}
+			catalog[schema.DdService] = def
+		}
+		if len(definitions.Data) < serviceCatalogPageSize {
+			break
+		}
+	}
+
+	if !d.cacheDisabled {
+		cacheSet(d.cache, cacheKey, catalog)
+	}
+	return catalog, nil
+}
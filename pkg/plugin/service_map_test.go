@@ -0,0 +1,193 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wasilak/grafana-datadog-datasource/pkg/plugin/fakedatadog"
+)
+
+func TestServiceEdges(t *testing.T) {
+	dependencies := map[string][]string{
+		"web":      {"checkout", "auth"},
+		"checkout": {"payments"},
+		"auth":     {},
+	}
+
+	edges := serviceEdges(dependencies, "")
+	require.Len(t, edges, 3)
+	assert.Equal(t, "checkout", edges[0].source)
+	assert.Equal(t, "payments", edges[0].target)
+	assert.Equal(t, "auth", edges[1].target)
+
+	edges = serviceEdges(dependencies, "checkout")
+	require.Len(t, edges, 2, "only calls from and to the service")
+	assert.Equal(t, [2]string{"checkout", "payments"}, [2]string{edges[0].source, edges[0].target})
+	assert.Equal(t, [2]string{"web", "checkout"}, [2]string{edges[1].source, edges[1].target})
+}
+
+func TestEdgeStatsQueries(t *testing.T) {
+	queries := edgeStatsQueries(QueryModel{Env: "prod"})
+	assert.Equal(t, "sum:trace.http.client.request.hits{env:prod} by {service,peer.service}.rollup(sum)", queries["hits"])
+	assert.Len(t, queries, 3)
+
+	queries = edgeStatsQueries(QueryModel{Env: "prod", Service: "checkout", Operation: "grpc.client"})
+	assert.Equal(t, "sum:trace.grpc.client.errors{env:prod AND (service:checkout OR peer.service:checkout)} by {service,peer.service}.rollup(sum)", queries["errors"])
+}
+
+// fieldByName returns the field of a frame with the given name.
+func fieldByName(t *testing.T, frame *data.Frame, name string) *data.Field {
+	t.Helper()
+	field, idx := frame.FieldByName(name)
+	require.NotEqual(t, -1, idx, "field %q", name)
+	return field
+}
+
+// stringValues returns the values of a string field.
+func stringValues(field *data.Field) []string {
+	values := make([]string, field.Len())
+	for i := range values {
+		values[i] = field.At(i).(string)
+	}
+	return values
+}
+
+func TestIntegration_ServiceMap(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+	srv.Enqueue(fakedatadog.EndpointServiceDependencies, fakedatadog.JSON(map[string]interface{}{
+		"web":      map[string]interface{}{"calls": []string{"checkout"}},
+		"checkout": map[string]interface{}{"calls": []string{"payments"}},
+	}))
+	srv.Enqueue(fakedatadog.EndpointScalarQuery, fakedatadog.JSON(fakedatadog.ScalarResponse(
+		[]string{"service", "peer.service"}, []string{"duration", "errors", "hits"},
+		fakedatadog.ScalarRow{Group: []string{"web", "checkout"}, Values: fakedatadog.Points(720, 36, 3600)},
+	)))
+	srv.Enqueue(fakedatadog.EndpointServiceDefinitions, fakedatadog.JSON(map[string]interface{}{
+		"data": []interface{}{map[string]interface{}{
+			"type": "service-definition",
+			"attributes": map[string]interface{}{
+				"schema": map[string]interface{}{
+					"schema-version": "v2.2",
+					"dd-service":     "checkout",
+					"team":           "payments",
+					"tier":           "1",
+					"contacts":       []interface{}{map[string]interface{}{"type": "slack", "name": "Payments", "contact": "https://slack.example.com/payments"}},
+					"links":          []interface{}{map[string]interface{}{"name": "Runbook", "type": "runbook", "url": "https://wiki.example.com/checkout"}},
+				},
+			},
+		}},
+	}))
+
+	resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{dataQuery("A", map[string]interface{}{"queryType": "service-map", "env": "prod"})},
+	})
+	require.NoError(t, err)
+	res := resp.Responses["A"]
+	require.NoError(t, res.Error)
+	require.Len(t, res.Frames, 2)
+	nodes, edges := res.Frames[0], res.Frames[1]
+	assert.Equal(t, "nodes", nodes.Name)
+	assert.Equal(t, data.VisType(data.VisTypeNodeGraph), nodes.Meta.PreferredVisualization)
+	assert.Empty(t, nodes.Meta.Notices)
+
+	// Nodes are sorted: checkout, payments, web
+	require.Equal(t, 3, nodes.Rows())
+	assert.Equal(t, []string{"checkout", "payments", "web"}, stringValues(fieldByName(t, nodes, "id")))
+	assert.Equal(t, "payments", fieldByName(t, nodes, "subtitle").At(0))
+	assert.Equal(t, "Payments (https://slack.example.com/payments)", fieldByName(t, nodes, "detail__contacts").At(0))
+	runbook := fieldByName(t, nodes, "detail__runbook")
+	assert.Equal(t, "https://wiki.example.com/checkout", runbook.At(0))
+	require.Len(t, runbook.Config.Links, 1)
+	assert.Equal(t, "https://app.datadoghq.com/apm/services/checkout?env=prod", fieldByName(t, nodes, "detail__datadog").At(0))
+
+	// 3600 calls in an hour: 1 req/s into checkout, 1% failed
+	rate := fieldByName(t, nodes, "mainstat").At(0).(*float64)
+	require.NotNil(t, rate)
+	assert.InDelta(t, 1.0, *rate, 0.001)
+	assert.InDelta(t, 0.01, *fieldByName(t, nodes, "secondarystat").At(0).(*float64), 0.0001)
+	assert.InDelta(t, 0.99, fieldByName(t, nodes, "arc__success").At(0), 0.0001)
+	assert.Nil(t, fieldByName(t, nodes, "mainstat").At(2), "nothing calls web")
+
+	assert.Equal(t, "edges", edges.Name)
+	require.Equal(t, 2, edges.Rows())
+	assert.Equal(t, []string{"checkout->payments", "web->checkout"}, stringValues(fieldByName(t, edges, "id")))
+	assert.Nil(t, fieldByName(t, edges, "mainstat").At(0), "no statistics for checkout->payments")
+	assert.InDelta(t, 1.0, *fieldByName(t, edges, "mainstat").At(1).(*float64), 0.001)
+	assert.InDelta(t, 200.0, *fieldByName(t, edges, "secondarystat").At(1).(*float64), 0.001, "720s over 3600 calls")
+	assert.InDelta(t, 0.01, *fieldByName(t, edges, "detail__error_rate").At(1).(*float64), 0.0001)
+
+	deps := srv.Requests(fakedatadog.EndpointServiceDependencies)
+	require.Len(t, deps, 1)
+	assert.Equal(t, "prod", deps[0].Query.Get("env"))
+	assert.NotEmpty(t, deps[0].Query.Get("start"))
+	assert.Equal(t, 1, srv.Hits(fakedatadog.EndpointScalarQuery))
+
+	// The catalog is cached
+	_, err = d.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{dataQuery("A", map[string]interface{}{"queryType": "service-map", "env": "prod"})},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, srv.Hits(fakedatadog.EndpointServiceDefinitions))
+}
+
+func TestIntegration_ServiceMap_Degraded(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+	srv.Enqueue(fakedatadog.EndpointServiceDependencies, fakedatadog.JSON(map[string]interface{}{
+		"web": map[string]interface{}{"calls": []string{"checkout"}},
+	}))
+	srv.Enqueue(fakedatadog.EndpointScalarQuery, fakedatadog.Error(http.StatusBadRequest, "Invalid query"))
+	srv.Enqueue(fakedatadog.EndpointServiceDefinitions, fakedatadog.Error(http.StatusForbidden, "Forbidden"))
+
+	resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{dataQuery("A", map[string]interface{}{"queryType": "service-map", "env": "prod"})},
+	})
+	require.NoError(t, err)
+	res := resp.Responses["A"]
+	require.NoError(t, res.Error)
+	require.Len(t, res.Frames, 2)
+	assert.Equal(t, 2, res.Frames[0].Rows())
+	require.Len(t, res.Frames[0].Meta.Notices, 2)
+	assert.Contains(t, res.Frames[0].Meta.Notices[0].Text, "Edge statistics are not available")
+	assert.Contains(t, res.Frames[0].Meta.Notices[1].Text, "apm_service_catalog_read")
+}
+
+func TestIntegration_ServiceMap_Errors(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+
+	resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{dataQuery("A", map[string]interface{}{"queryType": "service-map"})},
+	})
+	require.NoError(t, err)
+	require.Error(t, resp.Responses["A"].Error)
+	assert.Contains(t, resp.Responses["A"].Error.Error(), "need an env")
+
+	srv.Enqueue(fakedatadog.EndpointServiceDependencies, fakedatadog.Error(http.StatusForbidden, "Forbidden"))
+	resp, err = d.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{dataQuery("A", map[string]interface{}{"queryType": "service-map", "env": "prod"})},
+	})
+	require.NoError(t, err)
+	require.Error(t, resp.Responses["A"].Error)
+	assert.Contains(t, resp.Responses["A"].Error.Error(), "apm_read")
+}
+
+func TestIntegration_ServiceMap_Policy(t *testing.T) {
+	for name, policy := range map[string]*PolicyOptions{
+		"metrics and logs": testPolicy,
+		"logs only":        {AllowedLogIndexes: []string{"payments"}, RequiredLogQuery: "team:payments"},
+	} {
+		d, srv := newFakeBackedDatasourceWithPolicyOptions(t, policy)
+
+		resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+			Queries: []backend.DataQuery{dataQuery("A", map[string]interface{}{"queryType": "service-map", "env": "prod"})},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, backend.StatusForbidden, resp.Responses["A"].Status, name)
+		assert.Zero(t, srv.Hits(fakedatadog.EndpointServiceDependencies), name)
+	}
+}
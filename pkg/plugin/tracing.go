@@ -0,0 +1,51 @@
+package plugin
+
+import (
+	"context"
+	"sort"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Span attribute keys shared by query, resource and outbound Datadog spans.
+const (
+	attrRefID        = attribute.Key("datadog.ref_id")
+	attrRefIDs       = attribute.Key("datadog.ref_ids")
+	attrQueryType    = attribute.Key("datadog.query_type")
+	attrQueryCount   = attribute.Key("datadog.query_count")
+	attrPageCount    = attribute.Key("datadog.page_count")
+	attrCacheHit     = attribute.Key("datadog.cache_hit")
+	attrCachePartial = attribute.Key("datadog.cache_partial")
+	attrCoalesced    = attribute.Key("datadog.coalesced")
+	attrEndpoint     = attribute.Key("datadog.endpoint")
+	attrRoute        = attribute.Key("datadog.resource.route")
+)
+
+// startSpan starts a span on the SDK default tracer, which Grafana configures when
+// tracing is enabled and which parents spans on the trace propagated with each request.
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracing.DefaultTracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// setSpanCacheHit records on the current span whether the result was served from cache.
+func setSpanCacheHit(ctx context.Context, hit bool) {
+	trace.SpanFromContext(ctx).SetAttributes(attrCacheHit.Bool(hit))
+}
+
+// traceIDFromContext returns the trace ID of the request for log correlation,
+// or an empty string when the request is not being traced.
+func traceIDFromContext(ctx context.Context) string {
+	return tracing.TraceIDFromContext(ctx, false)
+}
+
+// sortedRefIDs returns the refIDs of queryModels in a stable order for span attributes.
+func sortedRefIDs(queryModels map[string]QueryModel) []string {
+	refIDs := make([]string, 0, len(queryModels))
+	for refID := range queryModels {
+		refIDs = append(refIDs, refID)
+	}
+	sort.Strings(refIDs)
+	return refIDs
+}
@@ -0,0 +1,125 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/wasilak/grafana-datadog-datasource/pkg/plugin/fakedatadog"
+)
+
+// recordSpans routes the SDK default tracer to an in-memory recorder for the duration of the test.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	tracing.InitDefaultTracer(tp.Tracer("test"))
+
+	prevPropagator := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTextMapPropagator(prevPropagator)
+		tracing.InitDefaultTracer(otel.Tracer("github.com/grafana/grafana-plugin-sdk-go"))
+		_ = tp.Shutdown(context.Background())
+	})
+	return recorder
+}
+
+func spansByName(recorder *tracetest.SpanRecorder) map[string]sdktrace.ReadOnlySpan {
+	out := make(map[string]sdktrace.ReadOnlySpan)
+	for _, s := range recorder.Ended() {
+		out[s.Name()] = s
+	}
+	return out
+}
+
+func spanAttr(s sdktrace.ReadOnlySpan, key attribute.Key) (attribute.Value, bool) {
+	for _, kv := range s.Attributes() {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestTracing_QueryDataSpanHierarchy(t *testing.T) {
+	recorder := recordSpans(t)
+	d, srv := newFakeBackedDatasource(t)
+
+	_, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{dataQuery("A", map[string]interface{}{"queryText": "avg:system.cpu.user{*}"})},
+	})
+	require.NoError(t, err)
+
+	spans := spansByName(recorder)
+	root, ok := spans["datadog.QueryData"]
+	require.True(t, ok, "missing QueryData span")
+	handler, ok := spans["datadog.metrics.executeQueries"]
+	require.True(t, ok, "missing metrics handler span")
+	call, ok := spans["datadog.api timeseries_query"]
+	require.True(t, ok, "missing outbound Datadog span")
+
+	assert.Equal(t, root.SpanContext().SpanID(), handler.Parent().SpanID())
+	assert.Equal(t, handler.SpanContext().SpanID(), call.Parent().SpanID())
+
+	refIDs, _ := spanAttr(handler, attrRefIDs)
+	assert.Equal(t, []string{"A"}, refIDs.AsStringSlice())
+	status, _ := spanAttr(call, "http.response.status_code")
+	assert.Equal(t, int64(http.StatusOK), status.AsInt64())
+
+	// The trace context is propagated to Datadog.
+	reqs := srv.Requests(fakedatadog.EndpointTimeseriesQuery)
+	require.Len(t, reqs, 1)
+	assert.Contains(t, reqs[0].Header.Get("traceparent"), root.SpanContext().TraceID().String())
+}
+
+func TestTracing_LogsQueryCacheHitAndPageCount(t *testing.T) {
+	recorder := recordSpans(t)
+	d, _ := newFakeBackedDatasource(t)
+
+	q := dataQuery("L", map[string]interface{}{"queryType": "logs", "logQuery": "service:checkout"})
+	for i := 0; i < 2; i++ {
+		_, err := d.QueryData(context.Background(), &backend.QueryDataRequest{Queries: []backend.DataQuery{q}})
+		require.NoError(t, err)
+	}
+
+	var cacheHits []bool
+	var pageCounts []int64
+	for _, s := range recorder.Ended() {
+		if s.Name() != "datadog.logs.query" {
+			continue
+		}
+		refID, _ := spanAttr(s, attrRefID)
+		assert.Equal(t, "L", refID.AsString())
+		hit, _ := spanAttr(s, attrCacheHit)
+		pages, _ := spanAttr(s, attrPageCount)
+		cacheHits = append(cacheHits, hit.AsBool())
+		pageCounts = append(pageCounts, pages.AsInt64())
+	}
+	assert.Equal(t, []bool{false, true}, cacheHits)
+	assert.Equal(t, []int64{1, 0}, pageCounts)
+}
+
+func TestTracing_CallResourceSpanUsesRouteTemplate(t *testing.T) {
+	recorder := recordSpans(t)
+	d, _ := newFakeBackedDatasource(t)
+
+	callResource(t, d, http.MethodGet, "autocomplete/tags/system.cpu.user", nil)
+
+	spans := spansByName(recorder)
+	span, ok := spans["datadog.CallResource autocomplete/tags/{metric}"]
+	require.True(t, ok, "missing CallResource span")
+	hit, ok := spanAttr(span, attrCacheHit)
+	require.True(t, ok)
+	assert.False(t, hit.AsBool())
+}
@@ -0,0 +1,286 @@
+package plugin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// Per-user application keys. When enabled, Datadog calls are made with an application key
+// mapped to the Grafana user (or to a team the user belongs to) instead of the shared one, so
+// Datadog RBAC and restricted log indexes apply to the viewing user. The keys are stored in
+// the datasource secure settings:
+//
+//	userAppKey:<login or email>  application key of one Grafana user
+//	teamAppKey:<team>            application key shared by the members listed in jsonData.userAppKeys.teams
+//	backendAppKey                application key of queries made without a user, such as alert rule evaluation
+//
+// Each distinct application key gets its own view of the datasource: a Datasource with its own
+// caches, in-flight call coalescing and key pairs, so nothing fetched with one key is served to
+// users of another. Unused views are disposed of (see maxUserViews). Users without a key are refused unless allowSharedKeyFallback is set.
+// Queries without a user (alerting, recorded queries) use backendAppKey when it is configured.
+
+// Secure JSON data key prefixes of per-user and per-team application keys.
+const (
+	userAppKeyPrefix = "userAppKey:"
+	teamAppKeyPrefix = "teamAppKey:"
+)
+
+// backendAppKey is the secure JSON data key of the application key used for queries made
+// without a Grafana user, such as alert rule evaluation.
+const backendAppKey = "backendAppKey"
+
+// UserAppKeysOptions configures per-user application keys.
+type UserAppKeysOptions struct {
+	Enabled bool `json:"enabled"`
+	// Teams maps a team name to the logins or emails of its members. Grafana does not pass
+	// team membership to plugins, so teams are defined here.
+	Teams map[string][]string `json:"teams,omitempty"`
+	// AllowSharedKeyFallback lets users without a key of their own use the shared application
+	// key. Off by default: such users are refused.
+	AllowSharedKeyFallback bool `json:"allowSharedKeyFallback,omitempty"`
+}
+
+// Bounds of the per-user views of a datasource. Each view has its own caches and cache
+// janitor, so views idle for longer than userViewIdleTTL, and the least recently used views
+// beyond maxUserViews, are disposed of. A disposed view is created again on next use.
+const (
+	maxUserViews    = 100
+	userViewIdleTTL = time.Hour
+)
+
+// userViews holds the per-application-key views of a datasource.
+type userViews struct {
+	mu    sync.Mutex
+	views map[string]*userViewEntry
+}
+
+// userViewEntry is a per-user view and when it was last used.
+type userViewEntry struct {
+	view     *Datasource
+	lastUsed time.Time
+}
+
+// userAppKeysEnabled reports whether requests must use per-user application keys.
+func (d *Datasource) userAppKeysEnabled() bool {
+	return d.JSONData != nil && d.JSONData.UserAppKeys != nil && d.JSONData.UserAppKeys.Enabled
+}
+
+// resolveUserAppKey returns the application key mapped to user and a description of its
+// owner ("user alice", "team support"). The user's own key wins over team keys; teams are
+// tried in name order.
+func (d *Datasource) resolveUserAppKey(user *backend.User) (appKey, owner string) {
+	if user == nil {
+		return "", ""
+	}
+	identities := []string{}
+	for _, id := range []string{user.Login, user.Email} {
+		if id != "" {
+			identities = append(identities, strings.ToLower(id))
+		}
+	}
+	if len(identities) == 0 {
+		return "", ""
+	}
+
+	userKeys := make(map[string]string)
+	for key, value := range d.SecureJSONData {
+		if strings.HasPrefix(key, userAppKeyPrefix) && value != "" {
+			userKeys[strings.ToLower(strings.TrimPrefix(key, userAppKeyPrefix))] = value
+		}
+	}
+	for _, id := range identities {
+		if appKey, ok := userKeys[id]; ok {
+			return appKey, "user " + user.Login
+		}
+	}
+
+	teams := make([]string, 0, len(d.JSONData.UserAppKeys.Teams))
+	for team := range d.JSONData.UserAppKeys.Teams {
+		teams = append(teams, team)
+	}
+	sort.Strings(teams)
+	for _, team := range teams {
+		appKey := d.SecureJSONData[teamAppKeyPrefix+team]
+		if appKey == "" {
+			continue
+		}
+		for _, member := range d.JSONData.UserAppKeys.Teams[team] {
+			for _, id := range identities {
+				if strings.ToLower(member) == id {
+					return appKey, "team " + team
+				}
+			}
+		}
+	}
+	return "", ""
+}
+
+// userView returns the datasource to serve user with: a view using the user's application
+// key, or d itself when per-user keys are disabled or the shared key may be used.
+func (d *Datasource) userView(user *backend.User) (view *Datasource, owner string, err error) {
+	if !d.userAppKeysEnabled() {
+		return d, "", nil
+	}
+	appKey, owner := d.resolveUserAppKey(user)
+	if appKey == "" {
+		if d.JSONData.UserAppKeys.AllowSharedKeyFallback {
+			return d, "shared key", nil
+		}
+		login := "anonymous"
+		if user != nil && user.Login != "" {
+			login = user.Login
+		}
+		return nil, "", fmt.Errorf("no Datadog application key is configured for Grafana user %q", login)
+	}
+	return d.appKeyView(appKey, owner)
+}
+
+// queryView returns the datasource to run req with. Queries made without a user, such as
+// alert rule evaluation, use the backend application key when one is configured; all other
+// queries are served like any request of their user. Request headers such as FromAlert are
+// set by the client and never select the backend key.
+func (d *Datasource) queryView(req *backend.QueryDataRequest) (view *Datasource, owner string, err error) {
+	if !d.userAppKeysEnabled() {
+		return d, "", nil
+	}
+	appKey := d.SecureJSONData[backendAppKey]
+	if user := req.PluginContext.User; appKey != "" && (user == nil || user.Login == "") {
+		return d.appKeyView(appKey, "backend")
+	}
+	return d.userView(req.PluginContext.User)
+}
+
+// appKeyView returns the view of d using appKey, creating it on first use. Views evicted to
+// make room are disposed of.
+func (d *Datasource) appKeyView(appKey, owner string) (*Datasource, string, error) {
+	id := hashedViewID(appKey)
+	view, evicted, err := d.userViews.get(id, time.Now(), func() (*Datasource, error) {
+		return d.newUserView(id, appKey)
+	})
+	for _, v := range evicted {
+		v.Dispose()
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return view, owner, nil
+}
+
+// hashedViewID returns the identifier of the view using appKey, safe to log.
+func hashedViewID(appKey string) string {
+	sum := sha256.Sum256([]byte(appKey))
+	return hex.EncodeToString(sum[:8])
+}
+
+// get returns the view called id, creating it with create when missing, and removes the views
+// that are idle or beyond maxUserViews. It returns the removed views for the caller to dispose
+// of outside the lock.
+func (u *userViews) get(id string, now time.Time, create func() (*Datasource, error)) (*Datasource, []*Datasource, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	entry, ok := u.views[id]
+	if !ok {
+		view, err := create()
+		if err != nil {
+			return nil, u.evict(now), err
+		}
+		if u.views == nil {
+			u.views = make(map[string]*userViewEntry)
+		}
+		entry = &userViewEntry{view: view}
+		u.views[id] = entry
+		log.New().Debug("Created datasource view for per-user application key", "view", id)
+	}
+	entry.lastUsed = now
+	return entry.view, u.evict(now), nil
+}
+
+// evict removes the views idle for longer than userViewIdleTTL, then the least recently used
+// ones until at most maxUserViews are left. u.mu must be held.
+func (u *userViews) evict(now time.Time) []*Datasource {
+	var evicted []*Datasource
+	for id, entry := range u.views {
+		if now.Sub(entry.lastUsed) > userViewIdleTTL {
+			evicted = append(evicted, entry.view)
+			delete(u.views, id)
+		}
+	}
+	for len(u.views) > maxUserViews {
+		oldest := ""
+		for id, entry := range u.views {
+			if oldest == "" || entry.lastUsed.Before(u.views[oldest].lastUsed) {
+				oldest = id
+			}
+		}
+		evicted = append(evicted, u.views[oldest].view)
+		delete(u.views, oldest)
+	}
+	if len(evicted) > 0 {
+		log.New().Debug("Disposing of unused per-user datasource views", "count", len(evicted))
+	}
+	return evicted
+}
+
+// newUserView builds a datasource with the settings of d whose key pairs carry appKey.
+func (d *Datasource) newUserView(id, appKey string) (*Datasource, error) {
+	settings := *d.InstanceSettings
+	settings.UID = d.InstanceSettings.UID + "-" + id
+	settings.DecryptedSecureJSONData = map[string]string{
+		"apiKey": d.SecureJSONData["apiKey"],
+		"appKey": appKey,
+	}
+	if secondary := d.SecureJSONData["secondaryApiKey"]; secondary != "" {
+		settings.DecryptedSecureJSONData["secondaryApiKey"] = secondary
+		settings.DecryptedSecureJSONData["secondaryAppKey"] = appKey
+	}
+
+	inst, err := NewDatasource(context.Background(), settings)
+	if err != nil {
+		return nil, err
+	}
+	view := inst.(*Datasource)
+	view.JSONData.UserAppKeys = nil
+	view.budget = d.budget
+	view.credentials().promote(d.credentials().current().name)
+	return view, nil
+}
+
+// views returns the per-user views created so far, in a stable order.
+func (d *Datasource) views() []*Datasource {
+	d.userViews.mu.Lock()
+	defer d.userViews.mu.Unlock()
+	ids := make([]string, 0, len(d.userViews.views))
+	for id := range d.userViews.views {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	views := make([]*Datasource, len(ids))
+	for i, id := range ids {
+		views[i] = d.userViews.views[id].view
+	}
+	return views
+}
+
+// userViewRequired reports whether a resource route calls Datadog on behalf of the user.
+// Administration routes are served by the datasource itself.
+func userViewRequired(path string) bool {
+	return !strings.HasPrefix(path, "cache/") && !strings.HasPrefix(path, "credentials/")
+}
+
+// sendNoUserAppKey refuses a resource request from a user without an application key.
+func sendNoUserAppKey(sender backend.CallResourceResponseSender, err error) error {
+	return sender.Send(&backend.CallResourceResponse{
+		Status: http.StatusForbidden,
+		Body:   []byte(fmt.Sprintf(`{"error": %q}`, err.Error())),
+	})
+}
@@ -0,0 +1,263 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wasilak/grafana-datadog-datasource/pkg/plugin/fakedatadog"
+)
+
+var (
+	alice   = &backend.User{Login: "alice", Email: "alice@example.com", Role: "Viewer"}
+	bob     = &backend.User{Login: "bob", Email: "Bob@Example.com", Role: "Viewer"}
+	mallory = &backend.User{Login: "mallory", Role: "Editor"}
+)
+
+// newFakeBackedDatasourceWithUserKeys returns a datasource with per-user application keys:
+// alice has her own key and bob belongs to the support team. The fake server accepts any
+// application key so that the key sent can be asserted.
+func newFakeBackedDatasourceWithUserKeys(t *testing.T, fallback bool) (*Datasource, *fakedatadog.Server) {
+	t.Helper()
+	srv := fakedatadog.New()
+	t.Cleanup(srv.Close)
+	srv.SetCredentials(fakedatadog.TestAPIKey, "")
+
+	inst, err := NewDatasource(context.Background(), backend.DataSourceInstanceSettings{
+		UID: "fake-datadog-users",
+		JSONData: []byte(fmt.Sprintf(`{"site": "datadoghq.com", "baseUrl": %q, "userAppKeys": {
+			"enabled": true,
+			"allowSharedKeyFallback": %t,
+			"teams": {"support": ["bob@example.com", "carol"]}
+		}}`, srv.URL(), fallback)),
+		DecryptedSecureJSONData: map[string]string{
+			"apiKey":             fakedatadog.TestAPIKey,
+			"appKey":             "shared-admin-app-key",
+			"userAppKey:alice":   "alice-app-key",
+			"teamAppKey:support": "support-app-key",
+		},
+	})
+	require.NoError(t, err)
+	d := inst.(*Datasource)
+	t.Cleanup(d.Dispose)
+	return d, srv
+}
+
+// callResourceAs invokes CallResource on behalf of user.
+func callResourceAs(t *testing.T, d *Datasource, user *backend.User, method, path string) *backend.CallResourceResponse {
+	t.Helper()
+	var got *backend.CallResourceResponse
+	err := d.CallResource(context.Background(), &backend.CallResourceRequest{
+		PluginContext: backend.PluginContext{User: user},
+		Method:        method,
+		Path:          path,
+		URL:           path,
+	}, backend.CallResourceResponseSenderFunc(func(resp *backend.CallResourceResponse) error {
+		got = resp
+		return nil
+	}))
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	return got
+}
+
+func queryAs(t *testing.T, d *Datasource, user *backend.User) backend.DataResponse {
+	t.Helper()
+	resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: backend.PluginContext{User: user},
+		Queries:       []backend.DataQuery{dataQuery("A", map[string]interface{}{"queryText": "avg:system.cpu.user{*}"})},
+	})
+	require.NoError(t, err)
+	return resp.Responses["A"]
+}
+
+func TestResolveUserAppKey(t *testing.T) {
+	d := &Datasource{
+		JSONData: &MyDataSourceOptions{UserAppKeys: &UserAppKeysOptions{
+			Enabled: true,
+			Teams: map[string][]string{
+				"support": {"bob@example.com"},
+				"admins":  {"alice"},
+			},
+		}},
+		SecureJSONData: map[string]string{
+			"userAppKey:Alice":   "alice-app-key",
+			"teamAppKey:support": "support-app-key",
+			"teamAppKey:admins":  "admins-app-key",
+		},
+	}
+
+	tests := []struct {
+		name   string
+		user   *backend.User
+		appKey string
+		owner  string
+	}{
+		{"own key wins over team key, case-insensitive", alice, "alice-app-key", "user alice"},
+		{"team member by email", bob, "support-app-key", "team support"},
+		{"no key", mallory, "", ""},
+		{"no user", nil, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			appKey, owner := d.resolveUserAppKey(tt.user)
+			assert.Equal(t, tt.appKey, appKey)
+			assert.Equal(t, tt.owner, owner)
+		})
+	}
+}
+
+func TestUserViews_Eviction(t *testing.T) {
+	var u userViews
+	created := 0
+	create := func() (*Datasource, error) {
+		created++
+		return &Datasource{}, nil
+	}
+	start := time.Now()
+
+	first, evicted, err := u.get("first", start, create)
+	require.NoError(t, err)
+	assert.Empty(t, evicted)
+	for i := 0; i < maxUserViews; i++ {
+		_, evicted, err = u.get(fmt.Sprintf("view-%d", i), start.Add(time.Duration(i+1)*time.Second), create)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []*Datasource{first}, evicted, "the least recently used view makes room")
+	assert.Len(t, u.views, maxUserViews)
+
+	again, _, err := u.get("view-0", start.Add(time.Hour), create)
+	require.NoError(t, err)
+	assert.Same(t, u.views["view-0"].view, again)
+	assert.Equal(t, maxUserViews+1, created)
+
+	_, evicted, err = u.get("view-0", start.Add(3*time.Hour), create)
+	require.NoError(t, err)
+	assert.Len(t, evicted, maxUserViews-1, "idle views are removed")
+	assert.Len(t, u.views, 1)
+}
+
+func TestIntegration_UserAppKeys_EvictedViewsAreDisposed(t *testing.T) {
+	d, _ := newFakeBackedDatasourceWithUserKeys(t, false)
+
+	require.NoError(t, queryAs(t, d, alice).Error)
+	views := d.views()
+	require.Len(t, views, 1)
+	d.userViews.views[hashedViewID("alice-app-key")].lastUsed = time.Now().Add(-2 * userViewIdleTTL)
+
+	require.NoError(t, queryAs(t, d, bob).Error)
+	assert.Len(t, d.views(), 1, "alice's idle view is removed")
+	select {
+	case <-views[0].janitor.done:
+	default:
+		t.Fatal("the cache janitor of a removed view is stopped")
+	}
+}
+
+func TestIntegration_UserAppKeys_QueriesUseTheViewersKey(t *testing.T) {
+	d, srv := newFakeBackedDatasourceWithUserKeys(t, false)
+
+	require.NoError(t, queryAs(t, d, alice).Error)
+	require.NoError(t, queryAs(t, d, bob).Error)
+
+	reqs := srv.Requests(fakedatadog.EndpointTimeseriesQuery)
+	require.Len(t, reqs, 2, "results fetched with one key are not served to users of another")
+	assert.Equal(t, "alice-app-key", reqs[0].Header.Get("DD-APPLICATION-KEY"))
+	assert.Equal(t, "support-app-key", reqs[1].Header.Get("DD-APPLICATION-KEY"))
+	assert.Equal(t, fakedatadog.TestAPIKey, reqs[1].Header.Get("DD-API-KEY"))
+}
+
+func TestIntegration_UserAppKeys_ResourcesAreIsolated(t *testing.T) {
+	d, srv := newFakeBackedDatasourceWithUserKeys(t, false)
+
+	require.Equal(t, http.StatusOK, callResourceAs(t, d, alice, http.MethodGet, "autocomplete/metrics").Status)
+	require.Equal(t, http.StatusOK, callResourceAs(t, d, alice, http.MethodGet, "autocomplete/metrics").Status)
+	assert.Equal(t, 1, srv.Hits(fakedatadog.EndpointTagConfigurations), "alice's second call is cached")
+
+	require.Equal(t, http.StatusOK, callResourceAs(t, d, bob, http.MethodGet, "autocomplete/metrics").Status)
+	reqs := srv.Requests(fakedatadog.EndpointTagConfigurations)
+	require.Len(t, reqs, 2, "bob does not get alice's cached list")
+	assert.Equal(t, "support-app-key", reqs[1].Header.Get("DD-APPLICATION-KEY"))
+
+	// Administration routes are served by the datasource itself and see every view.
+	resp := callResourceAs(t, d, &backend.User{Login: "admin", Role: orgAdminRole}, http.MethodPost, "cache/purge")
+	require.Equal(t, http.StatusOK, resp.Status)
+	assert.JSONEq(t, `{"removed": {"autocomplete": 2, "logs": 0, "logs_autocomplete": 0, "all_tags": 0, "metric_metadata": 0, "metrics": 0}}`, string(resp.Body))
+}
+
+func TestIntegration_UserAppKeys_UsersWithoutKeyAreRefused(t *testing.T) {
+	d, srv := newFakeBackedDatasourceWithUserKeys(t, false)
+
+	res := queryAs(t, d, mallory)
+	require.Error(t, res.Error)
+	assert.Equal(t, backend.StatusForbidden, res.Status)
+	assert.Contains(t, res.Error.Error(), `"mallory"`)
+
+	assert.Equal(t, http.StatusForbidden, callResourceAs(t, d, mallory, http.MethodGet, "autocomplete/metrics").Status)
+	assert.Equal(t, http.StatusForbidden, callResourceAs(t, d, nil, http.MethodGet, "autocomplete/metrics").Status)
+
+	health, err := d.CheckHealth(context.Background(), &backend.CheckHealthRequest{PluginContext: backend.PluginContext{User: mallory}})
+	require.NoError(t, err)
+	assert.Equal(t, backend.HealthStatusError, health.Status)
+
+	assert.Zero(t, srv.Hits(""), "the shared key is never used")
+}
+
+func TestIntegration_UserAppKeys_SharedKeyFallback(t *testing.T) {
+	d, srv := newFakeBackedDatasourceWithUserKeys(t, true)
+
+	require.NoError(t, queryAs(t, d, mallory).Error)
+	reqs := srv.Requests(fakedatadog.EndpointTimeseriesQuery)
+	require.Len(t, reqs, 1)
+	assert.Equal(t, "shared-admin-app-key", reqs[0].Header.Get("DD-APPLICATION-KEY"))
+}
+
+func TestIntegration_UserAppKeys_BackendQueries(t *testing.T) {
+	d, srv := newFakeBackedDatasourceWithUserKeys(t, false)
+
+	res := queryAs(t, d, nil)
+	require.Error(t, res.Error, "without a backend key, queries without a user are refused")
+	assert.Equal(t, backend.StatusForbidden, res.Status)
+	assert.Zero(t, srv.Hits(""))
+
+	d.SecureJSONData[backendAppKey] = "alerting-app-key"
+	require.NoError(t, queryAs(t, d, nil).Error)
+
+	reqs := srv.Requests(fakedatadog.EndpointTimeseriesQuery)
+	require.NotEmpty(t, reqs)
+	for _, r := range reqs {
+		assert.Equal(t, "alerting-app-key", r.Header.Get("DD-APPLICATION-KEY"))
+	}
+
+	// The FromAlert header is set by the client and does not grant the backend key to a user
+	resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: backend.PluginContext{User: mallory},
+		Headers:       map[string]string{"FromAlert": "true"},
+		Queries:       []backend.DataQuery{dataQuery("A", map[string]interface{}{"queryText": "avg:system.cpu.user{*}"})},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, backend.StatusForbidden, resp.Responses["A"].Status)
+	assert.Len(t, srv.Requests(fakedatadog.EndpointTimeseriesQuery), len(reqs))
+
+	// Users are still served with their own key, and resources still need a user
+	assert.Equal(t, backend.StatusForbidden, queryAs(t, d, mallory).Status)
+	assert.Equal(t, http.StatusForbidden, callResourceAs(t, d, nil, http.MethodGet, "autocomplete/metrics").Status)
+}
+
+func TestIntegration_UserAppKeys_CheckHealthUsesTheUsersKey(t *testing.T) {
+	d, srv := newFakeBackedDatasourceWithUserKeys(t, false)
+
+	res, err := d.CheckHealth(context.Background(), &backend.CheckHealthRequest{PluginContext: backend.PluginContext{User: bob}})
+	require.NoError(t, err)
+	assert.Equal(t, backend.HealthStatusOk, res.Status, res.Message)
+	assert.Equal(t, "Connected to Datadog (application key of team support)", res.Message)
+	for _, r := range srv.Requests("") {
+		assert.Equal(t, "support-app-key", r.Header.Get("DD-APPLICATION-KEY"), r.Endpoint)
+	}
+}
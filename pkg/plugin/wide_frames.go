@@ -0,0 +1,75 @@
+package plugin
+
+import (
+	"sort"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Output formats of metrics queries. Datadog returns the points of all series of a query on
+// shared timestamps; "multi" gives each series its own frame, while "wide" puts them all in
+// one frame with a value field per series. Wide frames are much cheaper for transformations
+// such as "join by field" and for CSV export of panels with many series.
+
+const (
+	// formatMulti returns one frame per series (default)
+	formatMulti = "multi"
+	// formatWide returns a single frame with one time field and a value field per series
+	formatWide = "wide"
+)
+
+// validFormat reports whether format is an output format a metrics query may ask for.
+func validFormat(format string) bool {
+	return format == "" || format == formatMulti || format == formatWide
+}
+
+// wideFrame joins the series frames of a query on their timestamps into a single frame. Each
+// series keeps its labels and field config on its value field; timestamps a series has no
+// point at are null. The notices of the first frame move to the wide frame.
+func wideFrame(frames data.Frames) *data.Frame {
+	seen := map[int64]bool{}
+	var millis []int64
+	for _, frame := range frames {
+		timeField := frame.Fields[0]
+		for i := 0; i < timeField.Len(); i++ {
+			ms := timeField.At(i).(time.Time).UnixMilli()
+			if !seen[ms] {
+				seen[ms] = true
+				millis = append(millis, ms)
+			}
+		}
+	}
+	sort.Slice(millis, func(i, j int) bool { return millis[i] < millis[j] })
+
+	index := make(map[int64]int, len(millis))
+	times := make([]time.Time, len(millis))
+	for i, ms := range millis {
+		index[ms] = i
+		times[i] = time.UnixMilli(ms)
+	}
+
+	timeField := data.NewField("Time", nil, times)
+	timeField.Config = frames[0].Fields[0].Config
+	wide := data.NewFrame("", timeField)
+	wide.Meta = &data.FrameMeta{Type: data.FrameTypeTimeSeriesWide}
+	if frames[0].Meta != nil {
+		wide.Meta.Notices = frames[0].Meta.Notices
+	}
+
+	for _, frame := range frames {
+		seriesTimes, seriesValues := frame.Fields[0], frame.Fields[1]
+		values := make([]*float64, len(millis))
+		for i := 0; i < seriesValues.Len(); i++ {
+			v, err := seriesValues.NullableFloatAt(i)
+			if err != nil {
+				continue
+			}
+			values[index[seriesTimes.At(i).(time.Time).UnixMilli()]] = v
+		}
+		field := data.NewField(seriesValues.Name, seriesValues.Labels, values)
+		field.Config = seriesValues.Config
+		wide.Fields = append(wide.Fields, field)
+	}
+	return wide
+}
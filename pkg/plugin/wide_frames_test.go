@@ -0,0 +1,93 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wasilak/grafana-datadog-datasource/pkg/plugin/fakedatadog"
+)
+
+func TestWideFrame(t *testing.T) {
+	at := func(ms ...int64) []time.Time {
+		out := make([]time.Time, len(ms))
+		for i, m := range ms {
+			out[i] = time.UnixMilli(m)
+		}
+		return out
+	}
+	a := data.NewFrame("a",
+		data.NewField("Time", nil, at(1000, 3000)),
+		data.NewField("Value", data.Labels{"host": "a"}, []float64{1, 3}),
+	)
+	a.Fields[0].Config = &data.FieldConfig{Interval: 1000}
+	a.Fields[1].Config = &data.FieldConfig{DisplayName: "host a", Unit: "bytes"}
+	notice := data.Notice{Severity: data.NoticeSeverityInfo, Text: "note"}
+	a.Meta = &data.FrameMeta{Notices: []data.Notice{notice}}
+	b := data.NewFrame("b",
+		data.NewField("Time", nil, at(1000, 2000)),
+		data.NewField("Value", data.Labels{"host": "b"}, []*float64{ptr(10), nil}),
+	)
+
+	wide := wideFrame(data.Frames{a, b})
+	require.Len(t, wide.Fields, 3)
+	assert.Equal(t, data.FrameTypeTimeSeriesWide, wide.Meta.Type)
+	assert.Equal(t, []data.Notice{notice}, wide.Meta.Notices)
+	assert.Equal(t, 1000.0, wide.Fields[0].Config.Interval)
+	for i, ms := range []int64{1000, 2000, 3000} {
+		assert.Equal(t, ms, wide.Fields[0].At(i).(time.Time).UnixMilli())
+	}
+	assert.Equal(t, data.Labels{"host": "a"}, wide.Fields[1].Labels)
+	assert.Equal(t, "host a", wide.Fields[1].Config.DisplayName)
+	assert.Equal(t, []*float64{ptr(1), nil, ptr(3)}, fieldValues(wide.Fields[1]))
+	assert.Equal(t, []*float64{ptr(10), nil, nil}, fieldValues(wide.Fields[2]))
+}
+
+func fieldValues(f *data.Field) []*float64 {
+	out := make([]*float64, f.Len())
+	for i := range out {
+		out[i], _ = f.NullableFloatAt(i)
+	}
+	return out
+}
+
+func TestIntegration_WideFormat(t *testing.T) {
+	d, srv := newFakeBackedDatasource(t)
+
+	now := time.Now().Truncate(time.Minute).UnixMilli()
+	srv.Enqueue(fakedatadog.EndpointTimeseriesQuery, fakedatadog.JSON(fakedatadog.TimeseriesResponse(
+		[]int64{now - 60000, now},
+		fakedatadog.Series{GroupTags: []string{"host:web-01"}, Values: fakedatadog.Points(1, 2)},
+		fakedatadog.Series{GroupTags: []string{"host:web-02"}, Values: []*float64{nil, ptr(4)}},
+	)))
+	resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{dataQuery("A", map[string]interface{}{
+			"queryText": "avg:system.mem.used{*}", "format": "wide", "nullHandling": "drop",
+		})},
+	})
+	require.NoError(t, err)
+	res := resp.Responses["A"]
+	require.NoError(t, res.Error)
+	require.Len(t, res.Frames, 1)
+	frame := res.Frames[0]
+	require.Len(t, frame.Fields, 3)
+	assert.Equal(t, 2, frame.Rows())
+	assert.Equal(t, "web-02", frame.Fields[2].Labels["host"])
+	assert.Equal(t, []*float64{nil, ptr(4)}, fieldValues(frame.Fields[2]))
+	assert.Equal(t, "bytes", frame.Fields[1].Config.Unit)
+	assert.Equal(t, "avg:system.mem.used{*} by {*}", frame.Meta.ExecutedQueryString)
+	assert.Equal(t, 2.0, frameStats(t, res)["Series"])
+	require.Len(t, frame.Meta.Notices, 1)
+	assert.Contains(t, frame.Meta.Notices[0].Text, "Dropped 1 null points")
+
+	resp, err = d.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{dataQuery("A", map[string]interface{}{"queryText": "avg:system.mem.used{*}", "format": "table"})},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, backend.StatusBadRequest, resp.Responses["A"].Status)
+}
@@ -1,8 +1,8 @@
 import React, { ChangeEvent, useState } from 'react';
-import { InlineField, Input, SecretInput, Button, Alert } from '@grafana/ui';
+import { InlineField, InlineSwitch, Input, SecretInput, Button, Alert } from '@grafana/ui';
 import { DataSourcePluginOptionsEditorProps } from '@grafana/data';
 import { getBackendSrv } from '@grafana/runtime';
-import { MyDataSourceOptions, MySecureJsonData } from './types';
+import { MyDataSourceOptions, MySecureJsonData, PolicyListField, QueryLimitNumberField } from './types';
 
 interface Props extends DataSourcePluginOptionsEditorProps<MyDataSourceOptions, MySecureJsonData> {}
 
@@ -10,6 +10,24 @@ interface Props extends DataSourcePluginOptionsEditorProps<MyDataSourceOptions,
 const VALID_SITE_PATTERN =
   /^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*\.[a-zA-Z]{2,}$/;
 
+function validateBaseUrl(baseUrl: string): string | null {
+  if (!baseUrl) {
+    return null; // Empty is ok — backend derives https://api.<site>
+  }
+  try {
+    const parsed = new URL(baseUrl);
+    if (parsed.protocol !== 'http:' && parsed.protocol !== 'https:') {
+      return 'Base URL must use http:// or https://';
+    }
+    if (parsed.search || parsed.hash) {
+      return 'Base URL must not contain a query string or fragment';
+    }
+  } catch {
+    return `"${baseUrl}" is not a valid URL. Use format like: https://dd-proxy.internal:8443/datadog`;
+  }
+  return null;
+}
+
 function validateSite(site: string): string | null {
   if (!site) {
     return null; // Empty is ok — backend defaults to datadoghq.com
@@ -27,8 +45,11 @@ export function ConfigEditor(props: Props) {
   const { jsonData, secureJsonFields, secureJsonData } = options;
 
   const [siteError, setSiteError] = useState<string | null>(null);
+  const [baseUrlError, setBaseUrlError] = useState<string | null>(null);
   const [testStatus, setTestStatus] = useState<'idle' | 'loading' | 'success' | 'error'>('idle');
   const [testMessage, setTestMessage] = useState<string>('');
+  const [rotateStatus, setRotateStatus] = useState<'idle' | 'loading' | 'success' | 'error'>('idle');
+  const [rotateMessage, setRotateMessage] = useState<string>('');
 
   const onSiteChange = (event: ChangeEvent<HTMLInputElement>) => {
     const value = event.target.value;
@@ -46,6 +67,110 @@ export function ConfigEditor(props: Props) {
     setSiteError(validateSite(event.target.value));
   };
 
+  const onBaseUrlChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const value = event.target.value;
+    setBaseUrlError(validateBaseUrl(value));
+    onOptionsChange({
+      ...options,
+      jsonData: {
+        ...jsonData,
+        baseUrl: value,
+      },
+    });
+  };
+
+  const onLogQueriesChange = (event: React.FormEvent<HTMLInputElement>) => {
+    onOptionsChange({
+      ...options,
+      jsonData: {
+        ...jsonData,
+        logQueries: event.currentTarget.checked,
+      },
+    });
+  };
+
+  const onPersistentCacheChange = (event: React.FormEvent<HTMLInputElement>) => {
+    onOptionsChange({
+      ...options,
+      jsonData: {
+        ...jsonData,
+        persistentCache: event.currentTarget.checked,
+      },
+    });
+  };
+
+  const onUserAppKeysChange = (field: 'enabled' | 'allowSharedKeyFallback') => (event: React.FormEvent<HTMLInputElement>) => {
+    onOptionsChange({
+      ...options,
+      jsonData: {
+        ...jsonData,
+        userAppKeys: {
+          enabled: false,
+          ...jsonData.userAppKeys,
+          [field]: event.currentTarget.checked,
+        },
+      },
+    });
+  };
+
+  // Policy lists are edited as comma-separated text and stored on blur, so typing a comma is not undone
+  const onPolicyListBlur = (field: PolicyListField) => (event: React.FocusEvent<HTMLInputElement>) => {
+    const values = event.currentTarget.value
+      .split(',')
+      .map((value) => value.trim())
+      .filter((value) => value !== '');
+    onOptionsChange({
+      ...options,
+      jsonData: {
+        ...jsonData,
+        policy: {
+          ...jsonData.policy,
+          [field]: values.length > 0 ? values : undefined,
+        },
+      },
+    });
+  };
+
+  const onRequiredLogQueryChange = (event: ChangeEvent<HTMLInputElement>) => {
+    onOptionsChange({
+      ...options,
+      jsonData: {
+        ...jsonData,
+        policy: {
+          ...jsonData.policy,
+          requiredLogQuery: event.target.value || undefined,
+        },
+      },
+    });
+  };
+
+  const onLimitNumberChange = (field: QueryLimitNumberField) => (event: ChangeEvent<HTMLInputElement>) => {
+    const value = parseInt(event.target.value, 10);
+    onOptionsChange({
+      ...options,
+      jsonData: {
+        ...jsonData,
+        limits: {
+          ...jsonData.limits,
+          [field]: Number.isNaN(value) ? undefined : value,
+        },
+      },
+    });
+  };
+
+  const onMaxLogsTimeRangeChange = (event: ChangeEvent<HTMLInputElement>) => {
+    onOptionsChange({
+      ...options,
+      jsonData: {
+        ...jsonData,
+        limits: {
+          ...jsonData.limits,
+          maxLogsTimeRange: event.target.value || undefined,
+        },
+      },
+    });
+  };
+
   const onAPIKeyChange = (event: ChangeEvent<HTMLInputElement>) => {
     onOptionsChange({
       ...options,
@@ -94,6 +219,62 @@ export function ConfigEditor(props: Props) {
     });
   };
 
+  const onSecretChange = (key: keyof MySecureJsonData) => (event: ChangeEvent<HTMLInputElement>) => {
+    onOptionsChange({
+      ...options,
+      secureJsonData: {
+        ...secureJsonData,
+        [key]: event.target.value,
+      },
+    });
+  };
+
+  const onSecretReset = (key: keyof MySecureJsonData) => () => {
+    onOptionsChange({
+      ...options,
+      secureJsonFields: {
+        ...secureJsonFields,
+        [key]: false,
+      },
+      secureJsonData: {
+        ...secureJsonData,
+        [key]: '',
+      },
+    });
+  };
+
+  // Validates the standby key pair in the backend and promotes it when every check passes.
+  // The promotion takes effect immediately; saving keeps it across restarts.
+  const onRotateKeys = async () => {
+    setRotateStatus('loading');
+    setRotateMessage('');
+    try {
+      const response = await getBackendSrv()
+        .fetch<{ active: 'primary' | 'secondary' }>({
+          url: `/api/datasources/uid/${options.uid}/resources/credentials/rotate`,
+          method: 'POST',
+          showErrorAlert: false,
+        })
+        .toPromise();
+      const active = response?.data.active ?? 'primary';
+      onOptionsChange({
+        ...options,
+        jsonData: {
+          ...jsonData,
+          activeKeyPair: active,
+        },
+      });
+      setRotateStatus('success');
+      setRotateMessage(`The ${active} key pair is now active. Save to keep it active after restarts.`);
+    } catch (err: any) {
+      setRotateStatus('error');
+      setRotateMessage(err?.data?.error || err?.message || 'Key rotation failed');
+    }
+  };
+
+  const activeKeyPair = jsonData.activeKeyPair || 'primary';
+  const secondaryConfigured = !!secureJsonFields?.secondaryApiKey && !!secureJsonFields?.secondaryAppKey;
+
   const onTestConnection = async () => {
     setTestStatus('loading');
     setTestMessage('');
@@ -139,7 +320,29 @@ export function ConfigEditor(props: Props) {
           invalid={!!siteError}
         />
       </InlineField>
-      <InlineField label="API Key" labelWidth={14} interactive tooltip="Datadog API key (backend only)">
+      <InlineField
+        label="Base URL"
+        labelWidth={14}
+        interactive
+        tooltip="Optional API endpoint override for PrivateLink/proxy gateways (replaces https://api.<site>)"
+        invalid={!!baseUrlError}
+        error={baseUrlError || undefined}
+      >
+        <Input
+          id="config-editor-base-url"
+          onChange={onBaseUrlChange}
+          value={jsonData.baseUrl || ''}
+          placeholder="https://api.datadoghq.com"
+          width={40}
+          invalid={!!baseUrlError}
+        />
+      </InlineField>
+      <InlineField
+        label="API Key"
+        labelWidth={14}
+        interactive
+        tooltip="Datadog API key (backend only)"
+      >
         <SecretInput
           required
           id="config-editor-api-key"
@@ -151,7 +354,12 @@ export function ConfigEditor(props: Props) {
           onChange={onAPIKeyChange}
         />
       </InlineField>
-      <InlineField label="APP Key" labelWidth={14} interactive tooltip="Datadog application key (backend only)">
+      <InlineField
+        label="APP Key"
+        labelWidth={14}
+        interactive
+        tooltip="Datadog application key (backend only)"
+      >
         <SecretInput
           required
           id="config-editor-app-key"
@@ -163,11 +371,249 @@ export function ConfigEditor(props: Props) {
           onChange={onAPPKeyChange}
         />
       </InlineField>
+      <InlineField
+        label="Secondary API Key"
+        labelWidth={14}
+        interactive
+        tooltip="Optional second Datadog API key. Requests switch to the other key pair when Datadog rejects the active one (401/403)"
+      >
+        <SecretInput
+          id="config-editor-secondary-api-key"
+          isConfigured={secureJsonFields?.secondaryApiKey}
+          value={secureJsonData?.secondaryApiKey}
+          placeholder="Enter a second API key"
+          width={40}
+          onReset={onSecretReset('secondaryApiKey')}
+          onChange={onSecretChange('secondaryApiKey')}
+        />
+      </InlineField>
+      <InlineField
+        label="Secondary APP Key"
+        labelWidth={14}
+        interactive
+        tooltip="Application key of the secondary key pair"
+      >
+        <SecretInput
+          id="config-editor-secondary-app-key"
+          isConfigured={secureJsonFields?.secondaryAppKey}
+          value={secureJsonData?.secondaryAppKey}
+          placeholder="Enter a second application key"
+          width={40}
+          onReset={onSecretReset('secondaryAppKey')}
+          onChange={onSecretChange('secondaryAppKey')}
+        />
+      </InlineField>
+      {secondaryConfigured && (
+        <InlineField
+          label="Key rotation"
+          labelWidth={14}
+          interactive
+          tooltip="Validates the standby key pair against every capability the plugin uses and makes it active when all checks pass"
+        >
+          <Button
+            variant="secondary"
+            onClick={onRotateKeys}
+            disabled={rotateStatus === 'loading'}
+            icon={rotateStatus === 'loading' ? 'spinner' : 'sync'}
+          >
+            {`Promote ${activeKeyPair === 'primary' ? 'secondary' : 'primary'} key pair`}
+          </Button>
+        </InlineField>
+      )}
+      {rotateStatus === 'success' && (
+        <Alert title="Key pair promoted" severity="success">
+          {rotateMessage}
+        </Alert>
+      )}
+      {rotateStatus === 'error' && (
+        <Alert title="Key pair not promoted" severity="error">
+          {rotateMessage}
+        </Alert>
+      )}
+      <InlineField
+        label="Per-user keys"
+        labelWidth={14}
+        interactive
+        tooltip="Query Datadog with the application key of the viewing user or team so that Datadog RBAC and restricted log indexes apply. Keys are provisioned as userAppKey:<login> and teamAppKey:<team> secure fields; alert rules use the backendAppKey secure field"
+      >
+        <InlineSwitch
+          id="config-editor-user-app-keys"
+          value={jsonData.userAppKeys?.enabled || false}
+          onChange={onUserAppKeysChange('enabled')}
+        />
+      </InlineField>
+      {jsonData.userAppKeys?.enabled && (
+        <InlineField
+          label="Shared fallback"
+          labelWidth={14}
+          interactive
+          tooltip="Let users without a key of their own use the shared APP key. Off: such users are refused"
+        >
+          <InlineSwitch
+            id="config-editor-user-app-keys-fallback"
+            value={jsonData.userAppKeys?.allowSharedKeyFallback || false}
+            onChange={onUserAppKeysChange('allowSharedKeyFallback')}
+          />
+        </InlineField>
+      )}
+      <InlineField
+        label="Metric prefixes"
+        labelWidth={14}
+        interactive
+        tooltip="Only metrics starting with one of these prefixes may be queried or listed. Empty: any metric"
+      >
+        <Input
+          id="config-editor-policy-metric-prefixes"
+          onBlur={onPolicyListBlur('allowedMetricPrefixes')}
+          defaultValue={(jsonData.policy?.allowedMetricPrefixes || []).join(', ')}
+          placeholder="payments., trace.http."
+          width={40}
+        />
+      </InlineField>
+      <InlineField
+        label="Tag keys"
+        labelWidth={14}
+        interactive
+        tooltip="Tag keys metric queries may filter and group by, and that autocomplete lists. Empty: any key"
+      >
+        <Input
+          id="config-editor-policy-tag-keys"
+          onBlur={onPolicyListBlur('allowedTagKeys')}
+          defaultValue={(jsonData.policy?.allowedTagKeys || []).join(', ')}
+          placeholder="host, service"
+          width={40}
+        />
+      </InlineField>
+      <InlineField
+        label="Required tags"
+        labelWidth={14}
+        interactive
+        tooltip="key:value tags added to the scope of every metric query"
+      >
+        <Input
+          id="config-editor-policy-required-tags"
+          onBlur={onPolicyListBlur('requiredMetricTags')}
+          defaultValue={(jsonData.policy?.requiredMetricTags || []).join(', ')}
+          placeholder="env:prod, team:payments"
+          width={40}
+        />
+      </InlineField>
+      <InlineField
+        label="Log indexes"
+        labelWidth={14}
+        interactive
+        tooltip="Log indexes logs queries may search. Empty: all indexes"
+      >
+        <Input
+          id="config-editor-policy-log-indexes"
+          onBlur={onPolicyListBlur('allowedLogIndexes')}
+          defaultValue={(jsonData.policy?.allowedLogIndexes || []).join(', ')}
+          placeholder="payments"
+          width={40}
+        />
+      </InlineField>
+      <InlineField
+        label="Required logs"
+        labelWidth={14}
+        interactive
+        tooltip="Logs search ANDed to every logs query and logs autocomplete lookup"
+      >
+        <Input
+          id="config-editor-policy-required-logs"
+          onChange={onRequiredLogQueryChange}
+          value={jsonData.policy?.requiredLogQuery || ''}
+          placeholder="team:payments"
+          width={40}
+        />
+      </InlineField>
+      <InlineField
+        label="Max series"
+        labelWidth={14}
+        interactive
+        tooltip="Series returned for one metrics query; the rest are dropped with a warning. Default 1000, -1: no limit"
+      >
+        <Input
+          id="config-editor-limits-max-series"
+          type="number"
+          onChange={onLimitNumberChange('maxSeries')}
+          value={jsonData.limits?.maxSeries ?? ''}
+          placeholder="1000"
+          width={40}
+        />
+      </InlineField>
+      <InlineField
+        label="Logs range"
+        labelWidth={14}
+        interactive
+        tooltip="Logs queries over a longer time range are refused, e.g. 12h, 7d or 2w. Empty: no limit"
+      >
+        <Input
+          id="config-editor-limits-logs-range"
+          onChange={onMaxLogsTimeRangeChange}
+          value={jsonData.limits?.maxLogsTimeRange || ''}
+          placeholder="7d"
+          width={40}
+        />
+      </InlineField>
+      <InlineField
+        label="Logs pages"
+        labelWidth={14}
+        interactive
+        tooltip="Pages of up to 1000 lines one logs query may fetch. Default 1"
+      >
+        <Input
+          id="config-editor-limits-logs-pages"
+          type="number"
+          onChange={onLimitNumberChange('maxLogsPages')}
+          value={jsonData.limits?.maxLogsPages ?? ''}
+          placeholder="1"
+          width={40}
+        />
+      </InlineField>
+      <InlineField
+        label="Requests/min"
+        labelWidth={14}
+        interactive
+        tooltip="Datadog requests the datasource may make per minute, for queries, autocomplete and health checks together. Empty: no limit"
+      >
+        <Input
+          id="config-editor-limits-requests-per-minute"
+          type="number"
+          onChange={onLimitNumberChange('requestsPerMinute')}
+          value={jsonData.limits?.requestsPerMinute ?? ''}
+          placeholder="300"
+          width={40}
+        />
+      </InlineField>
+      <InlineField
+        label="Log queries"
+        labelWidth={14}
+        interactive
+        tooltip="Write query text to the Grafana server log for troubleshooting. Off by default: queries are logged as fingerprints"
+      >
+        <InlineSwitch
+          id="config-editor-log-queries"
+          value={jsonData.logQueries || false}
+          onChange={onLogQueriesChange}
+        />
+      </InlineField>
+      <InlineField
+        label="Persistent cache"
+        labelWidth={14}
+        interactive
+        tooltip="Keep organization-wide tag listings used by variables on disk under the Grafana data directory, so they survive plugin restarts"
+      >
+        <InlineSwitch
+          id="config-editor-persistent-cache"
+          value={jsonData.persistentCache || false}
+          onChange={onPersistentCacheChange}
+        />
+      </InlineField>
       <InlineField label=" " labelWidth={14}>
         <Button
           variant="secondary"
           onClick={onTestConnection}
-          disabled={testStatus === 'loading' || !!siteError}
+          disabled={testStatus === 'loading' || !!siteError || !!baseUrlError}
           icon={testStatus === 'loading' ? 'spinner' : 'heart'}
         >
           {testStatus === 'loading' ? 'Testing...' : 'Test Connection'}
@@ -1,9 +1,9 @@
 import React, { useRef, useState, useEffect } from 'react';
-import { CodeEditor, Stack, Alert, useTheme2, Button, InlineField, InlineFieldRow } from '@grafana/ui';
-import { QueryEditorProps } from '@grafana/data';
+import { CodeEditor, Stack, Alert, useTheme2, Button, InlineField, InlineFieldRow, Select } from '@grafana/ui';
+import { QueryEditorProps, SelectableValue } from '@grafana/data';
 import type * as monacoType from 'monaco-editor/esm/vs/editor/editor.api';
 import { DataSource } from './datasource';
-import { MyDataSourceOptions, MyQuery, CompletionItem } from './types';
+import { MyDataSourceOptions, MyQuery, CompletionItem, SavedQuery } from './types';
 import { useQueryAutocomplete } from './hooks/useQueryAutocomplete';
 import { registerDatadogLanguage } from './utils/autocomplete/syntaxHighlighter';
 import { LogsQueryEditorHelp } from './LogsQueryEditorHelp';
@@ -31,6 +31,7 @@ export function LogsQueryEditor({ query, onChange, onRunQuery, datasource, ...re
   const [showHelp, setShowHelp] = useState(false);
   const [validationError, setValidationError] = useState<string | null>(null);
   const [validationWarnings, setValidationWarnings] = useState<string[]>([]);
+  const [savedViews, setSavedViews] = useState<SavedQuery[]>([]);
 
   // Ref to track autocomplete state for Monaco keyboard handler
   const autocompleteStateRef = useRef({ isOpen: false, selectedIndex: 0, suggestions: [] as CompletionItem[] });
@@ -47,6 +48,43 @@ export function LogsQueryEditor({ query, onChange, onRunQuery, datasource, ...re
     };
   }, []);
 
+  // Load the saved log views of the datasource; the picker is hidden when there are none
+  useEffect(() => {
+    let cancelled = false;
+    datasource
+      .getResource('logs/saved-views')
+      .then((response: { views?: SavedQuery[] }) => {
+        if (!cancelled) {
+          setSavedViews(response.views || []);
+        }
+      })
+      .catch(() => {
+        // Saved views are optional; the editor works without them
+      });
+    return () => {
+      cancelled = true;
+    };
+  }, [datasource]);
+
+  const savedViewOptions: Array<SelectableValue<SavedQuery>> = savedViews.map((view) => ({
+    label: view.name,
+    value: view,
+    description: view.query.logQuery,
+  }));
+
+  // Applying a saved view replaces the search, indexes and JSON parsing of the query
+  const onSavedViewChange = (option: SelectableValue<SavedQuery>) => {
+    if (!option.value) {
+      return;
+    }
+    const { logQuery = '', indexes = [], jsonParsing } = option.value.query;
+    const validation = validateLogsQuery(logQuery);
+    setValidationError(validation.isValid ? null : validation.error || null);
+    setValidationWarnings(validation.warnings || []);
+    onChange({ ...query, queryType: 'logs', logQuery, indexes, jsonParsing });
+    onRunQuery();
+  };
+
   // Define handleItemSelect for logs-specific autocomplete
   const handleItemSelect = async (item: CompletionItem) => {
     // Get CURRENT values from Monaco editor
@@ -321,6 +359,25 @@ export function LogsQueryEditor({ query, onChange, onRunQuery, datasource, ...re
 
   return (
     <Stack gap={2} direction="column">
+      {/* Saved log views configured on the datasource */}
+      {savedViewOptions.length > 0 && (
+        <InlineFieldRow>
+          <InlineField
+            label="Saved view"
+            labelWidth={14}
+            tooltip="Replace the search, indexes and JSON parsing with a saved log view of the datasource"
+          >
+            <Select
+              width={40}
+              options={savedViewOptions}
+              value={null}
+              placeholder="Choose a saved view"
+              onChange={onSavedViewChange}
+            />
+          </InlineField>
+        </InlineFieldRow>
+      )}
+
       {/* Logs Query field */}
       <InlineFieldRow>
         <InlineField
@@ -16,11 +16,13 @@ import { QueryEditorProps, SelectableValue, CoreApp } from '@grafana/data';
 import { getBackendSrv } from '@grafana/runtime';
 import type * as monacoType from 'monaco-editor/esm/vs/editor/editor.api';
 import { DataSource } from './datasource';
-import { MyDataSourceOptions, MyQuery, CompletionItem } from './types';
+import { MyDataSourceOptions, MyQuery, CompletionItem, NullHandling, QueryType } from './types';
 import { useQueryAutocomplete } from './hooks/useQueryAutocomplete';
 import { registerDatadogLanguage } from './utils/autocomplete/syntaxHighlighter';
 import { QueryEditorHelp } from './QueryEditorHelp';
 import { LogsQueryEditor } from './LogsQueryEditor';
+import { ServiceMapQueryEditor } from './ServiceMapQueryEditor';
+import { RUMQueryEditor } from './RUMQueryEditor';
 
 type Props = QueryEditorProps<DataSource, MyQuery, MyDataSourceOptions>;
 
@@ -74,21 +76,24 @@ export function QueryEditor({ query, onChange, onRunQuery, datasource, ...restPr
   const theme = useTheme2();
 
   // Query type options
-  const queryTypeOptions: Array<SelectableValue<'metrics' | 'logs'>> = [
+  const queryTypeOptions: Array<SelectableValue<QueryType>> = [
     { label: 'Metrics', value: 'metrics', description: 'Query Datadog metrics and time series data' },
     { label: 'Logs', value: 'logs', description: 'Search and analyze Datadog logs' },
+    { label: 'Service Map', value: 'service-map', description: 'APM service dependencies as a node graph' },
+    { label: 'RUM', value: 'rum', description: 'Search and aggregate Real User Monitoring events' },
   ];
 
   // Get current query type, defaulting to 'metrics'
   const currentQueryType = query.queryType || 'metrics';
 
-  const onQueryTypeChange = (option: SelectableValue<'metrics' | 'logs'>) => {
+  const onQueryTypeChange = (option: SelectableValue<QueryType>) => {
     const newQueryType = option.value || 'metrics';
     onChange({
       ...query,
       queryType: newQueryType,
-      // Clear the other query field when switching types to avoid confusion
+      // Clear the other query fields when switching types to avoid confusion
       ...(newQueryType === 'logs' ? { queryText: '' } : { logQuery: '' }),
+      ...(newQueryType === 'service-map' || newQueryType === 'rum' ? { queryText: '' } : {}),
     });
   };
 
@@ -96,7 +101,7 @@ export function QueryEditor({ query, onChange, onRunQuery, datasource, ...restPr
     <Stack gap={2} direction="column">
       {/* Query Type Selector */}
       <InlineFieldRow>
-        <InlineField label="Query Type" labelWidth={14} tooltip="Select whether to query metrics or logs">
+        <InlineField label="Query Type" labelWidth={14} tooltip="Select whether to query metrics, logs, the service map or RUM events">
           <Select
             options={queryTypeOptions}
             value={queryTypeOptions.find((opt) => opt.value === currentQueryType)}
@@ -116,6 +121,22 @@ export function QueryEditor({ query, onChange, onRunQuery, datasource, ...restPr
           datasource={datasource}
           {...restProps}
         />
+      ) : currentQueryType === 'rum' ? (
+        <RUMQueryEditor
+          query={query}
+          onChange={onChange}
+          onRunQuery={onRunQuery}
+          datasource={datasource}
+          {...restProps}
+        />
+      ) : currentQueryType === 'service-map' ? (
+        <ServiceMapQueryEditor
+          query={query}
+          onChange={onChange}
+          onRunQuery={onRunQuery}
+          datasource={datasource}
+          {...restProps}
+        />
       ) : (
         <MetricsQueryEditor
           query={query}
@@ -152,6 +173,33 @@ function MetricsQueryEditor({ query, onChange, onRunQuery, datasource, app, ...r
     { label: 'Custom', value: 'custom', description: 'Provide a naming template' },
   ];
 
+  const nullHandlingOptions: Array<SelectableValue<NullHandling>> = [
+    { label: 'Drop', value: 'drop', description: 'Leave null points out and connect the rest' },
+    { label: 'Auto', value: 'auto', description: 'Zero for counts and rates, gaps for gauges' },
+    { label: 'Null', value: 'null', description: 'Keep null points as nulls' },
+    { label: 'Zero', value: 'zero', description: 'Replace null points with 0' },
+    { label: 'Previous', value: 'previous', description: 'Repeat the last value before the nulls' },
+    { label: 'Gaps', value: 'gaps', description: 'Break the line where points are missing' },
+  ];
+
+  const formatOptions: Array<SelectableValue<'multi' | 'wide'>> = [
+    { label: 'Multi-frame', value: 'multi', description: 'One frame per series' },
+    { label: 'Wide', value: 'wide', description: 'One frame with a value field per series' },
+  ];
+
+  const orderOptions: Array<SelectableValue<'desc' | 'asc'>> = [
+    { label: 'Top', value: 'desc', description: 'Series with the highest values' },
+    { label: 'Bottom', value: 'asc', description: 'Series with the lowest values' },
+  ];
+
+  const orderByOptions: Array<SelectableValue<'avg' | 'max' | 'min' | 'last' | 'sum'>> = [
+    { label: 'avg', value: 'avg' },
+    { label: 'max', value: 'max' },
+    { label: 'min', value: 'min' },
+    { label: 'last', value: 'last' },
+    { label: 'sum', value: 'sum' },
+  ];
+
   // Ref to track autocomplete state for Monaco keyboard handler
   const autocompleteStateRef = useRef({ isOpen: false, selectedIndex: 0, suggestions: [] as CompletionItem[] });
 
@@ -875,6 +923,80 @@ function MetricsQueryEditor({ query, onChange, onRunQuery, datasource, app, ...r
             />
           </InlineField>
         </InlineFieldRow>
+
+        {/* Top-N series */}
+        <InlineFieldRow>
+          <InlineField
+            label="Limit"
+            labelWidth={14}
+            tooltip="Keep only the top or bottom N series, ranked by the chosen value. Leave empty to keep all series."
+          >
+            <Input
+              type="number"
+              min={1}
+              value={query.limit || ''}
+              onChange={(e) => {
+                const value = e.currentTarget.value;
+                const limit = value ? parseInt(value, 10) : undefined;
+                onChange({ ...query, limit });
+              }}
+              placeholder="All"
+              width={20}
+            />
+          </InlineField>
+          {!!query.limit && (
+            <>
+              <InlineField label="Order">
+                <Select
+                  options={orderOptions}
+                  value={orderOptions.find((opt) => opt.value === (query.order || 'desc'))}
+                  onChange={(option) => onChange({ ...query, order: option.value })}
+                  width={12}
+                />
+              </InlineField>
+              <InlineField label="By">
+                <Select
+                  options={orderByOptions}
+                  value={orderByOptions.find((opt) => opt.value === (query.orderBy || 'avg'))}
+                  onChange={(option) => onChange({ ...query, orderBy: option.value })}
+                  width={12}
+                />
+              </InlineField>
+            </>
+          )}
+        </InlineFieldRow>
+
+        {/* Null handling */}
+        <InlineFieldRow>
+          <InlineField
+            label="Nulls"
+            labelWidth={14}
+            tooltip="What intervals without data become. Drop (default) leaves them out; Auto fills counts and rates with zero and breaks gauges at missing data."
+          >
+            <Select
+              options={nullHandlingOptions}
+              value={nullHandlingOptions.find((opt) => opt.value === (query.nullHandling || 'drop'))}
+              onChange={(option) => onChange({ ...query, nullHandling: option.value })}
+              width={20}
+            />
+          </InlineField>
+        </InlineFieldRow>
+
+        {/* Output format */}
+        <InlineFieldRow>
+          <InlineField
+            label="Format"
+            labelWidth={14}
+            tooltip="Wide returns all series in one frame, which is cheaper for transformations such as join by field and for CSV export."
+          >
+            <Select
+              options={formatOptions}
+              value={formatOptions.find((opt) => opt.value === (query.format || 'multi'))}
+              onChange={(option) => onChange({ ...query, format: option.value })}
+              width={20}
+            />
+          </InlineField>
+        </InlineFieldRow>
       </Collapse>
     </Stack>
   );
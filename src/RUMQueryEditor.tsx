@@ -0,0 +1,122 @@
+import React, { ChangeEvent } from 'react';
+import { InlineField, InlineFieldRow, Input, Select, Stack, TagsInput } from '@grafana/ui';
+import { QueryEditorProps, SelectableValue } from '@grafana/data';
+import { DataSource } from './datasource';
+import { MyDataSourceOptions, MyQuery, RUMAggregation } from './types';
+
+type RUMQueryEditorProps = QueryEditorProps<DataSource, MyQuery, MyDataSourceOptions>;
+
+const aggregationOptions: Array<SelectableValue<RUMAggregation | ''>> = [
+  { label: 'List events', value: '', description: 'Latest events as a table' },
+  { label: 'Count', value: 'count' },
+  { label: 'Unique count', value: 'cardinality' },
+  { label: 'Average', value: 'avg' },
+  { label: 'Sum', value: 'sum' },
+  { label: 'Min', value: 'min' },
+  { label: 'Max', value: 'max' },
+  { label: 'Median', value: 'median' },
+  { label: 'p75', value: 'pc75' },
+  { label: 'p90', value: 'pc90' },
+  { label: 'p95', value: 'pc95' },
+  { label: 'p98', value: 'pc98' },
+  { label: 'p99', value: 'pc99' },
+];
+
+/**
+ * RUMQueryEditor edits RUM queries: a search of RUM events, listed as a table or aggregated
+ * into time series. Text fields are applied on blur so that typing does not run a query per keystroke.
+ */
+export function RUMQueryEditor({ query, onChange, onRunQuery }: RUMQueryEditorProps) {
+  const update = (changes: Partial<MyQuery>) => {
+    onChange({ ...query, queryType: 'rum', ...changes });
+    onRunQuery();
+  };
+
+  const onFieldBlur = (field: 'rumQuery' | 'rumMetric') => (event: ChangeEvent<HTMLInputElement>) => {
+    const value = event.target.value.trim();
+    if ((query[field] || '') !== value) {
+      update({ [field]: value || undefined });
+    }
+  };
+
+  const onLimitBlur = (event: ChangeEvent<HTMLInputElement>) => {
+    const limit = parseInt(event.target.value, 10);
+    const value = Number.isFinite(limit) && limit > 0 ? limit : undefined;
+    if (query.limit !== value) {
+      update({ limit: value });
+    }
+  };
+
+  const onLegendBlur = (event: ChangeEvent<HTMLInputElement>) => {
+    const value = event.target.value;
+    if ((query.legendTemplate || '') !== value) {
+      update({ legendMode: value ? 'custom' : 'auto', legendTemplate: value });
+    }
+  };
+
+  const aggregating = !!query.rumAggregation;
+  const needsMetric = aggregating && query.rumAggregation !== 'count';
+
+  return (
+    <Stack gap={0} direction="column">
+      <InlineFieldRow>
+        <InlineField label="Search" labelWidth={14} grow tooltip="RUM events search, e.g. @type:view @application.name:Shop">
+          <Input defaultValue={query.rumQuery || ''} placeholder="*" onBlur={onFieldBlur('rumQuery')} />
+        </InlineField>
+      </InlineFieldRow>
+      <InlineFieldRow>
+        <InlineField label="Aggregation" labelWidth={14}>
+          <Select
+            width={20}
+            options={aggregationOptions}
+            value={aggregationOptions.find((opt) => opt.value === (query.rumAggregation || ''))}
+            onChange={(option) => update({ rumAggregation: option.value || undefined })}
+          />
+        </InlineField>
+        {needsMetric && (
+          <InlineField label="Of" labelWidth={6} tooltip="Measure or facet aggregated, e.g. @view.loading_time (in nanoseconds)">
+            <Input
+              width={28}
+              defaultValue={query.rumMetric || ''}
+              placeholder="@view.loading_time"
+              onBlur={onFieldBlur('rumMetric')}
+            />
+          </InlineField>
+        )}
+        <InlineField
+          label="Limit"
+          labelWidth={8}
+          tooltip={aggregating ? 'Groups kept for each facet (default 10)' : 'Events listed (default 100, at most 1000)'}
+        >
+          <Input
+            width={10}
+            type="number"
+            defaultValue={query.limit ?? ''}
+            placeholder={aggregating ? '10' : '100'}
+            onBlur={onLimitBlur}
+          />
+        </InlineField>
+      </InlineFieldRow>
+      {aggregating && (
+        <InlineFieldRow>
+          <InlineField label="Group by" labelWidth={14} tooltip="Facets the time series are grouped by, e.g. @view.url_path">
+            <TagsInput
+              width={40}
+              tags={query.rumGroupBy || []}
+              placeholder="@view.url_path"
+              onChange={(tags) => update({ rumGroupBy: tags.length > 0 ? tags : undefined })}
+            />
+          </InlineField>
+          <InlineField label="Legend" labelWidth={8} tooltip="Series name, e.g. {{@view.url_path}}. Leave empty for the default.">
+            <Input
+              width={28}
+              defaultValue={query.legendMode === 'custom' ? query.legendTemplate || '' : ''}
+              placeholder="Auto"
+              onBlur={onLegendBlur}
+            />
+          </InlineField>
+        </InlineFieldRow>
+      )}
+    </Stack>
+  );
+}
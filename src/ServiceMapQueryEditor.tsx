@@ -0,0 +1,49 @@
+import React, { ChangeEvent } from 'react';
+import { InlineField, InlineFieldRow, Input } from '@grafana/ui';
+import { QueryEditorProps } from '@grafana/data';
+import { DataSource } from './datasource';
+import { MyDataSourceOptions, MyQuery } from './types';
+
+type ServiceMapQueryEditorProps = QueryEditorProps<DataSource, MyQuery, MyDataSourceOptions>;
+
+/**
+ * ServiceMapQueryEditor edits APM service map queries, shown in the node graph panel.
+ * Fields are applied on blur so that typing does not run a query per keystroke.
+ */
+export function ServiceMapQueryEditor({ query, onChange, onRunQuery }: ServiceMapQueryEditorProps) {
+  const onFieldBlur = (field: 'env' | 'service' | 'operation') => (event: ChangeEvent<HTMLInputElement>) => {
+    const value = event.target.value.trim();
+    if ((query[field] || '') === value) {
+      return;
+    }
+    onChange({ ...query, queryType: 'service-map', [field]: value || undefined });
+    onRunQuery();
+  };
+
+  return (
+    <InlineFieldRow>
+      <InlineField label="Env" labelWidth={14} tooltip="APM environment of the service map, e.g. prod or $env" required>
+        <Input width={20} defaultValue={query.env || ''} placeholder="prod" onBlur={onFieldBlur('env')} />
+      </InlineField>
+      <InlineField
+        label="Service"
+        labelWidth={14}
+        tooltip="Only show this service and the services it calls or is called by. Leave empty for the whole environment."
+      >
+        <Input width={24} defaultValue={query.service || ''} placeholder="All services" onBlur={onFieldBlur('service')} />
+      </InlineField>
+      <InlineField
+        label="Operation"
+        labelWidth={14}
+        tooltip="Client span operation whose trace metrics give the request rate, error rate and latency of the edges"
+      >
+        <Input
+          width={24}
+          defaultValue={query.operation || ''}
+          placeholder="http.client.request"
+          onBlur={onFieldBlur('operation')}
+        />
+      </InlineField>
+    </InlineFieldRow>
+  );
+}
@@ -13,7 +13,7 @@ import {
 import { DataSourceWithBackend, getBackendSrv, getTemplateSrv } from '@grafana/runtime';
 import { DataSourceWithQueryModificationSupport } from '@grafana/data';
 
-import { MyQuery, MyDataSourceOptions, DEFAULT_QUERY, MyVariableQuery } from './types';
+import { MyQuery, MyDataSourceOptions, DEFAULT_QUERY, MyVariableQuery, QueryType } from './types';
 import { variableInterpolationService } from './utils/variableInterpolation';
 import { migrateJsonParsingConfiguration } from './utils/jsonParsingMigration';
 
@@ -56,6 +56,8 @@ export class DataSource extends DataSourceWithBackend<MyQuery, MyDataSourceOptio
     const hasMetricsQuery = !!query.queryText;
     const hasExpressionQuery = query.type === 'math' && !!query.expression;
     const hasLogsQuery = !!query.logQuery;
+    const hasServiceMapQuery = query.queryType === 'service-map' && !!query.env;
+    const hasRUMQuery = query.queryType === 'rum';
     
     // For logs queries, perform additional validation
     if (hasLogsQuery && query.logQuery) {
@@ -70,13 +72,13 @@ export class DataSource extends DataSourceWithBackend<MyQuery, MyDataSourceOptio
       });
     }
     
-    return hasMetricsQuery || hasExpressionQuery || hasLogsQuery;
+    return hasMetricsQuery || hasExpressionQuery || hasLogsQuery || hasServiceMapQuery || hasRUMQuery;
   }
 
   /**
    * Detects whether a query should be treated as a logs query based on panel context and query properties
    */
-  private detectQueryType(query: MyQuery): 'logs' | 'metrics' {
+  private detectQueryType(query: MyQuery): QueryType {
     // If queryType is explicitly set, use it
     if (query.queryType) {
       return query.queryType;
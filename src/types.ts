@@ -11,6 +11,9 @@ export interface JSONParsingConfig {
   };
 }
 
+// What the null points of a metrics series become ('auto': zero for counts and rates, gaps for gauges)
+export type NullHandling = 'auto' | 'drop' | 'null' | 'zero' | 'previous' | 'gaps';
+
 export interface MyQuery extends DataQuery {
   queryText?: string;
   // Legend configuration
@@ -24,8 +27,22 @@ export interface MyQuery extends DataQuery {
   expression?: string; // Math expression like "$A*100/$B"
   // Query options
   interval?: number;   // Override interval in milliseconds
+  nullHandling?: NullHandling; // What null points become - defaults to 'drop'
+  format?: 'multi' | 'wide';   // One frame per series (default) or a single wide frame
+  limit?: number;              // Keep the top N series
+  order?: 'desc' | 'asc';      // Top (default) or bottom series
+  orderBy?: 'avg' | 'max' | 'min' | 'last' | 'sum'; // Value series are ranked by - defaults to 'avg'
+  // Service map query fields
+  env?: string;       // APM environment of the service map
+  service?: string;   // Only this service and the services it calls or is called by
+  operation?: string; // Client span operation of the edge statistics - defaults to 'http.client.request'
+  // RUM query fields
+  rumQuery?: string;              // RUM events search query - all events when empty
+  rumAggregation?: RUMAggregation; // Aggregates events into time series - lists them when unset
+  rumMetric?: string;             // Measure or facet aggregated, e.g. '@view.loading_time'
+  rumGroupBy?: string[];          // Facets the time series are grouped by, e.g. '@view.url_path'
   // Logs query fields
-  queryType?: 'logs' | 'metrics'; // Query type - defaults to 'metrics'
+  queryType?: QueryType; // Query type - defaults to 'metrics'
   logQuery?: string;   // Logs search query
   indexes?: string[];  // Target log indexes
   // JSON parsing configuration
@@ -38,6 +55,23 @@ export interface MyQuery extends DataQuery {
   };
 }
 
+export type QueryType = 'logs' | 'metrics' | 'service-map' | 'rum';
+
+// Aggregations of RUM analytics
+export type RUMAggregation =
+  | 'count'
+  | 'cardinality'
+  | 'sum'
+  | 'min'
+  | 'max'
+  | 'avg'
+  | 'median'
+  | 'pc75'
+  | 'pc90'
+  | 'pc95'
+  | 'pc98'
+  | 'pc99';
+
 export const DEFAULT_QUERY: Partial<MyQuery> = {
   queryText: '',
   legendMode: 'auto',
@@ -62,14 +96,81 @@ export const DEFAULT_QUERY: Partial<MyQuery> = {
  */
 export interface MyDataSourceOptions extends DataSourceJsonData {
   site?: string;
+  // Optional full API base URL (scheme, host, port, path prefix) overriding https://api.<site>
+  baseUrl?: string;
+  // Log query text verbatim in the plugin backend logs (redacted by default)
+  logQueries?: boolean;
+  // Keep organization-wide tag listings on disk so they survive plugin restarts
+  persistentCache?: boolean;
+  // Key pair used first when a secondary pair is configured ('primary' by default)
+  activeKeyPair?: 'primary' | 'secondary';
+  // Use the application key of the viewing user or team (keys are provisioned as userAppKey:<login> / teamAppKey:<team>)
+  userAppKeys?: UserAppKeysOptions;
+  // Restrict the metrics, tags and log indexes queries may use and add mandatory filters (enforced by the backend)
+  policy?: PolicyOptions;
+  limits?: QueryLimits;
+  // Named logs searches offered by the logs query editor, e.g. saved views copied from Datadog
+  savedLogViews?: SavedLogView[];
+}
+
+export interface SavedLogView {
+  name: string;
+  logQuery: string;
+  indexes?: string[];
+  jsonParsing?: JSONParsingConfig;
+}
+
+// A saved view as returned by the logs/saved-views resource: the query it stands for
+export interface SavedQuery {
+  name: string;
+  query: Partial<MyQuery>;
 }
 
+export interface UserAppKeysOptions {
+  enabled: boolean;
+  // Team name -> logins or emails of its members
+  teams?: Record<string, string[]>;
+  // Let users without a key of their own use the shared application key
+  allowSharedKeyFallback?: boolean;
+}
+
+export interface PolicyOptions {
+  // Metric name prefixes queries may use, e.g. 'payments.'
+  allowedMetricPrefixes?: string[];
+  // Tag keys metric queries may filter and group by
+  allowedTagKeys?: string[];
+  // key:value tags added to the scope of every metric, e.g. 'env:prod'
+  requiredMetricTags?: string[];
+  // Log indexes logs queries may search
+  allowedLogIndexes?: string[];
+  // Logs search ANDed to every logs query
+  requiredLogQuery?: string;
+}
+
+export interface QueryLimits {
+  // Series returned for one metrics query; negative: unlimited. Default 1000
+  maxSeries?: number;
+  // Longest time range of a logs query, e.g. '7d'
+  maxLogsTimeRange?: string;
+  // Pages of up to 1000 lines one logs query may fetch. Default 1
+  maxLogsPages?: number;
+  // Datadog requests the datasource makes per minute
+  requestsPerMinute?: number;
+}
+
+export type QueryLimitNumberField = 'maxSeries' | 'maxLogsPages' | 'requestsPerMinute';
+
+export type PolicyListField = 'allowedMetricPrefixes' | 'allowedTagKeys' | 'requiredMetricTags' | 'allowedLogIndexes';
+
 /**
  * Value that is used in the backend, but never sent over HTTP to the frontend
  */
 export interface MySecureJsonData {
   apiKey?: string;
   appKey?: string;
+  // Optional second key pair, used on failover and for rotating keys without downtime
+  secondaryApiKey?: string;
+  secondaryAppKey?: string;
 }
 
 /**
@@ -37,6 +37,25 @@ export class VariableInterpolationService {
         result.logQuery = this.interpolateLogsQuery(query.logQuery, scopedVars);
       }
 
+      // Service map environment and service are plain values
+      if (query.env) {
+        result.env = this.interpolateString(query.env, scopedVars);
+      }
+      if (query.service) {
+        result.service = this.interpolateString(query.service, scopedVars);
+      }
+
+      // RUM searches use the logs search syntax
+      if (query.rumQuery) {
+        result.rumQuery = this.interpolateLogsQuery(query.rumQuery, scopedVars);
+      }
+      if (query.rumMetric) {
+        result.rumMetric = this.interpolateString(query.rumMetric, scopedVars);
+      }
+      if (query.rumGroupBy) {
+        result.rumGroupBy = query.rumGroupBy.map((facet) => this.interpolateString(facet, scopedVars));
+      }
+
       return result;
     } catch (error) {
       // Return original query as fallback